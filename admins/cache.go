@@ -0,0 +1,127 @@
+package admins
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fetcher loads the user IDs of a chat's current administrators, including
+// its creator
+type Fetcher func(chatID int64) ([]int64, error)
+
+// Stats is a snapshot of cache effectiveness
+type Stats struct {
+	Hits      int64 // Lookups answered from a fresh entry
+	Misses    int64 // Lookups that had to fetch the administrator list
+	Fallbacks int64 // Misses answered from a stale entry because the fetch failed
+	Chats     int   // Chats with a cached administrator list
+}
+
+// entry is one chat's administrator list
+type entry struct {
+	admins    map[int64]bool
+	fetchedAt time.Time
+	expired   bool // Invalidated before its TTL ran out
+}
+
+// Cache remembers each chat's administrators for a TTL so admin checks do
+// not cost an API call every time. When a refresh fails, the last list that
+// was fetched successfully is used instead, so a flaky network does not lock
+// real administrators out.
+type Cache struct {
+	fetch Fetcher
+	ttl   time.Duration
+	now   func() time.Time
+
+	chats map[int64]*entry
+	mu    sync.Mutex
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	fallbacks atomic.Int64
+}
+
+// NewCache creates a cache that loads administrator lists with fetch and
+// keeps them for ttl (default: 5 minutes)
+func NewCache(fetch Fetcher, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+
+	return &Cache{
+		fetch: fetch,
+		ttl:   ttl,
+		now:   time.Now,
+		chats: make(map[int64]*entry),
+	}
+}
+
+// IsAdmin reports whether a user administers a chat. It returns an error
+// only if the list cannot be fetched and none was fetched before.
+func (c *Cache) IsAdmin(chatID, userID int64) (bool, error) {
+	c.mu.Lock()
+	cached := c.chats[chatID]
+	fresh := cached != nil && !cached.expired && c.now().Sub(cached.fetchedAt) < c.ttl
+	c.mu.Unlock()
+
+	if fresh {
+		c.hits.Add(1)
+		return cached.admins[userID], nil
+	}
+	c.misses.Add(1)
+
+	// Fetch without holding the lock so other chats are not held up
+	ids, err := c.fetch(chatID)
+	if err != nil {
+		if cached == nil {
+			return false, err
+		}
+		c.fallbacks.Add(1)
+		return cached.admins[userID], nil
+	}
+
+	admins := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		admins[id] = true
+	}
+
+	c.mu.Lock()
+	c.chats[chatID] = &entry{admins: admins, fetchedAt: c.now()}
+	c.mu.Unlock()
+
+	return admins[userID], nil
+}
+
+// Invalidate makes the next lookup in a chat fetch a new administrator list,
+// e.g. after someone was promoted or demoted. The current list is kept as a
+// fallback in case that fetch fails.
+func (c *Cache) Invalidate(chatID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.chats[chatID]; ok {
+		cached.expired = true
+	}
+}
+
+// Forget drops everything known about a chat, e.g. after the bot left it
+func (c *Cache) Forget(chatID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.chats, chatID)
+}
+
+// Stats returns the cache counters
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	chats := len(c.chats)
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Fallbacks: c.fallbacks.Load(),
+		Chats:     chats,
+	}
+}
@@ -0,0 +1,113 @@
+package admins
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeFetcher serves scripted administrator lists and counts calls
+type fakeFetcher struct {
+	admins map[int64][]int64
+	err    error
+	calls  int
+}
+
+func (f *fakeFetcher) fetch(chatID int64) ([]int64, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.admins[chatID], nil
+}
+
+func newTestCache(f *fakeFetcher) (*Cache, *time.Time) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewCache(f.fetch, time.Minute)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestIsAdmin_CachesWithinTTL(t *testing.T) {
+	f := &fakeFetcher{admins: map[int64][]int64{-100: {1, 2}}}
+	c, now := newTestCache(f)
+
+	for _, tt := range []struct {
+		userID int64
+		want   bool
+	}{{1, true}, {2, true}, {3, false}} {
+		got, err := c.IsAdmin(-100, tt.userID)
+		if err != nil || got != tt.want {
+			t.Errorf("Expected user %d admin=%v, got %v (err %v)", tt.userID, tt.want, got, err)
+		}
+	}
+	if f.calls != 1 {
+		t.Errorf("Expected 1 fetch for 3 lookups, got %d", f.calls)
+	}
+
+	// The list is fetched again once the TTL has passed
+	*now = now.Add(time.Minute)
+	f.admins[-100] = []int64{3}
+	if got, _ := c.IsAdmin(-100, 3); !got {
+		t.Error("Expected the refreshed list after the TTL")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Fallbacks != 0 || stats.Chats != 1 {
+		t.Errorf("Expected 2 hits, 2 misses, 0 fallbacks in 1 chat, got %+v", stats)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	f := &fakeFetcher{admins: map[int64][]int64{-100: {1}, -200: {1}}}
+	c, _ := newTestCache(f)
+
+	c.IsAdmin(-100, 1)
+	c.IsAdmin(-200, 1)
+	f.admins[-100] = nil
+	c.Invalidate(-100)
+
+	if got, _ := c.IsAdmin(-100, 1); got {
+		t.Error("Expected a demoted admin to lose access after invalidation")
+	}
+	if got, _ := c.IsAdmin(-200, 1); !got {
+		t.Error("Expected other chats to stay cached")
+	}
+	if f.calls != 3 {
+		t.Errorf("Expected 3 fetches, got %d", f.calls)
+	}
+}
+
+func TestIsAdmin_FallsBackOnError(t *testing.T) {
+	f := &fakeFetcher{admins: map[int64][]int64{-100: {1}}}
+	c, now := newTestCache(f)
+
+	// Nothing known yet: the error is returned
+	f.err = errors.New("timeout")
+	if got, err := c.IsAdmin(-100, 1); err == nil || got {
+		t.Errorf("Expected an error with no cached list, got %v, %v", got, err)
+	}
+
+	f.err = nil
+	c.IsAdmin(-100, 1)
+
+	// Expired and invalidated lists are still better than nothing
+	f.err = errors.New("timeout")
+	*now = now.Add(time.Hour)
+	if got, err := c.IsAdmin(-100, 1); err != nil || !got {
+		t.Errorf("Expected the expired list to be used, got %v, %v", got, err)
+	}
+	c.Invalidate(-100)
+	if got, err := c.IsAdmin(-100, 2); err != nil || got {
+		t.Errorf("Expected the invalidated list to be used, got %v, %v", got, err)
+	}
+	if stats := c.Stats(); stats.Fallbacks != 2 {
+		t.Errorf("Expected 2 fallbacks, got %d", stats.Fallbacks)
+	}
+
+	// Forgetting a chat removes the fallback too
+	c.Forget(-100)
+	if _, err := c.IsAdmin(-100, 1); err == nil {
+		t.Error("Expected an error after the chat was forgotten")
+	}
+}
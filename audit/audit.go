@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// ActionDenied is recorded when a user tries something they are not allowed
+// to do. Other entries use the name of the command that made the change.
+const ActionDenied = "denied"
+
+// exportBatchSize is how many entries Export reads per query
+const exportBatchSize = 500
+
+// Store is the part of storage.Storage the audit log uses
+type Store interface {
+	SaveAuditEntry(ctx context.Context, entry *storage.AuditEntry) error
+	GetAuditLog(ctx context.Context, chatID int64, limit int, offset int) ([]*storage.AuditEntry, error)
+	GetAuditLogBefore(ctx context.Context, chatID int64, beforeID int64, limit int) ([]*storage.AuditEntry, error)
+}
+
+// Log records settings changes and admin actions in storage
+type Log struct {
+	store Store
+	now   func() time.Time
+	log   *slog.Logger
+}
+
+// New creates an audit log backed by store
+func New(store Store) *Log {
+	return &Log{
+		store: store,
+		now:   time.Now,
+		log:   slog.Default(),
+	}
+}
+
+// SetLogger sets where entries that fail to write are logged
+func (l *Log) SetLogger(logger *slog.Logger) {
+	l.log = logger
+}
+
+// Record appends an entry for an action by actorID in a chat. A failure to
+// write is logged rather than returned: the action itself already happened.
+func (l *Log) Record(ctx context.Context, chatID, actorID int64, action, oldValue, newValue string) {
+	entry := &storage.AuditEntry{
+		ChatID:    chatID,
+		ActorID:   actorID,
+		Action:    action,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		Timestamp: l.now(),
+	}
+	if err := l.store.SaveAuditEntry(ctx, entry); err != nil {
+		l.log.Warn("Failed to write audit log entry", "action", action, "chat_id", chatID, "error", err)
+	}
+}
+
+// Page returns one page of a chat's entries, newest first. Pages are
+// numbered from 1.
+func (l *Log) Page(ctx context.Context, chatID int64, page, pageSize int) ([]*storage.AuditEntry, error) {
+	if page < 1 {
+		page = 1
+	}
+	return l.store.GetAuditLog(ctx, chatID, pageSize, (page-1)*pageSize)
+}
+
+// Export writes a chat's entries as JSON lines, oldest first. Chat ID 0
+// exports every chat. It returns the number of entries written. Batches are
+// read below the last ID seen, so entries recorded during the export are
+// left out rather than shifting later batches.
+func (l *Log) Export(ctx context.Context, chatID int64, w io.Writer) (int, error) {
+	var entries []*storage.AuditEntry
+	var before int64
+	for {
+		batch, err := l.store.GetAuditLogBefore(ctx, chatID, before, exportBatchSize)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		entries = append(entries, batch...)
+		if len(batch) < exportBatchSize {
+			break
+		}
+		before = batch[len(batch)-1].ID
+	}
+
+	// Stored newest first; logs read oldest first
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := WriteJSONLine(w, entries[i]); err != nil {
+			return len(entries) - 1 - i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// record is the JSON form of an entry
+type record struct {
+	ID        int64     `json:"id"`
+	ChatID    int64     `json:"chat_id"`
+	ActorID   int64     `json:"actor_id"`
+	Action    string    `json:"action"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WriteJSONLine writes an entry as one line of JSON
+func WriteJSONLine(w io.Writer, entry *storage.AuditEntry) error {
+	line, err := json.Marshal(record{
+		ID:        entry.ID,
+		ChatID:    entry.ChatID,
+		ActorID:   entry.ActorID,
+		Action:    entry.Action,
+		OldValue:  entry.OldValue,
+		NewValue:  entry.NewValue,
+		Timestamp: entry.Timestamp.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
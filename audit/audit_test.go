@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// failingStore rejects every write
+type failingStore struct {
+	*storage.MockStorage
+}
+
+func (failingStore) SaveAuditEntry(ctx context.Context, entry *storage.AuditEntry) error {
+	return errors.New("disk full")
+}
+
+// recordingStore writes a new entry after the first batch is read, like an
+// admin changing a setting while an export runs
+type recordingStore struct {
+	*storage.MockStorage
+	written bool
+}
+
+func (s *recordingStore) GetAuditLog(ctx context.Context, chatID int64, limit int, offset int) ([]*storage.AuditEntry, error) {
+	entries, err := s.MockStorage.GetAuditLog(ctx, chatID, limit, offset)
+	s.write(ctx, chatID)
+	return entries, err
+}
+
+func (s *recordingStore) GetAuditLogBefore(ctx context.Context, chatID int64, beforeID int64, limit int) ([]*storage.AuditEntry, error) {
+	entries, err := s.MockStorage.GetAuditLogBefore(ctx, chatID, beforeID, limit)
+	s.write(ctx, chatID)
+	return entries, err
+}
+
+func (s *recordingStore) write(ctx context.Context, chatID int64) {
+	if !s.written {
+		s.written = true
+		s.SaveAuditEntry(ctx, &storage.AuditEntry{ChatID: chatID, Action: "setfrequency"})
+	}
+}
+
+func newTestLog(store Store) *Log {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := New(store)
+	l.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	return l
+}
+
+func TestRecordAndPage(t *testing.T) {
+	store := storage.NewMockStorage()
+	l := newTestLog(store)
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		l.Record(ctx, -100, int64(i), "setfrequency", "", "")
+	}
+	l.Record(ctx, -200, 9, ActionDenied, "", "/resetsettings")
+
+	page, err := l.Page(ctx, -100, 1, 2)
+	if err != nil || len(page) != 2 || page[0].ActorID != 5 || page[1].ActorID != 4 {
+		t.Fatalf("Expected actors 5 and 4 on page 1, got %+v (error %v)", page, err)
+	}
+	if page, _ := l.Page(ctx, -100, 3, 2); len(page) != 1 || page[0].ActorID != 1 {
+		t.Errorf("Expected actor 1 alone on page 3, got %+v", page)
+	}
+	if page, _ := l.Page(ctx, -100, 0, 2); len(page) != 2 || page[0].ActorID != 5 {
+		t.Errorf("Expected page 0 to mean the first page, got %+v", page)
+	}
+
+	// Write failures do not panic or block the caller
+	newTestLog(failingStore{storage.NewMockStorage()}).Record(ctx, -100, 1, "setfrequency", "10", "5")
+}
+
+func TestExport(t *testing.T) {
+	store := storage.NewMockStorage()
+	l := newTestLog(store)
+	ctx := context.Background()
+
+	l.Record(ctx, -100, 1, "setfrequency", "10", "5")
+	l.Record(ctx, -200, 2, "togglementions", "on", "off")
+	l.Record(ctx, -100, 3, ActionDenied, "", "/resetsettings (requires admin)")
+
+	var out strings.Builder
+	n, err := l.Export(ctx, -100, &out)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 entries exported, got %d (error %v)", n, err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", out.String())
+	}
+	want := `{"id":1,"chat_id":-100,"actor_id":1,"action":"setfrequency","old_value":"10","new_value":"5","timestamp":"2024-03-01T12:01:00Z"}`
+	if lines[0] != want {
+		t.Errorf("Expected the oldest entry first:\n%s\ngot:\n%s", want, lines[0])
+	}
+	var last record
+	if err := json.Unmarshal([]byte(lines[1]), &last); err != nil || last.Action != ActionDenied || last.ActorID != 3 {
+		t.Errorf("Expected the denial last, got %+v (error %v)", last, err)
+	}
+
+	// Chat 0 exports everything
+	out.Reset()
+	if n, _ := l.Export(ctx, 0, &out); n != 3 {
+		t.Errorf("Expected 3 entries across chats, got %d", n)
+	}
+}
+
+func TestExport_ConcurrentWrite(t *testing.T) {
+	store := &recordingStore{MockStorage: storage.NewMockStorage()}
+	l := newTestLog(store)
+	ctx := context.Background()
+
+	for i := 1; i <= exportBatchSize+1; i++ {
+		l.Record(ctx, -100, int64(i), "setfrequency", "", "")
+	}
+
+	var out strings.Builder
+	n, err := l.Export(ctx, -100, &out)
+	if err != nil || n != exportBatchSize+1 {
+		t.Fatalf("Expected %d entries exported, got %d (error %v)", exportBatchSize+1, n, err)
+	}
+
+	seen := make(map[int64]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		var r record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Failed to parse %q: %v", line, err)
+		}
+		if seen[r.ID] {
+			t.Errorf("Entry %d exported twice", r.ID)
+		}
+		seen[r.ID] = true
+	}
+	if !seen[1] || !seen[int64(exportBatchSize+1)] {
+		t.Errorf("Expected the oldest and newest recorded entries, got %d distinct", len(seen))
+	}
+}
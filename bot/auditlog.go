@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/Zind-dev/HowardTheChad_bot/audit"
+	"github.com/Zind-dev/HowardTheChad_bot/settings"
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// auditPageSize is how many entries one /auditlog page shows
+const auditPageSize = 10
+
+// handleAuditLogCommand pages through the chat's audit log, newest first, or
+// sends the whole log as a JSON lines file with "/auditlog export"
+func (b *Bot) handleAuditLogCommand(ctx context.Context, message *tgbotapi.Message) {
+	if !b.authorize(ctx, message, "auditlog") {
+		return
+	}
+
+	args := strings.TrimSpace(message.CommandArguments())
+	if strings.EqualFold(args, "export") {
+		b.exportAuditLog(ctx, message)
+		return
+	}
+
+	page := 1
+	if args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 {
+			b.sendMessage(message.Chat.ID, "Usage: /auditlog [page] or /auditlog export", message.MessageID)
+			return
+		}
+		page = n
+	}
+
+	// One extra to know whether there is a next page
+	entries, err := b.audit.Page(ctx, message.Chat.ID, page, auditPageSize+1)
+	if err != nil {
+		b.log.Error("Failed to get audit log", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to load the audit log. Please try again later.", message.MessageID)
+		return
+	}
+	more := len(entries) > auditPageSize
+	if more {
+		entries = entries[:auditPageSize]
+	}
+
+	if len(entries) == 0 {
+		if page == 1 {
+			b.sendMessage(message.Chat.ID, "📜 Nothing in the audit log yet.", message.MessageID)
+		} else {
+			b.sendMessage(message.Chat.ID, fmt.Sprintf("📜 The audit log has no page %d.", page), message.MessageID)
+		}
+		return
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, b.formatAuditEntry(ctx, entry))
+	}
+
+	response := fmt.Sprintf("📜 Audit log, page %d:\n\n", page) + strings.Join(lines, "\n")
+	if more {
+		response += fmt.Sprintf("\n\nOlder entries: /auditlog %d", page+1)
+	}
+	b.sendMessage(message.Chat.ID, response, message.MessageID)
+}
+
+// exportAuditLog sends the chat's audit log as a JSON lines document
+func (b *Bot) exportAuditLog(ctx context.Context, message *tgbotapi.Message) {
+	var buf bytes.Buffer
+	n, err := b.audit.Export(ctx, message.Chat.ID, &buf)
+	if err != nil {
+		b.log.Error("Failed to export audit log", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to export the audit log. Please try again later.", message.MessageID)
+		return
+	}
+	if n == 0 {
+		b.sendMessage(message.Chat.ID, "📜 Nothing in the audit log yet.", message.MessageID)
+		return
+	}
+
+	doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("audit-log-%d.jsonl", message.Chat.ID),
+		Bytes: buf.Bytes(),
+	})
+	doc.Caption = fmt.Sprintf("📜 %d audit log entries", n)
+	doc.ReplyToMessageID = message.MessageID
+	if _, err := b.send(doc, reasonCommand); err != nil {
+		b.log.Error("Failed to send audit log", "chat_id", message.Chat.ID, "error", err)
+	}
+}
+
+// formatAuditEntry formats an entry as one /auditlog line
+func (b *Bot) formatAuditEntry(ctx context.Context, entry *storage.AuditEntry) string {
+	prefix := fmt.Sprintf("• %s %s", entry.Timestamp.UTC().Format("2006-01-02 15:04"), b.userName(ctx, entry.ActorID))
+
+	switch {
+	case entry.Action == audit.ActionDenied:
+		return fmt.Sprintf("%s was denied %s", prefix, entry.NewValue)
+	case entry.OldValue == "":
+		return fmt.Sprintf("%s /%s: %s", prefix, entry.Action, entry.NewValue)
+	case entry.NewValue == "":
+		return fmt.Sprintf("%s /%s: removed %s", prefix, entry.Action, entry.OldValue)
+	default:
+		return fmt.Sprintf("%s /%s: %s → %s", prefix, entry.Action, entry.OldValue, entry.NewValue)
+	}
+}
+
+// auditSettingsChange records a settings change made by a command or panel
+// button, comparing the chat's settings before it with the current ones.
+// Nothing is recorded if the setting kept its value.
+func (b *Bot) auditSettingsChange(ctx context.Context, chatID, actorID int64, action string, before *settings.Settings) {
+	after := b.settingsManager.GetSettings(ctx, chatID)
+	oldValue, newValue := settingValue(action, before), settingValue(action, after)
+	if oldValue == newValue {
+		return
+	}
+	b.audit.Record(ctx, chatID, actorID, action, oldValue, newValue)
+}
+
+// settingValue formats the part of the settings an action changes
+func settingValue(action string, s *settings.Settings) string {
+	switch action {
+	case "setfrequency":
+		return strconv.Itoa(s.ResponseFrequency)
+	case "togglementions":
+		return formatOnOff(s.AlwaysRespondToMentions)
+	case "togglemedia":
+		return "media counts: " + formatYesNo(!s.IgnoreMedia)
+	case "setretention":
+		return formatRetention(s.RetentionMaxAge, s.RetentionMaxMessages)
+	default:
+		return fmt.Sprintf("frequency %d, mentions %s, media counts: %s, retention: %s",
+			s.ResponseFrequency, formatOnOff(s.AlwaysRespondToMentions), formatYesNo(!s.IgnoreMedia),
+			formatRetention(s.RetentionMaxAge, s.RetentionMaxMessages))
+	}
+}
+
+// formatOnOff formats a switch for the audit log
+func formatOnOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
@@ -1,143 +1,505 @@
 package bot
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/Zind-dev/HowardTheChad_bot/admins"
+	"github.com/Zind-dev/HowardTheChad_bot/audit"
+	"github.com/Zind-dev/HowardTheChad_bot/callback"
+	"github.com/Zind-dev/HowardTheChad_bot/chatcontext"
 	"github.com/Zind-dev/HowardTheChad_bot/chats"
 	"github.com/Zind-dev/HowardTheChad_bot/config"
+	"github.com/Zind-dev/HowardTheChad_bot/dispatcher"
+	"github.com/Zind-dev/HowardTheChad_bot/health"
+	"github.com/Zind-dev/HowardTheChad_bot/logging"
+	"github.com/Zind-dev/HowardTheChad_bot/metrics"
+	"github.com/Zind-dev/HowardTheChad_bot/permissions"
+	"github.com/Zind-dev/HowardTheChad_bot/profiler"
+	"github.com/Zind-dev/HowardTheChad_bot/responder"
+	"github.com/Zind-dev/HowardTheChad_bot/retention"
 	"github.com/Zind-dev/HowardTheChad_bot/settings"
 	"github.com/Zind-dev/HowardTheChad_bot/storage"
 	"github.com/Zind-dev/HowardTheChad_bot/users"
+	"github.com/Zind-dev/HowardTheChad_bot/webhook"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 // Bot represents the Telegram bot
 type Bot struct {
-	api             *tgbotapi.BotAPI
+	api             TelegramClient
 	config          *config.Config
 	userManager     *users.Manager
 	chatManager     *chats.Manager
 	settingsManager *settings.Manager
 	storage         storage.Storage
+	responder       responder.Responder
+	contextBuilder  *chatcontext.Builder
+	profiler        *profiler.Profiler
+	janitor         *retention.Janitor
+	callbacks       *callback.Signer
+	admins          *admins.Cache
+	permissions     *permissions.Authorizer
+	audit           *audit.Log
+	log             *slog.Logger
+	mentionLog      *slog.Logger    // Mention detection trace, enabled by traceMentions
+	traceMentions   *logging.Toggle // Shared with mentionLog so reloads can flip it
+	metrics         *botMetrics
+	health          *health.Checker // Served as /readyz
+	diagnostics     *health.Checker // Run by /ping
+	started         time.Time
+	lastUpdate      atomic.Int64 // Unix nanoseconds when the last update arrived; 0 before the first
+	lastPoll        atomic.Int64 // Unix nanoseconds when getUpdates last returned; 0 before the first
+	maxUpdateAge    atomic.Int64 // Readiness limit on the age of the last update or poll, as a time.Duration
 }
 
-// New creates a new bot instance
-func New(cfg *config.Config, store storage.Storage) (*Bot, error) {
+// Options holds a bot's optional dependencies
+type Options struct {
+	Logger  *slog.Logger      // The bot and its components log here; nil means the default logger
+	Metrics *metrics.Registry // The bot registers its metrics here; nil keeps them private
+}
+
+// allowedUpdates are the update types requested from Telegram. chat_member
+// is not delivered by default but keeps the administrator cache current.
+var allowedUpdates = []string{
+	"message",
+	"edited_message",
+	"callback_query",
+	"my_chat_member",
+	"chat_member",
+}
+
+const (
+	// pollBuffer is how many fetched updates wait for the dispatcher
+	pollBuffer = 100
+	// pollRetryDelay is the pause after a failed getUpdates
+	pollRetryDelay = 3 * time.Second
+)
+
+// New creates a new bot instance connected to the Telegram Bot API
+func New(cfg *config.Config, store storage.Storage, opts Options) (*Bot, error) {
 	api, err := tgbotapi.NewBotAPI(cfg.TelegramToken)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Authorized on account %s", api.Self.UserName)
-	log.Printf("Configured bot username: %s", cfg.BotUsername)
-	log.Printf("NOTE: These usernames must match for mentions to work!")
+	return NewWithClient(cfg, store, apiClient{api}, opts)
+}
+
+// NewWithClient creates a bot that talks to Telegram through the given client
+func NewWithClient(cfg *config.Config, store storage.Storage, api TelegramClient, opts Options) (*Bot, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	component := func(name string) *slog.Logger {
+		return logger.With("component", name)
+	}
+
+	reg := opts.Metrics
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+	chatMgr := chats.NewManager()
+	botMetrics := newBotMetrics(reg, chatMgr)
+	api = instrumentedClient{TelegramClient: api, errors: botMetrics.apiErrors}
+
+	botLog := component("bot")
+	botLog.Info("Authorized on account", "account", api.Self().UserName, "configured_username", cfg.BotUsername)
+	if api.Self().UserName != cfg.BotUsername {
+		botLog.Warn("Configured username does not match the account; mentions will not be detected")
+	}
 
 	// Create settings manager with defaults from config
 	defaultSettings := settings.NewCustomSettings(cfg.ResponseFrequency, cfg.RespondToMentions)
-	settingsMgr := settings.NewManager(defaultSettings)
+	settingsMgr := settings.NewManager(defaultSettings, store)
+	settingsMgr.SetLogger(component("settings"))
+
+	resp, err := responder.New(cfg, component("responder"))
+	if err != nil {
+		return nil, err
+	}
+	botLog.Info("Using responder", "backend", cfg.Responder.Backend)
 
-	return &Bot{
+	extractor, err := profiler.NewExtractor(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	auditLog := audit.New(store)
+	auditLog.SetLogger(component("audit"))
+	adminCache := admins.NewCache(chatAdminFetcher(api), cfg.AdminCacheTTL)
+	authorizer, err := permissions.New(cfg.Owners, cfg.CommandPermissions, adminCache, store, auditLog)
+	if err != nil {
+		return nil, err
+	}
+	authorizer.SetLogger(component("permissions"))
+
+	traceMentions := new(logging.Toggle)
+	traceMentions.Set(cfg.Logging.TraceMentions)
+
+	b := &Bot{
 		api:             api,
 		config:          cfg,
 		userManager:     users.NewManager(),
-		chatManager:     chats.NewManager(),
+		chatManager:     chatMgr,
 		settingsManager: settingsMgr,
 		storage:         store,
-	}, nil
+		responder:       resp,
+		contextBuilder: chatcontext.NewBuilder(store, api.Self().ID, chatcontext.Options{
+			HistoryLimit: cfg.ContextMessages,
+			MaxChars:     cfg.ContextMaxChars,
+		}),
+		profiler: profiler.New(store, extractor, profiler.Options{
+			Interval: cfg.ProfilerInterval,
+			Logger:   component("profiler"),
+		}),
+		janitor: retention.New(store, retention.Options{
+			Interval:  cfg.Storage.RetentionInterval,
+			BatchSize: cfg.Storage.RetentionBatchSize,
+			Logger:    component("retention"),
+		}),
+		// Panel buttons are signed with the token, so only this bot can mint them
+		callbacks:     callback.NewSigner([]byte("settings-panel:"+cfg.TelegramToken), settingsPanelTTL),
+		admins:        adminCache,
+		permissions:   authorizer,
+		audit:         auditLog,
+		log:           botLog,
+		mentionLog:    logging.WithLevel(component("mentions"), traceMentions),
+		traceMentions: traceMentions,
+		metrics:       botMetrics,
+		started:       time.Now(),
+	}
+	b.maxUpdateAge.Store(int64(cfg.Monitoring.MaxUpdateAge))
+	b.health, b.diagnostics = b.newHealthCheckers()
+	return b, nil
+}
+
+// ApplyConfig applies a reloaded configuration. Only the defaults for
+// settings chats have not changed themselves, mention tracing and the
+// readiness limit on update age change; everything else is read once at
+// startup.
+func (b *Bot) ApplyConfig(cfg *config.Config) {
+	b.settingsManager.SetDefaults(settings.NewCustomSettings(cfg.ResponseFrequency, cfg.RespondToMentions))
+	b.traceMentions.Set(cfg.Logging.TraceMentions)
+	b.maxUpdateAge.Store(int64(cfg.Monitoring.MaxUpdateAge))
+}
+
+// Start starts the bot and handles incoming updates until ctx is cancelled.
+// Updates arrive by long polling or webhook depending on the configured mode,
+// and are processed concurrently across chats and in order within a chat.
+// On cancellation it stops receiving, finishes in-flight work within the
+// configured shutdown timeout and flushes pending storage writes.
+func (b *Bot) Start(ctx context.Context) error {
+	// Keep user profiles up to date in the background
+	go b.profiler.Run(ctx)
+
+	// Prune messages outside each chat's retention rules
+	go b.janitor.Run(ctx)
+
+	// Handlers get their own context so in-flight work survives the shutdown
+	// signal; it is cancelled only once the shutdown deadline has passed.
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+	stopDeadline := context.AfterFunc(ctx, func() {
+		time.AfterFunc(b.config.ShutdownTimeout, cancelHandlers)
+	})
+	defer stopDeadline()
+
+	pool := dispatcher.New(b.config.Workers, b.config.QueueSize, b.handleUpdate)
+	pool.Start(handlerCtx)
+	b.log.Info("Processing updates", "workers", b.config.Workers)
+
+	var err error
+	if b.config.Transport.Mode == config.ModeWebhook {
+		err = b.receiveWebhook(ctx, pool)
+	} else {
+		b.receivePolling(ctx, handlerCtx, pool)
+	}
+
+	if shutdownErr := b.shutdown(handlerCtx, pool); shutdownErr != nil && err == nil {
+		err = shutdownErr
+	}
+	return err
 }
 
-// Start starts the bot and handles incoming messages
-func (b *Bot) Start() error {
+// receivePolling fetches updates with getUpdates until ctx is cancelled
+func (b *Bot) receivePolling(ctx, handlerCtx context.Context, pool *dispatcher.Dispatcher) {
+	// Telegram rejects getUpdates while a webhook is set, e.g. after
+	// switching back from webhook mode
+	if _, err := b.api.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		b.log.Warn("Failed to remove webhook", "error", err)
+	}
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
+	u.AllowedUpdates = allowedUpdates
+
+	stop := make(chan struct{})
+	updates := b.pollUpdates(u, stop)
+	b.log.Info("Receiving updates by long polling")
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.stopPolling(handlerCtx, stop, updates, pool)
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			// Blocks while this chat's worker is saturated (backpressure)
+			if err := pool.Dispatch(ctx, update); err != nil {
+				// Shutting down; keep the update for the drain below
+				if err := pool.Dispatch(handlerCtx, update); err != nil {
+					b.log.Warn("Dropped update during shutdown", "update_id", update.UpdateID, "error", err)
+				}
+				b.stopPolling(handlerCtx, stop, updates, pool)
+				return
+			}
+		}
+	}
+}
 
-	updates := b.api.GetUpdatesChan(u)
+// pollUpdates calls getUpdates in a loop until stop is closed, sending the
+// updates on the returned channel. Every poll that returns, with updates or
+// without, is recorded for the updates health check.
+func (b *Bot) pollUpdates(config tgbotapi.UpdateConfig, stop <-chan struct{}) tgbotapi.UpdatesChannel {
+	updates := make(chan tgbotapi.Update, pollBuffer)
+
+	go func() {
+		defer close(updates)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
 
-	for update := range updates {
-		if update.Message == nil {
-			continue
+			batch, err := b.api.GetUpdates(config)
+			if err != nil {
+				b.log.Warn("Failed to get updates, retrying", "retry_in", pollRetryDelay, "error", err)
+				select {
+				case <-stop:
+					return
+				case <-time.After(pollRetryDelay):
+				}
+				continue
+			}
+			b.lastPoll.Store(time.Now().UnixNano())
+
+			for _, update := range batch {
+				if update.UpdateID < config.Offset {
+					continue
+				}
+				config.Offset = update.UpdateID + 1
+				// Updates not yet confirmed by a later poll are sent again
+				// after a restart, so nothing is lost by stopping here
+				select {
+				case updates <- update:
+				case <-stop:
+					return
+				}
+			}
 		}
+	}()
 
-		// Store user information
-		b.userManager.UpdateUser(update.Message.From)
+	return updates
+}
+
+// stopPolling stops getUpdates and queues updates that were already fetched
+func (b *Bot) stopPolling(ctx context.Context, stop chan struct{}, updates tgbotapi.UpdatesChannel, pool *dispatcher.Dispatcher) {
+	b.log.Info("Shutting down: no longer receiving updates")
+	close(stop)
 
-		// Handle the message
-		b.handleMessage(update.Message)
+	// Telegram considers fetched updates delivered, so handle what is buffered
+	if n := b.drainUpdates(ctx, updates, pool); n > 0 {
+		b.log.Info("Queued buffered updates before exiting", "updates", n)
 	}
+}
 
+// receiveWebhook registers the webhook and serves it until ctx is cancelled
+func (b *Bot) receiveWebhook(ctx context.Context, pool *dispatcher.Dispatcher) error {
+	handler := webhook.NewHandler(b.config.Transport.WebhookSecret, pool.Dispatch)
+	handler.SetLogger(b.log.With("component", "webhook"))
+	server, err := webhook.NewServer(b.config.Transport.WebhookListenAddr, b.config.Transport.WebhookURL, handler)
+	if err != nil {
+		return err
+	}
+
+	if err := webhook.Register(b.api, b.config.Transport.WebhookURL, b.config.Transport.WebhookSecret, allowedUpdates); err != nil {
+		return err
+	}
+	b.log.Info("Receiving updates by webhook", "listen_addr", b.config.Transport.WebhookListenAddr)
+
+	// Telegram keeps undelivered updates while we are down, so the webhook
+	// stays registered; in-flight requests finish before the server returns
+	if err := server.Run(ctx, b.config.ShutdownTimeout); err != nil {
+		return err
+	}
+	b.log.Info("Shutting down: no longer receiving updates")
 	return nil
 }
 
-// handleMessage processes incoming messages
-func (b *Bot) handleMessage(message *tgbotapi.Message) {
-	log.Printf("Message received - Chat: %d, User: %s, Text: %s",
-		message.Chat.ID, message.From.UserName, message.Text)
-
-	// Save user to storage
-	user := &storage.User{
-		ID:           message.From.ID,
-		UserName:     message.From.UserName,
-		FirstName:    message.From.FirstName,
-		LastName:     message.From.LastName,
-		MessageCount: 0, // Will be updated separately
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+// shutdown finishes queued updates and flushes storage
+func (b *Bot) shutdown(ctx context.Context, pool *dispatcher.Dispatcher) error {
+	pool.Stop()
+
+	stats := pool.Stats()
+	b.log.Info("Handled updates", "updates", stats.Processed,
+		"queue_full_waits", stats.Blocked, "queue_full_time", stats.BlockedTime)
+	adminStats := b.admins.Stats()
+	b.log.Info("Admin checks", "cached", adminStats.Hits, "fetched", adminStats.Misses,
+		"stale_after_api_errors", adminStats.Fallbacks)
+	if ctx.Err() != nil {
+		b.log.Warn("Shutdown timeout reached, in-flight work was cancelled")
 	}
-	if err := b.storage.SaveUser(user); err != nil {
-		log.Printf("Warning: Failed to save user: %v", err)
+
+	// Flushing gets a fresh deadline even if the handler deadline has passed
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), b.config.ShutdownTimeout)
+	defer cancel()
+
+	if flusher, ok := b.storage.(storage.Flusher); ok {
+		if err := flusher.Flush(flushCtx); err != nil {
+			return fmt.Errorf("failed to flush storage: %w", err)
+		}
 	}
 
-	// Save chat to storage
-	chat := &storage.Chat{
-		ID:           message.Chat.ID,
-		Title:        message.Chat.Title,
-		Type:         message.Chat.Type,
-		MessageCount: 0, // Will be updated separately
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+	b.log.Info("Shutdown complete")
+	return nil
+}
+
+// drainUpdates queues updates already waiting in the channel without blocking on polling
+func (b *Bot) drainUpdates(ctx context.Context, updates tgbotapi.UpdatesChannel, pool *dispatcher.Dispatcher) int {
+	queued := 0
+	for ctx.Err() == nil {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return queued
+			}
+			if err := pool.Dispatch(ctx, update); err != nil {
+				return queued
+			}
+			queued++
+		default:
+			return queued
+		}
 	}
-	if err := b.storage.SaveChat(chat); err != nil {
-		log.Printf("Warning: Failed to save chat: %v", err)
+	return queued
+}
+
+// handleUpdate routes a single update to the matching handler
+func (b *Bot) handleUpdate(ctx context.Context, update tgbotapi.Update) {
+	b.lastUpdate.Store(time.Now().UnixNano())
+	defer b.observeUpdate(update, time.Now())
+
+	switch {
+	case update.Message != nil:
+		b.handleMessage(ctx, update.Message)
+	case update.EditedMessage != nil:
+		b.handleEditedMessage(ctx, update.EditedMessage)
+	case update.CallbackQuery != nil:
+		b.handleCallbackQuery(ctx, update.CallbackQuery)
+	case update.MyChatMember != nil:
+		b.handleMemberUpdate(update.MyChatMember)
+	case update.ChatMember != nil:
+		b.handleMemberUpdate(update.ChatMember)
 	}
+}
+
+// handleMemberUpdate keeps the administrator cache in step with promotions,
+// demotions and the bot leaving a chat
+func (b *Bot) handleMemberUpdate(update *tgbotapi.ChatMemberUpdated) {
+	chatID := update.Chat.ID
+	member := update.NewChatMember
+
+	if member.User != nil && member.User.ID == b.api.Self().ID && (member.HasLeft() || member.WasKicked()) {
+		b.admins.Forget(chatID)
+		return
+	}
+
+	wasAdmin := update.OldChatMember.IsCreator() || update.OldChatMember.IsAdministrator()
+	isAdmin := member.IsCreator() || member.IsAdministrator()
+	if wasAdmin || isAdmin {
+		b.admins.Invalidate(chatID)
+	}
+}
+
+// handleEditedMessage updates the stored text, or a media message's
+// caption, of an edited message. Edits never trigger a response or count
+// toward the response frequency.
+func (b *Bot) handleEditedMessage(ctx context.Context, message *tgbotapi.Message) {
+	msg := storage.MessageFromTelegram(message)
+	if msg.EditedAt.IsZero() {
+		msg.EditedAt = time.Now()
+	}
+
+	if err := b.storage.EditMessage(ctx, msg.ChatID, msg.MessageID, msg.Text, msg.Caption, msg.EditedAt); err != nil {
+		b.log.Warn("Failed to save message edit", "chat_id", message.Chat.ID, "error", err)
+	}
+}
+
+// handleMessage processes incoming messages
+func (b *Bot) handleMessage(ctx context.Context, message *tgbotapi.Message) {
+	msg := storage.MessageFromTelegram(message)
+	b.log.Debug("Message received", logging.ChatID(message.Chat.ID), logging.UserID(message.From.ID),
+		logging.Username(message.From.UserName), logging.Text(msg.Describe()))
+
+	// Track the sender and chat, saving their details only when they change
+	b.trackUser(ctx, message.From)
+	chatLoaded := b.trackChat(ctx, message.Chat)
 
 	// Save message to storage for AI context
-	msg := &storage.Message{
-		ChatID:    message.Chat.ID,
-		UserID:    message.From.ID,
-		Text:      message.Text,
-		IsBot:     message.From.IsBot,
-		Timestamp: time.Now(),
+	if err := b.storage.SaveMessage(ctx, msg); err != nil {
+		b.log.Warn("Failed to save message", "chat_id", message.Chat.ID, "error", err)
 	}
-	if err := b.storage.SaveMessage(msg); err != nil {
-		log.Printf("Warning: Failed to save message: %v", err)
+
+	// Track interaction for user profiling
+	if !message.From.IsBot {
+		if err := b.profiler.RecordInteraction(ctx, message.Chat.ID, message.From.ID, time.Now()); err != nil {
+			b.log.Warn("Failed to record interaction", "chat_id", message.Chat.ID, "error", err)
+		}
 	}
 
 	// Check if the message is in a group
 	if !message.Chat.IsGroup() && !message.Chat.IsSuperGroup() {
 		// Respond to private messages
-		b.respondToPrivateMessage(message)
+		b.respondToPrivateMessage(ctx, message)
 		return
 	}
 
 	// Handle commands first
 	if message.IsCommand() {
-		b.handleCommand(message)
+		b.handleCommand(ctx, message)
 		return
 	}
 
-	// Track message count for this chat
-	messageCount := b.chatManager.IncrementMessageCount(
-		message.Chat.ID,
-		message.Chat.Title,
-		message.Chat.Type,
-	)
-
 	// Get settings for this specific chat
-	chatSettings := b.settingsManager.GetSettings(message.Chat.ID)
+	chatSettings := b.settingsManager.GetSettings(ctx, message.Chat.ID)
+
+	// Media is stored for context but may be left out of the response
+	// cadence. Mentions in a caption are still answered.
+	counted := chatLoaded && !(msg.IsMedia() && chatSettings.IgnoreMedia)
+
+	// Track message count for this chat. If its stored count could not be
+	// loaded the message goes uncounted rather than resetting that count.
+	var messageCount int
+	if counted {
+		messageCount = b.chatManager.IncrementMessageCount(
+			message.Chat.ID,
+			message.Chat.Title,
+			message.Chat.Type,
+		)
+		if err := b.storage.UpdateChatMessageCount(ctx, message.Chat.ID, messageCount); err != nil {
+			b.log.Warn("Failed to update chat message count", "chat_id", message.Chat.ID, "error", err)
+		}
+	}
 
 	// Check if bot is mentioned
 	isMentioned := b.isBotMentioned(message)
@@ -146,37 +508,148 @@ func (b *Bot) handleMessage(message *tgbotapi.Message) {
 	shouldRespond := false
 	if isMentioned && chatSettings.ShouldRespondToMention() {
 		shouldRespond = true
-	} else if !isMentioned && chatSettings.ShouldRespondToRegularMessage(messageCount) {
+	} else if !isMentioned && counted && chatSettings.ShouldRespondToRegularMessage(messageCount) {
 		shouldRespond = true
 	}
 
 	if shouldRespond {
 		if isMentioned {
-			b.respondToMention(message)
+			b.respondToMention(ctx, message)
 		} else {
-			b.respondToRegularMessage(message)
+			b.respondToRegularMessage(ctx, message)
 		}
 	}
 }
 
+// trackUser counts a message for the sender and persists the user.
+// Counts survive restarts: a user seen for the first time since startup
+// resumes from the stored count. If that count cannot be loaded the
+// message is not counted, so the stored count is not overwritten, and the
+// next message tries again. SaveUser only sets the count for a new user,
+// so the count is always written through UpdateUserMessageCount.
+func (b *Bot) trackUser(ctx context.Context, from *tgbotapi.User) {
+	if b.userManager.GetUser(from.ID) == nil {
+		stored, err := b.storage.GetUser(ctx, from.ID)
+		if err != nil {
+			b.log.Warn("Failed to load user", "user_id", from.ID, "error", err)
+			return
+		}
+		if stored != nil {
+			b.userManager.Restore(users.User{
+				ID:           stored.ID,
+				UserName:     stored.UserName,
+				FirstName:    stored.FirstName,
+				LastName:     stored.LastName,
+				MessageCount: stored.MessageCount,
+			})
+		}
+	}
+
+	changed := b.userManager.UpdateUser(from)
+	count := b.userManager.GetUser(from.ID).MessageCount
+
+	if changed {
+		user := &storage.User{
+			ID:           from.ID,
+			UserName:     from.UserName,
+			FirstName:    from.FirstName,
+			LastName:     from.LastName,
+			MessageCount: count,
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+		}
+		if err := b.storage.SaveUser(ctx, user); err != nil {
+			b.log.Warn("Failed to save user", "user_id", from.ID, "error", err)
+		}
+	}
+
+	if err := b.storage.UpdateUserMessageCount(ctx, from.ID, count); err != nil {
+		b.log.Warn("Failed to update user message count", "user_id", from.ID, "error", err)
+	}
+}
+
+// trackChat persists a chat, restoring its stored message count the first
+// time it is seen since startup. It reports false if that count could not be
+// loaded; the chat is then left untracked until a later message loads it.
+func (b *Bot) trackChat(ctx context.Context, chat *tgbotapi.Chat) bool {
+	if b.chatManager.GetChat(chat.ID) == nil {
+		stored, err := b.storage.GetChat(ctx, chat.ID)
+		if err != nil {
+			b.log.Warn("Failed to load chat", "chat_id", chat.ID, "error", err)
+			return false
+		}
+		if stored != nil {
+			b.chatManager.Restore(chats.Chat{
+				ID:           stored.ID,
+				Title:        stored.Title,
+				Type:         stored.Type,
+				MessageCount: stored.MessageCount,
+			})
+		}
+	}
+
+	if !b.chatManager.UpdateChat(chat.ID, chat.Title, chat.Type) {
+		return true
+	}
+
+	record := &storage.Chat{
+		ID:           chat.ID,
+		Title:        chat.Title,
+		Type:         chat.Type,
+		MessageCount: b.chatManager.GetMessageCount(chat.ID),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := b.storage.SaveChat(ctx, record); err != nil {
+		b.log.Warn("Failed to save chat", "chat_id", chat.ID, "error", err)
+	}
+	return true
+}
+
 // isBotMentioned checks if the bot is mentioned in the message
 func (b *Bot) isBotMentioned(message *tgbotapi.Message) bool {
 	botUsername := b.config.BotUsername
+	trace := b.mentionLog.With("message_id", message.MessageID)
+	if message.Chat != nil {
+		trace = trace.With(logging.ChatID(message.Chat.ID))
+	}
+
+	trace.Debug("Checking mention", "bot_username", botUsername, logging.Text(message.Text))
+
+	// Media carries its text and entities in the caption instead
+	if mentionsIn(message.Text, message.Entities, botUsername, trace) ||
+		mentionsIn(message.Caption, message.CaptionEntities, botUsername, trace) {
+		return true
+	}
+
+	// Check if the message is a reply to the bot
+	if message.ReplyToMessage != nil {
+		if message.ReplyToMessage.From != nil {
+			if message.ReplyToMessage.From.UserName == botUsername {
+				trace.Debug("Detected reply to bot message")
+				return true
+			}
+		}
+	}
 
-	log.Printf("Checking mention - Bot username: %s, Message: %s", botUsername, message.Text)
+	trace.Debug("No mention")
+	return false
+}
 
+// mentionsIn reports whether text or its entities mention the bot
+func mentionsIn(text string, entities []tgbotapi.MessageEntity, botUsername string, trace *slog.Logger) bool {
 	// Check for @ mentions (with or without @)
 	botMention := "@" + botUsername
-	if strings.Contains(message.Text, botMention) {
-		log.Printf("Detected mention via text: %s", botMention)
+	if strings.Contains(text, botMention) {
+		trace.Debug("Detected mention via text")
 		return true
 	}
 
 	// Check entities for mentions
-	for _, entity := range message.Entities {
+	for _, entity := range entities {
 		if entity.Type == "mention" {
-			mention := message.Text[entity.Offset : entity.Offset+entity.Length]
-			log.Printf("Found mention entity: %s", mention)
+			mention := text[entity.Offset : entity.Offset+entity.Length]
+			trace.Debug("Found mention entity", logging.Username(strings.TrimPrefix(mention, "@")))
 			if mention == botMention {
 				return true
 			}
@@ -184,27 +657,16 @@ func (b *Bot) isBotMentioned(message *tgbotapi.Message) bool {
 		// Also check text_mention type (when user doesn't have username)
 		if entity.Type == "text_mention" {
 			if entity.User != nil && entity.User.UserName == botUsername {
-				log.Printf("Detected text_mention for bot")
+				trace.Debug("Detected text_mention for bot")
 				return true
 			}
 		}
 	}
-
-	// Check if the message is a reply to the bot
-	if message.ReplyToMessage != nil {
-		if message.ReplyToMessage.From != nil {
-			if message.ReplyToMessage.From.UserName == botUsername {
-				log.Printf("Detected reply to bot message")
-				return true
-			}
-		}
-	}
-
 	return false
 }
 
 // respondToPrivateMessage handles private messages
-func (b *Bot) respondToPrivateMessage(message *tgbotapi.Message) {
+func (b *Bot) respondToPrivateMessage(ctx context.Context, message *tgbotapi.Message) {
 	userInfo := b.userManager.GetUser(message.From.ID)
 
 	response := "Hello! I'm HowardTheChad bot. "
@@ -216,82 +678,91 @@ func (b *Bot) respondToPrivateMessage(message *tgbotapi.Message) {
 	msg := tgbotapi.NewMessage(message.Chat.ID, response)
 	msg.ReplyToMessageID = message.MessageID
 
-	if _, err := b.api.Send(msg); err != nil {
-		log.Printf("Error sending message: %v", err)
+	sent, err := b.send(msg, reasonPrivate)
+	if err != nil {
+		b.log.Error("Failed to send message", "chat_id", message.Chat.ID, "error", err)
 	} else {
-		b.saveResponseMessage(message.Chat.ID, response)
+		b.saveResponseMessage(ctx, message, sent)
 	}
 }
 
 // respondToMention handles mentions in group chats
-func (b *Bot) respondToMention(message *tgbotapi.Message) {
+func (b *Bot) respondToMention(ctx context.Context, message *tgbotapi.Message) {
 	userInfo := b.userManager.GetUser(message.From.ID)
 
 	// Build context-aware response
-	response := b.generateResponse(message, userInfo)
+	response := b.generateResponse(ctx, message, userInfo)
+	if response == "" {
+		return
+	}
 
 	msg := tgbotapi.NewMessage(message.Chat.ID, response)
 	msg.ReplyToMessageID = message.MessageID
 
-	if _, err := b.api.Send(msg); err != nil {
-		log.Printf("Error sending message: %v", err)
+	sent, err := b.send(msg, reasonMention)
+	if err != nil {
+		b.log.Error("Failed to send message", "chat_id", message.Chat.ID, "error", err)
 	} else {
-		b.saveResponseMessage(message.Chat.ID, response)
+		b.saveResponseMessage(ctx, message, sent)
 	}
 }
 
 // respondToRegularMessage handles regular messages in group chats (periodic responses)
-func (b *Bot) respondToRegularMessage(message *tgbotapi.Message) {
+func (b *Bot) respondToRegularMessage(ctx context.Context, message *tgbotapi.Message) {
 	userInfo := b.userManager.GetUser(message.From.ID)
 
 	// Build context-aware response
-	response := b.generateResponse(message, userInfo)
+	response := b.generateResponse(ctx, message, userInfo)
+	if response == "" {
+		return
+	}
 
 	msg := tgbotapi.NewMessage(message.Chat.ID, response)
 	msg.ReplyToMessageID = message.MessageID
 
-	if _, err := b.api.Send(msg); err != nil {
-		log.Printf("Error sending message: %v", err)
+	sent, err := b.send(msg, reasonFrequency)
+	if err != nil {
+		b.log.Error("Failed to send message", "chat_id", message.Chat.ID, "error", err)
 	} else {
-		b.saveResponseMessage(message.Chat.ID, response)
+		b.saveResponseMessage(ctx, message, sent)
 	}
 }
 
-// generateResponse generates a context-aware response
-func (b *Bot) generateResponse(message *tgbotapi.Message, userInfo *users.User) string {
-	// For now, return a simple response
-	// This is where AI model integration would happen in the future
-
-	userName := "there"
-	if userInfo != nil && userInfo.FirstName != "" {
-		userName = userInfo.FirstName
+// generateResponse generates a context-aware response using the configured responder
+func (b *Bot) generateResponse(ctx context.Context, message *tgbotapi.Message, userInfo *users.User) string {
+	req := &responder.Request{
+		ChatID: message.Chat.ID,
+		Text:   storage.MessageFromTelegram(message).Describe(),
+	}
+	if userInfo != nil {
+		req.SenderName = userInfo.FirstName
 	}
 
-	responses := []string{
-		"Hey " + userName + "! What's up?",
-		"Hello " + userName + "! I'm here to help.",
-		"Hi " + userName + "! What can I do for you?",
-		userName + ", I'm listening!",
-		"Yo " + userName + "! How can I contribute?",
+	// Attach conversation history; backends without chat support ignore it
+	prompt, err := b.contextBuilder.Build(ctx, message)
+	if err != nil {
+		b.log.Warn("Failed to build conversation context", "chat_id", message.Chat.ID, "error", err)
+	} else {
+		req.Messages = prompt.Messages()
 	}
 
-	// Simple selection based on message length
-	// In the future, this would be replaced with AI model
-	index := len(message.Text) % len(responses)
-	return responses[index]
+	response, err := b.responder.Respond(ctx, req)
+	if err != nil {
+		b.log.Error("Failed to generate response", "chat_id", message.Chat.ID, "error", err)
+		return ""
+	}
+	return response
 }
 
-// saveResponseMessage saves a bot response message to storage
-func (b *Bot) saveResponseMessage(chatID int64, text string) {
-	msg := &storage.Message{
-		ChatID:    chatID,
-		UserID:    b.api.Self.ID,
-		Text:      text,
-		IsBot:     true,
-		Timestamp: time.Now(),
-	}
-	if err := b.storage.SaveMessage(msg); err != nil {
-		log.Printf("Warning: Failed to save bot response: %v", err)
+// saveResponseMessage saves the bot's reply to a message to storage
+func (b *Bot) saveResponseMessage(ctx context.Context, replyTo *tgbotapi.Message, sent tgbotapi.Message) {
+	msg := storage.MessageFromTelegram(&sent)
+	msg.ChatID = replyTo.Chat.ID
+	msg.UserID = b.api.Self().ID
+	msg.IsBot = true
+	msg.ReplyToMessageID = replyTo.MessageID
+	if err := b.storage.SaveMessage(ctx, msg); err != nil {
+		b.log.Warn("Failed to save bot response", "chat_id", replyTo.Chat.ID, "error", err)
 	}
 }
 
@@ -316,45 +787,72 @@ func (b *Bot) GetAllChats() map[int64]*chats.Chat {
 }
 
 // UpdateSettings updates the bot's behavior settings for a specific chat
-func (b *Bot) UpdateSettings(chatID int64, newSettings *settings.Settings) {
-	b.settingsManager.SetSettings(chatID, newSettings)
+func (b *Bot) UpdateSettings(ctx context.Context, chatID int64, newSettings *settings.Settings) error {
+	return b.settingsManager.SetSettings(ctx, chatID, newSettings)
 }
 
 // GetSettings returns the current bot settings for a specific chat
-func (b *Bot) GetSettings(chatID int64) *settings.Settings {
-	return b.settingsManager.GetSettings(chatID)
+func (b *Bot) GetSettings(ctx context.Context, chatID int64) *settings.Settings {
+	return b.settingsManager.GetSettings(ctx, chatID)
 }
 
-// isUserAdmin checks if a user is an administrator in a chat
-func (b *Bot) isUserAdmin(chatID int64, userID int64) bool {
-	chatConfig := tgbotapi.GetChatMemberConfig{
-		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
-			ChatID: chatID,
-			UserID: userID,
-		},
+// authorize checks that the sender of a command may run it, replying with
+// a refusal if not
+func (b *Bot) authorize(ctx context.Context, message *tgbotapi.Message, action string) bool {
+	allowed, required := b.permissions.Authorize(ctx, message.Chat.ID, message.From.ID, action)
+	if !allowed {
+		what, ok := deniedActions[action]
+		if !ok {
+			what = "change settings"
+		}
+		b.sendMessage(message.Chat.ID, deniedText(required, what), message.MessageID)
 	}
+	return allowed
+}
 
-	member, err := b.api.GetChatMember(chatConfig)
-	if err != nil {
-		log.Printf("Error getting chat member: %v", err)
-		return false
+// deniedText tells a user who may do what they tried
+func deniedText(required permissions.Level, what string) string {
+	switch required {
+	case permissions.Owner:
+		return "❌ Only bot owners can " + what + "."
+	case permissions.Admin:
+		return "❌ Only administrators can " + what + "."
+	default:
+		return "❌ Only administrators and bot moderators can " + what + "."
 	}
+}
+
+// chatAdminFetcher loads a chat's administrators, including its creator
+func chatAdminFetcher(api TelegramClient) admins.Fetcher {
+	return func(chatID int64) ([]int64, error) {
+		members, err := api.GetChatAdministrators(tgbotapi.ChatAdministratorsConfig{
+			ChatConfig: tgbotapi.ChatConfig{ChatID: chatID},
+		})
+		if err != nil {
+			return nil, err
+		}
 
-	// Check if user is creator or administrator
-	return member.Status == "creator" || member.Status == "administrator"
+		ids := make([]int64, 0, len(members))
+		for _, member := range members {
+			if member.User != nil {
+				ids = append(ids, member.User.ID)
+			}
+		}
+		return ids, nil
+	}
 }
 
 // handleCommand processes bot commands
-func (b *Bot) handleCommand(message *tgbotapi.Message) {
+func (b *Bot) handleCommand(ctx context.Context, message *tgbotapi.Message) {
 	command := message.Command()
-	log.Printf("Received command: /%s from user %d in chat %d", command, message.From.ID, message.Chat.ID)
+	b.log.Info("Received command", "command", command, "chat_id", message.Chat.ID, "user_id", message.From.ID)
 
 	// Handle commands in private chats
 	if !message.Chat.IsGroup() && !message.Chat.IsSuperGroup() {
 		if command == "help" || command == "start" {
 			b.handleHelpCommand(message)
 		} else {
-			b.respondToPrivateMessage(message)
+			b.respondToPrivateMessage(ctx, message)
 		}
 		return
 	}
@@ -362,45 +860,144 @@ func (b *Bot) handleCommand(message *tgbotapi.Message) {
 	// Handle commands in group chats
 	switch command {
 	case "settings":
-		b.handleSettingsCommand(message)
+		b.handleSettingsCommand(ctx, message)
 	case "setfrequency":
-		b.handleSetFrequencyCommand(message)
+		b.handleSetFrequencyCommand(ctx, message)
 	case "togglementions":
-		b.handleToggleMentionsCommand(message)
+		b.handleToggleMentionsCommand(ctx, message)
+	case "search":
+		b.handleSearchCommand(ctx, message)
+	case "togglemedia":
+		b.handleToggleMediaCommand(ctx, message)
+	case "setretention":
+		b.handleSetRetentionCommand(ctx, message)
 	case "resetsettings":
-		b.handleResetSettingsCommand(message)
+		b.handleResetSettingsCommand(ctx, message)
+	case "grant":
+		b.handleGrantCommand(ctx, message)
+	case "revoke":
+		b.handleRevokeCommand(ctx, message)
+	case "moderators":
+		b.handleModeratorsCommand(ctx, message)
+	case "auditlog":
+		b.handleAuditLogCommand(ctx, message)
+	case "ping":
+		b.handlePingCommand(ctx, message)
 	case "help", "start":
 		b.handleHelpCommand(message)
 	default:
-		log.Printf("Unknown command: /%s", command)
+		b.log.Debug("Unknown command", "command", command, "chat_id", message.Chat.ID)
 	}
 } // handleSettingsCommand shows current settings for the chat
-func (b *Bot) handleSettingsCommand(message *tgbotapi.Message) {
-	chatSettings := b.settingsManager.GetSettings(message.Chat.ID)
+func (b *Bot) handleSettingsCommand(ctx context.Context, message *tgbotapi.Message) {
+	chatSettings := b.settingsManager.GetSettings(ctx, message.Chat.ID)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, settingsText(chatSettings))
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = b.settingsKeyboard(message.Chat.ID, chatSettings)
 
-	mentionsStatus := "enabled"
-	if !chatSettings.AlwaysRespondToMentions {
-		mentionsStatus = "disabled"
+	if _, err := b.send(msg, reasonCommand); err != nil {
+		b.log.Error("Failed to send message", "chat_id", message.Chat.ID, "error", err)
 	}
+}
 
-	response := "📊 Current Settings:\n\n"
-	response += "• Response Frequency: every " + formatFrequency(chatSettings.ResponseFrequency) + "\n"
-	response += "• Respond to Mentions: " + mentionsStatus + "\n\n"
-	response += "Use /help to see available commands."
+// searchResultLimit caps how many matches /search shows
+const searchResultLimit = 5
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, response)
-	msg.ReplyToMessageID = message.MessageID
+// handleSearchCommand finds stored messages in the chat matching the arguments
+func (b *Bot) handleSearchCommand(ctx context.Context, message *tgbotapi.Message) {
+	query := strings.TrimSpace(message.CommandArguments())
+	if len(storage.SearchTerms(query)) == 0 {
+		b.sendMessage(message.Chat.ID, "Usage: /search <words>\nExample: /search release date", message.MessageID)
+		return
+	}
+
+	// Commands, including this one, are left out by the search itself
+	found, err := b.storage.SearchMessages(ctx, message.Chat.ID, query, searchResultLimit)
+	if err != nil {
+		b.log.Error("Failed to search messages", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Search failed. Please try again later.", message.MessageID)
+		return
+	}
+
+	var lines []string
+	for _, msg := range found {
+		lines = append(lines, fmt.Sprintf("• %s, %s:\n%s",
+			b.authorName(ctx, msg), msg.Timestamp.Format("2006-01-02"), snippet(msg.Describe(), storage.SearchTerms(query))))
+	}
 
-	if _, err := b.api.Send(msg); err != nil {
-		log.Printf("Error sending message: %v", err)
+	if len(lines) == 0 {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("🔍 No messages found for \"%s\".", query), message.MessageID)
+		return
 	}
+
+	response := fmt.Sprintf("🔍 Results for \"%s\":\n\n", query) + strings.Join(lines, "\n\n")
+	b.sendMessage(message.Chat.ID, response, message.MessageID)
+}
+
+// authorName returns a display name for a stored message's sender
+func (b *Bot) authorName(ctx context.Context, msg *storage.Message) string {
+	if msg.IsBot && msg.UserID == b.api.Self().ID {
+		return "@" + b.api.Self().UserName
+	}
+	return b.userName(ctx, msg.UserID)
+}
+
+// userName returns a display name for a user, or their ID if they are unknown
+func (b *Bot) userName(ctx context.Context, userID int64) string {
+	if user := b.userManager.GetUser(userID); user != nil {
+		return displayName(user.UserName, user.FirstName)
+	}
+	if user, err := b.storage.GetUser(ctx, userID); err == nil && user != nil {
+		return displayName(user.UserName, user.FirstName)
+	}
+	return fmt.Sprintf("user %d", userID)
+}
+
+// displayName prefers the @username, falling back to the first name
+func displayName(userName, firstName string) string {
+	if userName != "" {
+		return "@" + userName
+	}
+	return firstName
+}
+
+// snippetRunes is the length of a /search excerpt
+const snippetRunes = 80
+
+// snippet returns an excerpt of text around the first matching term
+func snippet(text string, terms []string) string {
+	runes := []rune(text)
+	if len(runes) <= snippetRunes {
+		return text
+	}
+
+	// Lowercasing can change byte lengths, so search rune by rune
+	lower := []rune(strings.ToLower(text))
+	start := 0
+	if len(lower) == len(runes) {
+		for _, term := range terms {
+			if i := strings.Index(string(lower), term); i >= 0 {
+				start = len([]rune(string(lower)[:i])) - snippetRunes/4
+				break
+			}
+		}
+	}
+	start = max(0, min(start, len(runes)-snippetRunes))
+
+	excerpt := string(runes[start : start+snippetRunes])
+	if start > 0 {
+		excerpt = "…" + excerpt
+	}
+	if start+snippetRunes < len(runes) {
+		excerpt += "…"
+	}
+	return excerpt
 }
 
 // handleSetFrequencyCommand changes the response frequency
-func (b *Bot) handleSetFrequencyCommand(message *tgbotapi.Message) {
-	// Check if user is admin
-	if !b.isUserAdmin(message.Chat.ID, message.From.ID) {
-		b.sendMessage(message.Chat.ID, "❌ Only administrators can change settings.", message.MessageID)
+func (b *Bot) handleSetFrequencyCommand(ctx context.Context, message *tgbotapi.Message) {
+	if !b.authorize(ctx, message, "setfrequency") {
 		return
 	}
 
@@ -416,21 +1013,32 @@ func (b *Bot) handleSetFrequencyCommand(message *tgbotapi.Message) {
 		return
 	}
 
-	b.settingsManager.SetFrequency(message.Chat.ID, frequency)
+	before := b.settingsManager.GetSettings(ctx, message.Chat.ID)
+	if err := b.settingsManager.SetFrequency(ctx, message.Chat.ID, frequency); err != nil {
+		b.log.Error("Failed to save settings", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to save settings. Please try again later.", message.MessageID)
+		return
+	}
+	b.auditSettingsChange(ctx, message.Chat.ID, message.From.ID, "setfrequency", before)
 
 	response := "✅ Response frequency updated to: every " + formatFrequency(frequency)
 	b.sendMessage(message.Chat.ID, response, message.MessageID)
 }
 
 // handleToggleMentionsCommand toggles mention response setting
-func (b *Bot) handleToggleMentionsCommand(message *tgbotapi.Message) {
-	// Check if user is admin
-	if !b.isUserAdmin(message.Chat.ID, message.From.ID) {
-		b.sendMessage(message.Chat.ID, "❌ Only administrators can change settings.", message.MessageID)
+func (b *Bot) handleToggleMentionsCommand(ctx context.Context, message *tgbotapi.Message) {
+	if !b.authorize(ctx, message, "togglementions") {
 		return
 	}
 
-	newValue := b.settingsManager.ToggleMentionResponse(message.Chat.ID)
+	before := b.settingsManager.GetSettings(ctx, message.Chat.ID)
+	newValue, err := b.settingsManager.ToggleMentionResponse(ctx, message.Chat.ID)
+	if err != nil {
+		b.log.Error("Failed to save settings", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to save settings. Please try again later.", message.MessageID)
+		return
+	}
+	b.auditSettingsChange(ctx, message.Chat.ID, message.From.ID, "togglementions", before)
 
 	status := "enabled"
 	if !newValue {
@@ -441,15 +1049,67 @@ func (b *Bot) handleToggleMentionsCommand(message *tgbotapi.Message) {
 	b.sendMessage(message.Chat.ID, response, message.MessageID)
 }
 
+// handleToggleMediaCommand toggles whether media messages count toward the response frequency
+func (b *Bot) handleToggleMediaCommand(ctx context.Context, message *tgbotapi.Message) {
+	if !b.authorize(ctx, message, "togglemedia") {
+		return
+	}
+
+	before := b.settingsManager.GetSettings(ctx, message.Chat.ID)
+	countsMedia, err := b.settingsManager.ToggleMediaCounting(ctx, message.Chat.ID)
+	if err != nil {
+		b.log.Error("Failed to save settings", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to save settings. Please try again later.", message.MessageID)
+		return
+	}
+	b.auditSettingsChange(ctx, message.Chat.ID, message.From.ID, "togglemedia", before)
+
+	response := "✅ Media messages count toward response frequency: " + formatYesNo(countsMedia)
+	b.sendMessage(message.Chat.ID, response, message.MessageID)
+}
+
+// handleSetRetentionCommand sets how long stored messages are kept
+func (b *Bot) handleSetRetentionCommand(ctx context.Context, message *tgbotapi.Message) {
+	if !b.authorize(ctx, message, "setretention") {
+		return
+	}
+
+	args := message.CommandArguments()
+	if args == "" {
+		b.sendMessage(message.Chat.ID, "Usage: /setretention <age> <count> | off\nExamples: /setretention 30d, /setretention 5000, /setretention 7d 1000", message.MessageID)
+		return
+	}
+
+	maxAge, maxMessages, err := parseRetention(args)
+	if err != nil {
+		b.sendMessage(message.Chat.ID, "❌ Please provide an age like 30d or 12h, a message count greater than 0, or both.", message.MessageID)
+		return
+	}
+
+	before := b.settingsManager.GetSettings(ctx, message.Chat.ID)
+	if err := b.settingsManager.SetRetention(ctx, message.Chat.ID, maxAge, maxMessages); err != nil {
+		b.log.Error("Failed to save settings", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to save settings. Please try again later.", message.MessageID)
+		return
+	}
+	b.auditSettingsChange(ctx, message.Chat.ID, message.From.ID, "setretention", before)
+
+	b.sendMessage(message.Chat.ID, "✅ Message retention updated to: "+formatRetention(maxAge, maxMessages), message.MessageID)
+}
+
 // handleResetSettingsCommand resets settings to defaults
-func (b *Bot) handleResetSettingsCommand(message *tgbotapi.Message) {
-	// Check if user is admin
-	if !b.isUserAdmin(message.Chat.ID, message.From.ID) {
-		b.sendMessage(message.Chat.ID, "❌ Only administrators can change settings.", message.MessageID)
+func (b *Bot) handleResetSettingsCommand(ctx context.Context, message *tgbotapi.Message) {
+	if !b.authorize(ctx, message, "resetsettings") {
 		return
 	}
 
-	b.settingsManager.ResetSettings(message.Chat.ID)
+	before := b.settingsManager.GetSettings(ctx, message.Chat.ID)
+	if err := b.settingsManager.ResetSettings(ctx, message.Chat.ID); err != nil {
+		b.log.Error("Failed to reset settings", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to reset settings. Please try again later.", message.MessageID)
+		return
+	}
+	b.auditSettingsChange(ctx, message.Chat.ID, message.From.ID, "resetsettings", before)
 	b.sendMessage(message.Chat.ID, "✅ Settings reset to defaults.", message.MessageID)
 }
 
@@ -458,13 +1118,22 @@ func (b *Bot) handleHelpCommand(message *tgbotapi.Message) {
 	response := "🤖 HowardTheChad Bot Commands\n\n"
 	response += "📊 Information:\n"
 	response += "/settings - Show current settings\n"
+	response += "/search <words> - Search this chat's message history\n"
+	response += "/moderators - List bot moderators\n"
 	response += "/help - Show this help message\n\n"
-	response += "⚙️ Admin Commands:\n"
+	response += "⚙️ Admin and Moderator Commands:\n"
 	response += "/setfrequency <number> - Set response frequency\n"
 	response += "  Example: /setfrequency 10 (respond every 10th message)\n"
 	response += "  Use 0 to only respond to mentions\n"
 	response += "/togglementions - Toggle automatic response to mentions\n"
+	response += "/togglemedia - Toggle whether photos, stickers and other media count toward the frequency\n"
+	response += "/setretention <age> <count> - Limit stored message history\n"
+	response += "  Example: /setretention 30d 5000, or /setretention off\n"
 	response += "/resetsettings - Reset settings to defaults\n"
+	response += "/grant, /revoke - Make a user a bot moderator or remove them\n"
+	response += "  Reply to their message, or give their @username or user ID\n"
+	response += "/auditlog [page] - Show who changed what; /auditlog export sends the full log as JSON lines\n"
+	response += "/ping - Check storage, Telegram and update delivery, with latencies\n"
 
 	b.sendMessage(message.Chat.ID, response, message.MessageID)
 }
@@ -476,11 +1145,19 @@ func (b *Bot) sendMessage(chatID int64, text string, replyToMessageID int) {
 		msg.ReplyToMessageID = replyToMessageID
 	}
 
-	if _, err := b.api.Send(msg); err != nil {
-		log.Printf("Error sending message: %v", err)
+	if _, err := b.send(msg, reasonCommand); err != nil {
+		b.log.Error("Failed to send message", "chat_id", chatID, "error", err)
 	}
 }
 
+// formatYesNo formats a boolean setting for display
+func formatYesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
 // formatFrequency formats the frequency number for display
 func formatFrequency(frequency int) string {
 	if frequency == 0 {
@@ -491,3 +1168,65 @@ func formatFrequency(frequency int) string {
 		return fmt.Sprintf("%d messages", frequency)
 	}
 }
+
+// parseRetention reads "/setretention" arguments: an age such as 30d or 12h,
+// a message count, both, or "off"
+func parseRetention(args string) (time.Duration, int, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 1 && strings.EqualFold(fields[0], "off") {
+		return 0, 0, nil
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, 0, fmt.Errorf("expected an age, a message count or both, got %d arguments", len(fields))
+	}
+
+	var maxAge time.Duration
+	var maxMessages int
+	for _, field := range fields {
+		if n, err := strconv.Atoi(field); err == nil {
+			if n <= 0 || maxMessages != 0 {
+				return 0, 0, fmt.Errorf("invalid message count: %s", field)
+			}
+			maxMessages = n
+			continue
+		}
+
+		age, err := parseAge(field)
+		if err != nil || age <= 0 || maxAge != 0 {
+			return 0, 0, fmt.Errorf("invalid age: %s", field)
+		}
+		maxAge = age
+	}
+	return maxAge, maxMessages, nil
+}
+
+// parseAge parses a Go duration, plus whole days with a "d" suffix
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// formatRetention formats retention limits for display
+func formatRetention(maxAge time.Duration, maxMessages int) string {
+	age := maxAge.String()
+	if maxAge%(24*time.Hour) == 0 {
+		age = fmt.Sprintf("%d days", maxAge/(24*time.Hour))
+	}
+
+	switch {
+	case maxAge > 0 && maxMessages > 0:
+		return fmt.Sprintf("last %s, at most %d messages", age, maxMessages)
+	case maxAge > 0:
+		return "last " + age
+	case maxMessages > 0:
+		return fmt.Sprintf("last %d messages", maxMessages)
+	default:
+		return "keep everything"
+	}
+}
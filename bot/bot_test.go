@@ -1,10 +1,25 @@
 package bot
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Zind-dev/HowardTheChad_bot/chatcontext"
 	"github.com/Zind-dev/HowardTheChad_bot/config"
+	"github.com/Zind-dev/HowardTheChad_bot/health"
+	"github.com/Zind-dev/HowardTheChad_bot/logging"
+	"github.com/Zind-dev/HowardTheChad_bot/metrics"
+	"github.com/Zind-dev/HowardTheChad_bot/permissions"
+	"github.com/Zind-dev/HowardTheChad_bot/responder"
 	"github.com/Zind-dev/HowardTheChad_bot/storage"
 	"github.com/Zind-dev/HowardTheChad_bot/users"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -19,7 +34,7 @@ func TestNew(t *testing.T) {
 	mockStore := storage.NewMockStorage()
 
 	// This will fail with invalid token, which is expected in test environment
-	_, err := New(cfg, mockStore)
+	_, err := New(cfg, mockStore, Options{})
 	// We expect an error with invalid token
 	if err == nil {
 		t.Error("Expected error with invalid token")
@@ -43,7 +58,7 @@ func TestNew_WithRealToken(t *testing.T) {
 
 	mockStore := storage.NewMockStorage()
 
-	bot, err := New(cfg, mockStore)
+	bot, err := New(cfg, mockStore, Options{})
 	if err != nil {
 		t.Fatalf("Failed to create bot with valid token: %v", err)
 	}
@@ -72,6 +87,7 @@ func TestIsBotMentioned(t *testing.T) {
 			BotUsername: "testbot",
 		},
 		userManager: users.NewManager(),
+		mentionLog:  slog.New(slog.DiscardHandler),
 	}
 
 	tests := []struct {
@@ -155,7 +171,9 @@ func TestGenerateResponse(t *testing.T) {
 		config: &config.Config{
 			BotUsername: "testbot",
 		},
-		userManager: users.NewManager(),
+		userManager:    users.NewManager(),
+		responder:      responder.NewCanned(),
+		contextBuilder: chatcontext.NewBuilder(storage.NewMockStorage(), 0, chatcontext.Options{}),
 	}
 
 	tests := []struct {
@@ -168,6 +186,8 @@ func TestGenerateResponse(t *testing.T) {
 			name: "With user info",
 			message: &tgbotapi.Message{
 				Text: "Hello bot!",
+				Chat: &tgbotapi.Chat{ID: 1},
+				From: &tgbotapi.User{ID: 123},
 			},
 			userInfo: &users.User{
 				ID:        123,
@@ -180,6 +200,8 @@ func TestGenerateResponse(t *testing.T) {
 			name: "Without user info",
 			message: &tgbotapi.Message{
 				Text: "Hello bot!",
+				Chat: &tgbotapi.Chat{ID: 1},
+				From: &tgbotapi.User{ID: 123},
 			},
 			userInfo:    nil,
 			shouldCheck: true,
@@ -188,6 +210,8 @@ func TestGenerateResponse(t *testing.T) {
 			name: "Different message length",
 			message: &tgbotapi.Message{
 				Text: "A",
+				Chat: &tgbotapi.Chat{ID: 1},
+				From: &tgbotapi.User{ID: 123},
 			},
 			userInfo: &users.User{
 				FirstName: "Jane",
@@ -198,7 +222,7 @@ func TestGenerateResponse(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			response := bot.generateResponse(tt.message, tt.userInfo)
+			response := bot.generateResponse(context.Background(), tt.message, tt.userInfo)
 			if response == "" {
 				t.Error("generateResponse() returned empty string")
 			}
@@ -275,18 +299,1237 @@ func TestGetAllUsers(t *testing.T) {
 	}
 }
 
+// newTestBot creates a bot wired to a mock Telegram client and mock storage
+func newTestBot(t *testing.T) (*Bot, *MockTelegramClient, *storage.MockStorage) {
+	t.Helper()
+
+	cfg := &config.Config{
+		TelegramToken:     "test_token",
+		BotUsername:       "testbot",
+		ResponseFrequency: 3,
+		RespondToMentions: true,
+		Workers:           2,
+		QueueSize:         10,
+		ShutdownTimeout:   time.Second,
+	}
+	client := NewMockTelegramClient(tgbotapi.User{ID: 999, IsBot: true, UserName: "testbot", FirstName: "Howard"})
+	store := storage.NewMockStorage()
+
+	b, err := NewWithClient(cfg, store, client, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	return b, client, store
+}
+
+const testGroupID int64 = -100123
+
+// groupMessage builds an update for a message in the test group
+func groupMessage(userID int64, text string) tgbotapi.Update {
+	msg := &tgbotapi.Message{
+		MessageID: int(userID)*100 + len(text),
+		From:      &tgbotapi.User{ID: userID, UserName: fmt.Sprintf("user%d", userID), FirstName: "Alice"},
+		Chat:      &tgbotapi.Chat{ID: testGroupID, Type: "supergroup", Title: "Test Group"},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+	return tgbotapi.Update{Message: msg}
+}
+
+func TestNewWithClient(t *testing.T) {
+	b, client, _ := newTestBot(t)
+
+	// Calls go through the injected client, counting failures on the way
+	if wrapped, ok := b.api.(instrumentedClient); !ok || wrapped.TelegramClient != client {
+		t.Error("Bot should use the injected client")
+	}
+	if b.contextBuilder == nil || b.profiler == nil || b.responder == nil {
+		t.Error("Expected all components to be initialized")
+	}
+}
+
 func TestHandleMessage_PrivateChat(t *testing.T) {
-	t.Skip("Skipping test that requires mocked Telegram API")
-	// Note: To properly test this, we would need to:
-	// 1. Create an interface for the Telegram API
-	// 2. Implement a mock version
-	// 3. Inject the mock into the Bot struct
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+
+	b.handleUpdate(ctx, tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 7,
+		From:      &tgbotapi.User{ID: 42, FirstName: "Alice"},
+		Chat:      &tgbotapi.Chat{ID: 42, Type: "private"},
+		Text:      "hi",
+	}})
+
+	sent := client.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("Expected 1 reply, got %d", len(sent))
+	}
+	if sent[0].ChatID != 42 || sent[0].ReplyToMessageID != 7 {
+		t.Errorf("Expected reply to message 7 in chat 42, got chat %d reply-to %d", sent[0].ChatID, sent[0].ReplyToMessageID)
+	}
+	if !strings.Contains(sent[0].Text, "I can see you, Alice!") {
+		t.Errorf("Expected greeting with user's name, got '%s'", sent[0].Text)
+	}
+
+	// Both the incoming message and the reply are stored
+	messages, _ := store.GetRecentMessages(ctx, 42, 10)
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 stored messages, got %d", len(messages))
+	}
+	if !messages[1].IsBot || messages[1].UserID != 999 {
+		t.Errorf("Expected stored bot reply from user 999, got %+v", messages[1])
+	}
 }
 
 func TestHandleMessage_GroupChat(t *testing.T) {
-	t.Skip("Skipping test that requires mocked Telegram API")
-	// Note: To properly test this, we would need to:
-	// 1. Create an interface for the Telegram API
-	// 2. Implement a mock version
-	// 3. Inject the mock into the Bot struct
+	b, client, _ := newTestBot(t)
+	ctx := context.Background()
+
+	// Frequency is 3: only the third regular message gets a reply
+	for i := 1; i <= 3; i++ {
+		b.handleUpdate(ctx, groupMessage(1, fmt.Sprintf("message %d", i)))
+		want := 0
+		if i == 3 {
+			want = 1
+		}
+		if got := len(client.SentMessages()); got != want {
+			t.Fatalf("After message %d: expected %d replies, got %d", i, want, got)
+		}
+	}
+
+	// Mentions are always answered
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(1, "@testbot are you there?"))
+	sent := client.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("Expected a reply to the mention, got %d", len(sent))
+	}
+	if sent[0].ChatID != testGroupID {
+		t.Errorf("Expected reply in group %d, got %d", testGroupID, sent[0].ChatID)
+	}
+}
+
+func TestHandleMessage_StoresThread(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	sentAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	update := groupMessage(1, "@testbot are you there?")
+	update.Message.MessageID = 50
+	update.Message.Date = int(sentAt.Unix())
+	update.Message.ReplyToMessage = &tgbotapi.Message{MessageID: 49}
+	b.handleUpdate(ctx, update)
+
+	if len(client.SentMessages()) != 1 {
+		t.Fatalf("Expected a reply to the mention, got %d", len(client.SentMessages()))
+	}
+
+	incoming, _ := store.GetMessage(ctx, testGroupID, 50)
+	if incoming == nil || incoming.ReplyToMessageID != 49 || !incoming.Timestamp.Equal(sentAt) {
+		t.Errorf("Expected message 50 replying to 49 sent at %v, got %+v", sentAt, incoming)
+	}
+
+	// The bot's reply is stored under the ID Telegram assigned, as a reply
+	recent, _ := store.GetRecentMessages(ctx, testGroupID, 1)
+	if len(recent) != 1 || !recent[0].IsBot || recent[0].MessageID == 0 || recent[0].ReplyToMessageID != 50 {
+		t.Errorf("Expected stored bot reply to message 50, got %+v", recent)
+	}
+}
+
+func TestHandleUpdate_EditedMessage(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	editedAt := time.Date(2024, 3, 1, 12, 5, 0, 0, time.UTC)
+
+	original := groupMessage(1, "see you at 5")
+	b.handleUpdate(ctx, original)
+
+	edited := *original.Message
+	edited.Text = "see you at 6"
+	edited.EditDate = int(editedAt.Unix())
+	b.handleUpdate(ctx, tgbotapi.Update{EditedMessage: &edited})
+
+	stored, _ := store.GetMessage(ctx, testGroupID, original.Message.MessageID)
+	if stored == nil || stored.Text != "see you at 6" || !stored.EditedAt.Equal(editedAt) {
+		t.Fatalf("Expected edited text stored, got %+v", stored)
+	}
+	edits, _ := store.GetMessageEdits(ctx, testGroupID, original.Message.MessageID)
+	if len(edits) != 1 || edits[0].Text != "see you at 5" {
+		t.Errorf("Expected original text in edit history, got %+v", edits)
+	}
+
+	// Edits are not new messages: nothing is answered or counted
+	if len(client.SentMessages()) != 0 {
+		t.Errorf("Expected no replies to an edit, got %d", len(client.SentMessages()))
+	}
+	if chat := b.GetChatInfo(testGroupID); chat == nil || chat.MessageCount != 1 {
+		t.Errorf("Expected message count 1, got %+v", chat)
+	}
+
+	// Editing a photo changes its caption
+	photo := groupMessage(1, "")
+	photo.Message.MessageID = 60
+	photo.Message.Photo = []tgbotapi.PhotoSize{{FileID: "photo-1", Width: 90, Height: 90}}
+	photo.Message.Caption = "the view from the office"
+	b.handleUpdate(ctx, photo)
+
+	editedPhoto := *photo.Message
+	editedPhoto.Caption = "the view from the new office"
+	editedPhoto.EditDate = int(editedAt.Unix())
+	b.handleUpdate(ctx, tgbotapi.Update{EditedMessage: &editedPhoto})
+
+	stored, _ = store.GetMessage(ctx, testGroupID, 60)
+	if stored == nil || stored.Caption != "the view from the new office" || stored.FileID != "photo-1" {
+		t.Errorf("Expected edited caption stored, got %+v", stored)
+	}
+	edits, _ = store.GetMessageEdits(ctx, testGroupID, 60)
+	if len(edits) != 1 || edits[0].Caption != "the view from the office" {
+		t.Errorf("Expected original caption in edit history, got %+v", edits)
+	}
+}
+
+func TestHandleMessage_Media(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	client.SetChatMemberStatus(testGroupID, 1, "administrator")
+
+	sticker := func() tgbotapi.Update {
+		update := groupMessage(2, "")
+		update.Message.Sticker = &tgbotapi.Sticker{FileID: "sticker-1", Emoji: "😂"}
+		return update
+	}
+
+	// Media counts by default: frequency is 3, so the third message is answered
+	b.handleUpdate(ctx, groupMessage(2, "first"))
+	b.handleUpdate(ctx, sticker())
+	b.handleUpdate(ctx, sticker())
+	if got := len(client.SentMessages()); got != 1 {
+		t.Fatalf("Expected the third message (a sticker) to be answered, got %d replies", got)
+	}
+
+	recent, _ := store.GetRecentMessages(ctx, testGroupID, 2)
+	if len(recent) != 2 || recent[0].ContentType != storage.ContentSticker || recent[0].Text != "😂" || recent[0].FileID != "sticker-1" {
+		t.Errorf("Expected sticker stored with its emoji as text, got %+v", recent)
+	}
+
+	// Once toggled off, media is stored but not counted
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(1, "/togglemedia"))
+	sent := client.SentMessages()
+	if len(sent) != 1 || sent[0].Text != "✅ Media messages count toward response frequency: no" {
+		t.Fatalf("Expected toggle confirmation, got %+v", sent)
+	}
+
+	client.Reset()
+	before := b.GetChatInfo(testGroupID).MessageCount
+	for i := 0; i < 3; i++ {
+		b.handleUpdate(ctx, sticker())
+	}
+	if got := b.GetChatInfo(testGroupID).MessageCount; got != before {
+		t.Errorf("Expected ignored media to leave the count at %d, got %d", before, got)
+	}
+	if len(client.SentMessages()) != 0 {
+		t.Errorf("Expected no replies to ignored media, got %d", len(client.SentMessages()))
+	}
+	if recent, _ := store.GetRecentMessages(ctx, testGroupID, 100); len(recent) != 8 {
+		t.Errorf("Expected ignored media to still be stored (8 messages), got %d", len(recent))
+	}
+
+	// Ignored media still gets an answer when it mentions the bot
+	reply := sticker()
+	reply.Message.ReplyToMessage = &tgbotapi.Message{MessageID: 1, From: &tgbotapi.User{ID: 999, UserName: "testbot"}}
+	b.handleUpdate(ctx, reply)
+	if len(client.SentMessages()) != 1 {
+		t.Errorf("Expected a reply to media answering the bot, got %d", len(client.SentMessages()))
+	}
+	if got := b.GetChatInfo(testGroupID).MessageCount; got != before {
+		t.Errorf("Expected the mention to leave the count at %d, got %d", before, got)
+	}
+
+	// So does a caption mentioning it
+	client.Reset()
+	photo := groupMessage(2, "")
+	photo.Message.Photo = []tgbotapi.PhotoSize{{FileID: "photo-1", Width: 90, Height: 90}}
+	photo.Message.Caption = "what do you make of this @testbot"
+	photo.Message.CaptionEntities = []tgbotapi.MessageEntity{{Type: "mention", Offset: 25, Length: 8}}
+	b.handleUpdate(ctx, photo)
+	if len(client.SentMessages()) != 1 {
+		t.Errorf("Expected a reply to a caption mentioning the bot, got %d", len(client.SentMessages()))
+	}
+
+	client.Reset()
+	// Only admins may change it
+	b.handleUpdate(ctx, groupMessage(2, "/togglemedia"))
+	if sent := client.SentMessages(); len(sent) != 1 || !strings.HasPrefix(sent[0].Text, "❌") {
+		t.Errorf("Expected non-admin to be refused, got %+v", sent)
+	}
+}
+
+func TestHandleMessage_SendFailure(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	client.SetError("Send", errors.New("network down"))
+
+	b.handleUpdate(ctx, groupMessage(1, "@testbot hello"))
+
+	// The failed reply is not stored as if it had been sent
+	messages, _ := store.GetRecentMessages(ctx, testGroupID, 10)
+	if len(messages) != 1 {
+		t.Errorf("Expected only the incoming message to be stored, got %d", len(messages))
+	}
+}
+
+func TestHandleCommand_AdminChecks(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        string
+		command       string
+		wantReply     string
+		wantFrequency int
+	}{
+		{name: "Member cannot change frequency", status: "member", command: "/setfrequency 5", wantReply: "❌ Only administrators", wantFrequency: 3},
+		{name: "Admin changes frequency", status: "administrator", command: "/setfrequency 5", wantReply: "✅ Response frequency updated to: every 5 messages", wantFrequency: 5},
+		{name: "Creator changes frequency", status: "creator", command: "/setfrequency 1", wantReply: "✅", wantFrequency: 1},
+		{name: "Invalid frequency", status: "administrator", command: "/setfrequency lots", wantReply: "❌ Please provide a valid number", wantFrequency: 3},
+		{name: "Missing argument", status: "administrator", command: "/setfrequency", wantReply: "Usage: /setfrequency", wantFrequency: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, client, _ := newTestBot(t)
+			ctx := context.Background()
+			client.SetChatMemberStatus(testGroupID, 1, tt.status)
+
+			b.handleUpdate(ctx, groupMessage(1, tt.command))
+
+			sent := client.SentMessages()
+			if len(sent) != 1 {
+				t.Fatalf("Expected 1 reply, got %d", len(sent))
+			}
+			if !strings.HasPrefix(sent[0].Text, tt.wantReply) {
+				t.Errorf("Expected reply starting with '%s', got '%s'", tt.wantReply, sent[0].Text)
+			}
+			if got := b.GetSettings(ctx, testGroupID).ResponseFrequency; got != tt.wantFrequency {
+				t.Errorf("Expected frequency %d, got %d", tt.wantFrequency, got)
+			}
+		})
+	}
+}
+
+func TestHandleCommand_Settings(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	client.SetChatMemberStatus(testGroupID, 1, "administrator")
+
+	b.handleUpdate(ctx, groupMessage(1, "/togglementions"))
+	b.handleUpdate(ctx, groupMessage(2, "/settings"))
+
+	sent := client.SentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("Expected 2 replies, got %d", len(sent))
+	}
+	if sent[0].Text != "✅ Respond to mentions: disabled" {
+		t.Errorf("Unexpected toggle reply: '%s'", sent[0].Text)
+	}
+	if !strings.Contains(sent[1].Text, "Respond to Mentions: disabled") {
+		t.Errorf("Expected /settings to show mentions disabled, got '%s'", sent[1].Text)
+	}
+
+	// Settings were persisted through storage
+	stored, _ := store.GetChatSettings(ctx, testGroupID)
+	if stored == nil || stored.AlwaysRespondToMentions {
+		t.Errorf("Expected persisted settings with mentions disabled, got %+v", stored)
+	}
+
+	// Non-admins cannot reset
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(2, "/resetsettings"))
+	if sent := client.SentMessages(); len(sent) != 1 || !strings.HasPrefix(sent[0].Text, "❌") {
+		t.Errorf("Expected reset to be refused for a member, got %+v", sent)
+	}
+
+	// Admins can
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(1, "/resetsettings"))
+	if !b.GetSettings(ctx, testGroupID).AlwaysRespondToMentions {
+		t.Error("Expected settings to be reset to defaults")
+	}
+
+	// With no administrator list to fall back on, a failed lookup counts as not an admin
+	client.SetError("GetChatAdministrators", errors.New("timeout"))
+	b.admins.Forget(testGroupID)
+	b.handleUpdate(ctx, groupMessage(1, "/togglementions"))
+	if !b.GetSettings(ctx, testGroupID).AlwaysRespondToMentions {
+		t.Error("Expected toggle to be refused when the admin check fails")
+	}
+}
+
+func TestAdminCache(t *testing.T) {
+	b, client, _ := newTestBot(t)
+	ctx := context.Background()
+	client.SetChatMemberStatus(testGroupID, 1, "administrator")
+
+	// Admin commands share one administrator lookup
+	b.handleUpdate(ctx, groupMessage(1, "/setfrequency 5"))
+	b.handleUpdate(ctx, groupMessage(1, "/togglementions"))
+	b.handleUpdate(ctx, groupMessage(2, "/togglemedia"))
+	if got := client.AdminLookups(); got != 1 {
+		t.Errorf("Expected 1 administrator lookup for 3 commands, got %d", got)
+	}
+	if stats := b.admins.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss, got %+v", stats)
+	}
+
+	// A transient API failure falls back to the last known administrators
+	client.SetError("GetChatAdministrators", errors.New("timeout"))
+	b.handleUpdate(ctx, tgbotapi.Update{ChatMember: &tgbotapi.ChatMemberUpdated{
+		Chat:          tgbotapi.Chat{ID: testGroupID},
+		OldChatMember: tgbotapi.ChatMember{User: &tgbotapi.User{ID: 3}, Status: "member"},
+		NewChatMember: tgbotapi.ChatMember{User: &tgbotapi.User{ID: 3}, Status: "administrator"},
+	}})
+	b.handleUpdate(ctx, groupMessage(1, "/setfrequency 7"))
+	if got := b.GetSettings(ctx, testGroupID).ResponseFrequency; got != 7 {
+		t.Errorf("Expected a cached admin to keep access during an outage, got frequency %d", got)
+	}
+
+	// Once the API recovers, a demotion takes effect on the next command
+	client.SetError("GetChatAdministrators", nil)
+	client.SetChatMemberStatus(testGroupID, 1, "member")
+	b.handleUpdate(ctx, tgbotapi.Update{ChatMember: &tgbotapi.ChatMemberUpdated{
+		Chat:          tgbotapi.Chat{ID: testGroupID},
+		OldChatMember: tgbotapi.ChatMember{User: &tgbotapi.User{ID: 1}, Status: "administrator"},
+		NewChatMember: tgbotapi.ChatMember{User: &tgbotapi.User{ID: 1}, Status: "member"},
+	}})
+	b.handleUpdate(ctx, groupMessage(1, "/setfrequency 9"))
+	if got := b.GetSettings(ctx, testGroupID).ResponseFrequency; got != 7 {
+		t.Errorf("Expected a demoted admin to be refused, got frequency %d", got)
+	}
+
+	// The bot leaving a chat forgets its administrators
+	b.handleUpdate(ctx, tgbotapi.Update{MyChatMember: &tgbotapi.ChatMemberUpdated{
+		Chat:          tgbotapi.Chat{ID: testGroupID},
+		OldChatMember: tgbotapi.ChatMember{User: &tgbotapi.User{ID: 999}, Status: "member"},
+		NewChatMember: tgbotapi.ChatMember{User: &tgbotapi.User{ID: 999}, Status: "kicked"},
+	}})
+	if stats := b.admins.Stats(); stats.Chats != 0 {
+		t.Errorf("Expected the chat to be forgotten, got %+v", stats)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	client.SetChatMemberStatus(testGroupID, 1, "administrator")
+
+	// Users 2 and 3 have written in the chat; user 7 owns the bot
+	b.handleUpdate(ctx, groupMessage(2, "hello"))
+	b.handleUpdate(ctx, groupMessage(3, "hi"))
+	authorizer, err := permissions.New([]int64{7}, nil, b.admins, store, b.audit)
+	if err != nil {
+		t.Fatalf("Failed to create authorizer: %v", err)
+	}
+	b.permissions = authorizer
+
+	reply := func(userID int64, text string) string {
+		t.Helper()
+		client.Reset()
+		b.handleUpdate(ctx, groupMessage(userID, text))
+		sent := client.SentMessages()
+		if len(sent) != 1 {
+			t.Fatalf("Expected 1 reply to '%s', got %d", text, len(sent))
+		}
+		return sent[0].Text
+	}
+
+	// Members cannot change settings or grant roles
+	if got := reply(2, "/setfrequency 5"); got != "❌ Only administrators and bot moderators can change settings." {
+		t.Errorf("Unexpected refusal: '%s'", got)
+	}
+	if got := reply(2, "/grant @user3"); got != "❌ Only administrators can manage bot moderators." {
+		t.Errorf("Unexpected refusal: '%s'", got)
+	}
+
+	// An admin makes user 2 a moderator, by username or by replying
+	if got := reply(1, "/grant @User2"); got != "✅ @user2 is now a bot moderator and can change settings." {
+		t.Errorf("Unexpected grant reply: '%s'", got)
+	}
+	update := groupMessage(1, "/grant")
+	update.Message.ReplyToMessage = &tgbotapi.Message{MessageID: 1, From: &tgbotapi.User{ID: 2}}
+	client.Reset()
+	b.handleUpdate(ctx, update)
+	if sent := client.SentMessages(); len(sent) != 1 || !strings.Contains(sent[0].Text, "already a bot moderator") {
+		t.Errorf("Expected the repeated grant to be reported, got %+v", sent)
+	}
+	if got := reply(1, "/grant @nobody"); !strings.HasPrefix(got, "❌ I don't know that user") {
+		t.Errorf("Unexpected reply for an unknown user: '%s'", got)
+	}
+
+	// Moderators change settings but cannot reset them or grant roles
+	if got := reply(2, "/setfrequency 5"); !strings.HasPrefix(got, "✅") {
+		t.Errorf("Expected a moderator to change the frequency, got '%s'", got)
+	}
+	if got := reply(2, "/resetsettings"); got != "❌ Only administrators can change settings." {
+		t.Errorf("Unexpected refusal: '%s'", got)
+	}
+	if got := reply(2, "/grant 3"); !strings.HasPrefix(got, "❌ Only administrators") {
+		t.Errorf("Expected a moderator to be refused /grant, got '%s'", got)
+	}
+	if got := reply(3, "/moderators"); !strings.Contains(got, "• @user2 (added by @user1 on ") {
+		t.Errorf("Expected user 2 to be listed, got '%s'", got)
+	}
+
+	// Owners manage any chat without being admins there
+	if got := reply(7, "/resetsettings"); got != "✅ Settings reset to defaults." {
+		t.Errorf("Expected the owner to reset settings, got '%s'", got)
+	}
+	if got := reply(7, "/revoke 2"); got != "✅ @user2 is no longer a bot moderator." {
+		t.Errorf("Unexpected revoke reply: '%s'", got)
+	}
+	if got := reply(2, "/togglementions"); !strings.HasPrefix(got, "❌") {
+		t.Errorf("Expected a revoked moderator to be refused, got '%s'", got)
+	}
+	if roles, _ := store.GetChatRoles(ctx, testGroupID); len(roles) != 0 {
+		t.Errorf("Expected no stored roles, got %+v", roles)
+	}
+}
+
+func TestSettingsPanel(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	client.SetChatMemberStatus(testGroupID, 1, "administrator")
+
+	b.handleUpdate(ctx, groupMessage(2, "/settings"))
+	sent := client.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("Expected the settings panel, got %d messages", len(sent))
+	}
+	keyboard, ok := sent[0].ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok || len(keyboard.InlineKeyboard) != 3 {
+		t.Fatalf("Expected a 3-row inline keyboard, got %#v", sent[0].ReplyMarkup)
+	}
+
+	// buttonData finds a button's callback data by label
+	buttonData := func(label string) string {
+		for _, row := range keyboard.InlineKeyboard {
+			for _, button := range row {
+				if button.Text == label && button.CallbackData != nil {
+					return *button.CallbackData
+				}
+			}
+		}
+		t.Fatalf("No button labelled '%s'", label)
+		return ""
+	}
+	press := func(userID int64, chatID int64, data string) (*tgbotapi.EditMessageTextConfig, tgbotapi.CallbackConfig) {
+		t.Helper()
+		client.Reset()
+		b.handleUpdate(ctx, tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:      "query",
+			From:    &tgbotapi.User{ID: userID},
+			Message: &tgbotapi.Message{MessageID: 1001, Chat: &tgbotapi.Chat{ID: chatID, Type: "supergroup"}},
+			Data:    data,
+		}})
+
+		var edit *tgbotapi.EditMessageTextConfig
+		var answer tgbotapi.CallbackConfig
+		for _, request := range client.Requests() {
+			switch r := request.(type) {
+			case tgbotapi.EditMessageTextConfig:
+				edit = &r
+			case tgbotapi.CallbackConfig:
+				answer = r
+			}
+		}
+		if answer.CallbackQueryID != "query" {
+			t.Fatalf("Expected the button press to be answered, got %+v", client.Requests())
+		}
+		return edit, answer
+	}
+
+	// An admin press saves the setting and redraws the panel in place
+	edit, answer := press(1, testGroupID, buttonData("20"))
+	if b.GetSettings(ctx, testGroupID).ResponseFrequency != 20 {
+		t.Errorf("Expected frequency 20, got %d", b.GetSettings(ctx, testGroupID).ResponseFrequency)
+	}
+	if stored, _ := store.GetChatSettings(ctx, testGroupID); stored == nil || stored.ResponseFrequency != 20 {
+		t.Errorf("Expected frequency 20 to be persisted, got %+v", stored)
+	}
+	if edit == nil || edit.MessageID != 1001 || !strings.Contains(edit.Text, "every 20 messages") {
+		t.Fatalf("Expected panel message 1001 to be edited, got %+v", edit)
+	}
+	if row := edit.ReplyMarkup.InlineKeyboard[0]; row[len(row)-1].Text != "✓ 20" {
+		t.Errorf("Expected the new preset to be marked, got '%s'", row[len(row)-1].Text)
+	}
+	if answer.Text != "✅ Settings saved" {
+		t.Errorf("Unexpected answer: '%s'", answer.Text)
+	}
+
+	press(1, testGroupID, buttonData("Mentions: on"))
+	if b.GetSettings(ctx, testGroupID).AlwaysRespondToMentions {
+		t.Error("Expected mentions toggled off")
+	}
+
+	// Pressing the current value is not audited
+	press(1, testGroupID, buttonData("20"))
+	if entries, _ := store.GetAuditLog(ctx, testGroupID, 10, 0); len(entries) != 2 {
+		t.Errorf("Expected 2 audit entries for the 2 changes, got %+v", entries)
+	}
+
+	// Non-admins, forged, replayed and stale buttons change nothing
+	data := buttonData("Reset to defaults")
+	tests := []struct {
+		name   string
+		userID int64
+		chatID int64
+		data   string
+		answer string
+	}{
+		{name: "member", userID: 2, chatID: testGroupID, data: data, answer: "❌ Only administrators can change settings."},
+		{name: "forged", userID: 1, chatID: testGroupID, data: strings.Replace(buttonData("Off"), "|f|0|", "|r||", 1), answer: "❌ Invalid button."},
+		{name: "other chat", userID: 1, chatID: -100999, data: data, answer: "❌ Invalid button."},
+		{name: "old version", userID: 1, chatID: testGroupID, data: "0" + data[1:], answer: "⌛ This panel has expired. Send /settings for a new one."},
+	}
+	for _, tt := range tests {
+		edit, answer := press(tt.userID, tt.chatID, tt.data)
+		if edit != nil || answer.Text != tt.answer || !answer.ShowAlert {
+			t.Errorf("%s: expected alert '%s' and no edit, got '%s' (edit %v)", tt.name, tt.answer, answer.Text, edit != nil)
+		}
+	}
+	if got := b.GetSettings(ctx, testGroupID); got.ResponseFrequency != 20 || got.AlwaysRespondToMentions {
+		t.Errorf("Expected rejected presses to leave settings alone, got %+v", got)
+	}
+
+	press(1, testGroupID, data)
+	if got := b.GetSettings(ctx, testGroupID); got.ResponseFrequency != 3 || !got.AlwaysRespondToMentions {
+		t.Errorf("Expected reset to config defaults, got %+v", got)
+	}
+}
+
+func TestHandleCommand_SetRetention(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	client.SetChatMemberStatus(testGroupID, 1, "administrator")
+
+	b.handleUpdate(ctx, groupMessage(1, "/setretention 30d 5000"))
+	b.handleUpdate(ctx, groupMessage(1, "/setretention soon"))
+	b.handleUpdate(ctx, groupMessage(2, "/setretention off"))
+
+	sent := client.SentMessages()
+	if len(sent) != 3 {
+		t.Fatalf("Expected 3 replies, got %d", len(sent))
+	}
+	if sent[0].Text != "✅ Message retention updated to: last 30 days, at most 5000 messages" {
+		t.Errorf("Unexpected reply: '%s'", sent[0].Text)
+	}
+	if !strings.HasPrefix(sent[1].Text, "❌") || !strings.HasPrefix(sent[2].Text, "❌") {
+		t.Errorf("Expected invalid and non-admin requests to be refused, got '%s' and '%s'", sent[1].Text, sent[2].Text)
+	}
+
+	stored, _ := store.GetChatSettings(ctx, testGroupID)
+	if stored == nil || stored.RetentionMaxAge != 30*24*time.Hour || stored.RetentionMaxMessages != 5000 {
+		t.Errorf("Expected persisted retention 30d/5000, got %+v", stored)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		args        string
+		maxAge      time.Duration
+		maxMessages int
+		expectError bool
+	}{
+		{args: "off", maxAge: 0, maxMessages: 0},
+		{args: "30d", maxAge: 30 * 24 * time.Hour},
+		{args: "12h", maxAge: 12 * time.Hour},
+		{args: "5000", maxMessages: 5000},
+		{args: "1000 7d", maxAge: 7 * 24 * time.Hour, maxMessages: 1000},
+		{args: "0", expectError: true},
+		{args: "-5d", expectError: true},
+		{args: "7d 14d", expectError: true},
+		{args: "7d 10 20", expectError: true},
+		{args: "forever", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			maxAge, maxMessages, err := parseRetention(tt.args)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got %v/%d", maxAge, maxMessages)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if maxAge != tt.maxAge || maxMessages != tt.maxMessages {
+				t.Errorf("Expected %v/%d, got %v/%d", tt.maxAge, tt.maxMessages, maxAge, maxMessages)
+			}
+		})
+	}
+}
+
+func TestHandleCommand_Search(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	history := []*storage.Message{
+		{ChatID: testGroupID, UserID: 1, Text: "The release date is next Friday", Timestamp: day},
+		{ChatID: testGroupID, UserID: 2, Text: "Anyone up for lunch?", Timestamp: day.Add(time.Hour)},
+		{ChatID: testGroupID, UserID: 999, IsBot: true, Text: "I heard the release slipped", Timestamp: day.Add(2 * time.Hour)},
+	}
+	for _, msg := range history {
+		if err := store.SaveMessage(ctx, msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+	store.SaveUser(ctx, &storage.User{ID: 1, UserName: "alice", FirstName: "Alice"})
+
+	b.handleUpdate(ctx, groupMessage(2, "/search release"))
+	b.handleUpdate(ctx, groupMessage(2, "/search pizza"))
+	b.handleUpdate(ctx, groupMessage(2, "/search"))
+
+	sent := client.SentMessages()
+	if len(sent) != 3 {
+		t.Fatalf("Expected 3 replies, got %d", len(sent))
+	}
+
+	results := sent[0].Text
+	if !strings.Contains(results, "@testbot, 2024-03-01:\nI heard the release slipped") ||
+		!strings.Contains(results, "@alice, 2024-03-01:\nThe release date is next Friday") {
+		t.Errorf("Expected both matches with authors, got '%s'", results)
+	}
+	if strings.Index(results, "slipped") > strings.Index(results, "Friday") {
+		t.Errorf("Expected newest match first, got '%s'", results)
+	}
+	if strings.Contains(results, "/search") || strings.Contains(results, "lunch") {
+		t.Errorf("Expected only matching history, got '%s'", results)
+	}
+	if !strings.HasPrefix(sent[1].Text, "🔍 No messages found") {
+		t.Errorf("Expected no results reply, got '%s'", sent[1].Text)
+	}
+	if !strings.HasPrefix(sent[2].Text, "Usage: /search") {
+		t.Errorf("Expected usage reply, got '%s'", sent[2].Text)
+	}
+
+	// Earlier /search commands do not take up result slots
+	for i := range 4 {
+		store.SaveMessage(ctx, &storage.Message{ChatID: testGroupID, UserID: 1,
+			Text: fmt.Sprintf("release note %d", i), Timestamp: day.Add(time.Duration(3+i) * time.Hour)})
+	}
+	b.handleUpdate(ctx, groupMessage(2, "/search release"))
+	b.handleUpdate(ctx, groupMessage(2, "/search release"))
+	sent = client.SentMessages()
+	if results := sent[len(sent)-1].Text; strings.Count(results, "• ") != searchResultLimit {
+		t.Errorf("Expected a full page of %d results, got '%s'", searchResultLimit, results)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("a", 100) + " needle " + strings.Repeat("b", 100)
+
+	tests := []struct {
+		name     string
+		text     string
+		terms    []string
+		expected string
+	}{
+		{name: "short text", text: "short needle", terms: []string{"needle"}, expected: "short needle"},
+		{name: "match at start", text: "needle " + strings.Repeat("c", 100), terms: []string{"needle"},
+			expected: "needle " + strings.Repeat("c", 73) + "…"},
+		{name: "match in middle", text: long, terms: []string{"needle"},
+			expected: "…" + strings.Repeat("a", 19) + " needle " + strings.Repeat("b", 53) + "…"},
+		{name: "match at end", text: strings.Repeat("d", 100) + " needle", terms: []string{"needle"},
+			expected: "…" + strings.Repeat("d", 73) + " needle"},
+		{name: "no match", text: long, terms: []string{"missing"}, expected: strings.Repeat("a", 80) + "…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippet(tt.text, tt.terms); got != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStart_Polling(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Start(ctx)
+	}()
+
+	client.PushUpdate(groupMessage(1, "@testbot hello"))
+	client.PushUpdate(groupMessage(2, "just chatting"))
+
+	// Wait for both messages to be handled
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		messages, _ := store.GetRecentMessages(context.Background(), testGroupID, 10)
+		if len(messages) >= 3 { // two incoming plus the mention reply
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for updates to be handled, %d stored", len(messages))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Polls that return nothing still count as the bot receiving updates
+	polled := b.lastPoll.Load()
+	for b.lastPoll.Load() == polled {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for an empty poll, %d polls so far", client.Polls())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if detail, _ := b.checkUpdates(context.Background()); detail != "last poll 0s ago" {
+		t.Errorf("Expected a recent poll, got '%s'", detail)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start did not return after cancellation")
+	}
+
+	// Polling mode clears any webhook left over from webhook mode
+	requests := client.Requests()
+	if len(requests) == 0 {
+		t.Fatal("Expected a deleteWebhook request")
+	}
+	if _, ok := requests[0].(tgbotapi.DeleteWebhookConfig); !ok {
+		t.Errorf("Expected DeleteWebhookConfig, got %T", requests[0])
+	}
+	if len(client.SentMessages()) != 1 {
+		t.Errorf("Expected 1 reply, got %d", len(client.SentMessages()))
+	}
+}
+
+func TestMessageCounts_SurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMockStorage()
+	client := NewMockTelegramClient(tgbotapi.User{ID: 999, IsBot: true, UserName: "testbot"})
+	cfg := &config.Config{BotUsername: "testbot", ResponseFrequency: 3, RespondToMentions: true}
+
+	// First run: two regular messages, then shut down
+	counters := storage.NewCounterBuffer(store)
+	first, err := NewWithClient(cfg, counters, client, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	first.handleUpdate(ctx, groupMessage(1, "one"))
+	first.handleUpdate(ctx, groupMessage(1, "two"))
+	if err := counters.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	chat, _ := store.GetChat(ctx, testGroupID)
+	if chat == nil || chat.MessageCount != 2 {
+		t.Fatalf("Expected stored chat count 2, got %+v", chat)
+	}
+	user, _ := store.GetUser(ctx, 1)
+	if user == nil || user.MessageCount != 2 {
+		t.Fatalf("Expected stored user count 2, got %+v", user)
+	}
+
+	// Second run continues the cadence: the next message is the third
+	second, err := NewWithClient(cfg, storage.NewCounterBuffer(store), client, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	second.handleUpdate(ctx, groupMessage(1, "three"))
+
+	if len(client.SentMessages()) != 1 {
+		t.Errorf("Expected a reply on the third message across restarts, got %d replies", len(client.SentMessages()))
+	}
+	if count := second.GetChatInfo(testGroupID).MessageCount; count != 3 {
+		t.Errorf("Expected chat count 3 after restart, got %d", count)
+	}
+	if count := second.GetUserInfo(1).MessageCount; count != 3 {
+		t.Errorf("Expected user count 3 after restart, got %d", count)
+	}
+
+	// Unchanged metadata is not rewritten, so the stored count is not reset
+	user, _ = store.GetUser(ctx, 1)
+	if user.MessageCount != 2 {
+		t.Errorf("Expected stored user count to stay 2 until the next flush, got %d", user.MessageCount)
+	}
+}
+
+// loadFailingStorage is storage whose GetChat and GetUser fail with err when
+// it is set
+type loadFailingStorage struct {
+	storage.Storage
+	err error
+}
+
+func (s *loadFailingStorage) GetChat(ctx context.Context, chatID int64) (*storage.Chat, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Storage.GetChat(ctx, chatID)
+}
+
+func (s *loadFailingStorage) GetUser(ctx context.Context, userID int64) (*storage.User, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Storage.GetUser(ctx, userID)
+}
+
+func TestMessageCounts_LoadFailure(t *testing.T) {
+	ctx := context.Background()
+	mock := storage.NewMockStorage()
+	mock.SaveChat(ctx, &storage.Chat{ID: testGroupID, Title: "Test Group", Type: "supergroup", MessageCount: 50})
+	mock.SaveUser(ctx, &storage.User{ID: 1, UserName: "user1", FirstName: "User", MessageCount: 20})
+	store := &loadFailingStorage{Storage: mock, err: errors.New("database is locked")}
+
+	client := NewMockTelegramClient(tgbotapi.User{ID: 999, IsBot: true, UserName: "testbot"})
+	cfg := &config.Config{BotUsername: "testbot", ResponseFrequency: 1, RespondToMentions: true}
+	b, err := NewWithClient(cfg, store, client, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	// Stored counts are kept while they cannot be loaded
+	b.handleUpdate(ctx, groupMessage(1, "hello"))
+	if chat, _ := mock.GetChat(ctx, testGroupID); chat.MessageCount != 50 {
+		t.Errorf("Expected stored chat count 50 to be kept, got %d", chat.MessageCount)
+	}
+	if user, _ := mock.GetUser(ctx, 1); user.MessageCount != 20 {
+		t.Errorf("Expected stored user count 20 to be kept, got %d", user.MessageCount)
+	}
+	if len(client.SentMessages()) != 0 {
+		t.Errorf("Expected no frequency reply without a count, got %d replies", len(client.SentMessages()))
+	}
+
+	// Once storage recovers the counts resume
+	store.err = nil
+	b.handleUpdate(ctx, groupMessage(1, "again"))
+	if count := b.GetChatInfo(testGroupID).MessageCount; count != 51 {
+		t.Errorf("Expected chat count 51, got %d", count)
+	}
+	if count := b.GetUserInfo(1).MessageCount; count != 21 {
+		t.Errorf("Expected user count 21, got %d", count)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	b, client, store := newTestBot(t)
+	ctx := context.Background()
+	client.SetChatMemberStatus(testGroupID, 1, "administrator")
+
+	b.handleUpdate(ctx, groupMessage(2, "hello"))
+	b.handleUpdate(ctx, groupMessage(1, "/setfrequency 5"))
+	b.handleUpdate(ctx, groupMessage(1, "/togglementions"))
+	b.handleUpdate(ctx, groupMessage(2, "/resetsettings"))
+	b.handleUpdate(ctx, groupMessage(1, "/grant 2"))
+
+	entries, err := store.GetAuditLog(ctx, testGroupID, 10, 0)
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("Expected 4 audit entries, got %d (error %v)", len(entries), err)
+	}
+	want := []struct {
+		actor              int64
+		action, old, value string
+	}{
+		{1, "grant", "", "user 2: moderator"},
+		{2, "denied", "", "/resetsettings (requires admin)"},
+		{1, "togglementions", "on", "off"},
+		{1, "setfrequency", "3", "5"},
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.ActorID != w.actor || e.Action != w.action || e.OldValue != w.old || e.NewValue != w.value {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, w, e)
+		}
+	}
+
+	// Only admins may read the log
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(2, "/auditlog"))
+	if sent := client.SentMessages(); len(sent) != 1 || sent[0].Text != "❌ Only administrators can view the audit log." {
+		t.Fatalf("Expected a refusal, got %+v", sent)
+	}
+
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(1, "/auditlog"))
+	sent := client.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("Expected 1 reply, got %d", len(sent))
+	}
+	for _, line := range []string{"@user1 /setfrequency: 3 → 5", "@user2 was denied /auditlog (requires admin)", "@user1 /grant: user 2: moderator"} {
+		if !strings.Contains(sent[0].Text, line) {
+			t.Errorf("Expected '%s' in:\n%s", line, sent[0].Text)
+		}
+	}
+	if strings.Contains(sent[0].Text, "/auditlog 2") {
+		t.Errorf("Expected no next page, got:\n%s", sent[0].Text)
+	}
+
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(1, "/auditlog export"))
+	docs := client.SentDocuments()
+	if len(docs) != 1 {
+		t.Fatalf("Expected 1 document, got %d", len(docs))
+	}
+	file, ok := docs[0].File.(tgbotapi.FileBytes)
+	if !ok {
+		t.Fatalf("Expected file bytes, got %T", docs[0].File)
+	}
+	lines := strings.Split(strings.TrimSpace(string(file.Bytes)), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[0], `"action":"setfrequency"`) {
+		t.Errorf("Expected 5 JSON lines oldest first, got:\n%s", file.Bytes)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	b, client, _ := newTestBot(t)
+	ctx := context.Background()
+	client.SetChatMemberStatus(testGroupID, 1, "administrator")
+
+	// Another group customizes its frequency
+	client.SetChatMemberStatus(-100456, 1, "administrator")
+	other := groupMessage(1, "/setfrequency 2")
+	other.Message.Chat.ID = -100456
+	b.handleUpdate(ctx, other)
+
+	cfg := config.Default()
+	cfg.ResponseFrequency = 1
+	b.ApplyConfig(cfg)
+
+	if got := b.settingsManager.GetSettings(ctx, testGroupID).ResponseFrequency; got != 1 {
+		t.Errorf("Expected the reloaded default frequency 1, got %d", got)
+	}
+	if got := b.settingsManager.GetSettings(ctx, -100456).ResponseFrequency; got != 2 {
+		t.Errorf("Expected the customized frequency 2 to be kept, got %d", got)
+	}
+
+	// Every message now gets a reply
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(2, "hello"))
+	if len(client.SentMessages()) != 1 {
+		t.Errorf("Expected a reply with frequency 1, got %d", len(client.SentMessages()))
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf strings.Builder
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelDebug)
+	logger := logging.New(&buf, logging.Options{Level: level, RedactUserIDs: true, MessageText: logging.TextDrop})
+
+	cfg := &config.Config{
+		TelegramToken:     "test_token",
+		BotUsername:       "testbot",
+		ResponseFrequency: 10,
+		RespondToMentions: true,
+	}
+	client := NewMockTelegramClient(tgbotapi.User{ID: 999, IsBot: true, UserName: "testbot"})
+	b, err := NewWithClient(cfg, storage.NewMockStorage(), client, Options{Logger: logger})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	ctx := context.Background()
+
+	b.handleUpdate(ctx, groupMessage(42, "my secret plans"))
+	out := buf.String()
+	if !strings.Contains(out, `msg="Message received" component=bot`) || !strings.Contains(out, "text_len=15") {
+		t.Errorf("Expected the message to be logged with its length, got:\n%s", out)
+	}
+	for _, leaked := range []string{"secret plans", "user42", "user_id=42"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("Expected %q to be redacted, got:\n%s", leaked, out)
+		}
+	}
+	if strings.Contains(out, "Checking mention") {
+		t.Errorf("Expected no mention trace by default, got:\n%s", out)
+	}
+
+	// Tracing is switched on by a reload, independently of the level
+	level.Set(slog.LevelInfo)
+	traced := *cfg
+	traced.Logging.TraceMentions = true
+	b.ApplyConfig(&traced)
+	buf.Reset()
+
+	b.handleUpdate(ctx, groupMessage(42, "hi @testbot"))
+	out = buf.String()
+	if !strings.Contains(out, `msg="Checking mention" component=mentions`) ||
+		!strings.Contains(out, `msg="Detected mention via text"`) {
+		t.Errorf("Expected the mention trace, got:\n%s", out)
+	}
+	if strings.Contains(out, "Message received") {
+		t.Errorf("Expected other debug output to stay off, got:\n%s", out)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	cfg := &config.Config{
+		TelegramToken:     "test_token",
+		BotUsername:       "testbot",
+		ResponseFrequency: 2,
+		RespondToMentions: true,
+	}
+	client := NewMockTelegramClient(tgbotapi.User{ID: 999, IsBot: true, UserName: "testbot"})
+	b, err := NewWithClient(cfg, storage.NewMockStorage(), client, Options{Metrics: reg})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	ctx := context.Background()
+
+	b.handleUpdate(ctx, groupMessage(1, "hello"))
+	b.handleUpdate(ctx, groupMessage(1, "again")) // Every 2nd message
+	b.handleUpdate(ctx, groupMessage(2, "@testbot hi"))
+	b.handleUpdate(ctx, groupMessage(2, "/help"))
+	b.handleUpdate(ctx, tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 7,
+		From:      &tgbotapi.User{ID: 42, FirstName: "Alice"},
+		Chat:      &tgbotapi.Chat{ID: 42, Type: "private"},
+		Text:      "hi",
+	}})
+	b.handleUpdate(ctx, tgbotapi.Update{EditedMessage: groupMessage(1, "hello!").Message})
+	client.SetError("Send", errors.New("network down"))
+	b.handleUpdate(ctx, groupMessage(2, "@testbot still there?"))
+
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	scraped := string(body)
+
+	for _, line := range []string{
+		`howard_updates_received_total{type="message"} 6`,
+		`howard_updates_received_total{type="edited_message"} 1`,
+		`howard_update_handling_duration_seconds_count{type="message"} 6`,
+		`howard_responses_sent_total{reason="command"} 1`,
+		`howard_responses_sent_total{reason="frequency"} 1`,
+		`howard_responses_sent_total{reason="mention"} 1`,
+		`howard_responses_sent_total{reason="private"} 1`,
+		`howard_telegram_api_errors_total{method="sendMessage"} 1`,
+		`howard_active_chats 2`, // The group and the private chat
+	} {
+		if !strings.Contains(scraped, line+"\n") {
+			t.Errorf("Expected %q in the scrape, got:\n%s", line, scraped)
+		}
+	}
+}
+
+// pingStorage is storage whose Ping fails with err when it is set
+type pingStorage struct {
+	storage.Storage
+	err error
+}
+
+func (s *pingStorage) Ping(ctx context.Context) error { return s.err }
+
+func TestHealth(t *testing.T) {
+	cfg := config.Default()
+	cfg.TelegramToken = "test_token"
+	cfg.BotUsername = "testbot"
+	client := NewMockTelegramClient(tgbotapi.User{ID: 999, IsBot: true, UserName: "testbot"})
+	store := &pingStorage{Storage: storage.NewMockStorage()}
+	b, err := NewWithClient(cfg, store, client, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	ctx := context.Background()
+	client.SetChatMemberStatus(testGroupID, 1, "administrator")
+
+	server := httptest.NewServer(b.Health())
+	defer server.Close()
+	probe := func() int {
+		t.Helper()
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Failed to probe readiness: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := probe(); status != http.StatusOK {
+		t.Errorf("Expected ready after startup, got %d", status)
+	}
+	b.handleUpdate(ctx, groupMessage(1, "/ping"))
+	sent := client.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("Expected 1 reply, got %d", len(sent))
+	}
+	for _, part := range []string{"All checks passed", "✅ storage (", "✅ telegram (", "@testbot", "no polls since start 0s ago", "Total: "} {
+		if !strings.Contains(sent[0].Text, part) {
+			t.Errorf("Expected '%s' in:\n%s", part, sent[0].Text)
+		}
+	}
+
+	// Only admins may run diagnostics
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(2, "/ping"))
+	if sent := client.SentMessages(); len(sent) != 1 || sent[0].Text != "❌ Only administrators can run diagnostics." {
+		t.Errorf("Expected a refusal, got %+v", sent)
+	}
+
+	store.err = errors.New("database is locked")
+	client.SetError("GetMe", errors.New("network down"))
+	if status := probe(); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready with storage and Telegram down, got %d", status)
+	}
+	client.Reset()
+	b.handleUpdate(ctx, groupMessage(1, "/ping"))
+	sent = client.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("Expected 1 reply, got %d", len(sent))
+	}
+	for _, part := range []string{"Some checks failed", "❌ storage (", "database is locked", "❌ telegram (", "network down", "✅ updates ("} {
+		if !strings.Contains(sent[0].Text, part) {
+			t.Errorf("Expected '%s' in:\n%s", part, sent[0].Text)
+		}
+	}
+
+	// Polling stopped: updates handled since then do not hide it
+	store.err = nil
+	client.SetError("GetMe", nil)
+	b.lastPoll.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+	results := b.Health().Run(ctx)
+	if updates := results[2]; updates.OK() || !strings.Contains(updates.Err.Error(), "last poll 2h0m0s ago, limit is 1h0m0s") {
+		t.Errorf("Expected the poll age check to fail, got %+v", updates)
+	}
+
+	// The limit follows reloads
+	cfg = config.Default()
+	cfg.Monitoring.MaxUpdateAge = 3 * time.Hour
+	b.ApplyConfig(cfg)
+	if status := probe(); status != http.StatusOK {
+		t.Errorf("Expected ready with a 3h limit, got %d", status)
+	}
+	// In webhook mode a quiet spell does not take the bot out of service,
+	// but /ping still reports it
+	cfg.Transport.Mode = config.ModeWebhook
+	webhookBot, err := NewWithClient(cfg, storage.NewMockStorage(), client, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	webhookBot.lastUpdate.Store(time.Now().Add(-4 * time.Hour).UnixNano())
+	if results := webhookBot.Health().Run(ctx); !health.Ready(results) || len(results) != 2 {
+		t.Errorf("Expected readiness without the update age in webhook mode, got %+v", results)
+	}
+	if results := webhookBot.diagnostics.Run(ctx); len(results) != 3 || results[2].OK() {
+		t.Errorf("Expected /ping to report the stale updates, got %+v", results)
+	}
 }
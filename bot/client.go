@@ -0,0 +1,32 @@
+package bot
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TelegramClient is the subset of the Telegram Bot API the bot uses.
+// *tgbotapi.BotAPI is adapted to it in New; tests use MockTelegramClient.
+type TelegramClient interface {
+	// Self returns the bot's own account
+	Self() tgbotapi.User
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	GetChatAdministrators(config tgbotapi.ChatAdministratorsConfig) ([]tgbotapi.ChatMember, error)
+	// GetMe fetches the bot's own account, checking that Telegram is reachable
+	GetMe() (tgbotapi.User, error)
+	// GetUpdates long-polls for updates from config.Offset on
+	GetUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error)
+	// MakeRequest calls API methods that have no Chattable config, such as
+	// setWebhook with a secret token
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+}
+
+// apiClient adapts *tgbotapi.BotAPI to TelegramClient
+type apiClient struct {
+	*tgbotapi.BotAPI
+}
+
+// Self returns the account the API was authorized as
+func (c apiClient) Self() tgbotapi.User {
+	return c.BotAPI.Self
+}
@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/Zind-dev/HowardTheChad_bot/config"
+	"github.com/Zind-dev/HowardTheChad_bot/health"
+)
+
+// healthCheckTimeout bounds each readiness check
+const healthCheckTimeout = 5 * time.Second
+
+// newHealthCheckers builds the checks served as /readyz and the fuller set
+// /ping runs. In webhook mode readiness leaves out the update age: a pod
+// taken out of service for a quiet spell could no longer receive the
+// webhook, and so would never become ready again.
+func (b *Bot) newHealthCheckers() (readiness, diagnostics *health.Checker) {
+	storage := health.Check{Name: "storage", Run: b.checkStorage}
+	telegram := health.Check{Name: "telegram", Run: b.checkTelegram}
+	updates := health.Check{Name: "updates", Run: b.checkUpdates}
+
+	diagnostics = health.NewChecker(healthCheckTimeout, storage, telegram, updates)
+	if b.config.Transport.Mode == config.ModeWebhook {
+		return health.NewChecker(healthCheckTimeout, storage, telegram), diagnostics
+	}
+	return diagnostics, diagnostics
+}
+
+// Health returns the bot's readiness checks, to be served as /readyz
+func (b *Bot) Health() *health.Checker {
+	return b.health
+}
+
+// checkStorage runs a trivial query against the database
+func (b *Bot) checkStorage(ctx context.Context) (string, error) {
+	return "", b.storage.Ping(ctx)
+}
+
+// checkTelegram calls getMe to check the Bot API is reachable
+func (b *Bot) checkTelegram(ctx context.Context) (string, error) {
+	me, err := b.api.GetMe()
+	if err != nil {
+		return "", err
+	}
+	return "@" + me.UserName, nil
+}
+
+// checkUpdates fails when updates have not been received within the
+// configured age. In polling mode that is the last getUpdates call to
+// return, empty or not, which catches polling that silently stopped without
+// failing quiet chats. In webhook mode it is the last update delivered.
+// Before the first the age counts from startup.
+func (b *Bot) checkUpdates(ctx context.Context) (string, error) {
+	limit := time.Duration(b.maxUpdateAge.Load())
+	last, what := b.lastPoll.Load(), "poll"
+	if b.config.Transport.Mode == config.ModeWebhook {
+		last, what = b.lastUpdate.Load(), "update"
+	}
+
+	var age time.Duration
+	var detail string
+	if last != 0 {
+		age = time.Since(time.Unix(0, last))
+		detail = fmt.Sprintf("last %s %s ago", what, formatAge(age))
+	} else {
+		age = time.Since(b.started)
+		detail = fmt.Sprintf("no %ss since start %s ago", what, formatAge(age))
+	}
+
+	if age > limit {
+		return detail, fmt.Errorf("%s, limit is %s", detail, limit)
+	}
+	return detail, nil
+}
+
+// formatAge rounds an age to whole seconds for display
+func formatAge(age time.Duration) string {
+	return age.Round(time.Second).String()
+}
+
+// handlePingCommand runs every health check, including the update age in
+// webhook mode, and reports each with its latency
+func (b *Bot) handlePingCommand(ctx context.Context, message *tgbotapi.Message) {
+	if !b.authorize(ctx, message, "ping") {
+		return
+	}
+
+	start := time.Now()
+	results := b.diagnostics.Run(ctx)
+
+	header := "🏓 Pong! All checks passed."
+	if !health.Ready(results) {
+		header = "🏓 Pong! Some checks failed."
+	}
+	lines := []string{header, ""}
+	for _, result := range results {
+		lines = append(lines, formatCheckResult(result))
+	}
+	lines = append(lines, "", "Total: "+formatLatency(time.Since(start)))
+
+	b.sendMessage(message.Chat.ID, strings.Join(lines, "\n"), message.MessageID)
+}
+
+// formatCheckResult formats a check as one /ping line
+func formatCheckResult(result health.Result) string {
+	line := "✅ " + result.Name
+	if !result.OK() {
+		line = "❌ " + result.Name
+	}
+	line += " (" + formatLatency(result.Latency) + ")"
+
+	switch {
+	case result.Err != nil:
+		line += ": " + result.Err.Error()
+	case result.Detail != "":
+		line += ": " + result.Detail
+	}
+	return line
+}
+
+// formatLatency shows a latency in milliseconds, with a decimal below 10ms
+func formatLatency(d time.Duration) string {
+	ms := float64(d.Microseconds()) / 1000
+	if ms < 10 {
+		return fmt.Sprintf("%.1fms", ms)
+	}
+	return fmt.Sprintf("%.0fms", ms)
+}
@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/chats"
+	"github.com/Zind-dev/HowardTheChad_bot/metrics"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Why a response was sent, as counted in howard_responses_sent_total
+const (
+	reasonMention   = "mention"   // The bot was mentioned or replied to
+	reasonFrequency = "frequency" // Every Nth message in a group
+	reasonPrivate   = "private"   // A private chat
+	reasonCommand   = "command"   // A reply to a command
+)
+
+// botMetrics are the bot's own metrics; storage registers its own
+type botMetrics struct {
+	updates   *metrics.Counter   // By update type
+	handling  *metrics.Histogram // By update type
+	responses *metrics.Counter   // By reason
+	apiErrors *metrics.Counter   // By Telegram method
+}
+
+// newBotMetrics registers the bot's metrics with reg
+func newBotMetrics(reg *metrics.Registry, chatManager *chats.Manager) *botMetrics {
+	reg.GaugeFunc("howard_active_chats", "Chats that have sent a message since the bot started.", func() float64 {
+		return float64(len(chatManager.GetAllChats()))
+	})
+	return &botMetrics{
+		updates: reg.Counter("howard_updates_received_total",
+			"Updates received from Telegram.", "type"),
+		handling: reg.Histogram("howard_update_handling_duration_seconds",
+			"Time taken to handle an update, including replies.", nil, "type"),
+		responses: reg.Counter("howard_responses_sent_total",
+			"Messages sent by the bot, by why they were sent.", "reason"),
+		apiErrors: reg.Counter("howard_telegram_api_errors_total",
+			"Telegram Bot API calls that failed.", "method"),
+	}
+}
+
+// updateType names the kind of an update the way allowedUpdates does
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.MyChatMember != nil:
+		return "my_chat_member"
+	case update.ChatMember != nil:
+		return "chat_member"
+	default:
+		return "other"
+	}
+}
+
+// instrumentedClient counts failed calls to the Telegram Bot API
+type instrumentedClient struct {
+	TelegramClient
+	errors *metrics.Counter
+}
+
+func (c instrumentedClient) Send(chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg, err := c.TelegramClient.Send(chattable)
+	c.count(apiMethod(chattable), err)
+	return msg, err
+}
+
+func (c instrumentedClient) Request(chattable tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	resp, err := c.TelegramClient.Request(chattable)
+	c.count(apiMethod(chattable), err)
+	return resp, err
+}
+
+func (c instrumentedClient) GetChatAdministrators(config tgbotapi.ChatAdministratorsConfig) ([]tgbotapi.ChatMember, error) {
+	members, err := c.TelegramClient.GetChatAdministrators(config)
+	c.count("getChatAdministrators", err)
+	return members, err
+}
+
+func (c instrumentedClient) GetMe() (tgbotapi.User, error) {
+	user, err := c.TelegramClient.GetMe()
+	c.count("getMe", err)
+	return user, err
+}
+
+func (c instrumentedClient) GetUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	updates, err := c.TelegramClient.GetUpdates(config)
+	c.count("getUpdates", err)
+	return updates, err
+}
+
+func (c instrumentedClient) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	resp, err := c.TelegramClient.MakeRequest(endpoint, params)
+	c.count(endpoint, err)
+	return resp, err
+}
+
+func (c instrumentedClient) count(method string, err error) {
+	if err != nil {
+		c.errors.Inc(method)
+	}
+}
+
+// apiMethod returns the Bot API method a config is sent with
+func apiMethod(c tgbotapi.Chattable) string {
+	switch c.(type) {
+	case tgbotapi.MessageConfig:
+		return "sendMessage"
+	case tgbotapi.DocumentConfig:
+		return "sendDocument"
+	case tgbotapi.EditMessageTextConfig:
+		return "editMessageText"
+	case tgbotapi.CallbackConfig:
+		return "answerCallbackQuery"
+	case tgbotapi.DeleteWebhookConfig:
+		return "deleteWebhook"
+	default:
+		return "other"
+	}
+}
+
+// send sends c and counts it as a response for reason if it went through
+func (b *Bot) send(c tgbotapi.Chattable, reason string) (tgbotapi.Message, error) {
+	sent, err := b.api.Send(c)
+	if err == nil {
+		b.metrics.responses.Inc(reason)
+	}
+	return sent, err
+}
+
+// observeUpdate counts an update and how long handling it took
+func (b *Bot) observeUpdate(update tgbotapi.Update, start time.Time) {
+	kind := updateType(update)
+	b.metrics.updates.Inc(kind)
+	b.metrics.handling.ObserveSince(start, kind)
+}
@@ -0,0 +1,257 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MockTelegramClient is an in-memory TelegramClient for testing.
+// It records everything sent and answers GetChatAdministrators from scripted
+// member statuses.
+type MockTelegramClient struct {
+	self         tgbotapi.User
+	sent         []tgbotapi.Chattable
+	requests     []tgbotapi.Chattable
+	calls        []string            // Raw MakeRequest endpoints
+	members      map[[2]int64]string // key: {chatID, userID}
+	adminLookups int
+	errors       map[string]error // Scripted failures by method name
+	pending      []tgbotapi.Update // Pushed updates not yet confirmed by an offset
+	pushed       chan struct{}     // Wakes a waiting GetUpdates
+	lastUpdateID int
+	polls        int
+	nextID       int
+	mu           sync.Mutex
+}
+
+// mockPollTimeout stands in for the long-poll timeout, so an empty
+// GetUpdates returns quickly in tests
+const mockPollTimeout = 20 * time.Millisecond
+
+// NewMockTelegramClient creates a mock client authorized as self
+func NewMockTelegramClient(self tgbotapi.User) *MockTelegramClient {
+	return &MockTelegramClient{
+		self:    self,
+		members: make(map[[2]int64]string),
+		errors:  make(map[string]error),
+		pushed:  make(chan struct{}, 1),
+		nextID:  1000,
+	}
+}
+
+func (m *MockTelegramClient) Self() tgbotapi.User { return m.self }
+
+func (m *MockTelegramClient) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errors["Send"]; err != nil {
+		return tgbotapi.Message{}, err
+	}
+	m.sent = append(m.sent, c)
+	m.nextID++
+
+	msg := tgbotapi.Message{MessageID: m.nextID, From: &m.self}
+	if cfg, ok := c.(tgbotapi.MessageConfig); ok {
+		msg.Chat = &tgbotapi.Chat{ID: cfg.ChatID}
+		msg.Text = cfg.Text
+		if cfg.ReplyToMessageID != 0 {
+			msg.ReplyToMessage = &tgbotapi.Message{MessageID: cfg.ReplyToMessageID}
+		}
+	}
+	return msg, nil
+}
+
+func (m *MockTelegramClient) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errors["Request"]; err != nil {
+		return nil, err
+	}
+	m.requests = append(m.requests, c)
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// GetChatAdministrators returns the users scripted as "creator" or
+// "administrator" in the chat
+func (m *MockTelegramClient) GetChatAdministrators(config tgbotapi.ChatAdministratorsConfig) ([]tgbotapi.ChatMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.adminLookups++
+	if err := m.errors["GetChatAdministrators"]; err != nil {
+		return nil, err
+	}
+
+	var admins []tgbotapi.ChatMember
+	for key, status := range m.members {
+		if key[0] == config.ChatID && (status == "creator" || status == "administrator") {
+			admins = append(admins, tgbotapi.ChatMember{
+				User:   &tgbotapi.User{ID: key[1]},
+				Status: status,
+			})
+		}
+	}
+	return admins, nil
+}
+
+// GetUpdates returns the pushed updates from config.Offset on, like
+// Telegram: updates below the offset are confirmed and dropped. With none
+// pending it waits up to mockPollTimeout for one and may return empty.
+func (m *MockTelegramClient) GetUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	timeout := time.NewTimer(mockPollTimeout)
+	defer timeout.Stop()
+
+	for {
+		m.mu.Lock()
+		if err := m.errors["GetUpdates"]; err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		for len(m.pending) > 0 && m.pending[0].UpdateID < config.Offset {
+			m.pending = m.pending[1:]
+		}
+		if len(m.pending) > 0 {
+			m.polls++
+			updates := append([]tgbotapi.Update(nil), m.pending...)
+			m.mu.Unlock()
+			return updates, nil
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-m.pushed:
+		case <-timeout.C:
+			m.mu.Lock()
+			m.polls++
+			m.mu.Unlock()
+			return nil, nil
+		}
+	}
+}
+
+// Polls returns how many GetUpdates calls have completed
+func (m *MockTelegramClient) Polls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.polls
+}
+
+func (m *MockTelegramClient) GetMe() (tgbotapi.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errors["GetMe"]; err != nil {
+		return tgbotapi.User{}, err
+	}
+	return m.self, nil
+}
+
+func (m *MockTelegramClient) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errors["MakeRequest"]; err != nil {
+		return nil, err
+	}
+	m.calls = append(m.calls, endpoint)
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// SetChatMemberStatus scripts a user's status in a chat ("creator",
+// "administrator", "member", "left", ...); GetChatAdministrators reports
+// the creators and administrators
+func (m *MockTelegramClient) SetChatMemberStatus(chatID, userID int64, status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members[[2]int64{chatID, userID}] = status
+}
+
+// SetError makes calls to the named method ("Send", "Request",
+// "GetChatAdministrators", "GetMe" or "MakeRequest") fail with err; nil
+// clears it
+func (m *MockTelegramClient) SetError(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		delete(m.errors, method)
+		return
+	}
+	m.errors[method] = err
+}
+
+// PushUpdate queues an update for GetUpdates, numbering it after the
+// previous one like Telegram does
+func (m *MockTelegramClient) PushUpdate(update tgbotapi.Update) {
+	m.mu.Lock()
+	m.lastUpdateID++
+	update.UpdateID = m.lastUpdateID
+	m.pending = append(m.pending, update)
+	m.mu.Unlock()
+
+	select {
+	case m.pushed <- struct{}{}:
+	default:
+	}
+}
+
+// SentMessages returns the text messages sent so far
+func (m *MockTelegramClient) SentMessages() []tgbotapi.MessageConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var messages []tgbotapi.MessageConfig
+	for _, c := range m.sent {
+		if msg, ok := c.(tgbotapi.MessageConfig); ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// SentDocuments returns the documents sent so far
+func (m *MockTelegramClient) SentDocuments() []tgbotapi.DocumentConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var documents []tgbotapi.DocumentConfig
+	for _, c := range m.sent {
+		if doc, ok := c.(tgbotapi.DocumentConfig); ok {
+			documents = append(documents, doc)
+		}
+	}
+	return documents
+}
+
+// Requests returns the Chattables passed to Request so far
+func (m *MockTelegramClient) Requests() []tgbotapi.Chattable {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]tgbotapi.Chattable(nil), m.requests...)
+}
+
+// Calls returns the endpoints passed to MakeRequest so far
+func (m *MockTelegramClient) Calls() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.calls...)
+}
+
+// AdminLookups returns how many times GetChatAdministrators was called
+func (m *MockTelegramClient) AdminLookups() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.adminLookups
+}
+
+// Reset forgets recorded traffic but keeps scripted statuses and errors
+func (m *MockTelegramClient) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = nil
+	m.requests = nil
+	m.calls = nil
+}
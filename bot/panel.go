@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/callback"
+	"github.com/Zind-dev/HowardTheChad_bot/settings"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// settingsPanelTTL is how long /settings buttons keep working
+const settingsPanelTTL = 24 * time.Hour
+
+// Settings panel button actions
+const (
+	panelFrequency = "f"
+	panelMentions  = "m"
+	panelMedia     = "md"
+	panelReset     = "r"
+)
+
+// panelCommands are the commands whose permissions each button requires
+var panelCommands = map[string]string{
+	panelFrequency: "setfrequency",
+	panelMentions:  "togglementions",
+	panelMedia:     "togglemedia",
+	panelReset:     "resetsettings",
+}
+
+// frequencyPresets are the response frequencies offered as buttons
+var frequencyPresets = []int{0, 1, 5, 10, 20}
+
+// settingsText describes a chat's settings for the /settings panel
+func settingsText(chatSettings *settings.Settings) string {
+	mentionsStatus := "enabled"
+	if !chatSettings.AlwaysRespondToMentions {
+		mentionsStatus = "disabled"
+	}
+
+	response := "📊 Current Settings:\n\n"
+	response += "• Response Frequency: every " + formatFrequency(chatSettings.ResponseFrequency) + "\n"
+	response += "• Respond to Mentions: " + mentionsStatus + "\n"
+	response += "• Media Counts Toward Frequency: " + formatYesNo(!chatSettings.IgnoreMedia) + "\n"
+	response += "• Message Retention: " + formatRetention(chatSettings.RetentionMaxAge, chatSettings.RetentionMaxMessages) + "\n\n"
+	response += "Administrators and bot moderators can change settings with the buttons below. Use /help to see available commands."
+	return response
+}
+
+// settingsKeyboard builds the /settings buttons, marking the current values
+func (b *Bot) settingsKeyboard(chatID int64, chatSettings *settings.Settings) tgbotapi.InlineKeyboardMarkup {
+	button := func(label, action, arg string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(label, b.callbacks.Encode(chatID, action, arg))
+	}
+
+	var presets []tgbotapi.InlineKeyboardButton
+	for _, frequency := range frequencyPresets {
+		label := strconv.Itoa(frequency)
+		if frequency == 0 {
+			label = "Off"
+		}
+		if frequency == chatSettings.ResponseFrequency {
+			label = "✓ " + label
+		}
+		presets = append(presets, button(label, panelFrequency, strconv.Itoa(frequency)))
+	}
+
+	mentions := "Mentions: on"
+	if !chatSettings.AlwaysRespondToMentions {
+		mentions = "Mentions: off"
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		presets,
+		tgbotapi.NewInlineKeyboardRow(
+			button(mentions, panelMentions, ""),
+			button("Media counts: "+formatYesNo(!chatSettings.IgnoreMedia), panelMedia, ""),
+		),
+		tgbotapi.NewInlineKeyboardRow(button("Reset to defaults", panelReset, "")),
+	)
+}
+
+// handleCallbackQuery applies a settings panel button press and redraws the
+// panel in place. Presses from users without the matching command's
+// permission and stale or forged buttons are rejected with a notice to the
+// presser.
+func (b *Bot) handleCallbackQuery(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	if query.Message == nil || query.Message.Chat == nil {
+		b.answerCallback(query, "❌ This button is not supported here.", false)
+		return
+	}
+	chatID := query.Message.Chat.ID
+
+	data, err := b.callbacks.Decode(chatID, query.Data)
+	if errors.Is(err, callback.ErrStale) {
+		b.answerCallback(query, "⌛ This panel has expired. Send /settings for a new one.", true)
+		return
+	}
+	if err != nil {
+		b.log.Warn("Rejected callback", "chat_id", chatID, "user_id", query.From.ID, "error", err)
+		b.answerCallback(query, "❌ Invalid button.", true)
+		return
+	}
+
+	if allowed, required := b.permissions.Authorize(ctx, chatID, query.From.ID, panelCommands[data.Action]); !allowed {
+		b.answerCallback(query, deniedText(required, "change settings"), true)
+		return
+	}
+
+	before := b.settingsManager.GetSettings(ctx, chatID)
+	if err := b.applyPanelAction(ctx, chatID, data); err != nil {
+		b.log.Error("Failed to save settings", "chat_id", chatID, "error", err)
+		b.answerCallback(query, "❌ Failed to save settings. Please try again later.", true)
+		return
+	}
+	b.auditSettingsChange(ctx, chatID, query.From.ID, panelCommands[data.Action], before)
+
+	chatSettings := b.settingsManager.GetSettings(ctx, chatID)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID,
+		settingsText(chatSettings), b.settingsKeyboard(chatID, chatSettings))
+	// Pressing the current value changes nothing, which Telegram reports as an error
+	if _, err := b.api.Request(edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		b.log.Warn("Failed to update settings panel", "chat_id", chatID, "error", err)
+	}
+
+	b.answerCallback(query, "✅ Settings saved", false)
+}
+
+// applyPanelAction changes the setting a panel button stands for
+func (b *Bot) applyPanelAction(ctx context.Context, chatID int64, data callback.Data) error {
+	switch data.Action {
+	case panelFrequency:
+		frequency, err := strconv.Atoi(data.Arg)
+		if err != nil || frequency < 0 {
+			return fmt.Errorf("invalid frequency %q", data.Arg)
+		}
+		return b.settingsManager.SetFrequency(ctx, chatID, frequency)
+	case panelMentions:
+		_, err := b.settingsManager.ToggleMentionResponse(ctx, chatID)
+		return err
+	case panelMedia:
+		_, err := b.settingsManager.ToggleMediaCounting(ctx, chatID)
+		return err
+	case panelReset:
+		return b.settingsManager.ResetSettings(ctx, chatID)
+	default:
+		return fmt.Errorf("unknown panel action %q", data.Action)
+	}
+}
+
+// answerCallback stops the button's loading indicator, showing text as a
+// toast or, if alert is set, as a dialog
+func (b *Bot) answerCallback(query *tgbotapi.CallbackQuery, text string, alert bool) {
+	answer := tgbotapi.NewCallback(query.ID, text)
+	answer.ShowAlert = alert
+	if _, err := b.api.Request(answer); err != nil {
+		b.log.Warn("Failed to answer callback query", "error", err)
+	}
+}
@@ -0,0 +1,169 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// deniedActions describes commands in refusals; the rest change settings
+var deniedActions = map[string]string{
+	"grant":    "manage bot moderators",
+	"revoke":   "manage bot moderators",
+	"auditlog": "view the audit log",
+	"ping":     "run diagnostics",
+}
+
+// handleGrantCommand makes a user a bot moderator in the chat
+func (b *Bot) handleGrantCommand(ctx context.Context, message *tgbotapi.Message) {
+	if !b.authorize(ctx, message, "grant") {
+		return
+	}
+
+	userID, ok := b.roleTarget(ctx, message)
+	if !ok {
+		b.sendMessage(message.Chat.ID, roleTargetHelp(message, "/grant"), message.MessageID)
+		return
+	}
+	if reply := message.ReplyToMessage; userID == b.api.Self().ID || (reply != nil && reply.From != nil && reply.From.ID == userID && reply.From.IsBot) {
+		b.sendMessage(message.Chat.ID, "❌ Bots cannot be moderators.", message.MessageID)
+		return
+	}
+
+	existing, err := b.storage.GetChatRole(ctx, message.Chat.ID, userID)
+	if err != nil {
+		b.log.Error("Failed to get chat role", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to grant the role. Please try again later.", message.MessageID)
+		return
+	}
+	if existing != nil && existing.Role == storage.RoleModerator {
+		b.sendMessage(message.Chat.ID, "ℹ️ "+b.userName(ctx, userID)+" is already a bot moderator.", message.MessageID)
+		return
+	}
+
+	role := &storage.ChatRole{
+		ChatID:    message.Chat.ID,
+		UserID:    userID,
+		Role:      storage.RoleModerator,
+		GrantedBy: message.From.ID,
+		GrantedAt: time.Now(),
+	}
+	if err := b.storage.SaveChatRole(ctx, role); err != nil {
+		b.log.Error("Failed to save chat role", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to grant the role. Please try again later.", message.MessageID)
+		return
+	}
+
+	b.audit.Record(ctx, message.Chat.ID, message.From.ID, "grant", "", roleValue(userID, storage.RoleModerator))
+	b.sendMessage(message.Chat.ID, "✅ "+b.userName(ctx, userID)+" is now a bot moderator and can change settings.", message.MessageID)
+}
+
+// handleRevokeCommand removes a user's bot moderator role in the chat
+func (b *Bot) handleRevokeCommand(ctx context.Context, message *tgbotapi.Message) {
+	if !b.authorize(ctx, message, "revoke") {
+		return
+	}
+
+	userID, ok := b.roleTarget(ctx, message)
+	if !ok {
+		b.sendMessage(message.Chat.ID, roleTargetHelp(message, "/revoke"), message.MessageID)
+		return
+	}
+
+	existing, err := b.storage.GetChatRole(ctx, message.Chat.ID, userID)
+	if err != nil {
+		b.log.Error("Failed to get chat role", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to revoke the role. Please try again later.", message.MessageID)
+		return
+	}
+	if existing == nil {
+		b.sendMessage(message.Chat.ID, "ℹ️ "+b.userName(ctx, userID)+" is not a bot moderator.", message.MessageID)
+		return
+	}
+
+	if err := b.storage.DeleteChatRole(ctx, message.Chat.ID, userID); err != nil {
+		b.log.Error("Failed to delete chat role", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to revoke the role. Please try again later.", message.MessageID)
+		return
+	}
+
+	b.audit.Record(ctx, message.Chat.ID, message.From.ID, "revoke", roleValue(userID, existing.Role), "")
+	b.sendMessage(message.Chat.ID, "✅ "+b.userName(ctx, userID)+" is no longer a bot moderator.", message.MessageID)
+}
+
+// handleModeratorsCommand lists the chat's bot moderators
+func (b *Bot) handleModeratorsCommand(ctx context.Context, message *tgbotapi.Message) {
+	roles, err := b.storage.GetChatRoles(ctx, message.Chat.ID)
+	if err != nil {
+		b.log.Error("Failed to get chat roles", "chat_id", message.Chat.ID, "error", err)
+		b.sendMessage(message.Chat.ID, "❌ Failed to load moderators. Please try again later.", message.MessageID)
+		return
+	}
+
+	var lines []string
+	for _, role := range roles {
+		if role.Role != storage.RoleModerator {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("• %s (added by %s on %s)",
+			b.userName(ctx, role.UserID), b.userName(ctx, role.GrantedBy), role.GrantedAt.Format("2006-01-02")))
+	}
+
+	if len(lines) == 0 {
+		b.sendMessage(message.Chat.ID, "No bot moderators yet. Administrators can add one with /grant.", message.MessageID)
+		return
+	}
+	b.sendMessage(message.Chat.ID, "🛡 Bot moderators:\n\n"+strings.Join(lines, "\n"), message.MessageID)
+}
+
+// roleValue describes a user's role for the audit log
+func roleValue(userID int64, role string) string {
+	return fmt.Sprintf("user %d: %s", userID, role)
+}
+
+// roleTargetHelp explains how to name the user for a command when
+// roleTarget found nobody
+func roleTargetHelp(message *tgbotapi.Message, command string) string {
+	if strings.TrimSpace(message.CommandArguments()) == "" {
+		return "Usage: reply to a message with " + command + ", or " + command + " <@username or user ID>"
+	}
+	return "❌ I don't know that user yet. Reply to one of their messages with " + command + " instead."
+}
+
+// roleTarget finds the user a /grant or /revoke is about: the author of the
+// replied-to message, or the user ID or @username in the arguments. Usernames
+// are looked up among users the bot has seen in the chat.
+func (b *Bot) roleTarget(ctx context.Context, message *tgbotapi.Message) (int64, bool) {
+	args := strings.TrimSpace(message.CommandArguments())
+	if args == "" {
+		if reply := message.ReplyToMessage; reply != nil && reply.From != nil {
+			return reply.From.ID, true
+		}
+		return 0, false
+	}
+
+	if id, err := strconv.ParseInt(args, 10, 64); err == nil {
+		return id, true
+	}
+
+	userName, ok := strings.CutPrefix(args, "@")
+	if !ok {
+		return 0, false
+	}
+	chatUsers, err := b.storage.GetChatUsers(ctx, message.Chat.ID)
+	if err != nil {
+		b.log.Error("Failed to get chat users", "chat_id", message.Chat.ID, "error", err)
+		return 0, false
+	}
+	for _, user := range chatUsers {
+		if strings.EqualFold(user.UserName, userName) {
+			return user.ID, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,97 @@
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the current callback data format. Buttons carrying any other
+// version were created by an older release and are rejected as stale.
+const Version = "1"
+
+// MaxLength is Telegram's limit on callback data, in bytes
+const MaxLength = 64
+
+// signatureBytes is how much of the HMAC is kept; 8 bytes make forging a
+// button a 2^64 guess while leaving room for the payload
+const signatureBytes = 8
+
+// Errors returned by Decode
+var (
+	ErrMalformed = errors.New("malformed callback data")
+	ErrStale     = errors.New("callback data is stale")
+	ErrForged    = errors.New("callback data has an invalid signature")
+)
+
+// Data is a decoded button press
+type Data struct {
+	Action   string
+	Arg      string
+	IssuedAt time.Time
+}
+
+// Signer encodes and verifies inline keyboard callback data.
+// Data has the form "version|action|arg|issued|signature"; the signature
+// covers the chat ID as well, so a button cannot be replayed in another chat.
+type Signer struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewSigner creates a signer. Buttons older than ttl are rejected as stale;
+// 0 means they never expire.
+func NewSigner(secret []byte, ttl time.Duration) *Signer {
+	return &Signer{
+		key: secret,
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+// Encode returns signed callback data for a button in a chat.
+// Action and arg must not contain "|", and the result must fit in MaxLength.
+func (s *Signer) Encode(chatID int64, action, arg string) string {
+	payload := strings.Join([]string{Version, action, arg, strconv.FormatInt(s.now().Unix(), 36)}, "|")
+	return payload + "|" + s.sign(chatID, payload)
+}
+
+// Decode verifies callback data from a button in a chat
+func (s *Signer) Decode(chatID int64, data string) (Data, error) {
+	parts := strings.Split(data, "|")
+	if parts[0] != Version {
+		return Data{}, ErrStale
+	}
+	if len(parts) != 5 {
+		return Data{}, ErrMalformed
+	}
+
+	payload := strings.Join(parts[:4], "|")
+	if !hmac.Equal([]byte(parts[4]), []byte(s.sign(chatID, payload))) {
+		return Data{}, ErrForged
+	}
+
+	issued, err := strconv.ParseInt(parts[3], 36, 64)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: bad timestamp", ErrMalformed)
+	}
+	issuedAt := time.Unix(issued, 0)
+	if s.ttl > 0 && s.now().Sub(issuedAt) > s.ttl {
+		return Data{}, ErrStale
+	}
+
+	return Data{Action: parts[1], Arg: parts[2], IssuedAt: issuedAt}, nil
+}
+
+// sign returns the truncated, URL-safe HMAC of a payload bound to a chat
+func (s *Signer) sign(chatID int64, payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%d|%s", chatID, payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureBytes])
+}
@@ -0,0 +1,79 @@
+package callback
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestSigner(secret string, ttl time.Duration) *Signer {
+	s := NewSigner([]byte(secret), ttl)
+	s.now = func() time.Time { return testNow }
+	return s
+}
+
+func TestEncodeDecode(t *testing.T) {
+	s := newTestSigner("secret", time.Hour)
+
+	data := s.Encode(-1001234567890, "f", "20")
+	if !strings.HasPrefix(data, Version+"|f|20|") {
+		t.Errorf("Expected versioned payload, got '%s'", data)
+	}
+	if len(data) > MaxLength {
+		t.Errorf("Expected at most %d bytes, got %d", MaxLength, len(data))
+	}
+
+	got, err := s.Decode(-1001234567890, data)
+	if err != nil {
+		t.Fatalf("Expected valid data, got %v", err)
+	}
+	if got.Action != "f" || got.Arg != "20" || !got.IssuedAt.Equal(testNow) {
+		t.Errorf("Expected f/20 issued at %v, got %+v", testNow, got)
+	}
+}
+
+func TestDecode_Rejects(t *testing.T) {
+	s := newTestSigner("secret", time.Hour)
+	valid := s.Encode(-100, "f", "20")
+	parts := strings.Split(valid, "|")
+
+	expired := newTestSigner("secret", time.Hour)
+	expired.now = func() time.Time { return testNow.Add(-2 * time.Hour) }
+
+	tests := []struct {
+		name   string
+		chatID int64
+		data   string
+		want   error
+	}{
+		{name: "Other chat", chatID: -200, data: valid, want: ErrForged},
+		{name: "Changed argument", chatID: -100, data: strings.Replace(valid, "|20|", "|1|", 1), want: ErrForged},
+		{name: "Other key", chatID: -100, data: newTestSigner("other", time.Hour).Encode(-100, "f", "20"), want: ErrForged},
+		{name: "Expired", chatID: -100, data: expired.Encode(-100, "f", "20"), want: ErrStale},
+		{name: "Old version", chatID: -100, data: "0|" + strings.Join(parts[1:], "|"), want: ErrStale},
+		{name: "Unversioned", chatID: -100, data: "setfreq_20", want: ErrStale},
+		{name: "Missing signature", chatID: -100, data: strings.Join(parts[:4], "|"), want: ErrMalformed},
+		{name: "Empty", chatID: -100, data: "", want: ErrStale},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Decode(tt.chatID, tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestDecode_NoExpiry(t *testing.T) {
+	old := newTestSigner("secret", 0)
+	old.now = func() time.Time { return testNow.Add(-365 * 24 * time.Hour) }
+	data := old.Encode(-100, "r", "")
+
+	if _, err := newTestSigner("secret", 0).Decode(-100, data); err != nil {
+		t.Errorf("Expected buttons to never expire with ttl 0, got %v", err)
+	}
+}
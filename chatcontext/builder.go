@@ -0,0 +1,325 @@
+package chatcontext
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/responder"
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Default limits used when Options leaves them unset
+const (
+	DefaultHistoryLimit = 20
+	DefaultMaxChars     = 8000 // Roughly 2000 tokens at ~4 characters per token
+)
+
+// maxQuoteChars caps how much of a replied-to message is quoted
+const maxQuoteChars = 200
+
+// Options controls how much history goes into a prompt
+type Options struct {
+	HistoryLimit int    // Number of recent chat messages to fetch
+	MaxChars     int    // Character budget for the whole prompt
+	SystemPrompt string // Persona instructions placed before everything else
+}
+
+// Builder assembles prompts from stored message history and user profiles
+type Builder struct {
+	storage   storage.Storage
+	botUserID int64
+	options   Options
+}
+
+// Turn is a single speaker turn in the prompt
+type Turn struct {
+	Role      string // "user" or "assistant"
+	Speaker   string // Resolved display name of the author
+	Text      string
+	Timestamp time.Time
+	MessageID int    // Telegram message ID, 0 if unknown
+	ReplyTo   string // Who a user turn replies to; "you" for the bot
+	Quote     string // Text replied to, set only when it is not elsewhere in the prompt
+}
+
+// Prompt is the structured context for one incoming message
+type Prompt struct {
+	System  string               // Persona plus what we know about the sender
+	Profile *storage.UserProfile // Sender's profile, nil if none is stored
+	History []Turn               // Prior chat turns, oldest first
+	Current Turn                 // The message being answered
+}
+
+// NewBuilder creates a context builder.
+// botUserID identifies the bot's own stored messages so they become assistant turns.
+func NewBuilder(store storage.Storage, botUserID int64, opts Options) *Builder {
+	if opts.HistoryLimit <= 0 {
+		opts.HistoryLimit = DefaultHistoryLimit
+	}
+	if opts.MaxChars <= 0 {
+		opts.MaxChars = DefaultMaxChars
+	}
+	if opts.SystemPrompt == "" {
+		opts.SystemPrompt = responder.DefaultSystemPrompt
+	}
+
+	return &Builder{
+		storage:   store,
+		botUserID: botUserID,
+		options:   opts,
+	}
+}
+
+// Build assembles the prompt for an incoming message.
+// The output depends only on storage contents, so it is safe to golden-test.
+func (b *Builder) Build(ctx context.Context, message *tgbotapi.Message) (*Prompt, error) {
+	chatID := message.Chat.ID
+	names := make(map[int64]string)
+
+	senderName := displayName(message.From.ID, message.From.FirstName, message.From.LastName, message.From.UserName)
+	names[message.From.ID] = senderName
+
+	profile, err := b.storage.GetUserProfile(ctx, chatID, message.From.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user profile: %w", err)
+	}
+
+	recent, err := b.storage.GetRecentMessages(ctx, chatID, b.options.HistoryLimit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recent messages: %w", err)
+	}
+
+	// The incoming message is usually stored before a reply is generated;
+	// drop it from history so it only appears once, as the current turn.
+	if n := len(recent); n > 0 && isMessage(recent[n-1], message) {
+		recent = recent[:n-1]
+	}
+	if len(recent) > b.options.HistoryLimit {
+		recent = recent[len(recent)-b.options.HistoryLimit:]
+	}
+
+	// Replies to messages still in the window are resolved without a lookup
+	inHistory := make(map[int]*storage.Message, len(recent))
+	for _, msg := range recent {
+		if msg.MessageID != 0 {
+			inHistory[msg.MessageID] = msg
+		}
+	}
+
+	history := make([]Turn, 0, len(recent))
+	for _, msg := range recent {
+		turn := Turn{
+			Role:      "user",
+			Text:      msg.Describe(),
+			Timestamp: msg.Timestamp,
+			MessageID: msg.MessageID,
+		}
+
+		if msg.IsBot && msg.UserID == b.botUserID {
+			turn.Role = "assistant"
+		} else {
+			name, err := b.resolveName(ctx, msg.UserID, names)
+			if err != nil {
+				return nil, err
+			}
+			turn.Speaker = name
+
+			if turn.ReplyTo, err = b.replyTarget(ctx, chatID, msg.ReplyToMessageID, inHistory, names); err != nil {
+				return nil, err
+			}
+		}
+
+		history = append(history, turn)
+	}
+
+	current := Turn{
+		Role:      "user",
+		Speaker:   senderName,
+		Text:      storage.MessageFromTelegram(message).Describe(),
+		Timestamp: message.Time(),
+		MessageID: message.MessageID,
+	}
+	if parent := message.ReplyToMessage; parent != nil {
+		// Telegram includes the replied-to message, so no lookup is needed
+		current.ReplyTo = b.authorOf(parent)
+		if inHistory[parent.MessageID] == nil {
+			current.Quote = truncate(storage.MessageFromTelegram(parent).Describe(), maxQuoteChars)
+		}
+	}
+
+	prompt := &Prompt{
+		System:  b.systemPrompt(senderName, profile),
+		Profile: profile,
+		History: history,
+		Current: current,
+	}
+
+	b.trim(prompt)
+	return prompt, nil
+}
+
+// Messages converts the prompt into chat-completion messages
+func (p *Prompt) Messages() []responder.Message {
+	messages := make([]responder.Message, 0, len(p.History)+2)
+	messages = append(messages, responder.Message{Role: "system", Content: p.System})
+	for _, turn := range p.History {
+		messages = append(messages, responder.Message{Role: turn.Role, Content: turn.content()})
+	}
+	messages = append(messages, responder.Message{Role: p.Current.Role, Content: p.Current.content()})
+	return messages
+}
+
+// Render returns a plain-text view of the prompt, one message per block
+func (p *Prompt) Render() string {
+	var sb strings.Builder
+	for _, msg := range p.Messages() {
+		fmt.Fprintf(&sb, "[%s]\n%s\n\n", msg.Role, msg.Content)
+	}
+	return sb.String()
+}
+
+// content formats a turn for the model, prefixing group-chat speakers and
+// who they reply to
+func (t Turn) content() string {
+	if t.Speaker == "" {
+		return t.Text
+	}
+	if t.ReplyTo == "" {
+		return t.Speaker + ": " + t.Text
+	}
+	if t.Quote == "" {
+		return t.Speaker + " (replying to " + t.ReplyTo + "): " + t.Text
+	}
+	return t.Speaker + " (replying to " + t.ReplyTo + ": \"" + t.Quote + "\"): " + t.Text
+}
+
+// systemPrompt combines the persona with the sender's profile
+func (b *Builder) systemPrompt(senderName string, profile *storage.UserProfile) string {
+	system := b.options.SystemPrompt
+	if profile == nil {
+		return system
+	}
+
+	var facts []string
+	if profile.Interests != "" {
+		facts = append(facts, "Interests: "+profile.Interests)
+	}
+	if profile.Topics != "" {
+		facts = append(facts, "Topics: "+profile.Topics)
+	}
+	if profile.Personality != "" {
+		facts = append(facts, "Personality: "+profile.Personality)
+	}
+	if profile.Notes != "" {
+		facts = append(facts, "Notes: "+profile.Notes)
+	}
+	if len(facts) == 0 {
+		return system
+	}
+
+	return system + "\n\nWhat you know about " + senderName + ":\n- " + strings.Join(facts, "\n- ")
+}
+
+// trim drops the oldest history turns until the prompt fits the character budget.
+// The system prompt and the current message are always kept.
+func (b *Builder) trim(prompt *Prompt) {
+	size := len(prompt.System) + len(prompt.Current.content())
+	for _, turn := range prompt.History {
+		size += len(turn.content())
+	}
+
+	for len(prompt.History) > 0 && size > b.options.MaxChars {
+		size -= len(prompt.History[0].content())
+		prompt.History = prompt.History[1:]
+	}
+}
+
+// resolveName looks up a user's display name, caching results for this build
+func (b *Builder) resolveName(ctx context.Context, userID int64, names map[int64]string) (string, error) {
+	if name, ok := names[userID]; ok {
+		return name, nil
+	}
+
+	user, err := b.storage.GetUser(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve user %d: %w", userID, err)
+	}
+
+	name := displayName(userID, "", "", "")
+	if user != nil {
+		name = displayName(userID, user.FirstName, user.LastName, user.UserName)
+	}
+
+	names[userID] = name
+	return name, nil
+}
+
+// replyTarget names the author of the message a history turn replies to,
+// or returns "" if it is not a reply or the original is no longer stored
+func (b *Builder) replyTarget(ctx context.Context, chatID int64, messageID int, inHistory map[int]*storage.Message, names map[int64]string) (string, error) {
+	if messageID == 0 {
+		return "", nil
+	}
+
+	parent := inHistory[messageID]
+	if parent == nil {
+		var err error
+		if parent, err = b.storage.GetMessage(ctx, chatID, messageID); err != nil {
+			return "", fmt.Errorf("failed to load replied-to message: %w", err)
+		}
+		if parent == nil {
+			return "", nil
+		}
+	}
+
+	if parent.IsBot && parent.UserID == b.botUserID {
+		return "you", nil
+	}
+	return b.resolveName(ctx, parent.UserID, names)
+}
+
+// authorOf names the sender of a message Telegram attached to an update
+func (b *Builder) authorOf(message *tgbotapi.Message) string {
+	if message.From == nil {
+		return "an earlier message"
+	}
+	if message.From.ID == b.botUserID {
+		return "you"
+	}
+	return displayName(message.From.ID, message.From.FirstName, message.From.LastName, message.From.UserName)
+}
+
+// isMessage reports whether a stored message is the incoming one. Older rows
+// have no Telegram ID, so those are matched by sender and text.
+func isMessage(stored *storage.Message, message *tgbotapi.Message) bool {
+	if stored.MessageID != 0 && message.MessageID != 0 {
+		return stored.MessageID == message.MessageID
+	}
+	return stored.UserID == message.From.ID && stored.Text == message.Text
+}
+
+// truncate shortens text to at most limit runes, marking the cut with "…"
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// displayName picks the friendliest available name for a user
+func displayName(userID int64, firstName, lastName, userName string) string {
+	if firstName != "" {
+		if lastName != "" {
+			return firstName + " " + lastName
+		}
+		return firstName
+	}
+	if userName != "" {
+		return "@" + userName
+	}
+	return fmt.Sprintf("User %d", userID)
+}
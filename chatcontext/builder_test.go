@@ -0,0 +1,279 @@
+package chatcontext
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const (
+	testChatID    int64 = 100
+	testBotUserID int64 = 999
+)
+
+var baseTime = time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+// newTestStore seeds a mock storage with a short group conversation
+func newTestStore(t *testing.T) *storage.MockStorage {
+	store := storage.NewMockStorage()
+
+	users := []*storage.User{
+		{ID: 1, UserName: "alice", FirstName: "Alice", LastName: "Smith"},
+		{ID: 2, UserName: "bob"},
+		{ID: testBotUserID, UserName: "howard_bot", FirstName: "Howard"},
+	}
+	for _, u := range users {
+		if err := store.SaveUser(context.Background(), u); err != nil {
+			t.Fatalf("Failed to save user: %v", err)
+		}
+	}
+
+	messages := []*storage.Message{
+		{UserID: 1, Text: "Anyone watching the match tonight?"},
+		{UserID: 2, Text: "Yeah, kickoff is at 8"},
+		{UserID: 3, Text: "I'll be late"}, // Unknown user
+		{UserID: testBotUserID, Text: "Enjoy the game!", IsBot: true},
+		{UserID: 1, Text: "@howard_bot who will win?"},
+	}
+	for i, msg := range messages {
+		msg.ChatID = testChatID
+		msg.Timestamp = baseTime.Add(time.Duration(i) * time.Minute)
+		if err := store.SaveMessage(context.Background(), msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+
+	return store
+}
+
+func incomingMessage() *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: 42,
+		Date:      int(baseTime.Add(4 * time.Minute).Unix()),
+		Chat:      &tgbotapi.Chat{ID: testChatID, Type: "group"},
+		From:      &tgbotapi.User{ID: 1, UserName: "alice", FirstName: "Alice", LastName: "Smith"},
+		Text:      "@howard_bot who will win?",
+	}
+}
+
+// checkGolden compares output against testdata/<name>.golden
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("Prompt does not match %s\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	store := newTestStore(t)
+	builder := NewBuilder(store, testBotUserID, Options{SystemPrompt: "You are a test bot."})
+
+	prompt, err := builder.Build(context.Background(), incomingMessage())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if len(prompt.History) != 4 {
+		t.Errorf("Expected 4 history turns (incoming message excluded), got %d", len(prompt.History))
+	}
+	if prompt.History[3].Role != "assistant" {
+		t.Errorf("Expected bot reply to be an assistant turn, got '%s'", prompt.History[3].Role)
+	}
+	if prompt.Current.Speaker != "Alice Smith" {
+		t.Errorf("Expected current speaker 'Alice Smith', got '%s'", prompt.Current.Speaker)
+	}
+
+	checkGolden(t, "basic", prompt.Render())
+}
+
+func TestBuild_WithProfile(t *testing.T) {
+	store := newTestStore(t)
+	store.SaveUserProfile(context.Background(), &storage.UserProfile{
+		ChatID:      testChatID,
+		UserID:      1,
+		Interests:   "football, cooking",
+		Personality: "competitive",
+	})
+	builder := NewBuilder(store, testBotUserID, Options{SystemPrompt: "You are a test bot."})
+
+	prompt, err := builder.Build(context.Background(), incomingMessage())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if prompt.Profile == nil {
+		t.Fatal("Expected sender profile to be loaded")
+	}
+
+	checkGolden(t, "profile", prompt.Render())
+}
+
+func TestBuild_HistoryLimit(t *testing.T) {
+	store := newTestStore(t)
+	builder := NewBuilder(store, testBotUserID, Options{HistoryLimit: 2})
+
+	prompt, err := builder.Build(context.Background(), incomingMessage())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if len(prompt.History) != 2 {
+		t.Fatalf("Expected 2 history turns, got %d", len(prompt.History))
+	}
+	if prompt.History[0].Text != "I'll be late" {
+		t.Errorf("Expected oldest kept turn 'I'll be late', got '%s'", prompt.History[0].Text)
+	}
+}
+
+func TestBuild_TrimsOldestFirst(t *testing.T) {
+	store := newTestStore(t)
+	system := "You are a test bot."
+	current := "Alice Smith: @howard_bot who will win?"
+	newest := "Enjoy the game!"
+	secondNewest := "User 3: I'll be late"
+
+	// Budget fits the system prompt, current message and the two newest turns only
+	budget := len(system) + len(current) + len(newest) + len(secondNewest)
+	builder := NewBuilder(store, testBotUserID, Options{SystemPrompt: system, MaxChars: budget})
+
+	prompt, err := builder.Build(context.Background(), incomingMessage())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if len(prompt.History) != 2 {
+		t.Fatalf("Expected 2 history turns after trimming, got %d", len(prompt.History))
+	}
+	if prompt.History[0].Speaker != "User 3" {
+		t.Errorf("Expected unknown user to resolve to 'User 3', got '%s'", prompt.History[0].Speaker)
+	}
+
+	// A budget smaller than the mandatory parts still keeps them
+	builder = NewBuilder(store, testBotUserID, Options{SystemPrompt: system, MaxChars: 1})
+	prompt, err = builder.Build(context.Background(), incomingMessage())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(prompt.History) != 0 {
+		t.Errorf("Expected all history to be trimmed, got %d turns", len(prompt.History))
+	}
+	if !strings.Contains(prompt.Render(), "who will win?") {
+		t.Error("Expected current message to be kept")
+	}
+}
+
+func TestBuild_Deterministic(t *testing.T) {
+	store := newTestStore(t)
+	builder := NewBuilder(store, testBotUserID, Options{})
+
+	first, err := builder.Build(context.Background(), incomingMessage())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	second, err := builder.Build(context.Background(), incomingMessage())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if first.Render() != second.Render() {
+		t.Error("Expected identical prompts for identical input")
+	}
+}
+
+func TestBuild_ReplyThreads(t *testing.T) {
+	store := storage.NewMockStorage()
+	ctx := context.Background()
+	store.SaveUser(ctx, &storage.User{ID: 1, UserName: "alice", FirstName: "Alice", LastName: "Smith"})
+	store.SaveUser(ctx, &storage.User{ID: 2, UserName: "bob"})
+
+	messages := []*storage.Message{
+		{MessageID: 100, UserID: 1, Text: "Who's bringing snacks on Saturday?"},
+		{MessageID: 101, ReplyToMessageID: 100, UserID: 2, Text: "I can bring chips"},
+		{MessageID: 102, ReplyToMessageID: 101, UserID: testBotUserID, IsBot: true, Text: "Chips are a solid choice"},
+		{MessageID: 103, ReplyToMessageID: 102, UserID: 3, Text: "Agreed"},
+		{MessageID: 104, ReplyToMessageID: 101, UserID: 1, Text: "Salted ones please"},
+		{MessageID: 105, ReplyToMessageID: 100, UserID: 2, Text: "@howard_bot what about drinks?"},
+	}
+	for i, msg := range messages {
+		msg.ChatID = testChatID
+		msg.Timestamp = baseTime.Add(time.Duration(i) * time.Minute)
+		if err := store.SaveMessage(ctx, msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+
+	// The question being replied to has scrolled out of the history window
+	incoming := &tgbotapi.Message{
+		MessageID: 105,
+		Date:      int(baseTime.Add(5 * time.Minute).Unix()),
+		Chat:      &tgbotapi.Chat{ID: testChatID, Type: "group"},
+		From:      &tgbotapi.User{ID: 2, UserName: "bob"},
+		Text:      "@howard_bot what about drinks?",
+		ReplyToMessage: &tgbotapi.Message{
+			MessageID: 100,
+			From:      &tgbotapi.User{ID: 1, UserName: "alice", FirstName: "Alice", LastName: "Smith"},
+			Text:      "Who's bringing snacks on Saturday?",
+		},
+	}
+	builder := NewBuilder(store, testBotUserID, Options{SystemPrompt: "You are a test bot.", HistoryLimit: 4})
+
+	prompt, err := builder.Build(ctx, incoming)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if len(prompt.History) != 4 || prompt.History[0].MessageID != 101 {
+		t.Fatalf("Expected messages 101-104 as history, got %d turns", len(prompt.History))
+	}
+	if prompt.History[0].ReplyTo != "Alice Smith" {
+		t.Errorf("Expected reply outside the window to be looked up, got '%s'", prompt.History[0].ReplyTo)
+	}
+	if prompt.History[2].ReplyTo != "you" {
+		t.Errorf("Expected reply to the bot to address it as 'you', got '%s'", prompt.History[2].ReplyTo)
+	}
+	if prompt.Current.Quote == "" {
+		t.Error("Expected the current message to quote the question it replies to")
+	}
+
+	checkGolden(t, "replies", prompt.Render())
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		text     string
+		limit    int
+		expected string
+	}{
+		{text: "short", limit: 10, expected: "short"},
+		{text: "exactly10!", limit: 10, expected: "exactly10!"},
+		{text: "a bit too long", limit: 10, expected: "a bit too…"},
+		{text: "ééééééé", limit: 5, expected: "éééé…"},
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.text, tt.limit); got != tt.expected {
+			t.Errorf("truncate(%q, %d): expected '%s', got '%s'", tt.text, tt.limit, tt.expected, got)
+		}
+	}
+}
@@ -47,6 +47,38 @@ func (m *Manager) IncrementMessageCount(chatID int64, title, chatType string) in
 	return 1
 }
 
+// UpdateChat records a chat's title and type without counting a message.
+// It reports whether the chat is new or its details changed.
+func (m *Manager) UpdateChat(chatID int64, title, chatType string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if chat, exists := m.chats[chatID]; exists {
+		changed := chat.Title != title || chat.Type != chatType
+		chat.Title = title
+		chat.Type = chatType
+		return changed
+	}
+
+	m.chats[chatID] = &Chat{
+		ID:    chatID,
+		Title: title,
+		Type:  chatType,
+	}
+	return true
+}
+
+// Restore seeds a previously stored chat, e.g. after a restart.
+// Chats the manager already knows are left untouched.
+func (m *Manager) Restore(chat Chat) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.chats[chat.ID]; !exists {
+		m.chats[chat.ID] = &chat
+	}
+}
+
 // GetChat retrieves chat information by ID
 func (m *Manager) GetChat(chatID int64) *Chat {
 	m.mu.RLock()
@@ -159,3 +159,35 @@ func TestChatTitleUpdate(t *testing.T) {
 		t.Errorf("Expected title to update to 'New Title', got '%s'", chat.Title)
 	}
 }
+
+func TestUpdateChat(t *testing.T) {
+	manager := NewManager()
+
+	if !manager.UpdateChat(123, "Test Group", "group") {
+		t.Error("Expected a new chat to be reported as changed")
+	}
+	if manager.GetMessageCount(123) != 0 {
+		t.Errorf("UpdateChat should not count a message, got %d", manager.GetMessageCount(123))
+	}
+	if manager.UpdateChat(123, "Test Group", "group") {
+		t.Error("Expected an unchanged chat not to be reported")
+	}
+	if !manager.UpdateChat(123, "Renamed Group", "supergroup") {
+		t.Error("Expected a title change to be reported")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	manager := NewManager()
+
+	manager.Restore(Chat{ID: 123, Title: "Test Group", Type: "group", MessageCount: 9})
+	if count := manager.IncrementMessageCount(123, "Test Group", "group"); count != 10 {
+		t.Errorf("Expected count to continue from 9 to 10, got %d", count)
+	}
+
+	// Restoring a known chat does not overwrite the live count
+	manager.Restore(Chat{ID: 123, MessageCount: 1})
+	if count := manager.GetMessageCount(123); count != 10 {
+		t.Errorf("Expected live count 10 to be kept, got %d", count)
+	}
+}
@@ -2,50 +2,291 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/logging"
+	"github.com/Zind-dev/HowardTheChad_bot/permissions"
+)
+
+// Update delivery modes
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+)
+
+// Storage backends
+const (
+	StorageSQLite   = "sqlite"
+	StoragePostgres = "postgres"
+)
+
+// Log output formats
+const (
+	LogText = "text"
+	LogJSON = "json"
 )
 
+// defaultSQLitePath is the database file used when no DSN is configured
+const defaultSQLitePath = "bot_data.db"
+
+// webhookSecretPattern matches the characters Telegram accepts in secret tokens
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
 // Config holds the application configuration
 type Config struct {
 	TelegramToken     string
 	BotUsername       string
 	ResponseFrequency int  // How often to respond to regular messages (e.g., every 10th message)
 	RespondToMentions bool // Whether to always respond to mentions
+
+	// Response generation
+	Responder ResponderConfig
+
+	// Conversation context
+	ContextMessages int // Number of recent chat messages included in prompts
+	ContextMaxChars int // Character budget for a prompt; oldest messages are trimmed first
+
+	// User profiling
+	Profiler         string        // Profile extractor: "keyword" or "llm"
+	ProfilerInterval time.Duration // Time between background profiling passes
+
+	// Permissions
+	AdminCacheTTL      time.Duration     // How long a chat's administrator list is trusted before it is fetched again
+	Owners             []int64           // User IDs allowed to manage every chat
+	CommandPermissions map[string]string // Level required per command ("everyone", "moderator", "admin" or "owner"), overriding the defaults
+
+	// Lifecycle
+	ShutdownTimeout time.Duration // Time allowed for in-flight work and storage flushes on shutdown
+
+	// Update processing
+	Workers   int // Number of concurrent update handlers; each chat is pinned to one
+	QueueSize int // Pending updates buffered per worker before polling blocks
+
+	Storage    StorageConfig
+	Transport  TransportConfig
+	Logging    LoggingConfig
+	Monitoring MonitoringConfig
+}
+
+// ResponderConfig selects and configures the response backend. The LLM
+// settings are shared with the "llm" profiler.
+type ResponderConfig struct {
+	Backend string        // Response backend: "canned" or "openai"
+	BaseURL string        // Base URL of an OpenAI-compatible API (e.g., https://api.openai.com/v1)
+	Model   string        // Model name sent with each chat-completion request
+	APIKey  string        // API key sent as a Bearer token (optional for local servers)
+	Timeout time.Duration // Maximum time to wait for a completion
+}
+
+// StorageConfig holds persistence settings
+type StorageConfig struct {
+	Backend              string        // "sqlite" or "postgres"
+	DSN                  string        // SQLite file path or PostgreSQL connection string
+	CounterFlushInterval time.Duration // How often buffered message counters are written to storage
+	RetentionInterval    time.Duration // Time between message pruning passes
+	RetentionBatchSize   int           // Messages deleted per statement while pruning
+	AllowNewerSchema     bool          // Start even if the database was migrated by a newer binary
+}
+
+// TransportConfig holds update delivery settings
+type TransportConfig struct {
+	Mode              string // "polling" (getUpdates) or "webhook"
+	WebhookListenAddr string // Local address the webhook server listens on
+	WebhookURL        string // Public HTTPS URL registered with Telegram
+	WebhookSecret     string // Secret token Telegram sends with every webhook request
 }
 
-// Load loads configuration from environment variables
-func Load() (*Config, error) {
-	token := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if token == "" {
-		return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN environment variable is required")
+// MonitoringConfig configures the HTTP server for metrics scrapers and
+// health probes
+type MonitoringConfig struct {
+	ListenAddr   string        // Address serving /metrics, /healthz and /readyz; empty disables the server
+	MaxUpdateAge time.Duration // In polling mode, readiness fails when no getUpdates poll has returned for this long
+}
+
+// LoggingConfig holds log output settings
+type LoggingConfig struct {
+	Level         string // "debug", "info", "warn" or "error"
+	Format        string // "text" or "json"
+	RedactUserIDs bool   // Log keyed hashes instead of user IDs and usernames
+	MessageText   string // "drop", "truncate" or "full"
+	TraceMentions bool   // Log mention detection for every message, whatever the level
+}
+
+// SlogLevel returns the configured level for log/slog
+func (l LoggingConfig) SlogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return slog.LevelInfo
 	}
+	return level
+}
 
-	username := os.Getenv("BOT_USERNAME")
-	if username == "" {
-		return nil, fmt.Errorf("BOT_USERNAME environment variable is required")
+// Default returns the configuration used for anything not set elsewhere
+func Default() *Config {
+	return &Config{
+		ResponseFrequency: 10,
+		RespondToMentions: true,
+		Responder: ResponderConfig{
+			Backend: "canned",
+			BaseURL: "https://api.openai.com/v1",
+			Model:   "gpt-4o-mini",
+			Timeout: 30 * time.Second,
+		},
+		ContextMessages:    20,
+		ContextMaxChars:    8000,
+		Profiler:           "keyword",
+		ProfilerInterval:   10 * time.Minute,
+		AdminCacheTTL:      5 * time.Minute,
+		CommandPermissions: map[string]string{},
+		ShutdownTimeout:    10 * time.Second,
+		Workers:            4,
+		QueueSize:          100,
+		Storage: StorageConfig{
+			Backend:              StorageSQLite,
+			CounterFlushInterval: 30 * time.Second,
+			RetentionInterval:    time.Hour,
+			RetentionBatchSize:   500,
+		},
+		Transport: TransportConfig{
+			Mode:              ModePolling,
+			WebhookListenAddr: ":8080",
+		},
+		Monitoring: MonitoringConfig{
+			MaxUpdateAge: time.Hour,
+		},
+		Logging: LoggingConfig{
+			Level:         "info",
+			Format:        LogText,
+			RedactUserIDs: true,
+			MessageText:   logging.TextDrop,
+		},
 	}
+}
+
+// Load builds the configuration from, in increasing precedence: the
+// defaults, the configuration file (-config or BOT_CONFIG_FILE), environment
+// variables and command-line flags. flags may be nil. Every problem found is
+// reported at once in a *ValidationError.
+func Load(flags *Flags) (*Config, error) {
+	values, errs := collect(flags)
 
-	// Load response frequency (default: 10)
-	frequency := 10
-	if freqStr := os.Getenv("BOT_RESPONSE_FREQUENCY"); freqStr != "" {
-		if f, err := strconv.Atoi(freqStr); err == nil {
-			frequency = f
+	cfg := Default()
+	errs = append(errs, apply(cfg, values, "")...)
+	errs = append(errs, cfg.validate(values)...)
+	errs = append(errs, cfg.Storage.validate()...)
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+	return cfg, nil
+}
+
+// LoadStorage loads only the storage section. It is separate from Load so
+// database tooling can run without bot credentials.
+func LoadStorage(flags *Flags) (StorageConfig, error) {
+	values, errs := collect(flags)
+
+	cfg := Default()
+	errs = append(errs, apply(cfg, values, "storage.")...)
+	errs = append(errs, cfg.Storage.validate()...)
+	if len(errs) > 0 {
+		return StorageConfig{}, &ValidationError{Errors: errs}
+	}
+	return cfg.Storage, nil
+}
+
+// validate checks settings that depend on each other or are required
+func (c *Config) validate(values map[string]value) []*FieldError {
+	var errs []*FieldError
+	// A token file that failed to read has already been reported
+	if _, fromFile := values["telegram.token_file"]; c.TelegramToken == "" && !fromFile {
+		errs = append(errs, &FieldError{Key: "telegram.token", Err: "is required; set TELEGRAM_BOT_TOKEN or telegram.token_file"})
+	}
+	if c.BotUsername == "" {
+		errs = append(errs, &FieldError{Key: "telegram.username", Err: "is required; set BOT_USERNAME"})
+	}
+
+	if err := permissions.ValidateOverrides(c.CommandPermissions); err != nil {
+		errs = append(errs, values["bot.command_permissions"].errorf("bot.command_permissions", "%v", err))
+	}
+
+	if c.Transport.Mode == ModeWebhook {
+		if c.Transport.WebhookURL == "" {
+			errs = append(errs, &FieldError{Key: "transport.webhook_url", Err: "is required in webhook mode"})
+		}
+		if c.Transport.WebhookSecret != "" && !webhookSecretPattern.MatchString(c.Transport.WebhookSecret) {
+			errs = append(errs, values["transport.webhook_secret"].errorf("transport.webhook_secret", "must be 1-256 characters of A-Z, a-z, 0-9, _ and -"))
+		}
+		if c.Monitoring.ListenAddr != "" && c.Monitoring.ListenAddr == c.Transport.WebhookListenAddr {
+			errs = append(errs, values["monitoring.listen_addr"].errorf("monitoring.listen_addr", "must differ from transport.webhook_listen_addr"))
 		}
 	}
+	return errs
+}
 
-	// Load respond to mentions setting (default: true)
-	respondToMentions := true
-	if mentionsStr := os.Getenv("BOT_RESPOND_TO_MENTIONS"); mentionsStr != "" {
-		if mentionsStr == "false" || mentionsStr == "0" {
-			respondToMentions = false
+// validate checks the storage settings and fills in the default SQLite path
+func (s *StorageConfig) validate() []*FieldError {
+	if s.DSN != "" {
+		return nil
+	}
+	if s.Backend == StoragePostgres {
+		return []*FieldError{{Key: "storage.dsn", Err: "is required for the postgres backend"}}
+	}
+	s.DSN = defaultSQLitePath
+	return nil
+}
+
+// parseOwners parses a comma-separated list of user IDs, e.g. "12345,67890"
+func parseOwners(value string) ([]int64, error) {
+	var owners []int64
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
 		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be comma-separated user IDs, got %q", field)
+		}
+		owners = append(owners, id)
 	}
+	return owners, nil
+}
 
-	return &Config{
-		TelegramToken:     token,
-		BotUsername:       username,
-		ResponseFrequency: frequency,
-		RespondToMentions: respondToMentions,
-	}, nil
+// parseCommandPermissions parses comma-separated command=level pairs, e.g.
+// "setretention=moderator,resetsettings=owner". Commands and levels are
+// checked by validate.
+func parseCommandPermissions(value string) (map[string]string, error) {
+	permissions := make(map[string]string)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		command, level, ok := strings.Cut(field, "=")
+		command = strings.TrimPrefix(strings.TrimSpace(command), "/")
+		if !ok || command == "" {
+			return nil, fmt.Errorf("entries must look like command=level, got %q", field)
+		}
+		permissions[command] = strings.TrimSpace(level)
+	}
+	return permissions, nil
+}
+
+// readSecret reads a secret from a file, ignoring surrounding whitespace such
+// as the trailing newline most editors add
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
 }
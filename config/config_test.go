@@ -1,8 +1,13 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad_Success(t *testing.T) {
@@ -12,7 +17,7 @@ func TestLoad_Success(t *testing.T) {
 	defer os.Unsetenv("TELEGRAM_BOT_TOKEN")
 	defer os.Unsetenv("BOT_USERNAME")
 
-	cfg, err := Load()
+	cfg, err := Load(nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -40,7 +45,7 @@ func TestLoad_MissingToken(t *testing.T) {
 	os.Unsetenv("TELEGRAM_BOT_TOKEN")
 	os.Unsetenv("BOT_USERNAME")
 
-	cfg, err := Load()
+	cfg, err := Load(nil)
 	if err == nil {
 		t.Fatal("Expected error for missing token, got nil")
 	}
@@ -55,7 +60,7 @@ func TestLoad_MissingUsername(t *testing.T) {
 	os.Unsetenv("BOT_USERNAME")
 	defer os.Unsetenv("TELEGRAM_BOT_TOKEN")
 
-	cfg, err := Load()
+	cfg, err := Load(nil)
 	if err == nil {
 		t.Fatal("Expected error for missing username, got nil")
 	}
@@ -77,7 +82,7 @@ func TestLoad_CustomSettings(t *testing.T) {
 		os.Unsetenv("BOT_RESPOND_TO_MENTIONS")
 	}()
 
-	cfg, err := Load()
+	cfg, err := Load(nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -102,13 +107,555 @@ func TestLoad_InvalidFrequency(t *testing.T) {
 		os.Unsetenv("BOT_RESPONSE_FREQUENCY")
 	}()
 
-	cfg, err := Load()
+	_, err := Load(nil)
+	expectFieldErrors(t, err, "bot.response_frequency")
+}
+
+func TestLoad_ResponderDefaults(t *testing.T) {
+	os.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	os.Setenv("BOT_USERNAME", "test_bot")
+	defer os.Unsetenv("TELEGRAM_BOT_TOKEN")
+	defer os.Unsetenv("BOT_USERNAME")
+
+	cfg, err := Load(nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 
-	// Should fall back to default
-	if cfg.ResponseFrequency != 10 {
-		t.Errorf("Expected default ResponseFrequency 10 for invalid value, got %d", cfg.ResponseFrequency)
+	if cfg.Responder.Backend != "canned" {
+		t.Errorf("Expected default Responder 'canned', got '%s'", cfg.Responder.Backend)
+	}
+	if cfg.Responder.BaseURL != "https://api.openai.com/v1" {
+		t.Errorf("Expected default LLMBaseURL, got '%s'", cfg.Responder.BaseURL)
+	}
+	if cfg.Responder.Timeout != 30*time.Second {
+		t.Errorf("Expected default LLMTimeout 30s, got %v", cfg.Responder.Timeout)
+	}
+	if cfg.ShutdownTimeout != 10*time.Second {
+		t.Errorf("Expected default ShutdownTimeout 10s, got %v", cfg.ShutdownTimeout)
+	}
+	if cfg.AdminCacheTTL != 5*time.Minute {
+		t.Errorf("Expected default AdminCacheTTL 5m, got %v", cfg.AdminCacheTTL)
+	}
+}
+
+func TestLoad_ResponderSettings(t *testing.T) {
+	os.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	os.Setenv("BOT_USERNAME", "test_bot")
+	os.Setenv("BOT_RESPONDER", "openai")
+	os.Setenv("LLM_BASE_URL", "http://localhost:8080/v1")
+	os.Setenv("LLM_MODEL", "local-model")
+	os.Setenv("LLM_API_KEY", "secret")
+	os.Setenv("LLM_TIMEOUT", "5s")
+	defer func() {
+		os.Unsetenv("TELEGRAM_BOT_TOKEN")
+		os.Unsetenv("BOT_USERNAME")
+		os.Unsetenv("BOT_RESPONDER")
+		os.Unsetenv("LLM_BASE_URL")
+		os.Unsetenv("LLM_MODEL")
+		os.Unsetenv("LLM_API_KEY")
+		os.Unsetenv("LLM_TIMEOUT")
+	}()
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.Responder.Backend != "openai" {
+		t.Errorf("Expected Responder 'openai', got '%s'", cfg.Responder.Backend)
+	}
+	if cfg.Responder.BaseURL != "http://localhost:8080/v1" {
+		t.Errorf("Expected custom LLMBaseURL, got '%s'", cfg.Responder.BaseURL)
+	}
+	if cfg.Responder.Model != "local-model" {
+		t.Errorf("Expected LLMModel 'local-model', got '%s'", cfg.Responder.Model)
+	}
+	if cfg.Responder.APIKey != "secret" {
+		t.Errorf("Expected LLMAPIKey 'secret', got '%s'", cfg.Responder.APIKey)
+	}
+	if cfg.Responder.Timeout != 5*time.Second {
+		t.Errorf("Expected LLMTimeout 5s, got %v", cfg.Responder.Timeout)
+	}
+}
+
+func TestLoad_ContextLimits(t *testing.T) {
+	os.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	os.Setenv("BOT_USERNAME", "test_bot")
+	os.Setenv("BOT_CONTEXT_MESSAGES", "50")
+	os.Setenv("BOT_CONTEXT_MAX_CHARS", "-1")
+	defer func() {
+		os.Unsetenv("TELEGRAM_BOT_TOKEN")
+		os.Unsetenv("BOT_USERNAME")
+		os.Unsetenv("BOT_CONTEXT_MESSAGES")
+		os.Unsetenv("BOT_CONTEXT_MAX_CHARS")
+	}()
+
+	_, err := Load(nil)
+	expectFieldErrors(t, err, "bot.context_max_chars")
+
+	os.Setenv("BOT_CONTEXT_MAX_CHARS", "4000")
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.ContextMessages != 50 {
+		t.Errorf("Expected ContextMessages 50, got %d", cfg.ContextMessages)
+	}
+	if cfg.ContextMaxChars != 4000 {
+		t.Errorf("Expected ContextMaxChars 4000, got %d", cfg.ContextMaxChars)
+	}
+}
+
+func TestLoad_ProfilerSettings(t *testing.T) {
+	os.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	os.Setenv("BOT_USERNAME", "test_bot")
+	os.Setenv("BOT_PROFILER", "llm")
+	os.Setenv("BOT_PROFILER_INTERVAL", "1h")
+	defer func() {
+		os.Unsetenv("TELEGRAM_BOT_TOKEN")
+		os.Unsetenv("BOT_USERNAME")
+		os.Unsetenv("BOT_PROFILER")
+		os.Unsetenv("BOT_PROFILER_INTERVAL")
+	}()
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.Profiler != "llm" {
+		t.Errorf("Expected Profiler 'llm', got '%s'", cfg.Profiler)
+	}
+	if cfg.ProfilerInterval != time.Hour {
+		t.Errorf("Expected ProfilerInterval 1h, got %v", cfg.ProfilerInterval)
+	}
+}
+
+func TestLoad_WorkerPool(t *testing.T) {
+	os.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	os.Setenv("BOT_USERNAME", "test_bot")
+	os.Setenv("BOT_WORKERS", "16")
+	os.Setenv("BOT_QUEUE_SIZE", "0")
+	defer func() {
+		os.Unsetenv("TELEGRAM_BOT_TOKEN")
+		os.Unsetenv("BOT_USERNAME")
+		os.Unsetenv("BOT_WORKERS")
+		os.Unsetenv("BOT_QUEUE_SIZE")
+	}()
+
+	// Zero is not a usable queue size
+	_, err := Load(nil)
+	expectFieldErrors(t, err, "bot.queue_size")
+
+	os.Unsetenv("BOT_QUEUE_SIZE")
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.Workers != 16 {
+		t.Errorf("Expected Workers 16, got %d", cfg.Workers)
+	}
+	if cfg.QueueSize != 100 {
+		t.Errorf("Expected default QueueSize 100, got %d", cfg.QueueSize)
+	}
+	if cfg.Storage.CounterFlushInterval != 30*time.Second {
+		t.Errorf("Expected default CounterFlushInterval 30s, got %v", cfg.Storage.CounterFlushInterval)
+	}
+	if cfg.Storage.AllowNewerSchema {
+		t.Error("Expected AllowNewerSchema to default to false")
+	}
+}
+
+func TestLoad_Mode(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		expectError bool
+		expectMode  string
+	}{
+		{
+			name:       "Default polling",
+			env:        map[string]string{},
+			expectMode: ModePolling,
+		},
+		{
+			name: "Webhook",
+			env: map[string]string{
+				"BOT_MODE":       "webhook",
+				"WEBHOOK_URL":    "https://example.com/telegram",
+				"WEBHOOK_SECRET": "abc_DEF-123",
+			},
+			expectMode: ModeWebhook,
+		},
+		{
+			name:        "Webhook without URL",
+			env:         map[string]string{"BOT_MODE": "webhook"},
+			expectError: true,
+		},
+		{
+			name: "Webhook with invalid secret",
+			env: map[string]string{
+				"BOT_MODE":       "webhook",
+				"WEBHOOK_URL":    "https://example.com/telegram",
+				"WEBHOOK_SECRET": "not allowed!",
+			},
+			expectError: true,
+		},
+		{
+			name: "Webhook sharing the monitoring address",
+			env: map[string]string{
+				"BOT_MODE":            "webhook",
+				"WEBHOOK_URL":         "https://example.com/telegram",
+				"BOT_MONITORING_ADDR": ":8080",
+			},
+			expectError: true,
+		},
+		{
+			name:        "Unknown mode",
+			env:         map[string]string{"BOT_MODE": "carrier-pigeon"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+			t.Setenv("BOT_USERNAME", "test_bot")
+			for _, key := range []string{"BOT_MODE", "WEBHOOK_URL", "WEBHOOK_SECRET", "WEBHOOK_LISTEN_ADDR", "BOT_MONITORING_ADDR"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			cfg, err := Load(nil)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if cfg.Transport.Mode != tt.expectMode {
+				t.Errorf("Expected Mode '%s', got '%s'", tt.expectMode, cfg.Transport.Mode)
+			}
+			if cfg.Transport.WebhookListenAddr != ":8080" {
+				t.Errorf("Expected default WebhookListenAddr ':8080', got '%s'", cfg.Transport.WebhookListenAddr)
+			}
+			if cfg.Transport.WebhookSecret != tt.env["WEBHOOK_SECRET"] {
+				t.Errorf("Expected WebhookSecret '%s', got '%s'", tt.env["WEBHOOK_SECRET"], cfg.Transport.WebhookSecret)
+			}
+		})
+	}
+}
+
+func TestLoad_Monitoring(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	t.Setenv("BOT_USERNAME", "test_bot")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Monitoring.MaxUpdateAge != time.Hour {
+		t.Errorf("Expected default MaxUpdateAge 1h, got %v", cfg.Monitoring.MaxUpdateAge)
+	}
+
+	// A limit of zero or less would keep readiness failing forever
+	for _, age := range []string{"0", "-5m"} {
+		t.Setenv("BOT_MONITORING_MAX_UPDATE_AGE", age)
+		_, err := Load(nil)
+		expectFieldErrors(t, err, "monitoring.max_update_age")
+	}
+
+	t.Setenv("BOT_MONITORING_MAX_UPDATE_AGE", "30m")
+	cfg, err = Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Monitoring.MaxUpdateAge != 30*time.Minute {
+		t.Errorf("Expected MaxUpdateAge 30m, got %v", cfg.Monitoring.MaxUpdateAge)
+	}
+}
+
+func TestLoad_Storage(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		expectError   bool
+		expectBackend string
+		expectDSN     string
+	}{
+		{
+			name:          "Default SQLite",
+			env:           map[string]string{},
+			expectBackend: StorageSQLite,
+			expectDSN:     "bot_data.db",
+		},
+		{
+			name:          "SQLite with custom path",
+			env:           map[string]string{"STORAGE_DSN": "/var/lib/howard/bot.db"},
+			expectBackend: StorageSQLite,
+			expectDSN:     "/var/lib/howard/bot.db",
+		},
+		{
+			name: "Postgres",
+			env: map[string]string{
+				"STORAGE_BACKEND": "postgres",
+				"STORAGE_DSN":     "postgres://bot@localhost/howard?sslmode=disable",
+			},
+			expectBackend: StoragePostgres,
+			expectDSN:     "postgres://bot@localhost/howard?sslmode=disable",
+		},
+		{
+			name:        "Postgres without DSN",
+			env:         map[string]string{"STORAGE_BACKEND": "postgres"},
+			expectError: true,
+		},
+		{
+			name:        "Unknown backend",
+			env:         map[string]string{"STORAGE_BACKEND": "mongodb"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+			t.Setenv("BOT_USERNAME", "test_bot")
+			for _, key := range []string{"STORAGE_BACKEND", "STORAGE_DSN"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			cfg, err := Load(nil)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if cfg.Storage.Backend != tt.expectBackend {
+				t.Errorf("Expected StorageBackend '%s', got '%s'", tt.expectBackend, cfg.Storage.Backend)
+			}
+			if cfg.Storage.DSN != tt.expectDSN {
+				t.Errorf("Expected StorageDSN '%s', got '%s'", tt.expectDSN, cfg.Storage.DSN)
+			}
+		})
+	}
+}
+
+func TestLoad_Retention(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	t.Setenv("BOT_USERNAME", "test_bot")
+	t.Setenv("BOT_RETENTION_INTERVAL", "")
+	t.Setenv("BOT_RETENTION_BATCH_SIZE", "")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Storage.RetentionInterval != time.Hour {
+		t.Errorf("Expected default RetentionInterval 1h, got %v", cfg.Storage.RetentionInterval)
+	}
+	if cfg.Storage.RetentionBatchSize != 500 {
+		t.Errorf("Expected default RetentionBatchSize 500, got %d", cfg.Storage.RetentionBatchSize)
+	}
+
+	t.Setenv("BOT_RETENTION_INTERVAL", "15m")
+	t.Setenv("BOT_RETENTION_BATCH_SIZE", "200")
+
+	cfg, err = Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Storage.RetentionInterval != 15*time.Minute {
+		t.Errorf("Expected RetentionInterval 15m, got %v", cfg.Storage.RetentionInterval)
+	}
+	if cfg.Storage.RetentionBatchSize != 200 {
+		t.Errorf("Expected RetentionBatchSize 200, got %d", cfg.Storage.RetentionBatchSize)
+	}
+
+	// Negative batch sizes and intervals are rejected together
+	t.Setenv("BOT_RETENTION_INTERVAL", "-1h")
+	t.Setenv("BOT_RETENTION_BATCH_SIZE", "-1")
+	_, err = Load(nil)
+	expectFieldErrors(t, err, "storage.retention_interval", "storage.retention_batch_size")
+}
+
+func TestLoad_Permissions(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	t.Setenv("BOT_USERNAME", "test_bot")
+	t.Setenv("BOT_OWNERS", "12345, 67890")
+	t.Setenv("BOT_COMMAND_PERMISSIONS", "setretention=moderator, /resetsettings=owner")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.Owners) != 2 || cfg.Owners[0] != 12345 || cfg.Owners[1] != 67890 {
+		t.Errorf("Expected owners [12345 67890], got %v", cfg.Owners)
+	}
+	if cfg.CommandPermissions["setretention"] != "moderator" || cfg.CommandPermissions["resetsettings"] != "owner" {
+		t.Errorf("Expected parsed command permissions, got %v", cfg.CommandPermissions)
+	}
+
+	// Unknown commands and levels are reported with the other field errors
+	t.Setenv("BOT_COMMAND_PERMISSIONS", "setfrequncy=admin,setretention=superuser")
+	_, err = Load(nil)
+	expectFieldErrors(t, err, "bot.command_permissions")
+	if !strings.Contains(err.Error(), "unknown command /setfrequncy") {
+		t.Errorf("Expected the misspelled command to be named, got %v", err)
+	}
+	t.Setenv("BOT_COMMAND_PERMISSIONS", "setretention=moderator, /resetsettings=owner")
+
+	for key, value := range map[string]string{"BOT_OWNERS": "@alice", "BOT_COMMAND_PERMISSIONS": "setretention"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := Load(nil); err == nil {
+				t.Errorf("Expected an error for %s=%s", key, value)
+			}
+		})
+	}
+}
+
+// expectFieldErrors checks that err reports problems with exactly these keys
+func expectFieldErrors(t *testing.T, err error, keys ...string) {
+	t.Helper()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected a validation error for %v, got %v", keys, err)
+	}
+	var got []string
+	for _, fe := range verr.Errors {
+		got = append(got, fe.Key)
+	}
+	if strings.Join(got, " ") != strings.Join(keys, " ") {
+		t.Errorf("Expected errors for %v, got:\n%v", keys, err)
+	}
+}
+
+// writeFile writes a file in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoad_File(t *testing.T) {
+	tokenPath := writeFile(t, "token", "file_token\n")
+	path := writeFile(t, "bot.yaml", `
+telegram:
+  token_file: `+tokenPath+`
+  username: file_bot
+bot:
+  response_frequency: 3
+  respond_to_mentions: false
+  owners: [12345, 67890]
+  command_permissions:
+    setretention: moderator
+responder:
+  backend: openai
+  timeout: 5s
+storage:
+  backend: postgres
+  dsn: postgres://bot@localhost/howard
+logging:
+  format: json
+  redact_user_ids: false
+  message_text: truncate
+`)
+	t.Setenv("BOT_CONFIG_FILE", path)
+	t.Setenv("TELEGRAM_BOT_TOKEN", "")
+	t.Setenv("BOT_USERNAME", "")
+	t.Setenv("BOT_RESPONSE_FREQUENCY", "7")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse([]string{"-bot.response_frequency=8", "-bot.respond_to_mentions"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	cfg, err := Load(flags)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.TelegramToken != "file_token" || cfg.BotUsername != "file_bot" {
+		t.Errorf("Expected credentials from the file, got %q and %q", cfg.TelegramToken, cfg.BotUsername)
+	}
+	// Flags beat environment variables, which beat the file
+	if cfg.ResponseFrequency != 8 || !cfg.RespondToMentions {
+		t.Errorf("Expected flags to win, got frequency %d, mentions %v", cfg.ResponseFrequency, cfg.RespondToMentions)
+	}
+	if len(cfg.Owners) != 2 || cfg.CommandPermissions["setretention"] != "moderator" {
+		t.Errorf("Expected a list and a map from the file, got %v and %v", cfg.Owners, cfg.CommandPermissions)
+	}
+	if cfg.Responder.Backend != "openai" || cfg.Responder.Timeout != 5*time.Second {
+		t.Errorf("Expected the responder section, got %+v", cfg.Responder)
+	}
+	if cfg.Storage.Backend != StoragePostgres || cfg.Logging.Format != LogJSON {
+		t.Errorf("Expected the storage and logging sections, got %+v, %+v", cfg.Storage, cfg.Logging)
+	}
+	if cfg.Logging.RedactUserIDs || cfg.Logging.MessageText != "truncate" {
+		t.Errorf("Expected the redaction policy from the file, got %+v", cfg.Logging)
+	}
+
+	// The environment overrides a secret file from the config file
+	t.Setenv("TELEGRAM_BOT_TOKEN", "env_token")
+	if cfg, err := Load(flags); err != nil || cfg.TelegramToken != "env_token" {
+		t.Errorf("Expected the environment token, got %+v (error %v)", cfg, err)
+	}
+}
+
+func TestLoad_FileErrors(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	t.Setenv("BOT_USERNAME", "test_bot")
+	path := writeFile(t, "bot.yaml", `
+bot:
+  response_frequency: -2
+  workers: many
+  greeting: hello
+storage:
+  backend: postgres
+transport:
+  webhook_secret: abc
+  webhook_secret_file: /run/secrets/webhook
+`)
+	t.Setenv("BOT_CONFIG_FILE", path)
+
+	// Every problem is reported at once, naming the setting
+	_, err := Load(nil)
+	expectFieldErrors(t, err, "bot.greeting", "bot.response_frequency", "bot.workers", "transport.webhook_secret_file", "storage.dsn")
+	if !strings.Contains(err.Error(), "bot.workers: must be a whole number, got \"many\" (from "+path+")") {
+		t.Errorf("Expected the file named in the error, got:\n%v", err)
+	}
+
+	t.Setenv("BOT_CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	_, err = Load(nil)
+	expectFieldErrors(t, err, "config")
+}
+
+func TestLoadStorage(t *testing.T) {
+	// Storage tooling needs no bot credentials
+	t.Setenv("TELEGRAM_BOT_TOKEN", "")
+	t.Setenv("BOT_USERNAME", "")
+	t.Setenv("STORAGE_BACKEND", "postgres")
+	t.Setenv("STORAGE_DSN", "")
+	t.Setenv("STORAGE_DSN_FILE", writeFile(t, "dsn", "postgres://bot:secret@db/howard"))
+
+	cfg, err := LoadStorage(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.DSN != "postgres://bot:secret@db/howard" {
+		t.Errorf("Expected the DSN from STORAGE_DSN_FILE, got %q", cfg.DSN)
 	}
 }
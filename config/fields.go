@@ -0,0 +1,369 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/logging"
+)
+
+// field is one setting and the names it goes by in each source
+type field struct {
+	key     string   // Config file key and command-line flag, e.g. "storage.dsn"
+	env     string   // Environment variable
+	usage   string   // Flag help
+	secret  bool     // Also readable from the file named by key+"_file" or env+"_FILE"; never a flag itself
+	live    bool     // Applied by a reload; other settings need a restart
+	choices []string // Allowed values of a string setting
+	min     int      // Smallest allowed value of an integer setting
+
+	// value returns a pointer to the setting in c: *string, *int, *bool,
+	// *time.Duration, *[]int64 or *map[string]string
+	value func(c *Config) any
+}
+
+// fields lists every setting. The README and config.example.yaml document
+// them in the same order.
+var fields = []field{
+	{key: "telegram.token", env: "TELEGRAM_BOT_TOKEN", secret: true, usage: "Telegram bot token from BotFather",
+		value: func(c *Config) any { return &c.TelegramToken }},
+	{key: "telegram.username", env: "BOT_USERNAME", usage: "bot username without @",
+		value: func(c *Config) any { return &c.BotUsername }},
+
+	{key: "bot.response_frequency", env: "BOT_RESPONSE_FREQUENCY", usage: "respond to every Nth message; 0 for mentions only",
+		live: true, min: 0, value: func(c *Config) any { return &c.ResponseFrequency }},
+	{key: "bot.respond_to_mentions", env: "BOT_RESPOND_TO_MENTIONS", usage: "always respond when mentioned",
+		live: true, value: func(c *Config) any { return &c.RespondToMentions }},
+	{key: "bot.context_messages", env: "BOT_CONTEXT_MESSAGES", usage: "recent chat messages included in each prompt",
+		min: 1, value: func(c *Config) any { return &c.ContextMessages }},
+	{key: "bot.context_max_chars", env: "BOT_CONTEXT_MAX_CHARS", usage: "character budget per prompt",
+		min: 1, value: func(c *Config) any { return &c.ContextMaxChars }},
+	{key: "bot.profiler", env: "BOT_PROFILER", usage: "user profile extractor: keyword or llm",
+		choices: []string{"keyword", "llm"}, value: func(c *Config) any { return &c.Profiler }},
+	{key: "bot.profiler_interval", env: "BOT_PROFILER_INTERVAL", usage: "time between profiling passes",
+		value: func(c *Config) any { return &c.ProfilerInterval }},
+	{key: "bot.admin_cache_ttl", env: "BOT_ADMIN_CACHE_TTL", usage: "how long administrator lists are cached",
+		value: func(c *Config) any { return &c.AdminCacheTTL }},
+	{key: "bot.owners", env: "BOT_OWNERS", usage: "comma-separated user IDs allowed to manage every chat",
+		value: func(c *Config) any { return &c.Owners }},
+	{key: "bot.command_permissions", env: "BOT_COMMAND_PERMISSIONS", usage: "comma-separated command=level overrides",
+		value: func(c *Config) any { return &c.CommandPermissions }},
+	{key: "bot.shutdown_timeout", env: "BOT_SHUTDOWN_TIMEOUT", usage: "time allowed to finish in-flight work on shutdown",
+		value: func(c *Config) any { return &c.ShutdownTimeout }},
+	{key: "bot.workers", env: "BOT_WORKERS", usage: "updates handled concurrently",
+		min: 1, value: func(c *Config) any { return &c.Workers }},
+	{key: "bot.queue_size", env: "BOT_QUEUE_SIZE", usage: "updates buffered per worker",
+		min: 1, value: func(c *Config) any { return &c.QueueSize }},
+
+	{key: "responder.backend", env: "BOT_RESPONDER", usage: "response backend: canned or openai",
+		choices: []string{"canned", "openai"}, value: func(c *Config) any { return &c.Responder.Backend }},
+	{key: "responder.base_url", env: "LLM_BASE_URL", usage: "base URL of an OpenAI-compatible API",
+		value: func(c *Config) any { return &c.Responder.BaseURL }},
+	{key: "responder.model", env: "LLM_MODEL", usage: "model name",
+		value: func(c *Config) any { return &c.Responder.Model }},
+	{key: "responder.api_key", env: "LLM_API_KEY", secret: true, usage: "API key sent as a Bearer token",
+		value: func(c *Config) any { return &c.Responder.APIKey }},
+	{key: "responder.timeout", env: "LLM_TIMEOUT", usage: "completion request timeout",
+		value: func(c *Config) any { return &c.Responder.Timeout }},
+
+	{key: "storage.backend", env: "STORAGE_BACKEND", usage: "database backend: sqlite or postgres",
+		choices: []string{StorageSQLite, StoragePostgres}, value: func(c *Config) any { return &c.Storage.Backend }},
+	{key: "storage.dsn", env: "STORAGE_DSN", secret: true, usage: "SQLite file path or PostgreSQL connection string",
+		value: func(c *Config) any { return &c.Storage.DSN }},
+	{key: "storage.counter_flush_interval", env: "BOT_COUNTER_FLUSH_INTERVAL", usage: "how often message counters are written",
+		value: func(c *Config) any { return &c.Storage.CounterFlushInterval }},
+	{key: "storage.retention_interval", env: "BOT_RETENTION_INTERVAL", usage: "time between message pruning passes",
+		value: func(c *Config) any { return &c.Storage.RetentionInterval }},
+	{key: "storage.retention_batch_size", env: "BOT_RETENTION_BATCH_SIZE", usage: "messages deleted per batch while pruning",
+		min: 1, value: func(c *Config) any { return &c.Storage.RetentionBatchSize }},
+	{key: "storage.allow_newer_schema", env: "BOT_ALLOW_NEWER_SCHEMA", usage: "start even if the database schema is newer",
+		value: func(c *Config) any { return &c.Storage.AllowNewerSchema }},
+
+	{key: "transport.mode", env: "BOT_MODE", usage: "update delivery: polling or webhook",
+		choices: []string{ModePolling, ModeWebhook}, value: func(c *Config) any { return &c.Transport.Mode }},
+	{key: "transport.webhook_url", env: "WEBHOOK_URL", usage: "public HTTPS URL Telegram posts updates to",
+		value: func(c *Config) any { return &c.Transport.WebhookURL }},
+	{key: "transport.webhook_listen_addr", env: "WEBHOOK_LISTEN_ADDR", usage: "local address of the webhook server",
+		value: func(c *Config) any { return &c.Transport.WebhookListenAddr }},
+	{key: "transport.webhook_secret", env: "WEBHOOK_SECRET", secret: true, usage: "secret token checked on webhook requests",
+		value: func(c *Config) any { return &c.Transport.WebhookSecret }},
+
+	{key: "monitoring.listen_addr", env: "BOT_MONITORING_ADDR", usage: "address of the HTTP server for /metrics, /healthz and /readyz, e.g. :9090; empty disables it",
+		value: func(c *Config) any { return &c.Monitoring.ListenAddr }},
+	{key: "monitoring.max_update_age", env: "BOT_MONITORING_MAX_UPDATE_AGE", usage: "in polling mode, readiness fails when no getUpdates poll has returned for this long",
+		live: true, value: func(c *Config) any { return &c.Monitoring.MaxUpdateAge }},
+
+	{key: "logging.level", env: "BOT_LOG_LEVEL", usage: "minimum log level: debug, info, warn or error",
+		live: true, choices: []string{"debug", "info", "warn", "error"}, value: func(c *Config) any { return &c.Logging.Level }},
+	{key: "logging.format", env: "BOT_LOG_FORMAT", usage: "log output: text or json",
+		choices: []string{LogText, LogJSON}, value: func(c *Config) any { return &c.Logging.Format }},
+	{key: "logging.redact_user_ids", env: "BOT_LOG_REDACT_USER_IDS", usage: "log hashes instead of user IDs and usernames",
+		value: func(c *Config) any { return &c.Logging.RedactUserIDs }},
+	{key: "logging.message_text", env: "BOT_LOG_MESSAGE_TEXT", usage: "message text in logs: drop, truncate or full",
+		choices: []string{logging.TextDrop, logging.TextTruncate, logging.TextFull}, value: func(c *Config) any { return &c.Logging.MessageText }},
+	{key: "logging.trace_mentions", env: "BOT_LOG_TRACE_MENTIONS", usage: "log how every message is checked for mentions",
+		live: true, value: func(c *Config) any { return &c.Logging.TraceMentions }},
+}
+
+// Sources in increasing precedence
+const (
+	layerFile = iota
+	layerEnv
+	layerFlag
+)
+
+// value is a setting as written in one source, before parsing
+type value struct {
+	raw    string
+	source string // Where it was set, e.g. "BOT_WORKERS" or "/etc/howard/bot.yaml"
+	layer  int
+}
+
+// errorf reports a problem with the setting at key, naming where it was set
+func (v value) errorf(key, format string, args ...any) *FieldError {
+	return &FieldError{Key: key, Source: v.source, Err: fmt.Sprintf(format, args...)}
+}
+
+// FieldError is a problem with one setting
+type FieldError struct {
+	Key    string // Config file key, e.g. "storage.dsn"
+	Source string // Where the bad value came from; empty if it was missing
+	Err    string
+}
+
+func (e *FieldError) Error() string {
+	if e.Source == "" {
+		return e.Key + ": " + e.Err
+	}
+	return fmt.Sprintf("%s: %s (from %s)", e.Key, e.Err, e.Source)
+}
+
+// ValidationError lists every problem found while loading the configuration
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		lines[i] = "  - " + err.Error()
+	}
+	return "invalid configuration:\n" + strings.Join(lines, "\n")
+}
+
+// Flags holds the settings given on the command line
+type Flags struct {
+	file   string
+	values map[string]string
+}
+
+// RegisterFlags adds -config and a flag per setting, named after its config
+// file key (e.g. -storage.dsn), to fs. Secrets only get a *_file flag so
+// they never show up in the process list.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: make(map[string]string)}
+	fs.StringVar(&f.file, "config", "", "YAML configuration file (default: $BOT_CONFIG_FILE)")
+
+	for _, fd := range fields {
+		key := fd.key
+		usage := fd.usage + " (" + fd.env + ")"
+		if fd.secret {
+			key += "_file"
+			usage = "file containing the " + fd.usage + " (" + fd.env + "_FILE)"
+		}
+		set := func(v string) error {
+			f.values[key] = v
+			return nil
+		}
+		if _, isBool := fd.value(&Config{}).(*bool); isBool {
+			fs.BoolFunc(key, usage, set)
+		} else {
+			fs.Func(key, usage, set)
+		}
+	}
+	return f
+}
+
+// collect gathers the raw settings from every source. Later sources replace
+// earlier ones.
+func collect(flags *Flags) (map[string]value, []*FieldError) {
+	values := make(map[string]value)
+	var errs []*FieldError
+
+	if path := filePath(flags); path != "" {
+		fileValues, fileErrs := readFile(path)
+		errs = append(errs, fileErrs...)
+		for key, v := range fileValues {
+			values[key] = v
+		}
+	}
+
+	for _, fd := range fields {
+		if raw := os.Getenv(fd.env); raw != "" {
+			values[fd.key] = value{raw: raw, source: fd.env, layer: layerEnv}
+		}
+		if raw := os.Getenv(fd.env + "_FILE"); raw != "" && fd.secret {
+			values[fd.key+"_file"] = value{raw: raw, source: fd.env + "_FILE", layer: layerEnv}
+		}
+	}
+
+	if flags != nil {
+		for key, raw := range flags.values {
+			values[key] = value{raw: raw, source: "-" + key, layer: layerFlag}
+		}
+	}
+	return values, errs
+}
+
+// filePath returns the configuration file to read, if any
+func filePath(flags *Flags) string {
+	if flags != nil && flags.file != "" {
+		return flags.file
+	}
+	return os.Getenv("BOT_CONFIG_FILE")
+}
+
+// apply parses the collected settings whose keys start with prefix into c
+func apply(c *Config, values map[string]value, prefix string) []*FieldError {
+	var errs []*FieldError
+	for _, fd := range fields {
+		if !strings.HasPrefix(fd.key, prefix) {
+			continue
+		}
+
+		v, ok := values[fd.key]
+		if fd.secret {
+			fromFile, hasFile := values[fd.key+"_file"]
+			switch {
+			case hasFile && ok && fromFile.layer == v.layer:
+				errs = append(errs, fromFile.errorf(fd.key+"_file", "cannot be used together with %s (from %s)", fd.key, v.source))
+				continue
+			case hasFile && (!ok || fromFile.layer > v.layer):
+				secret, err := readSecret(fromFile.raw)
+				if err != nil {
+					errs = append(errs, fromFile.errorf(fd.key+"_file", "%v", err))
+					continue
+				}
+				v, ok = value{raw: secret, source: fromFile.source, layer: fromFile.layer}, true
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		if err := fd.set(c, v.raw); err != nil {
+			errs = append(errs, v.errorf(fd.key, "%v", err))
+		}
+	}
+	return errs
+}
+
+// knownKey reports whether key is a setting, including *_file variants of secrets
+func knownKey(key string) bool {
+	return slices.ContainsFunc(fields, func(fd field) bool {
+		return fd.key == key || (fd.secret && fd.key+"_file" == key)
+	})
+}
+
+// set parses value into the setting in c
+func (fd field) set(c *Config, value string) error {
+	switch p := fd.value(c).(type) {
+	case *string:
+		if fd.choices != nil && !slices.Contains(fd.choices, value) {
+			return fmt.Errorf("must be one of %s, got %q", strings.Join(fd.choices, ", "), value)
+		}
+		*p = value
+	case *int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("must be a whole number, got %q", value)
+		}
+		if n < fd.min {
+			return fmt.Errorf("must be at least %d, got %d", fd.min, n)
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("must be true or false, got %q", value)
+		}
+		*p = b
+	case *time.Duration:
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 30s or 5m, got %q", value)
+		}
+		if d <= 0 {
+			return fmt.Errorf("must be positive, got %s", d)
+		}
+		*p = d
+	case *[]int64:
+		owners, err := parseOwners(value)
+		if err != nil {
+			return err
+		}
+		*p = owners
+	case *map[string]string:
+		permissions, err := parseCommandPermissions(value)
+		if err != nil {
+			return err
+		}
+		*p = permissions
+	default:
+		return fmt.Errorf("unsupported setting type %T", p)
+	}
+	return nil
+}
+
+// copyValue sets the setting in dst to its value in src
+func (fd field) copyValue(dst, src *Config) {
+	switch p := fd.value(dst).(type) {
+	case *string:
+		*p = *fd.value(src).(*string)
+	case *int:
+		*p = *fd.value(src).(*int)
+	case *bool:
+		*p = *fd.value(src).(*bool)
+	case *time.Duration:
+		*p = *fd.value(src).(*time.Duration)
+	case *[]int64:
+		*p = slices.Clone(*fd.value(src).(*[]int64))
+	case *map[string]string:
+		*p = maps.Clone(*fd.value(src).(*map[string]string))
+	}
+}
+
+// format returns the setting in c in the form set accepts
+func (fd field) format(c *Config) string {
+	switch p := fd.value(c).(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *time.Duration:
+		return p.String()
+	case *[]int64:
+		ids := make([]string, len(*p))
+		for i, id := range *p {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		return strings.Join(ids, ",")
+	case *map[string]string:
+		pairs := make([]string, 0, len(*p))
+		for command, level := range *p {
+			pairs = append(pairs, command+"="+level)
+		}
+		slices.Sort(pairs)
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(p)
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readFile reads a YAML configuration file. Settings are grouped in sections
+// named after the first part of their key:
+//
+//	storage:
+//	  backend: postgres
+//	  dsn_file: /run/secrets/db_dsn
+//
+// Lists and maps are accepted where the environment variable takes a
+// comma-separated list, e.g. bot.owners and bot.command_permissions.
+func readFile(path string) (map[string]value, []*FieldError) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []*FieldError{{Key: "config", Source: path, Err: err.Error()}}
+	}
+
+	var sections map[string]any
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return nil, []*FieldError{{Key: "config", Source: path, Err: err.Error()}}
+	}
+
+	values := make(map[string]value)
+	var errs []*FieldError
+	for section, content := range sections {
+		settings, ok := content.(map[string]any)
+		if !ok {
+			errs = append(errs, &FieldError{Key: section, Source: path, Err: "must be a section of settings"})
+			continue
+		}
+
+		for name, raw := range settings {
+			key := section + "." + name
+			if !knownKey(key) {
+				errs = append(errs, &FieldError{Key: key, Source: path, Err: "unknown setting"})
+				continue
+			}
+			if raw == nil {
+				continue
+			}
+			text, err := formatFileValue(raw)
+			if err != nil {
+				errs = append(errs, &FieldError{Key: key, Source: path, Err: err.Error()})
+				continue
+			}
+			values[key] = value{raw: text, source: path, layer: layerFile}
+		}
+	}
+
+	// Map iteration order is random; report problems in a stable order
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Key < errs[j].Key })
+	return values, errs
+}
+
+// formatFileValue turns a YAML value into the string form used by
+// environment variables
+func formatFileValue(raw any) (string, error) {
+	switch v := raw.(type) {
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			text, err := formatScalar(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = text
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		items := make([]string, 0, len(v))
+		for name, item := range v {
+			text, err := formatScalar(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, name+"="+text)
+		}
+		sort.Strings(items)
+		return strings.Join(items, ","), nil
+	default:
+		return formatScalar(raw)
+	}
+}
+
+// formatScalar formats a single YAML value
+func formatScalar(raw any) (string, error) {
+	switch raw.(type) {
+	case string, int, int64, uint64, float64, bool:
+		return fmt.Sprint(raw), nil
+	default:
+		return "", fmt.Errorf("must be a single value, a list or a map, got %T", raw)
+	}
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Change is a setting that differs between two configurations
+type Change struct {
+	Key      string
+	Old, New string // Empty for secrets, which are never logged
+	Secret   bool
+	Live     bool // Applied by a reload; otherwise it needs a restart
+}
+
+func (c Change) String() string {
+	if c.Secret {
+		return c.Key + " changed"
+	}
+	return fmt.Sprintf("%s: %s → %s", c.Key, displayValue(c.Old), displayValue(c.New))
+}
+
+// displayValue shows empty settings explicitly in change logs
+func displayValue(v string) string {
+	if v == "" {
+		return "(none)"
+	}
+	return v
+}
+
+// Diff lists the settings that differ between old and new
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	for _, fd := range fields {
+		before, after := fd.format(old), fd.format(new)
+		if before == after {
+			continue
+		}
+		change := Change{Key: fd.key, Old: before, New: after, Secret: fd.secret, Live: fd.live}
+		if fd.secret {
+			change.Old, change.New = "", ""
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// DefaultWatchInterval is how often a Watcher checks the configuration file
+// for changes
+const DefaultWatchInterval = 5 * time.Second
+
+// Watcher reloads the configuration on demand and when the configuration
+// file changes. Invalid configurations are rejected and the current one kept.
+type Watcher struct {
+	flags    *Flags
+	apply    func(old, new *Config)
+	interval time.Duration
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	current *Config
+	modTime time.Time
+}
+
+// NewWatcher creates a watcher starting from current, which was loaded with
+// flags. apply is called with every successfully reloaded configuration.
+func NewWatcher(flags *Flags, current *Config, apply func(old, new *Config)) *Watcher {
+	w := &Watcher{
+		flags:    flags,
+		apply:    apply,
+		interval: DefaultWatchInterval,
+		log:      slog.Default(),
+		current:  current,
+	}
+	w.modTime, _ = w.fileModTime()
+	return w
+}
+
+// SetLogger sets where reloads are reported
+func (w *Watcher) SetLogger(logger *slog.Logger) {
+	w.log = logger
+}
+
+// Current returns the configuration in effect: live settings from the last
+// successful load, and the others as the bot was started with
+func (w *Watcher) Current() *Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Run reloads whenever a value arrives on trigger (e.g. SIGHUP) and when
+// the configuration file's modification time changes, until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context, trigger <-chan os.Signal) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-trigger:
+			w.log.Info("Reloading configuration", "signal", sig.String())
+			w.Reload()
+		case <-ticker.C:
+			modTime, err := w.fileModTime()
+			if err != nil || modTime.Equal(w.lastModTime()) {
+				continue
+			}
+			w.log.Info("Configuration file changed, reloading")
+			w.Reload()
+		}
+	}
+}
+
+// Reload loads the configuration again and applies it if it is valid. It
+// logs what changed, and returns the error that made it keep the current
+// configuration, if any.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Remember the file version even if it is invalid, so it is not retried
+	// until it changes again
+	w.modTime, _ = w.fileModTime()
+
+	next, err := Load(w.flags)
+	if err != nil {
+		w.log.Error("Rejected configuration reload, keeping the current configuration", "error", err)
+		return err
+	}
+
+	changes := Diff(w.current, next)
+	if len(changes) == 0 {
+		w.log.Info("Configuration reloaded: no changes")
+		return nil
+	}
+
+	var applied, restart []string
+	for _, change := range changes {
+		if change.Live {
+			applied = append(applied, change.String())
+		} else {
+			restart = append(restart, change.String())
+		}
+	}
+	if len(applied) > 0 {
+		w.log.Info("Configuration reloaded", "changes", strings.Join(applied, "; "))
+	}
+	if len(restart) > 0 {
+		w.log.Warn("Restart to apply configuration changes", "changes", strings.Join(restart, "; "))
+	}
+
+	// Settings that need a restart keep their running values, so later
+	// reloads still report them as pending
+	running := *w.current
+	for _, fd := range fields {
+		if fd.live {
+			fd.copyValue(&running, next)
+		}
+	}
+
+	old := w.current
+	w.current = &running
+	w.apply(old, &running)
+	return nil
+}
+
+// lastModTime returns the modification time of the file last loaded
+func (w *Watcher) lastModTime() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.modTime
+}
+
+// fileModTime returns the configuration file's modification time
+func (w *Watcher) fileModTime() (time.Time, error) {
+	path := filePath(w.flags)
+	if path == "" {
+		return time.Time{}, os.ErrNotExist
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
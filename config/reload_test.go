@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	old, new := Default(), Default()
+	new.ResponseFrequency = 5
+	new.Storage.DSN = "postgres://bot:secret@db/howard"
+	new.Owners = []int64{1, 2}
+
+	changes := Diff(old, new)
+	if len(changes) != 3 {
+		t.Fatalf("Expected 3 changes, got %v", changes)
+	}
+	if got := changes[0].String(); got != "bot.response_frequency: 10 → 5" || !changes[0].Live {
+		t.Errorf("Unexpected change: %s (live %v)", got, changes[0].Live)
+	}
+	if got := changes[1].String(); got != "bot.owners: (none) → 1,2" || changes[1].Live {
+		t.Errorf("Unexpected change: %s (live %v)", got, changes[1].Live)
+	}
+	// Secrets are never shown
+	if got := changes[2].String(); got != "storage.dsn changed" {
+		t.Errorf("Unexpected change: %s", got)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	t.Setenv("BOT_USERNAME", "test_bot")
+	t.Setenv("BOT_RESPONSE_FREQUENCY", "")
+	path := writeFile(t, "bot.yaml", "bot:\n  response_frequency: 10\n")
+	t.Setenv("BOT_CONFIG_FILE", path)
+
+	initial, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	applied := make(chan *Config, 1)
+	w := NewWatcher(nil, initial, func(old, new *Config) { applied <- new })
+	w.interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx, nil)
+
+	// A changed file is picked up without a signal
+	rewrite := func(content string, age time.Duration) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to rewrite config: %v", err)
+		}
+		// Some file systems only store whole seconds
+		modTime := time.Now().Add(age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to touch config: %v", err)
+		}
+	}
+	rewrite("bot:\n  response_frequency: 4\nlogging:\n  level: debug\n", time.Hour)
+	select {
+	case cfg := <-applied:
+		if cfg.ResponseFrequency != 4 || cfg.Logging.Level != "debug" {
+			t.Errorf("Expected the new values, got frequency %d, level %s", cfg.ResponseFrequency, cfg.Logging.Level)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the changed file to be reloaded")
+	}
+
+	// An invalid file is rejected and the last good configuration kept
+	rewrite("bot:\n  response_frequency: often\n", 2*time.Hour)
+	if err := w.Reload(); err == nil {
+		t.Fatal("Expected the invalid configuration to be rejected")
+	}
+	if got := w.Current().ResponseFrequency; got != 4 {
+		t.Errorf("Expected frequency 4 to be kept, got %d", got)
+	}
+	select {
+	case cfg := <-applied:
+		t.Errorf("Expected nothing to be applied, got %+v", cfg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatcher_RestartOnlyChanges(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "test_token_123")
+	t.Setenv("BOT_USERNAME", "test_bot")
+	t.Setenv("BOT_RESPONSE_FREQUENCY", "")
+	t.Setenv("BOT_WORKERS", "")
+	path := writeFile(t, "bot.yaml", "bot:\n  response_frequency: 10\n  workers: 4\n")
+	t.Setenv("BOT_CONFIG_FILE", path)
+
+	initial, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	var applied *Config
+	w := NewWatcher(nil, initial, func(old, new *Config) { applied = new })
+	var logs bytes.Buffer
+	w.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	if err := os.WriteFile(path, []byte("bot:\n  response_frequency: 5\n  workers: 8\n"), 0o600); err != nil {
+		t.Fatalf("Failed to rewrite config: %v", err)
+	}
+	if err := w.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	// Live settings change; the others keep the values the bot runs with
+	for name, cfg := range map[string]*Config{"current": w.Current(), "applied": applied} {
+		if cfg.ResponseFrequency != 5 || cfg.Workers != 4 {
+			t.Errorf("Expected %s frequency 5 and workers 4, got %d and %d", name, cfg.ResponseFrequency, cfg.Workers)
+		}
+	}
+
+	// A later reload still reports the change waiting for a restart
+	if err := w.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if n := strings.Count(logs.String(), "bot.workers: 4 → 8"); n != 2 {
+		t.Errorf("Expected the pending restart to be reported on both reloads, got %d in:\n%s", n, logs.String())
+	}
+}
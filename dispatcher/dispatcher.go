@@ -0,0 +1,186 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ErrStopped is returned by Dispatch once Stop has been called
+var ErrStopped = errors.New("dispatcher stopped")
+
+// HandlerFunc processes a single update
+type HandlerFunc func(ctx context.Context, update tgbotapi.Update)
+
+// Dispatcher runs updates on a pool of workers sharded by chat ID.
+// All updates for one chat go to the same worker, so they are handled in
+// arrival order, while different chats are processed in parallel.
+type Dispatcher struct {
+	handler HandlerFunc
+	queues  []chan tgbotapi.Update
+	wg      sync.WaitGroup
+
+	// stopped is closed by Stop to turn away producers; mu keeps the queues
+	// open while a Dispatch call may still send on them
+	stopped chan struct{}
+	mu      sync.RWMutex
+
+	// Backpressure counters
+	dispatched  atomic.Int64
+	processed   atomic.Int64
+	blocked     atomic.Int64 // Dispatch calls that found their queue full
+	blockedTime atomic.Int64 // Total nanoseconds spent waiting on full queues
+}
+
+// Stats is a snapshot of dispatcher load
+type Stats struct {
+	Workers       int
+	QueueCapacity int   // Capacity of each worker queue
+	QueueDepths   []int // Updates waiting per worker
+	Dispatched    int64
+	Processed     int64
+	Blocked       int64         // Times a producer had to wait for queue space
+	BlockedTime   time.Duration // Total time producers spent waiting
+}
+
+// New creates a dispatcher with the given number of workers and per-worker queue size
+func New(workers, queueSize int, handler HandlerFunc) *Dispatcher {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+
+	queues := make([]chan tgbotapi.Update, workers)
+	for i := range queues {
+		queues[i] = make(chan tgbotapi.Update, queueSize)
+	}
+
+	return &Dispatcher{
+		handler: handler,
+		queues:  queues,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Start launches the workers. Handlers receive ctx.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for _, queue := range d.queues {
+		d.wg.Add(1)
+		go func(queue chan tgbotapi.Update) {
+			defer d.wg.Done()
+			for update := range queue {
+				d.handler(ctx, update)
+				d.processed.Add(1)
+			}
+		}(queue)
+	}
+}
+
+// Dispatch queues an update for its chat's worker.
+// It blocks while that worker's queue is full and returns ctx's error if
+// cancelled before the update could be queued, or ErrStopped if the
+// dispatcher is stopped first.
+func (d *Dispatcher) Dispatch(ctx context.Context, update tgbotapi.Update) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	select {
+	case <-d.stopped:
+		return ErrStopped
+	default:
+	}
+
+	queue := d.queues[d.shard(update)]
+
+	// Fast path: space is available
+	select {
+	case queue <- update:
+		d.dispatched.Add(1)
+		return nil
+	default:
+	}
+
+	d.blocked.Add(1)
+	start := time.Now()
+	defer func() {
+		d.blockedTime.Add(int64(time.Since(start)))
+	}()
+
+	select {
+	case queue <- update:
+		d.dispatched.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.stopped:
+		return ErrStopped
+	}
+}
+
+// Stop closes the queues and waits for workers to finish everything queued.
+// Dispatch calls still waiting for queue space, and any made afterwards,
+// return ErrStopped.
+func (d *Dispatcher) Stop() {
+	close(d.stopped)
+
+	// Wait for blocked producers to give up before closing their queues
+	d.mu.Lock()
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// Stats returns current queue depths and counters
+func (d *Dispatcher) Stats() Stats {
+	depths := make([]int, len(d.queues))
+	for i, queue := range d.queues {
+		depths[i] = len(queue)
+	}
+
+	return Stats{
+		Workers:       len(d.queues),
+		QueueCapacity: cap(d.queues[0]),
+		QueueDepths:   depths,
+		Dispatched:    d.dispatched.Load(),
+		Processed:     d.processed.Load(),
+		Blocked:       d.blocked.Load(),
+		BlockedTime:   time.Duration(d.blockedTime.Load()),
+	}
+}
+
+// shard picks the worker for an update's chat
+func (d *Dispatcher) shard(update tgbotapi.Update) int {
+	chatID := ChatID(update)
+	return int(uint64(chatID) % uint64(len(d.queues)))
+}
+
+// ChatID returns the chat an update belongs to, or 0 if it has none
+func ChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID
+	case update.ChannelPost != nil:
+		return update.ChannelPost.Chat.ID
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	case update.MyChatMember != nil:
+		return update.MyChatMember.Chat.ID
+	case update.ChatMember != nil:
+		return update.ChatMember.Chat.ID
+	case update.ChatJoinRequest != nil:
+		return update.ChatJoinRequest.Chat.ID
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,254 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func messageUpdate(chatID int64, seq int) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: seq,
+		Message: &tgbotapi.Message{
+			MessageID: seq,
+			Chat:      &tgbotapi.Chat{ID: chatID},
+		},
+	}
+}
+
+func TestPerChatOrdering(t *testing.T) {
+	const (
+		chats       = 50
+		perChat     = 200
+		workers     = 8
+		queueLength = 4
+	)
+
+	var mu sync.Mutex
+	seen := make(map[int64][]int)
+
+	d := New(workers, queueLength, func(ctx context.Context, update tgbotapi.Update) {
+		// Random jitter shuffles timing between workers
+		if rand.Intn(10) == 0 {
+			time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		}
+		mu.Lock()
+		seen[update.Message.Chat.ID] = append(seen[update.Message.Chat.ID], update.Message.MessageID)
+		mu.Unlock()
+	})
+	d.Start(context.Background())
+
+	// Several producers interleave chats, each chat owned by one producer
+	var producers sync.WaitGroup
+	for p := 0; p < 5; p++ {
+		producers.Add(1)
+		go func(p int) {
+			defer producers.Done()
+			for seq := 0; seq < perChat; seq++ {
+				for chat := p; chat < chats; chat += 5 {
+					// Negative IDs like real Telegram groups
+					if err := d.Dispatch(context.Background(), messageUpdate(-int64(1000+chat), seq)); err != nil {
+						t.Errorf("Dispatch failed: %v", err)
+						return
+					}
+				}
+			}
+		}(p)
+	}
+	producers.Wait()
+	d.Stop()
+
+	if len(seen) != chats {
+		t.Fatalf("Expected updates for %d chats, got %d", chats, len(seen))
+	}
+	for chatID, seqs := range seen {
+		if len(seqs) != perChat {
+			t.Errorf("Chat %d: expected %d updates, got %d", chatID, perChat, len(seqs))
+			continue
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Errorf("Chat %d: update %d handled at position %d", chatID, seq, i)
+				break
+			}
+		}
+	}
+
+	stats := d.Stats()
+	if stats.Dispatched != chats*perChat || stats.Processed != chats*perChat {
+		t.Errorf("Expected %d dispatched and processed, got %d and %d", chats*perChat, stats.Dispatched, stats.Processed)
+	}
+}
+
+func TestChatsRunInParallel(t *testing.T) {
+	release := make(chan struct{})
+	fastDone := make(chan struct{})
+
+	d := New(2, 1, func(ctx context.Context, update tgbotapi.Update) {
+		if update.Message.Chat.ID == 0 {
+			<-release // Slow chat
+		} else {
+			close(fastDone)
+		}
+	})
+	d.Start(context.Background())
+	defer d.Stop()
+	defer close(release)
+
+	// Chats 0 and 1 map to different workers
+	d.Dispatch(context.Background(), messageUpdate(0, 1))
+	d.Dispatch(context.Background(), messageUpdate(1, 1))
+
+	select {
+	case <-fastDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Fast chat was blocked by slow chat")
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+
+	d := New(1, 1, func(ctx context.Context, update tgbotapi.Update) {
+		started <- struct{}{}
+		<-release
+	})
+	d.Start(context.Background())
+
+	// First update occupies the worker, second fills the queue
+	d.Dispatch(context.Background(), messageUpdate(1, 1))
+	<-started
+	d.Dispatch(context.Background(), messageUpdate(1, 2))
+
+	if depth := d.Stats().QueueDepths[0]; depth != 1 {
+		t.Errorf("Expected queue depth 1, got %d", depth)
+	}
+
+	// Third update must wait; cancelling gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := d.Dispatch(ctx, messageUpdate(1, 3))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	stats := d.Stats()
+	if stats.Blocked != 1 {
+		t.Errorf("Expected 1 blocked dispatch, got %d", stats.Blocked)
+	}
+	if stats.BlockedTime <= 0 {
+		t.Error("Expected blocked time to be recorded")
+	}
+	if stats.Dispatched != 2 {
+		t.Errorf("Expected 2 dispatched updates, got %d", stats.Dispatched)
+	}
+
+	close(release)
+	d.Stop()
+
+	if processed := d.Stats().Processed; processed != 2 {
+		t.Errorf("Expected 2 processed updates after stop, got %d", processed)
+	}
+}
+
+func TestStopDrainsQueues(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+
+	d := New(3, 100, func(ctx context.Context, update tgbotapi.Update) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})
+	d.Start(context.Background())
+
+	for i := 0; i < 60; i++ {
+		d.Dispatch(context.Background(), messageUpdate(int64(i%6), i))
+	}
+	d.Stop()
+
+	if count != 60 {
+		t.Errorf("Expected all 60 queued updates to be handled, got %d", count)
+	}
+}
+
+func TestStopTurnsAwayProducers(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	d := New(1, 1, func(ctx context.Context, update tgbotapi.Update) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	d.Start(context.Background())
+
+	// Busy worker and full queue, with a producer waiting for space
+	d.Dispatch(context.Background(), messageUpdate(1, 1))
+	<-started
+	d.Dispatch(context.Background(), messageUpdate(1, 2))
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- d.Dispatch(context.Background(), messageUpdate(1, 3))
+	}()
+	for d.Stats().Blocked == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		d.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, ErrStopped) {
+			t.Errorf("Expected ErrStopped for the waiting producer, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Waiting producer was not released by Stop")
+	}
+
+	close(release)
+	<-stopped
+	if err := d.Dispatch(context.Background(), messageUpdate(1, 4)); !errors.Is(err, ErrStopped) {
+		t.Errorf("Expected ErrStopped after Stop, got %v", err)
+	}
+	if processed := d.Stats().Processed; processed != 2 {
+		t.Errorf("Expected the 2 queued updates to be handled, got %d", processed)
+	}
+}
+
+func TestChatID(t *testing.T) {
+	chat := &tgbotapi.Chat{ID: -42}
+	tests := []struct {
+		name   string
+		update tgbotapi.Update
+		want   int64
+	}{
+		{name: "Message", update: tgbotapi.Update{Message: &tgbotapi.Message{Chat: chat}}, want: -42},
+		{name: "Edited message", update: tgbotapi.Update{EditedMessage: &tgbotapi.Message{Chat: chat}}, want: -42},
+		{name: "Callback query", update: tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{Message: &tgbotapi.Message{Chat: chat}}}, want: -42},
+		{name: "Inline callback query", update: tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{}}, want: 0},
+		{name: "My chat member", update: tgbotapi.Update{MyChatMember: &tgbotapi.ChatMemberUpdated{Chat: *chat}}, want: -42},
+		{name: "Empty", update: tgbotapi.Update{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ChatID(tt.update); got != tt.want {
+				t.Errorf("ChatID() = %d, expected %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,4 +4,8 @@ go 1.24.9
 
 require github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 
-require github.com/mattn/go-sqlite3 v1.14.32 // indirect
+require github.com/mattn/go-sqlite3 v1.14.32
+
+require github.com/lib/pq v1.10.9
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,166 @@
+// Package health runs dependency checks and serves them as liveness and
+// readiness probes for container orchestrators.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Check is one dependency check. Run returns a short description of what it
+// found, or an error if the dependency is not ready.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name    string
+	Detail  string
+	Err     error
+	Latency time.Duration
+}
+
+// OK reports whether the check passed
+func (r Result) OK() bool {
+	return r.Err == nil
+}
+
+// Ready reports whether every check passed
+func Ready(results []Result) bool {
+	for _, r := range results {
+		if !r.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Checker runs a fixed set of checks
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+// NewChecker creates a checker that gives each check up to timeout
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// Run runs every check concurrently and returns the results in the order
+// the checks were given. A check still running after the timeout is
+// reported as failed without waiting for it, since some clients cannot be
+// cancelled.
+func (c *Checker) Run(ctx context.Context) []Result {
+	results := make([]Result, len(c.checks))
+	done := make(chan struct{}, len(c.checks))
+	for i, check := range c.checks {
+		go func() {
+			results[i] = c.run(ctx, check)
+			done <- struct{}{}
+		}()
+	}
+	for range c.checks {
+		<-done
+	}
+	return results
+}
+
+// run runs one check within the timeout
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	type outcome struct {
+		detail string
+		err    error
+	}
+	finished := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		detail, err := check.Run(ctx)
+		finished <- outcome{detail, err}
+	}()
+
+	result := Result{Name: check.Name}
+	select {
+	case o := <-finished:
+		result.Detail, result.Err = o.detail, o.err
+	case <-ctx.Done():
+		result.Err = ctx.Err()
+		if errors.Is(result.Err, context.DeadlineExceeded) {
+			result.Err = errors.New("timed out after " + c.timeout.String())
+		}
+	}
+	result.Latency = time.Since(start)
+	return result
+}
+
+// Live answers liveness probes: if the process can serve HTTP, it is alive.
+// Dependencies are left to readiness so an outage does not restart the bot.
+func Live(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// report is the JSON body served for readiness probes
+type report struct {
+	Status string        `json:"status"`
+	Checks []checkReport `json:"checks"`
+}
+
+type checkReport struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// ServeHTTP answers readiness probes with every check's result: 200 if all
+// passed, 503 otherwise
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r) {
+		return
+	}
+
+	results := c.Run(r.Context())
+	body := report{Status: "ready", Checks: make([]checkReport, len(results))}
+	for i, result := range results {
+		body.Checks[i] = checkReport{
+			Name:      result.Name,
+			OK:        result.OK(),
+			Detail:    result.Detail,
+			LatencyMS: float64(result.Latency.Microseconds()) / 1000,
+		}
+		if result.Err != nil {
+			body.Checks[i].Error = result.Err.Error()
+		}
+	}
+
+	status := http.StatusOK
+	if !Ready(results) {
+		body.Status = "not ready"
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// allowMethod rejects anything but GET and HEAD
+func allowMethod(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func passing(name string) Check {
+	return Check{Name: name, Run: func(ctx context.Context) (string, error) { return "fine", nil }}
+}
+
+func TestChecker_Run(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+
+	checker := NewChecker(50*time.Millisecond,
+		passing("storage"),
+		Check{Name: "telegram", Run: func(ctx context.Context) (string, error) {
+			return "", errors.New("connection refused")
+		}},
+		// Ignores its context, like a client without cancellation
+		Check{Name: "stuck", Run: func(ctx context.Context) (string, error) {
+			<-hang
+			return "", nil
+		}},
+	)
+
+	results := checker.Run(context.Background())
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	if results[0].Name != "storage" || !results[0].OK() || results[0].Detail != "fine" {
+		t.Errorf("Expected storage to pass, got %+v", results[0])
+	}
+	if results[1].OK() || results[1].Err.Error() != "connection refused" {
+		t.Errorf("Expected telegram to fail, got %+v", results[1])
+	}
+	if results[2].OK() || !strings.Contains(results[2].Err.Error(), "timed out") {
+		t.Errorf("Expected the stuck check to time out, got %+v", results[2])
+	}
+	if Ready(results) {
+		t.Error("Expected not ready with failing checks")
+	}
+	if !Ready(results[:1]) {
+		t.Error("Expected ready when every check passed")
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	failing := errors.New("database is locked")
+	var storageErr error
+	checker := NewChecker(time.Second,
+		Check{Name: "storage", Run: func(ctx context.Context) (string, error) { return "", storageErr }},
+		passing("telegram"),
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", Live)
+	mux.Handle("/readyz", checker)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	readyz := func() (int, report) {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/readyz")
+		if err != nil {
+			t.Fatalf("Failed to probe: %v", err)
+		}
+		defer resp.Body.Close()
+		var body report
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode readiness report: %v", err)
+		}
+		return resp.StatusCode, body
+	}
+
+	status, body := readyz()
+	if status != http.StatusOK || body.Status != "ready" || len(body.Checks) != 2 {
+		t.Errorf("Expected 200 and ready with 2 checks, got %d and %+v", status, body)
+	}
+
+	storageErr = failing
+	status, body = readyz()
+	if status != http.StatusServiceUnavailable || body.Status != "not ready" {
+		t.Errorf("Expected 503 and not ready, got %d and %+v", status, body)
+	}
+	if c := body.Checks[0]; c.OK || c.Error != failing.Error() {
+		t.Errorf("Expected the storage error in the report, got %+v", c)
+	}
+
+	// Liveness does not depend on the checks
+	resp, err := http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("Failed to probe: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected /healthz to answer 200, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(server.URL+"/readyz", "text/plain", strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", resp.StatusCode)
+	}
+}
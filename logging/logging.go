@@ -0,0 +1,173 @@
+package logging
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Attribute keys that carry personal data. Log them through these keys, or
+// the helpers below, so the redaction policy applies.
+const (
+	KeyUserID   = "user_id"
+	KeyUsername = "username"
+	KeyText     = "text"
+	KeyChatID   = "chat_id" // Private chats share their ID with the user
+)
+
+// Message text policies
+const (
+	TextDrop     = "drop"     // Log only the length
+	TextTruncate = "truncate" // Log the first TruncateRunes characters
+	TextFull     = "full"     // Log everything; for local debugging only
+)
+
+// TruncateRunes is how much message text the truncate policy keeps
+const TruncateRunes = 24
+
+// Options configures log output
+type Options struct {
+	Format        string       // "text" or "json"
+	Level         slog.Leveler // Minimum level; nil means info
+	RedactUserIDs bool         // Replace user IDs, usernames and private chat IDs with keyed hashes
+	MessageText   string       // One of TextDrop, TextTruncate or TextFull
+	HashKey       []byte       // Key for the hashes, so they cannot be reversed by hashing every ID
+}
+
+// New creates a logger writing to w with the given options
+func New(w io.Writer, opts Options) *slog.Logger {
+	handlerOpts := &slog.HandlerOptions{
+		// Levels are checked by levelHandler so they can differ per logger
+		Level:       slog.LevelDebug,
+		ReplaceAttr: redactor(opts),
+	}
+
+	var base slog.Handler = slog.NewTextHandler(w, handlerOpts)
+	if opts.Format == "json" {
+		base = slog.NewJSONHandler(w, handlerOpts)
+	}
+
+	level := opts.Level
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return slog.New(&levelHandler{Handler: base, level: level})
+}
+
+// WithLevel returns a logger writing where l does but with its own minimum
+// level, e.g. to enable one component's debug output
+func WithLevel(l *slog.Logger, level slog.Leveler) *slog.Logger {
+	h := l.Handler()
+	if lh, ok := h.(*levelHandler); ok {
+		h = lh.Handler
+	}
+	return slog.New(&levelHandler{Handler: h, level: level})
+}
+
+// Toggle is a level that enables debug output while it is on and silences
+// everything while it is off. It is safe to flip at runtime.
+type Toggle struct {
+	on atomic.Bool
+}
+
+// Set turns the output on or off
+func (t *Toggle) Set(on bool) {
+	t.on.Store(on)
+}
+
+// On reports whether the output is on
+func (t *Toggle) On() bool {
+	return t.on.Load()
+}
+
+// Level implements slog.Leveler
+func (t *Toggle) Level() slog.Level {
+	if t.on.Load() {
+		return slog.LevelDebug
+	}
+	return slog.LevelError + 1
+}
+
+// UserID is a user ID attribute, hashed when IDs are redacted
+func UserID(id int64) slog.Attr {
+	return slog.Int64(KeyUserID, id)
+}
+
+// Username is a username attribute, hashed when IDs are redacted
+func Username(name string) slog.Attr {
+	return slog.String(KeyUsername, name)
+}
+
+// Text is a message text attribute, dropped or truncated by policy
+func Text(text string) slog.Attr {
+	return slog.String(KeyText, text)
+}
+
+// ChatID is a chat ID attribute. Group IDs are logged as is; private chat
+// IDs are user IDs and are hashed when IDs are redacted.
+func ChatID(id int64) slog.Attr {
+	return slog.Int64(KeyChatID, id)
+}
+
+// levelHandler filters records by its own level before passing them on
+type levelHandler struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// redactor returns a ReplaceAttr function applying the redaction policy
+func redactor(opts Options) func(groups []string, a slog.Attr) slog.Attr {
+	hash := func(prefix, value string) string {
+		mac := hmac.New(sha256.New, opts.HashKey)
+		mac.Write([]byte(value))
+		return prefix + hex.EncodeToString(mac.Sum(nil))[:12]
+	}
+
+	return func(groups []string, a slog.Attr) slog.Attr {
+		switch a.Key {
+		case KeyUserID:
+			if opts.RedactUserIDs {
+				return slog.String(KeyUserID, hash("u-", a.Value.String()))
+			}
+		case KeyChatID:
+			if opts.RedactUserIDs && a.Value.Kind() == slog.KindInt64 && a.Value.Int64() > 0 {
+				// Same hash as the user's ID, so the two can be correlated
+				return slog.String(KeyChatID, hash("u-", strconv.FormatInt(a.Value.Int64(), 10)))
+			}
+		case KeyUsername:
+			if opts.RedactUserIDs && a.Value.String() != "" {
+				return slog.String(KeyUsername, hash("n-", a.Value.String()))
+			}
+		case KeyText:
+			text := a.Value.String()
+			switch opts.MessageText {
+			case TextFull:
+			case TextTruncate:
+				if utf8.RuneCountInString(text) > TruncateRunes {
+					return slog.String(KeyText, string([]rune(text)[:TruncateRunes])+"…")
+				}
+			default:
+				return slog.Int("text_len", utf8.RuneCountInString(text))
+			}
+		}
+		return a
+	}
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		want    map[string]any
+		notWant []string
+	}{
+		{
+			name:    "Defaults hash IDs and drop text",
+			opts:    Options{Format: "json", RedactUserIDs: true, MessageText: TextDrop, HashKey: []byte("key")},
+			want:    map[string]any{"chat_id": float64(-100123), "text_len": float64(41)},
+			notWant: []string{"12345", "alice", "secret plans", `"text"`},
+		},
+		{
+			name:    "Truncated text",
+			opts:    Options{Format: "json", RedactUserIDs: true, MessageText: TextTruncate, HashKey: []byte("key")},
+			want:    map[string]any{"text": "meet me at the secret pl…"},
+			notWant: []string{"12345", "alice"},
+		},
+		{
+			name: "Everything",
+			opts: Options{Format: "json", MessageText: TextFull},
+			want: map[string]any{"user_id": float64(12345), "username": "alice", "text": "meet me at the secret plans meeting today"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			New(&buf, tt.opts).Info("Message received",
+				ChatID(-100123), UserID(12345), Username("alice"), Text("meet me at the secret plans meeting today"))
+
+			var record map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("Expected JSON output, got %q: %v", buf.String(), err)
+			}
+			for key, value := range tt.want {
+				if record[key] != value {
+					t.Errorf("Expected %s=%v, got %v", key, value, record[key])
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(buf.String(), s) {
+					t.Errorf("Expected %q to be redacted from %s", s, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRedaction_StableHashes(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Options{RedactUserIDs: true, HashKey: []byte("key")})
+	logger.Info("a", UserID(42))
+	logger.Info("b", ChatID(42))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	userHash := lines[0][strings.Index(lines[0], "user_id=")+len("user_id="):]
+	if !strings.HasPrefix(userHash, "u-") || !strings.HasSuffix(lines[1], "chat_id="+userHash) {
+		t.Errorf("Expected a private chat to hash like its user, got:\n%s", buf.String())
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+	logger := New(&buf, Options{Level: level})
+
+	var trace Toggle
+	tracer := WithLevel(logger.With("component", "mentions"), &trace)
+
+	logger.Debug("hidden")
+	tracer.Debug("hidden trace")
+	trace.Set(true)
+	tracer.Debug("shown trace")
+	level.Set(slog.LevelDebug)
+	logger.Debug("shown")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("Expected debug output to be filtered, got:\n%s", out)
+	}
+	if !strings.Contains(out, `msg="shown trace" component=mentions`) || !strings.Contains(out, `msg=shown`) {
+		t.Errorf("Expected the toggled trace and the debug line, got:\n%s", out)
+	}
+}
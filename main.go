@@ -1,41 +1,250 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Zind-dev/HowardTheChad_bot/audit"
 	"github.com/Zind-dev/HowardTheChad_bot/bot"
 	"github.com/Zind-dev/HowardTheChad_bot/config"
+	"github.com/Zind-dev/HowardTheChad_bot/health"
+	"github.com/Zind-dev/HowardTheChad_bot/logging"
+	"github.com/Zind-dev/HowardTheChad_bot/metrics"
 	"github.com/Zind-dev/HowardTheChad_bot/storage"
 )
 
 func main() {
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "list pending database migrations and exit")
+	exportAuditLog := flag.Bool("export-audit-log", false, "write the audit log to stdout as JSON lines and exit")
+	auditChat := flag.Int64("audit-chat", 0, "chat ID to export with -export-audit-log (default: every chat)")
+	configFlags := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if *migrateDryRun {
+		if err := listMigrations(context.Background(), configFlags); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *exportAuditLog {
+		if err := exportAudit(context.Background(), configFlags, *auditChat); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Cancel on Ctrl+C or when the container/service manager asks us to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, configFlags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, configFlags *config.Flags) error {
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Load(configFlags)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		return fmt.Errorf("failed to load configuration: %w", err)
 	}
+	logger, logLevel := setupLogging(cfg)
+	storageLog := logger.With("component", "storage")
 
 	// Initialize storage
-	store, err := storage.NewSQLiteStorage("bot_data.db")
+	store, err := storage.Open(cfg.Storage.Backend, cfg.Storage.DSN, storageLog)
 	if err != nil {
-		log.Fatalf("Failed to create storage: %v", err)
+		return fmt.Errorf("failed to create storage: %w", err)
 	}
-	defer store.Close()
+	defer func() {
+		if err := store.Close(); err != nil {
+			storageLog.Error("Failed to close storage", "error", err)
+		}
+	}()
 
-	if err := store.Initialize(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	if err := store.Initialize(ctx); err != nil {
+		if !errors.Is(err, storage.ErrSchemaTooNew) || !cfg.Storage.AllowNewerSchema {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		storageLog.Warn("Continuing because storage.allow_newer_schema is set", "error", err)
 	}
-	log.Println("Database initialized successfully")
+	storageLog.Info("Database initialized", "backend", cfg.Storage.Backend)
+
+	// Batch message counter writes; the bot flushes what is left on shutdown.
+	// Metrics are measured below the buffer, where the database is reached.
+	registry := metrics.NewRegistry()
+	counters := storage.NewCounterBuffer(storage.NewInstrumented(store, registry))
+	counters.SetLogger(storageLog)
+	go counters.Run(ctx, cfg.Storage.CounterFlushInterval)
 
 	// Create bot instance
-	b, err := bot.New(cfg, store)
+	b, err := bot.New(cfg, counters, bot.Options{Logger: logger, Metrics: registry})
+	if err != nil {
+		return fmt.Errorf("failed to create bot: %w", err)
+	}
+
+	if addr := cfg.Monitoring.ListenAddr; addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		mux.HandleFunc("/healthz", health.Live)
+		mux.Handle("/readyz", b.Health())
+		stopMonitoring, err := serveMonitoring(addr, mux, logger.With("component", "monitoring"))
+		if err != nil {
+			return err
+		}
+		defer stopMonitoring()
+	}
+
+	// Reload on SIGHUP or when the configuration file changes
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	watcher := config.NewWatcher(configFlags, cfg, func(old, new *config.Config) {
+		logLevel.Set(new.Logging.SlogLevel())
+		b.ApplyConfig(new)
+	})
+	watcher.SetLogger(logger.With("component", "config"))
+	go watcher.Run(ctx, reload)
+
+	// Start the bot; returns after a graceful shutdown
+	logger.Info("Bot is starting")
+	if err := b.Start(ctx); err != nil {
+		return fmt.Errorf("bot error: %w", err)
+	}
+	return nil
+}
+
+// serveMonitoring serves handler on addr in the background. Scrapers can
+// keep reaching it during a graceful shutdown, until the returned function
+// is called.
+func serveMonitoring(addr string, handler http.Handler, logger *slog.Logger) (stop func(), err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Monitoring server stopped", "error", err)
+		}
+	}()
+	logger.Info("Serving monitoring endpoints", "listen_addr", listener.Addr().String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
+
+// setupLogging creates the logger with the configured level, format and
+// redaction policy and makes it the default, so the standard log package
+// writes through it too. The level can be changed later through the
+// returned variable.
+func setupLogging(cfg *config.Config) (*slog.Logger, *slog.LevelVar) {
+	level := new(slog.LevelVar)
+	level.Set(cfg.Logging.SlogLevel())
+
+	logger := logging.New(os.Stderr, logging.Options{
+		Format:        cfg.Logging.Format,
+		Level:         level,
+		RedactUserIDs: cfg.Logging.RedactUserIDs,
+		MessageText:   cfg.Logging.MessageText,
+		// Keyed with the token so hashes cannot be matched to IDs by anyone
+		// without it, yet stay stable across restarts
+		HashKey: []byte("log-redaction:" + cfg.TelegramToken),
+	})
+	slog.SetDefault(logger)
+	return logger, level
+}
+
+// listMigrations prints the schema version and pending migrations without applying them
+func listMigrations(ctx context.Context, configFlags *config.Flags) error {
+	storageCfg, err := config.LoadStorage(configFlags)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	backend, dsn := storageCfg.Backend, storageCfg.DSN
+
+	// Open SQLite read-only so a dry run never creates or changes the database;
+	// a missing database is reported as version 0 with everything pending
+	if backend == config.StorageSQLite {
+		if _, err := os.Stat(dsn); err == nil {
+			dsn = "file:" + dsn + "?mode=ro"
+		} else {
+			dsn = "file::memory:"
+		}
+	}
+
+	opened, err := storage.Open(backend, dsn, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer opened.Close()
+
+	store, ok := opened.(storage.Migrator)
+	if !ok {
+		return fmt.Errorf("storage backend %s does not support migrations", backend)
+	}
+
+	version, err := store.SchemaVersion(ctx)
 	if err != nil {
-		log.Fatalf("Failed to create bot: %v", err)
+		return err
 	}
+	fmt.Printf("Database schema version: %d (binary supports %d)\n", version, storage.LatestSchemaVersion())
 
-	// Start the bot
-	log.Println("Bot is starting...")
-	if err := b.Start(); err != nil {
-		log.Fatalf("Bot error: %v", err)
+	pending, err := store.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations.")
+		return nil
+	}
+
+	fmt.Println("Pending migrations:")
+	for _, m := range pending {
+		fmt.Printf("  %d: %s\n", m.Version, m.Description)
+	}
+	return nil
+}
+
+// exportAudit writes a chat's audit log, or every chat's with chatID 0, to
+// stdout as JSON lines, oldest first
+func exportAudit(ctx context.Context, configFlags *config.Flags, chatID int64) error {
+	storageCfg, err := config.LoadStorage(configFlags)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	backend, dsn := storageCfg.Backend, storageCfg.DSN
+	if backend == config.StorageSQLite {
+		if _, err := os.Stat(dsn); err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		dsn = "file:" + dsn + "?mode=ro"
+	}
+
+	store, err := storage.Open(backend, dsn, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	n, err := audit.New(store).Export(ctx, chatID, os.Stdout)
+	if err != nil {
+		return err
 	}
+	slog.Info("Exported audit log", "entries", n)
+	return nil
 }
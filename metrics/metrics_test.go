@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistry_Scrape(t *testing.T) {
+	reg := NewRegistry()
+	updates := reg.Counter("updates_total", "Updates received.", "type")
+	latency := reg.Histogram("latency_seconds", "Handler latency.", []float64{0.5, 0.1}, "type")
+	reg.GaugeFunc("active_chats", "Chats seen.\nSince startup.", func() float64 { return 3 })
+
+	updates.Inc("message")
+	updates.Add(2, "callback_query")
+	updates.Inc(`odd "label"`)
+	latency.Observe(0.05, "message")
+	latency.Observe(0.1, "message")
+	latency.Observe(2, "message")
+
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to scrape: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != ContentType {
+		t.Errorf("Expected 200 with %s, got %d with %s", ContentType, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	want := `# HELP updates_total Updates received.
+# TYPE updates_total counter
+updates_total{type="callback_query"} 2
+updates_total{type="message"} 1
+updates_total{type="odd \"label\""} 1
+# HELP latency_seconds Handler latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{type="message",le="0.1"} 2
+latency_seconds_bucket{type="message",le="0.5"} 2
+latency_seconds_bucket{type="message",le="+Inf"} 3
+latency_seconds_sum{type="message"} 2.15
+latency_seconds_count{type="message"} 3
+# HELP active_chats Chats seen.\nSince startup.
+# TYPE active_chats gauge
+active_chats 3
+`
+	if string(body) != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", body, want)
+	}
+
+	resp, err = http.Post(server.URL, "text/plain", strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", resp.StatusCode)
+	}
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	expectPanic := func(name string, f func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected %s to panic", name)
+			}
+		}()
+		f()
+	}
+
+	reg := NewRegistry()
+	counter := reg.Counter("errors_total", "Errors.", "operation")
+	expectPanic("a duplicate name", func() { reg.Counter("errors_total", "Again.") })
+	expectPanic("missing label values", func() { counter.Inc() })
+	expectPanic("a negative increment", func() { counter.Add(-1, "save") })
+}
+
+func TestCounter_Concurrent(t *testing.T) {
+	reg := NewRegistry()
+	counter := reg.Counter("events_total", "Events.")
+	histogram := reg.Histogram("event_seconds", "Event duration.", nil)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				counter.Inc()
+				histogram.Observe(0.01)
+				reg.WriteTo(io.Discard)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if counter.Value() != 800 || histogram.Count() != 800 {
+		t.Errorf("Expected 800 events, got %v and %d", counter.Value(), histogram.Count())
+	}
+}
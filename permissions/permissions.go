@@ -0,0 +1,205 @@
+package permissions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/Zind-dev/HowardTheChad_bot/audit"
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// Level is how much a user may do in a chat. Each level includes the ones
+// below it.
+type Level int
+
+// Permission levels, lowest first
+const (
+	Everyone  Level = iota // Any chat member
+	Moderator              // Granted with /grant by an administrator
+	Admin                  // Telegram creator or administrator of the chat
+	Owner                  // Bot owner from the configuration, in every chat
+)
+
+// levelNames are the names used in configuration and messages
+var levelNames = map[Level]string{
+	Everyone:  "everyone",
+	Moderator: "moderator",
+	Admin:     "admin",
+	Owner:     "owner",
+}
+
+// String returns the level's configuration name
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel parses a level name such as "moderator"
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return Everyone, fmt.Errorf("unknown permission level: %s", name)
+}
+
+// DefaultRequirements is the level each command needs unless overridden.
+// Commands not listed are open to everyone.
+var DefaultRequirements = map[string]Level{
+	"setfrequency":   Moderator,
+	"togglementions": Moderator,
+	"togglemedia":    Moderator,
+	"setretention":   Admin,
+	"resetsettings":  Admin,
+	"grant":          Admin,
+	"revoke":         Admin,
+	"auditlog":       Admin,
+	"ping":           Admin,
+}
+
+// ValidateOverrides checks that overrides, which map command names to level
+// names, only name commands with a permission check and only use known
+// levels. Problems are reported in command order.
+func ValidateOverrides(overrides map[string]string) error {
+	commands := make([]string, 0, len(overrides))
+	for command := range overrides {
+		commands = append(commands, command)
+	}
+	slices.Sort(commands)
+
+	var problems []string
+	for _, command := range commands {
+		if _, ok := DefaultRequirements[command]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown command /%s", command))
+			continue
+		}
+		if _, err := ParseLevel(overrides[command]); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid permission for /%s: %v", command, err))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// AdminChecker reports whether a user administers a chat; *admins.Cache
+// implements it
+type AdminChecker interface {
+	IsAdmin(chatID, userID int64) (bool, error)
+}
+
+// RoleStore looks up roles granted within a chat; storage.Storage implements it
+type RoleStore interface {
+	GetChatRole(ctx context.Context, chatID int64, userID int64) (*storage.ChatRole, error)
+}
+
+// Auditor records denied attempts; *audit.Log implements it
+type Auditor interface {
+	Record(ctx context.Context, chatID, actorID int64, action, oldValue, newValue string)
+}
+
+// Authorizer decides who may run which command. It is the single place
+// permission checks are made, and it audits every denial.
+type Authorizer struct {
+	owners       map[int64]bool
+	admins       AdminChecker
+	roles        RoleStore
+	audit        Auditor
+	requirements map[string]Level
+	log          *slog.Logger
+}
+
+// New creates an authorizer. owners may manage every chat; overrides maps
+// command names to level names and replaces their default requirement. It
+// fails if ValidateOverrides does.
+func New(owners []int64, overrides map[string]string, admins AdminChecker, roles RoleStore, auditor Auditor) (*Authorizer, error) {
+	if err := ValidateOverrides(overrides); err != nil {
+		return nil, err
+	}
+	requirements := make(map[string]Level, len(DefaultRequirements))
+	for action, level := range DefaultRequirements {
+		requirements[action] = level
+	}
+	for action, name := range overrides {
+		requirements[action], _ = ParseLevel(name)
+	}
+
+	ownerSet := make(map[int64]bool, len(owners))
+	for _, id := range owners {
+		ownerSet[id] = true
+	}
+
+	return &Authorizer{
+		owners:       ownerSet,
+		admins:       admins,
+		roles:        roles,
+		audit:        auditor,
+		requirements: requirements,
+		log:          slog.Default(),
+	}, nil
+}
+
+// SetLogger sets where denials and failed lookups are logged
+func (a *Authorizer) SetLogger(logger *slog.Logger) {
+	a.log = logger
+}
+
+// Required returns the level an action needs
+func (a *Authorizer) Required(action string) Level {
+	return a.requirements[action]
+}
+
+// IsOwner reports whether a user is a bot owner
+func (a *Authorizer) IsOwner(userID int64) bool {
+	return a.owners[userID]
+}
+
+// Authorize reports whether a user may perform an action in a chat, and
+// the level the action needs. Lookups that fail count as not holding the
+// level, so errors deny rather than grant.
+func (a *Authorizer) Authorize(ctx context.Context, chatID, userID int64, action string) (bool, Level) {
+	required := a.Required(action)
+	if a.HasLevel(ctx, chatID, userID, required) {
+		return true, required
+	}
+
+	a.log.Info("Denied command", "command", action, "chat_id", chatID, "user_id", userID, "required", required.String())
+	a.audit.Record(ctx, chatID, userID, audit.ActionDenied, "", fmt.Sprintf("/%s (requires %s)", action, required))
+	return false, required
+}
+
+// HasLevel reports whether a user holds at least level in a chat. Only the
+// lookups needed to decide are made.
+func (a *Authorizer) HasLevel(ctx context.Context, chatID, userID int64, level Level) bool {
+	if level <= Everyone || a.owners[userID] {
+		return true
+	}
+	if level > Admin {
+		return false
+	}
+
+	isAdmin, err := a.admins.IsAdmin(chatID, userID)
+	if err != nil {
+		a.log.Error("Failed to get chat administrators", "chat_id", chatID, "error", err)
+	} else if isAdmin {
+		return true
+	}
+	if level > Moderator {
+		return false
+	}
+
+	role, err := a.roles.GetChatRole(ctx, chatID, userID)
+	if err != nil {
+		a.log.Error("Failed to get chat role", "chat_id", chatID, "error", err)
+		return false
+	}
+	return role != nil && role.Role == storage.RoleModerator
+}
@@ -0,0 +1,139 @@
+package permissions
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Zind-dev/HowardTheChad_bot/audit"
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// fakeAdmins answers admin checks from a fixed set and counts calls
+type fakeAdmins struct {
+	admins map[int64]bool
+	err    error
+	calls  int
+}
+
+func (f *fakeAdmins) IsAdmin(chatID, userID int64) (bool, error) {
+	f.calls++
+	return f.admins[userID], f.err
+}
+
+const (
+	ownerID     int64 = 1
+	adminID     int64 = 2
+	moderatorID int64 = 3
+	memberID    int64 = 4
+	chatID      int64 = -100
+)
+
+func newTestAuthorizer(t *testing.T, overrides map[string]string) (*Authorizer, *fakeAdmins, *storage.MockStorage) {
+	t.Helper()
+	admins := &fakeAdmins{admins: map[int64]bool{adminID: true}}
+	store := storage.NewMockStorage()
+	store.SaveChatRole(context.Background(), &storage.ChatRole{ChatID: chatID, UserID: moderatorID, Role: storage.RoleModerator})
+
+	a, err := New([]int64{ownerID}, overrides, admins, store, audit.New(store))
+	if err != nil {
+		t.Fatalf("Failed to create authorizer: %v", err)
+	}
+	return a, admins, store
+}
+
+func TestAuthorize(t *testing.T) {
+	a, _, store := newTestAuthorizer(t, map[string]string{"setretention": "owner"})
+	ctx := context.Background()
+
+	tests := []struct {
+		action string
+		allow  map[int64]bool
+	}{
+		{action: "search", allow: map[int64]bool{ownerID: true, adminID: true, moderatorID: true, memberID: true}},
+		{action: "setfrequency", allow: map[int64]bool{ownerID: true, adminID: true, moderatorID: true}},
+		{action: "resetsettings", allow: map[int64]bool{ownerID: true, adminID: true}},
+		{action: "setretention", allow: map[int64]bool{ownerID: true}},
+	}
+	for _, tt := range tests {
+		for _, userID := range []int64{ownerID, adminID, moderatorID, memberID} {
+			if got, _ := a.Authorize(ctx, chatID, userID, tt.action); got != tt.allow[userID] {
+				t.Errorf("/%s by user %d: expected allowed=%v, got %v", tt.action, userID, tt.allow[userID], got)
+			}
+		}
+	}
+
+	// Roles are per chat, ownership is not
+	if got, _ := a.Authorize(ctx, -200, moderatorID, "setfrequency"); got {
+		t.Error("Expected a moderator to have no rights in another chat")
+	}
+	if got, _ := a.Authorize(ctx, -200, ownerID, "setretention"); !got {
+		t.Error("Expected an owner to manage any chat")
+	}
+	if _, required := a.Authorize(ctx, chatID, memberID, "resetsettings"); required != Admin {
+		t.Errorf("Expected /resetsettings to require admin, got %s", required)
+	}
+
+	// Every denial is audited
+	entries, _ := store.GetAuditLog(ctx, chatID, 100, 0)
+	if len(entries) != 7 {
+		t.Fatalf("Expected 7 audited denials, got %d", len(entries))
+	}
+	if last := entries[0]; last.ActorID != memberID || last.Action != audit.ActionDenied || last.NewValue != "/resetsettings (requires admin)" {
+		t.Errorf("Unexpected audit entry: %+v", last)
+	}
+}
+
+func TestAuthorize_LookupFailures(t *testing.T) {
+	a, admins, _ := newTestAuthorizer(t, nil)
+	ctx := context.Background()
+
+	// Commands open to everyone and owners need no lookups
+	a.Authorize(ctx, chatID, memberID, "search")
+	a.Authorize(ctx, chatID, ownerID, "resetsettings")
+	if admins.calls != 0 {
+		t.Errorf("Expected no admin lookups, got %d", admins.calls)
+	}
+
+	// A failed admin lookup still lets moderators through, but not admins
+	admins.err = errors.New("timeout")
+	if got, _ := a.Authorize(ctx, chatID, moderatorID, "setfrequency"); !got {
+		t.Error("Expected a moderator to be allowed when the admin lookup fails")
+	}
+	if got, _ := a.Authorize(ctx, chatID, adminID, "resetsettings"); got {
+		t.Error("Expected a failed admin lookup to deny")
+	}
+}
+
+func TestNew_InvalidOverride(t *testing.T) {
+	store := storage.NewMockStorage()
+	if _, err := New(nil, map[string]string{"setfrequency": "superuser"}, &fakeAdmins{}, store, audit.New(store)); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if _, err := New(nil, map[string]string{"setfrequncy": "admin"}, &fakeAdmins{}, store, audit.New(store)); err == nil {
+		t.Error("Expected an error for an unknown command")
+	}
+}
+
+func TestValidateOverrides(t *testing.T) {
+	if err := ValidateOverrides(map[string]string{"setretention": "moderator", "ping": "Owner"}); err != nil {
+		t.Errorf("Expected valid overrides, got %v", err)
+	}
+	err := ValidateOverrides(map[string]string{"setfrequncy": "admin", "grant": "superuser", "search": "admin"})
+	want := "invalid permission for /grant: unknown permission level: superuser; unknown command /search; unknown command /setfrequncy"
+	if err == nil || err.Error() != want {
+		t.Errorf("Expected '%s', got %v", want, err)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{Everyone, Moderator, Admin, Owner} {
+		got, err := ParseLevel(level.String())
+		if err != nil || got != level {
+			t.Errorf("Expected %s to round-trip, got %v (error %v)", level, got, err)
+		}
+	}
+	if got, err := ParseLevel("Admin"); err != nil || got != Admin {
+		t.Errorf("Expected names to be case-insensitive, got %v (error %v)", got, err)
+	}
+}
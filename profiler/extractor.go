@@ -0,0 +1,193 @@
+package profiler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Zind-dev/HowardTheChad_bot/config"
+	"github.com/Zind-dev/HowardTheChad_bot/responder"
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// Input is the material an extractor works from
+type Input struct {
+	UserMessages []*storage.Message // Messages written by the profiled user
+	ChatMessages []*storage.Message // Recent messages from the whole chat, used as background corpus
+}
+
+// Summary is what an extractor learned about a user
+type Summary struct {
+	Interests   []string
+	Topics      []string
+	Personality string // Empty when the extractor cannot judge personality
+	Notes       string
+}
+
+// Extractor derives a profile summary from a user's messages
+// This allows swapping the offline keyword extractor for an LLM summarizer
+type Extractor interface {
+	Extract(ctx context.Context, in *Input) (*Summary, error)
+}
+
+// NewExtractor creates the extractor selected in the configuration
+func NewExtractor(cfg *config.Config) (Extractor, error) {
+	switch cfg.Profiler {
+	case "", "keyword":
+		return NewKeywordExtractor(), nil
+	case "llm":
+		client := responder.NewOpenAI(cfg.Responder.BaseURL, cfg.Responder.Model, cfg.Responder.APIKey, cfg.Responder.Timeout)
+		return NewLLMSummarizer(client), nil
+	default:
+		return nil, fmt.Errorf("unknown profiler backend: %s", cfg.Profiler)
+	}
+}
+
+// KeywordExtractor ranks words by TF-IDF against the chat's recent history.
+// It works fully offline and is deterministic.
+type KeywordExtractor struct {
+	MaxInterests int // Number of single-word interests to keep
+	MaxTopics    int // Number of two-word topics to keep
+}
+
+// NewKeywordExtractor creates a keyword extractor with default limits
+func NewKeywordExtractor() *KeywordExtractor {
+	return &KeywordExtractor{
+		MaxInterests: 5,
+		MaxTopics:    3,
+	}
+}
+
+// Extract returns the words and word pairs that set the user apart from the rest of the chat
+func (k *KeywordExtractor) Extract(ctx context.Context, in *Input) (*Summary, error) {
+	// Document frequency over chat messages, one document per message
+	docFreq := make(map[string]int)
+	for _, msg := range in.ChatMessages {
+		seen := make(map[string]bool)
+		for _, term := range terms(tokenize(msg.Text)) {
+			if !seen[term] {
+				docFreq[term]++
+				seen[term] = true
+			}
+		}
+	}
+	docs := float64(len(in.ChatMessages))
+
+	wordFreq := make(map[string]int)
+	pairFreq := make(map[string]int)
+	for _, msg := range in.UserMessages {
+		words := tokenize(msg.Text)
+		for _, w := range words {
+			wordFreq[w]++
+		}
+		for _, p := range pairs(words) {
+			pairFreq[p]++
+		}
+	}
+
+	idf := func(term string) float64 {
+		return math.Log((docs+1)/(float64(docFreq[term])+1)) + 1
+	}
+
+	return &Summary{
+		Interests: topTerms(wordFreq, idf, 1, k.MaxInterests),
+		Topics:    topTerms(pairFreq, idf, 2, k.MaxTopics),
+	}, nil
+}
+
+// topTerms scores terms by tf*idf and returns the best ones seen at least minCount times
+func topTerms(freq map[string]int, idf func(string) float64, minCount, limit int) []string {
+	type scored struct {
+		term  string
+		score float64
+	}
+
+	var candidates []scored
+	for term, count := range freq {
+		if count < minCount {
+			continue
+		}
+		candidates = append(candidates, scored{term: term, score: float64(count) * idf(term)})
+	}
+
+	// Sort by score, then alphabetically so results are stable
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].term < candidates[j].term
+	})
+
+	var result []string
+	for i := 0; i < len(candidates) && i < limit; i++ {
+		result = append(result, candidates[i].term)
+	}
+	return result
+}
+
+// tokenize lowercases text and keeps meaningful words only
+func tokenize(text string) []string {
+	var words []string
+	for _, field := range strings.Fields(strings.ToLower(text)) {
+		// Skip mentions, commands and links
+		if strings.HasPrefix(field, "@") || strings.HasPrefix(field, "/") || strings.Contains(field, "://") {
+			continue
+		}
+
+		word := strings.TrimFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len([]rune(word)) < 3 || stopWords[word] || isNumber(word) {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// pairs returns adjacent word pairs
+func pairs(words []string) []string {
+	var result []string
+	for i := 0; i+1 < len(words); i++ {
+		result = append(result, words[i]+" "+words[i+1])
+	}
+	return result
+}
+
+// terms returns single words and pairs for document frequency counting
+func terms(words []string) []string {
+	return append(words, pairs(words)...)
+}
+
+func isNumber(word string) bool {
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// stopWords are common English words that say nothing about interests
+var stopWords = map[string]bool{
+	"about": true, "after": true, "again": true, "all": true, "also": true, "and": true,
+	"any": true, "are": true, "because": true, "been": true, "before": true, "being": true,
+	"but": true, "can": true, "could": true, "did": true, "does": true, "doing": true,
+	"don": true, "dont": true, "down": true, "each": true, "even": true, "for": true,
+	"from": true, "get": true, "got": true, "had": true, "has": true, "have": true,
+	"her": true, "here": true, "him": true, "his": true, "how": true, "i'm": true,
+	"into": true, "its": true, "it's": true, "just": true, "know": true, "like": true,
+	"lol": true, "make": true, "more": true, "most": true, "much": true, "must": true,
+	"not": true, "now": true, "off": true, "one": true, "only": true, "other": true,
+	"our": true, "out": true, "over": true, "really": true, "same": true, "see": true,
+	"she": true, "should": true, "some": true, "still": true, "such": true, "than": true,
+	"that": true, "the": true, "their": true, "them": true, "then": true, "there": true,
+	"these": true, "they": true, "thing": true, "think": true, "this": true, "those": true,
+	"too": true, "very": true, "want": true, "was": true, "way": true, "well": true,
+	"were": true, "what": true, "when": true, "where": true, "which": true, "while": true,
+	"who": true, "why": true, "will": true, "with": true, "would": true, "yeah": true,
+	"yes": true, "you": true, "your": true, "you're": true,
+}
@@ -0,0 +1,82 @@
+package profiler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Zind-dev/HowardTheChad_bot/responder"
+)
+
+// summarizerPrompt instructs the model to answer with machine-readable JSON
+const summarizerPrompt = "You analyse chat messages written by one person. " +
+	"Reply with JSON only, in the form " +
+	`{"interests": ["..."], "topics": ["..."], "personality": "...", "notes": "..."}. ` +
+	"Use at most five short interests and three topics. Leave fields empty when unsure."
+
+// LLMSummarizer asks a chat-completion backend to summarize a user
+type LLMSummarizer struct {
+	responder   responder.Responder
+	maxMessages int
+}
+
+// NewLLMSummarizer creates a summarizer backed by the given responder
+func NewLLMSummarizer(r responder.Responder) *LLMSummarizer {
+	return &LLMSummarizer{
+		responder:   r,
+		maxMessages: 100,
+	}
+}
+
+// Extract sends the user's messages to the model and parses its JSON answer
+func (l *LLMSummarizer) Extract(ctx context.Context, in *Input) (*Summary, error) {
+	messages := in.UserMessages
+	if len(messages) > l.maxMessages {
+		messages = messages[:l.maxMessages]
+	}
+
+	var sb strings.Builder
+	for _, msg := range messages {
+		if msg.Text == "" {
+			continue
+		}
+		sb.WriteString("- ")
+		sb.WriteString(msg.Text)
+		sb.WriteString("\n")
+	}
+
+	reply, err := l.responder.Respond(ctx, &responder.Request{
+		Messages: []responder.Message{
+			{Role: "system", Content: summarizerPrompt},
+			{Role: "user", Content: sb.String()},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("summarizer request failed: %w", err)
+	}
+
+	// Models sometimes wrap JSON in prose or code fences
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("summarizer reply contains no JSON object")
+	}
+
+	var parsed struct {
+		Interests   []string `json:"interests"`
+		Topics      []string `json:"topics"`
+		Personality string   `json:"personality"`
+		Notes       string   `json:"notes"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse summarizer reply: %w", err)
+	}
+
+	return &Summary{
+		Interests:   parsed.Interests,
+		Topics:      parsed.Topics,
+		Personality: parsed.Personality,
+		Notes:       parsed.Notes,
+	}, nil
+}
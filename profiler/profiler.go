@@ -0,0 +1,180 @@
+package profiler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// Options controls how often and how deeply users are profiled
+type Options struct {
+	Interval        time.Duration // Time between profiling passes
+	MinMessages     int           // Users with fewer stored messages are skipped
+	UserMessages    int           // Number of the user's recent messages to analyse
+	ChatCorpusLimit int           // Number of recent chat messages used as background corpus
+	Logger          *slog.Logger  // Where failures are reported; nil means the default logger
+}
+
+// userKey identifies a user within a chat
+type userKey struct {
+	chatID int64
+	userID int64
+}
+
+// Profiler keeps storage.UserProfile up to date for active users
+type Profiler struct {
+	storage   storage.Storage
+	extractor Extractor
+	options   Options
+
+	active map[userKey]struct{} // Users who wrote since the last pass
+	mu     sync.Mutex
+}
+
+// New creates a profiler that persists results to the given storage
+func New(store storage.Storage, extractor Extractor, opts Options) *Profiler {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Minute
+	}
+	if opts.MinMessages <= 0 {
+		opts.MinMessages = 5
+	}
+	if opts.UserMessages <= 0 {
+		opts.UserMessages = 200
+	}
+	if opts.ChatCorpusLimit <= 0 {
+		opts.ChatCorpusLimit = 500
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	return &Profiler{
+		storage:   store,
+		extractor: extractor,
+		options:   opts,
+		active:    make(map[userKey]struct{}),
+	}
+}
+
+// RecordInteraction bumps a user's interaction counters and marks them for the next pass
+func (p *Profiler) RecordInteraction(ctx context.Context, chatID, userID int64, at time.Time) error {
+	p.mu.Lock()
+	p.active[userKey{chatID: chatID, userID: userID}] = struct{}{}
+	p.mu.Unlock()
+
+	profile, err := p.storage.GetUserProfile(ctx, chatID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load profile: %w", err)
+	}
+
+	if profile == nil {
+		return p.storage.SaveUserProfile(ctx, &storage.UserProfile{
+			ChatID:           chatID,
+			UserID:           userID,
+			LastInteraction:  at,
+			InteractionCount: 1,
+			CreatedAt:        at,
+			UpdatedAt:        at,
+		})
+	}
+
+	return p.storage.UpdateUserProfile(ctx, chatID, userID, map[string]interface{}{
+		"interaction_count": profile.InteractionCount + 1,
+		"last_interaction":  at,
+	})
+}
+
+// Run profiles active users every interval until the context is cancelled
+func (p *Profiler) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.ProfileActive(ctx)
+		}
+	}
+}
+
+// ProfileActive profiles every user marked active since the previous pass
+func (p *Profiler) ProfileActive(ctx context.Context) {
+	p.mu.Lock()
+	active := p.active
+	p.active = make(map[userKey]struct{})
+	p.mu.Unlock()
+
+	for key := range active {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := p.ProfileUser(ctx, key.chatID, key.userID); err != nil {
+			p.options.Logger.Warn("Failed to profile user", "chat_id", key.chatID, "user_id", key.userID, "error", err)
+		}
+	}
+}
+
+// ProfileUser derives interests and topics for one user and saves them
+func (p *Profiler) ProfileUser(ctx context.Context, chatID, userID int64) error {
+	userMessages, err := p.storage.GetUserMessagesInChat(ctx, chatID, userID, p.options.UserMessages)
+	if err != nil {
+		return fmt.Errorf("failed to load user messages: %w", err)
+	}
+	if len(userMessages) < p.options.MinMessages {
+		return nil
+	}
+
+	chatMessages, err := p.storage.GetRecentMessages(ctx, chatID, p.options.ChatCorpusLimit)
+	if err != nil {
+		return fmt.Errorf("failed to load chat messages: %w", err)
+	}
+
+	summary, err := p.extractor.Extract(ctx, &Input{
+		UserMessages: userMessages,
+		ChatMessages: chatMessages,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to extract profile: %w", err)
+	}
+
+	updates := map[string]interface{}{
+		"interests": strings.Join(summary.Interests, ", "),
+		"topics":    strings.Join(summary.Topics, ", "),
+	}
+	// Keep earlier observations when this extractor has nothing to say
+	if summary.Personality != "" {
+		updates["personality"] = summary.Personality
+	}
+	if summary.Notes != "" {
+		updates["notes"] = summary.Notes
+	}
+
+	profile, err := p.storage.GetUserProfile(ctx, chatID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load profile: %w", err)
+	}
+
+	if profile == nil {
+		now := time.Now()
+		return p.storage.SaveUserProfile(ctx, &storage.UserProfile{
+			ChatID:      chatID,
+			UserID:      userID,
+			Interests:   updates["interests"].(string),
+			Topics:      updates["topics"].(string),
+			Personality: summary.Personality,
+			Notes:       summary.Notes,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+	}
+
+	return p.storage.UpdateUserProfile(ctx, chatID, userID, updates)
+}
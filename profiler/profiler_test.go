@@ -0,0 +1,234 @@
+package profiler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/config"
+	"github.com/Zind-dev/HowardTheChad_bot/responder"
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+func saveMessages(t *testing.T, store storage.Storage, chatID, userID int64, texts ...string) {
+	t.Helper()
+	for _, text := range texts {
+		err := store.SaveMessage(context.Background(), &storage.Message{
+			ChatID:    chatID,
+			UserID:    userID,
+			Text:      text,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+}
+
+func TestKeywordExtractor(t *testing.T) {
+	var user, chat []*storage.Message
+	for _, text := range []string{
+		"Just finished a long marathon training run",
+		"Marathon training is brutal this week",
+		"Anyone else doing marathon training? Need new running shoes",
+		"Running shoes are so expensive",
+	} {
+		msg := &storage.Message{Text: text}
+		user = append(user, msg)
+		chat = append(chat, msg)
+	}
+	for _, text := range []string{
+		"Good morning everyone",
+		"Is this week busy for everyone?",
+		"Morning! Busy week here too",
+	} {
+		chat = append(chat, &storage.Message{Text: text})
+	}
+
+	summary, err := NewKeywordExtractor().Extract(context.Background(), &Input{
+		UserMessages: user,
+		ChatMessages: chat,
+	})
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+
+	if len(summary.Interests) == 0 || summary.Interests[0] != "marathon" {
+		t.Errorf("Expected 'marathon' as top interest, got %v", summary.Interests)
+	}
+	if !reflect.DeepEqual(summary.Topics, []string{"marathon training", "running shoes"}) {
+		t.Errorf("Expected topics [marathon training, running shoes], got %v", summary.Topics)
+	}
+	if summary.Personality != "" {
+		t.Errorf("Expected no personality from keyword extractor, got '%s'", summary.Personality)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("@bot Check https://example.com — the NEW Go release is out! 2024 /help")
+	want := []string{"check", "new", "release"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize() = %v, expected %v", got, want)
+	}
+}
+
+type stubResponder struct {
+	reply string
+	err   error
+}
+
+func (s *stubResponder) Respond(ctx context.Context, req *responder.Request) (string, error) {
+	return s.reply, s.err
+}
+
+func TestLLMSummarizer(t *testing.T) {
+	reply := "Sure! ```json\n" +
+		`{"interests": ["chess", "jazz"], "topics": ["openings"], "personality": "curious", "notes": ""}` +
+		"\n```"
+	summarizer := NewLLMSummarizer(&stubResponder{reply: reply})
+
+	summary, err := summarizer.Extract(context.Background(), &Input{
+		UserMessages: []*storage.Message{{Text: "The Sicilian is underrated"}},
+	})
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(summary.Interests, []string{"chess", "jazz"}) {
+		t.Errorf("Unexpected interests: %v", summary.Interests)
+	}
+	if summary.Personality != "curious" {
+		t.Errorf("Expected personality 'curious', got '%s'", summary.Personality)
+	}
+}
+
+func TestLLMSummarizer_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		err   error
+	}{
+		{name: "Backend error", err: errors.New("unavailable")},
+		{name: "No JSON", reply: "I cannot help with that."},
+		{name: "Invalid JSON", reply: `{"interests": chess}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summarizer := NewLLMSummarizer(&stubResponder{reply: tt.reply, err: tt.err})
+			_, err := summarizer.Extract(context.Background(), &Input{})
+			if err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestRecordInteraction(t *testing.T) {
+	store := storage.NewMockStorage()
+	p := New(store, NewKeywordExtractor(), Options{})
+
+	first := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	if err := p.RecordInteraction(context.Background(), 100, 1, first); err != nil {
+		t.Fatalf("RecordInteraction failed: %v", err)
+	}
+	if err := p.RecordInteraction(context.Background(), 100, 1, second); err != nil {
+		t.Fatalf("RecordInteraction failed: %v", err)
+	}
+
+	profile, _ := store.GetUserProfile(context.Background(), 100, 1)
+	if profile == nil {
+		t.Fatal("Expected profile to be created")
+	}
+	if profile.InteractionCount != 2 {
+		t.Errorf("Expected interaction count 2, got %d", profile.InteractionCount)
+	}
+	if !profile.LastInteraction.Equal(second) {
+		t.Errorf("Expected last interaction %v, got %v", second, profile.LastInteraction)
+	}
+}
+
+func TestProfileActive(t *testing.T) {
+	store := storage.NewMockStorage()
+	p := New(store, NewKeywordExtractor(), Options{MinMessages: 3})
+
+	saveMessages(t, store, 100, 1,
+		"Who else plays guitar here?",
+		"New guitar strings arrived today",
+		"Practising guitar chords all evening",
+	)
+	saveMessages(t, store, 100, 2, "hello") // Too few messages to profile
+
+	p.RecordInteraction(context.Background(), 100, 1, time.Now())
+	p.RecordInteraction(context.Background(), 100, 2, time.Now())
+	store.UpdateUserProfile(context.Background(), 100, 1, map[string]interface{}{"personality": "cheerful"})
+
+	p.ProfileActive(context.Background())
+
+	profile, _ := store.GetUserProfile(context.Background(), 100, 1)
+	if profile.Interests == "" || profile.Interests[:6] != "guitar" {
+		t.Errorf("Expected interests to start with 'guitar', got '%s'", profile.Interests)
+	}
+	if profile.Personality != "cheerful" {
+		t.Errorf("Expected existing personality to be preserved, got '%s'", profile.Personality)
+	}
+	if profile.InteractionCount != 1 {
+		t.Errorf("Expected interaction count to be untouched, got %d", profile.InteractionCount)
+	}
+
+	skipped, _ := store.GetUserProfile(context.Background(), 100, 2)
+	if skipped.Interests != "" {
+		t.Errorf("Expected user with few messages to be skipped, got '%s'", skipped.Interests)
+	}
+
+	// Active set is cleared after a pass
+	if len(p.active) != 0 {
+		t.Errorf("Expected active users to be cleared, got %d", len(p.active))
+	}
+}
+
+func TestProfileUser_CreatesProfile(t *testing.T) {
+	store := storage.NewMockStorage()
+	p := New(store, &stubExtractor{summary: &Summary{Interests: []string{"a", "b"}, Personality: "calm"}}, Options{MinMessages: 1})
+
+	saveMessages(t, store, 100, 1, "hi")
+
+	if err := p.ProfileUser(context.Background(), 100, 1); err != nil {
+		t.Fatalf("ProfileUser failed: %v", err)
+	}
+
+	profile, _ := store.GetUserProfile(context.Background(), 100, 1)
+	if profile == nil {
+		t.Fatal("Expected profile to be created")
+	}
+	if profile.Interests != "a, b" {
+		t.Errorf("Expected interests 'a, b', got '%s'", profile.Interests)
+	}
+	if profile.Personality != "calm" {
+		t.Errorf("Expected personality 'calm', got '%s'", profile.Personality)
+	}
+}
+
+type stubExtractor struct {
+	summary *Summary
+}
+
+func (s *stubExtractor) Extract(ctx context.Context, in *Input) (*Summary, error) {
+	return s.summary, nil
+}
+
+func TestNewExtractor(t *testing.T) {
+	if e, err := NewExtractor(&config.Config{Profiler: "keyword"}); err != nil || e == nil {
+		t.Errorf("Expected keyword extractor, got %v, %v", e, err)
+	}
+	if e, err := NewExtractor(&config.Config{Profiler: "llm", Responder: config.ResponderConfig{Timeout: time.Second}}); err != nil || e == nil {
+		t.Errorf("Expected LLM summarizer, got %v, %v", e, err)
+	}
+	if _, err := NewExtractor(&config.Config{Profiler: "unknown"}); err == nil {
+		t.Error("Expected error for unknown backend")
+	}
+}
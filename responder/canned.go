@@ -0,0 +1,32 @@
+package responder
+
+import "context"
+
+// Canned picks one of a few fixed greetings.
+// It needs no network access and is used as the fallback for other backends.
+type Canned struct{}
+
+// NewCanned creates a canned responder
+func NewCanned() *Canned {
+	return &Canned{}
+}
+
+// Respond selects a greeting based on the incoming message
+func (c *Canned) Respond(ctx context.Context, req *Request) (string, error) {
+	userName := "there"
+	if req.SenderName != "" {
+		userName = req.SenderName
+	}
+
+	responses := []string{
+		"Hey " + userName + "! What's up?",
+		"Hello " + userName + "! I'm here to help.",
+		"Hi " + userName + "! What can I do for you?",
+		userName + ", I'm listening!",
+		"Yo " + userName + "! How can I contribute?",
+	}
+
+	// Simple selection based on message length
+	index := len(req.Text) % len(responses)
+	return responses[index], nil
+}
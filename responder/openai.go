@@ -0,0 +1,110 @@
+package responder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI talks to any OpenAI-compatible chat-completion endpoint
+// (OpenAI, Azure-style proxies, llama.cpp, Ollama, vLLM, etc.)
+type OpenAI struct {
+	baseURL    string
+	model      string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewOpenAI creates a client for an OpenAI-compatible API
+func NewOpenAI(baseURL, model, apiKey string, timeout time.Duration) *OpenAI {
+	return &OpenAI{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		model:      model,
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+type chatCompletionRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message Message `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Respond sends the conversation to the chat-completion endpoint and returns the reply
+func (o *OpenAI) Respond(ctx context.Context, req *Request) (string, error) {
+	messages := req.Messages
+	if len(messages) == 0 {
+		messages = []Message{
+			{Role: "system", Content: DefaultSystemPrompt},
+			{Role: "user", Content: req.Text},
+		}
+	}
+
+	body, err := json.Marshal(chatCompletionRequest{
+		Model:    o.model,
+		Messages: messages,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("chat completion request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var completion chatCompletionResponse
+	if err := json.Unmarshal(respBody, &completion); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("chat completion returned status %d", resp.StatusCode)
+		}
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if completion.Error != nil && completion.Error.Message != "" {
+			return "", fmt.Errorf("chat completion returned status %d: %s", resp.StatusCode, completion.Error.Message)
+		}
+		return "", fmt.Errorf("chat completion returned status %d", resp.StatusCode)
+	}
+
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("chat completion returned no choices")
+	}
+
+	reply := strings.TrimSpace(completion.Choices[0].Message.Content)
+	if reply == "" {
+		return "", fmt.Errorf("chat completion returned an empty reply")
+	}
+
+	return reply, nil
+}
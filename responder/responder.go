@@ -0,0 +1,83 @@
+package responder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/Zind-dev/HowardTheChad_bot/config"
+)
+
+// DefaultSystemPrompt describes the bot persona to chat-completion backends
+const DefaultSystemPrompt = "You are HowardTheChad, a friendly and witty member of a Telegram group chat. " +
+	"Keep replies short and conversational."
+
+// Responder generates a reply for an incoming message
+// This allows switching between canned responses, hosted LLMs, or local models
+type Responder interface {
+	Respond(ctx context.Context, req *Request) (string, error)
+}
+
+// Request holds everything a backend needs to produce a reply
+type Request struct {
+	ChatID     int64
+	SenderName string // Display name of the user being answered
+	Text       string // Text of the incoming message
+
+	// Messages is the conversation to send to chat-completion backends.
+	// When empty, backends build a minimal conversation from Text.
+	Messages []Message
+}
+
+// Message is a single turn in a chat-completion conversation
+type Message struct {
+	Role    string `json:"role"` // "system", "user" or "assistant"
+	Content string `json:"content"`
+}
+
+// New creates the responder selected in the configuration.
+// Every backend other than canned falls back to canned responses on failure,
+// logging the failure to logger, or the default logger if it is nil.
+func New(cfg *config.Config, logger *slog.Logger) (Responder, error) {
+	switch cfg.Responder.Backend {
+	case "", "canned":
+		return NewCanned(), nil
+	case "openai":
+		client := NewOpenAI(cfg.Responder.BaseURL, cfg.Responder.Model, cfg.Responder.APIKey, cfg.Responder.Timeout)
+		fallback := NewFallback(client, NewCanned())
+		if logger != nil {
+			fallback.log = logger
+		}
+		return fallback, nil
+	default:
+		return nil, fmt.Errorf("unknown responder backend: %s", cfg.Responder.Backend)
+	}
+}
+
+// Fallback tries a primary responder and uses a secondary one if it fails
+type Fallback struct {
+	primary   Responder
+	secondary Responder
+	log       *slog.Logger
+}
+
+// NewFallback creates a responder that falls back to secondary on any primary error
+func NewFallback(primary, secondary Responder) *Fallback {
+	return &Fallback{
+		primary:   primary,
+		secondary: secondary,
+		log:       slog.Default(),
+	}
+}
+
+// Respond returns the primary reply, or the secondary reply if the primary fails
+func (f *Fallback) Respond(ctx context.Context, req *Request) (string, error) {
+	reply, err := f.primary.Respond(ctx, req)
+	if err == nil && reply != "" {
+		return reply, nil
+	}
+	if err != nil {
+		f.log.Warn("Primary responder failed, using fallback", "chat_id", req.ChatID, "error", err)
+	}
+	return f.secondary.Respond(ctx, req)
+}
@@ -0,0 +1,235 @@
+package responder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/config"
+)
+
+// newCompletionServer starts a stand-in for an OpenAI-compatible endpoint
+func newCompletionServer(t *testing.T, handler func(w http.ResponseWriter, req chatCompletionRequest)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req chatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		handler(w, req)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeCompletion(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"choices": []map[string]interface{}{
+			{"message": map[string]string{"role": "assistant", "content": content}},
+		},
+	})
+}
+
+func TestCannedRespond(t *testing.T) {
+	canned := NewCanned()
+
+	reply, err := canned.Respond(context.Background(), &Request{SenderName: "John", Text: "Hello bot!"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(reply, "John") {
+		t.Errorf("Expected reply to contain sender name, got '%s'", reply)
+	}
+
+	reply, err = canned.Respond(context.Background(), &Request{Text: "A"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(reply, "there") {
+		t.Errorf("Expected generic greeting without sender name, got '%s'", reply)
+	}
+}
+
+func TestOpenAIRespond(t *testing.T) {
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+
+		var req chatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "test-model" {
+			t.Errorf("Expected model 'test-model', got '%s'", req.Model)
+		}
+		if len(req.Messages) != 2 || req.Messages[1].Content != "What's the weather?" {
+			t.Errorf("Unexpected messages: %+v", req.Messages)
+		}
+		writeCompletion(w, "  Sunny, probably.  ")
+	}))
+	defer server.Close()
+
+	client := NewOpenAI(server.URL+"/", "test-model", "secret", 5*time.Second)
+	reply, err := client.Respond(context.Background(), &Request{Text: "What's the weather?"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if reply != "Sunny, probably." {
+		t.Errorf("Expected trimmed reply, got '%s'", reply)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Expected bearer token, got '%s'", gotAuth)
+	}
+}
+
+func TestOpenAIRespond_UsesRequestMessages(t *testing.T) {
+	server := newCompletionServer(t, func(w http.ResponseWriter, req chatCompletionRequest) {
+		if len(req.Messages) != 3 {
+			t.Errorf("Expected 3 messages, got %d", len(req.Messages))
+		}
+		if req.Messages[1].Role != "assistant" {
+			t.Errorf("Expected assistant turn to be preserved, got '%s'", req.Messages[1].Role)
+		}
+		writeCompletion(w, "ok")
+	})
+
+	client := NewOpenAI(server.URL+"/v1", "m", "", 5*time.Second)
+	_, err := client.Respond(context.Background(), &Request{
+		Text: "ignored",
+		Messages: []Message{
+			{Role: "system", Content: "Be brief."},
+			{Role: "assistant", Content: "Hi!"},
+			{Role: "user", Content: "Hello"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestOpenAIRespond_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter, req chatCompletionRequest)
+		wantErr string
+	}{
+		{
+			name: "API error",
+			handler: func(w http.ResponseWriter, req chatCompletionRequest) {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error": {"message": "invalid api key"}}`))
+			},
+			wantErr: "invalid api key",
+		},
+		{
+			name: "Non-JSON error",
+			handler: func(w http.ResponseWriter, req chatCompletionRequest) {
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte("bad gateway"))
+			},
+			wantErr: "status 502",
+		},
+		{
+			name: "No choices",
+			handler: func(w http.ResponseWriter, req chatCompletionRequest) {
+				w.Write([]byte(`{"choices": []}`))
+			},
+			wantErr: "no choices",
+		},
+		{
+			name: "Empty reply",
+			handler: func(w http.ResponseWriter, req chatCompletionRequest) {
+				writeCompletion(w, "   ")
+			},
+			wantErr: "empty reply",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newCompletionServer(t, tt.handler)
+			client := NewOpenAI(server.URL+"/v1", "m", "", 5*time.Second)
+
+			_, err := client.Respond(context.Background(), &Request{Text: "hi"})
+			if err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing '%s', got '%v'", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestOpenAIRespond_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := newCompletionServer(t, func(w http.ResponseWriter, req chatCompletionRequest) {
+		<-release
+	})
+	defer close(release)
+
+	client := NewOpenAI(server.URL+"/v1", "m", "", 50*time.Millisecond)
+	_, err := client.Respond(context.Background(), &Request{Text: "hi"})
+	if err == nil {
+		t.Fatal("Expected timeout error, got nil")
+	}
+}
+
+type failingResponder struct{}
+
+func (f *failingResponder) Respond(ctx context.Context, req *Request) (string, error) {
+	return "", errors.New("backend unavailable")
+}
+
+func TestFallback(t *testing.T) {
+	fallback := NewFallback(&failingResponder{}, NewCanned())
+
+	reply, err := fallback.Respond(context.Background(), &Request{SenderName: "Jane", Text: "hey"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(reply, "Jane") {
+		t.Errorf("Expected canned reply, got '%s'", reply)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		backend string
+		wantErr bool
+	}{
+		{backend: "", wantErr: false},
+		{backend: "canned", wantErr: false},
+		{backend: "openai", wantErr: false},
+		{backend: "unknown", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			r, err := New(&config.Config{Responder: config.ResponderConfig{Backend: tt.backend, Timeout: time.Second}}, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected error for unknown backend")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if r == nil {
+				t.Fatal("Expected responder, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// Options controls how often and how aggressively messages are pruned
+type Options struct {
+	Interval   time.Duration // Time between pruning passes
+	BatchSize  int           // Messages deleted per statement
+	BatchPause time.Duration // Pause between batches so message handlers get the database
+	Logger     *slog.Logger  // Where pruning passes are reported; nil means the default logger
+}
+
+// Report summarises one pruning pass
+type Report struct {
+	Chats    int   // Chats with a retention rule
+	Removed  int64 // Messages deleted
+	Duration time.Duration
+}
+
+// Janitor deletes messages that fall outside each chat's retention rules
+type Janitor struct {
+	storage storage.Storage
+	options Options
+	now     func() time.Time
+}
+
+// New creates a janitor for the given storage
+func New(store storage.Storage, opts Options) *Janitor {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.BatchPause < 0 {
+		opts.BatchPause = 0
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	return &Janitor{
+		storage: store,
+		options: opts,
+		now:     time.Now,
+	}
+}
+
+// Run prunes every interval until the context is cancelled
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := j.Prune(ctx)
+			if err != nil {
+				j.options.Logger.Warn("Message pruning incomplete", "error", err)
+			}
+			if report.Removed > 0 || err != nil {
+				j.options.Logger.Info("Pruned messages", "removed", report.Removed, "chats", report.Chats,
+					"duration", report.Duration.Round(time.Millisecond))
+			}
+		}
+	}
+}
+
+// Prune applies every chat's retention rules, then vacuums if anything was
+// deleted. Errors for one chat do not stop the others; the first is returned.
+func (j *Janitor) Prune(ctx context.Context) (Report, error) {
+	start := time.Now()
+	var report Report
+
+	chats, err := j.storage.GetAllChats(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to list chats: %w", err)
+	}
+
+	var firstErr error
+	for _, chat := range chats {
+		if ctx.Err() != nil {
+			break
+		}
+
+		settings, err := j.storage.GetChatSettings(ctx, chat.ID)
+		if err != nil {
+			firstErr = keepFirst(firstErr, fmt.Errorf("failed to load settings for chat %d: %w", chat.ID, err))
+			continue
+		}
+		if settings == nil || (settings.RetentionMaxAge <= 0 && settings.RetentionMaxMessages <= 0) {
+			continue
+		}
+
+		report.Chats++
+		removed, err := j.pruneChat(ctx, chat.ID, settings)
+		report.Removed += removed
+		if err != nil {
+			firstErr = keepFirst(firstErr, fmt.Errorf("failed to prune chat %d: %w", chat.ID, err))
+		}
+	}
+
+	if report.Removed > 0 && ctx.Err() == nil {
+		if err := j.storage.Vacuum(ctx); err != nil {
+			firstErr = keepFirst(firstErr, fmt.Errorf("failed to vacuum: %w", err))
+		}
+	}
+
+	report.Duration = time.Since(start)
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return report, firstErr
+}
+
+// pruneChat deletes one chat's expired messages batch by batch
+func (j *Janitor) pruneChat(ctx context.Context, chatID int64, settings *storage.ChatSettings) (int64, error) {
+	var before time.Time
+	if settings.RetentionMaxAge > 0 {
+		before = j.now().Add(-settings.RetentionMaxAge)
+	}
+
+	var total int64
+	for {
+		n, err := j.storage.PruneMessages(ctx, chatID, before, settings.RetentionMaxMessages, j.options.BatchSize)
+		total += n
+		if err != nil || n < int64(j.options.BatchSize) {
+			return total, err
+		}
+
+		// Yield between batches; SQLite has a single connection
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(j.options.BatchPause):
+		}
+	}
+}
+
+func keepFirst(first, err error) error {
+	if first != nil {
+		return first
+	}
+	return err
+}
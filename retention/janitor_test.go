@@ -0,0 +1,123 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// countingStorage records prune batches and vacuums
+type countingStorage struct {
+	storage.Storage
+	batches int
+	vacuums int
+}
+
+func (c *countingStorage) PruneMessages(ctx context.Context, chatID int64, before time.Time, keepLatest int, limit int) (int64, error) {
+	c.batches++
+	return c.Storage.PruneMessages(ctx, chatID, before, keepLatest, limit)
+}
+
+func (c *countingStorage) Vacuum(ctx context.Context) error {
+	c.vacuums++
+	return c.Storage.Vacuum(ctx)
+}
+
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func seedChat(t *testing.T, store storage.Storage, chatID int64, settings *storage.ChatSettings, messages int) {
+	t.Helper()
+	ctx := context.Background()
+	store.SaveChat(ctx, &storage.Chat{ID: chatID, Title: "chat", Type: "group"})
+	if settings != nil {
+		store.SaveChatSettings(ctx, chatID, settings)
+	}
+	// One message per day, the newest at now
+	for i := messages - 1; i >= 0; i-- {
+		msg := &storage.Message{ChatID: chatID, UserID: 1, Text: "hi", Timestamp: now.Add(-time.Duration(i) * 24 * time.Hour)}
+		if err := store.SaveMessage(ctx, msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+}
+
+func remaining(t *testing.T, store storage.Storage, chatID int64) int {
+	t.Helper()
+	messages, err := store.GetRecentMessages(context.Background(), chatID, 1000)
+	if err != nil {
+		t.Fatalf("Failed to get messages: %v", err)
+	}
+	return len(messages)
+}
+
+func TestPrune(t *testing.T) {
+	store := &countingStorage{Storage: storage.NewMockStorage()}
+	seedChat(t, store, -1, &storage.ChatSettings{RetentionMaxAge: 7 * 24 * time.Hour}, 30)
+	seedChat(t, store, -2, &storage.ChatSettings{RetentionMaxMessages: 2}, 10)
+	seedChat(t, store, -3, &storage.ChatSettings{ResponseFrequency: 5}, 10)
+	seedChat(t, store, -4, nil, 10)
+
+	janitor := New(store, Options{BatchSize: 3})
+	janitor.now = func() time.Time { return now }
+
+	report, err := janitor.Prune(context.Background())
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+
+	// Age limit keeps messages from the last 7 days, including exactly 7 days ago
+	if got := remaining(t, store, -1); got != 8 {
+		t.Errorf("Expected 8 messages within 7 days, got %d", got)
+	}
+	if got := remaining(t, store, -2); got != 2 {
+		t.Errorf("Expected 2 newest messages kept, got %d", got)
+	}
+	if remaining(t, store, -3) != 10 || remaining(t, store, -4) != 10 {
+		t.Error("Expected chats without retention rules to be untouched")
+	}
+
+	if report.Chats != 2 {
+		t.Errorf("Expected 2 chats with rules, got %d", report.Chats)
+	}
+	if report.Removed != 22+8 {
+		t.Errorf("Expected 30 messages removed, got %d", report.Removed)
+	}
+
+	// 22 rows in batches of 3 take 8 statements, 8 rows take 3
+	if store.batches != 11 {
+		t.Errorf("Expected 11 batches, got %d", store.batches)
+	}
+	if store.vacuums != 1 {
+		t.Errorf("Expected 1 vacuum, got %d", store.vacuums)
+	}
+
+	// Nothing left to remove: no vacuum
+	report, err = janitor.Prune(context.Background())
+	if err != nil {
+		t.Fatalf("Second prune failed: %v", err)
+	}
+	if report.Removed != 0 || store.vacuums != 1 {
+		t.Errorf("Expected no removals or vacuum on the second pass, got %d removed and %d vacuums", report.Removed, store.vacuums)
+	}
+}
+
+func TestPrune_CancelledContext(t *testing.T) {
+	store := storage.NewMockStorage()
+	seedChat(t, store, -1, &storage.ChatSettings{RetentionMaxMessages: 1}, 10)
+
+	janitor := New(store, Options{BatchSize: 2, BatchPause: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	report, err := janitor.Prune(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if report.Removed != 2 {
+		t.Errorf("Expected only the first batch of 2 to be removed, got %d", report.Removed)
+	}
+}
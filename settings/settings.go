@@ -1,7 +1,14 @@
 package settings
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
 )
 
 // Settings holds bot behavior configuration
@@ -12,24 +19,112 @@ type Settings struct {
 
 	// AlwaysRespondToMentions when true, bot always responds when mentioned
 	AlwaysRespondToMentions bool
+
+	// RetentionMaxAge prunes stored messages older than this; 0 keeps them forever
+	RetentionMaxAge time.Duration
+
+	// RetentionMaxMessages keeps only this many recent messages; 0 means no limit
+	RetentionMaxMessages int
+
+	// IgnoreMedia when true, photos, stickers and other media do not count
+	// toward ResponseFrequency
+	IgnoreMedia bool
+}
+
+// Settings a chat can override individually, as stored in
+// storage.ChatSettings.Overridden
+const (
+	fieldResponseFrequency = "response_frequency"
+	fieldMentions          = "always_respond_to_mentions"
+	fieldRetention         = "retention"
+	fieldIgnoreMedia       = "ignore_media"
+)
+
+// allFields lists every overridable setting in storage order
+var allFields = []string{fieldResponseFrequency, fieldMentions, fieldRetention, fieldIgnoreMedia}
+
+// overrides are the settings a chat set itself. Fields not in set follow
+// the defaults, so they pick up new defaults from SetDefaults.
+type overrides struct {
+	values Settings
+	set    map[string]bool
 }
 
-// Manager manages settings per chat
+// apply returns the defaults with the chat's overrides applied
+func (o *overrides) apply(defaults *Settings) *Settings {
+	settings := *defaults
+	if o.set[fieldResponseFrequency] {
+		settings.ResponseFrequency = o.values.ResponseFrequency
+	}
+	if o.set[fieldMentions] {
+		settings.AlwaysRespondToMentions = o.values.AlwaysRespondToMentions
+	}
+	if o.set[fieldRetention] {
+		settings.RetentionMaxAge = o.values.RetentionMaxAge
+		settings.RetentionMaxMessages = o.values.RetentionMaxMessages
+	}
+	if o.set[fieldIgnoreMedia] {
+		settings.IgnoreMedia = o.values.IgnoreMedia
+	}
+	return &settings
+}
+
+// fields lists the overridden settings in storage order
+func (o *overrides) fields() []string {
+	fields := []string{}
+	for _, field := range allFields {
+		if o.set[field] {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Manager manages settings per chat.
+// It acts as a write-through cache in front of a storage backend: settings are
+// loaded lazily on first access and persisted on every change. Only the
+// settings a chat changed are its own; the rest follow the defaults.
+// Storage calls hold only the chat's own lock, so a slow database never
+// blocks reads or changes in other chats.
 type Manager struct {
-	chatSettings map[int64]*Settings
-	defaults     *Settings
-	mu           sync.RWMutex
+	chatSettings map[int64]*overrides // nil value means the chat uses defaults
+	chatLocks    map[int64]*sync.Mutex
+	defaults     atomic.Pointer[Settings]
+	storage      storage.Storage
+	mu           sync.RWMutex // guards the maps, never held during storage calls
+	log          *slog.Logger
 }
 
 // NewManager creates a new settings manager with default settings
-func NewManager(defaults *Settings) *Manager {
+func NewManager(defaults *Settings, store storage.Storage) *Manager {
 	if defaults == nil {
 		defaults = NewDefaultSettings()
 	}
-	return &Manager{
-		chatSettings: make(map[int64]*Settings),
-		defaults:     defaults,
+	m := &Manager{
+		chatSettings: make(map[int64]*overrides),
+		chatLocks:    make(map[int64]*sync.Mutex),
+		storage:      store,
+		log:          slog.Default(),
 	}
+	m.defaults.Store(defaults)
+	return m
+}
+
+// SetLogger sets where settings that fail to load are logged
+func (m *Manager) SetLogger(logger *slog.Logger) {
+	m.log = logger
+}
+
+// Defaults returns the settings used by chats that have not customized theirs
+func (m *Manager) Defaults() *Settings {
+	return m.defaults.Load()
+}
+
+// SetDefaults replaces the default settings. Chats use the new defaults
+// from their next message for every setting they have not changed
+// themselves.
+func (m *Manager) SetDefaults(defaults *Settings) {
+	m.defaults.Store(defaults)
 }
 
 // NewDefaultSettings creates settings with default values
@@ -62,77 +157,238 @@ func (s *Settings) ShouldRespondToMention() bool {
 }
 
 // GetSettings returns settings for a specific chat, or defaults if not set
-func (m *Manager) GetSettings(chatID int64) *Settings {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+func (m *Manager) GetSettings(ctx context.Context, chatID int64) *Settings {
+	chat, loaded := m.cached(chatID)
+	if !loaded {
+		unlock := m.lockChat(chatID)
+		var err error
+		chat, err = m.load(ctx, chatID)
+		unlock()
+		if err != nil {
+			m.log.Warn("Failed to load settings, using defaults", "chat_id", chatID, "error", err)
+			return m.Defaults()
+		}
+	}
 
-	if settings, exists := m.chatSettings[chatID]; exists {
-		return settings
+	if chat == nil {
+		return m.Defaults()
 	}
-	return m.defaults
+	return chat.apply(m.Defaults())
 }
 
-// SetSettings sets custom settings for a specific chat
-func (m *Manager) SetSettings(chatID int64, settings *Settings) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// SetSettings sets custom settings for a specific chat, overriding every
+// default
+func (m *Manager) SetSettings(ctx context.Context, chatID int64, settings *Settings) error {
+	unlock := m.lockChat(chatID)
+	defer unlock()
 
-	m.chatSettings[chatID] = settings
+	chat := &overrides{values: *settings, set: make(map[string]bool, len(allFields))}
+	for _, field := range allFields {
+		chat.set[field] = true
+	}
+	return m.save(ctx, chatID, chat)
 }
 
 // SetFrequency sets the response frequency for a specific chat
-func (m *Manager) SetFrequency(chatID int64, frequency int) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (m *Manager) SetFrequency(ctx context.Context, chatID int64, frequency int) error {
+	_, err := m.update(ctx, chatID, fieldResponseFrequency, func(s *Settings) {
+		s.ResponseFrequency = frequency
+	})
+	return err
+}
 
-	if settings, exists := m.chatSettings[chatID]; exists {
-		settings.ResponseFrequency = frequency
-	} else {
-		// Create new settings based on defaults
-		m.chatSettings[chatID] = &Settings{
-			ResponseFrequency:       frequency,
-			AlwaysRespondToMentions: m.defaults.AlwaysRespondToMentions,
-		}
+// ToggleMentionResponse toggles the mention response setting for a specific chat
+func (m *Manager) ToggleMentionResponse(ctx context.Context, chatID int64) (bool, error) {
+	updated, err := m.update(ctx, chatID, fieldMentions, func(s *Settings) {
+		s.AlwaysRespondToMentions = !s.AlwaysRespondToMentions
+	})
+	if err != nil {
+		return false, err
 	}
+	return updated.AlwaysRespondToMentions, nil
 }
 
-// ToggleMentionResponse toggles the mention response setting for a specific chat
-func (m *Manager) ToggleMentionResponse(chatID int64) bool {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// ToggleMediaCounting toggles whether media messages count toward the
+// response frequency for a specific chat, and returns whether they now do
+func (m *Manager) ToggleMediaCounting(ctx context.Context, chatID int64) (bool, error) {
+	updated, err := m.update(ctx, chatID, fieldIgnoreMedia, func(s *Settings) {
+		s.IgnoreMedia = !s.IgnoreMedia
+	})
+	if err != nil {
+		return false, err
+	}
+	return !updated.IgnoreMedia, nil
+}
 
-	if settings, exists := m.chatSettings[chatID]; exists {
-		settings.AlwaysRespondToMentions = !settings.AlwaysRespondToMentions
-		return settings.AlwaysRespondToMentions
-	} else {
-		// Create new settings based on defaults
-		newValue := !m.defaults.AlwaysRespondToMentions
-		m.chatSettings[chatID] = &Settings{
-			ResponseFrequency:       m.defaults.ResponseFrequency,
-			AlwaysRespondToMentions: newValue,
-		}
-		return newValue
+// SetRetention sets how long and how many messages are kept for a chat.
+// Zero values disable the corresponding limit.
+func (m *Manager) SetRetention(ctx context.Context, chatID int64, maxAge time.Duration, maxMessages int) error {
+	_, err := m.update(ctx, chatID, fieldRetention, func(s *Settings) {
+		s.RetentionMaxAge = maxAge
+		s.RetentionMaxMessages = maxMessages
+	})
+	return err
+}
+
+// update applies change to a chat's effective settings and saves the
+// changed field as an override, returning the new effective settings
+func (m *Manager) update(ctx context.Context, chatID int64, field string, change func(*Settings)) (*Settings, error) {
+	unlock := m.lockChat(chatID)
+	defer unlock()
+
+	current, err := m.current(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := current.apply(m.Defaults())
+	change(updated)
+
+	chat := &overrides{values: *updated, set: map[string]bool{field: true}}
+	for overridden := range current.set {
+		chat.set[overridden] = true
+	}
+	if err := m.save(ctx, chatID, chat); err != nil {
+		return nil, err
 	}
+	return updated, nil
 }
 
 // ResetSettings resets a chat to default settings
-func (m *Manager) ResetSettings(chatID int64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (m *Manager) ResetSettings(ctx context.Context, chatID int64) error {
+	unlock := m.lockChat(chatID)
+	defer unlock()
+
+	if err := m.storage.DeleteChatSettings(ctx, chatID); err != nil {
+		return fmt.Errorf("failed to delete settings: %w", err)
+	}
 
-	delete(m.chatSettings, chatID)
+	m.publish(chatID, nil)
+	return nil
 }
 
-// GetAllChatSettings returns all custom chat settings
+// GetAllChatSettings returns all custom chat settings currently cached
 func (m *Manager) GetAllChatSettings() map[int64]*Settings {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	// Return a copy to prevent concurrent access issues
+	// Return copies to prevent concurrent access issues
+	defaults := m.Defaults()
 	settings := make(map[int64]*Settings, len(m.chatSettings))
 	for k, v := range m.chatSettings {
-		settingsCopy := *v
-		settings[k] = &settingsCopy
+		if v == nil {
+			continue
+		}
+		settings[k] = v.apply(defaults)
 	}
 	return settings
 }
+
+// lockChat serializes loading and changing one chat's settings and
+// returns the function that releases it
+func (m *Manager) lockChat(chatID int64) func() {
+	m.mu.Lock()
+	lock, ok := m.chatLocks[chatID]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.chatLocks[chatID] = lock
+	}
+	m.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// cached returns a chat's cached overrides and whether they were loaded
+func (m *Manager) cached(chatID int64) (*overrides, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chat, loaded := m.chatSettings[chatID]
+	return chat, loaded
+}
+
+// publish caches a chat's overrides once storage agrees with them
+func (m *Manager) publish(chatID int64, chat *overrides) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chatSettings[chatID] = chat
+}
+
+// current returns a chat's overrides, loading them if needed. A chat on
+// defaults has an empty set. Caller must hold the chat's lock.
+func (m *Manager) current(ctx context.Context, chatID int64) (*overrides, error) {
+	chat, loaded := m.cached(chatID)
+	if !loaded {
+		var err error
+		chat, err = m.load(ctx, chatID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if chat == nil {
+		return &overrides{}, nil
+	}
+	return chat, nil
+}
+
+// load reads settings for a chat from storage and caches the result.
+// Caller must hold the chat's lock.
+func (m *Manager) load(ctx context.Context, chatID int64) (*overrides, error) {
+	// Another goroutine may have loaded it while we waited for the lock
+	if chat, loaded := m.cached(chatID); loaded {
+		return chat, nil
+	}
+
+	stored, err := m.storage.GetChatSettings(ctx, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+
+	var chat *overrides
+	if stored != nil {
+		chat = &overrides{
+			values: Settings{
+				ResponseFrequency:       stored.ResponseFrequency,
+				AlwaysRespondToMentions: stored.AlwaysRespondToMentions,
+				RetentionMaxAge:         stored.RetentionMaxAge,
+				RetentionMaxMessages:    stored.RetentionMaxMessages,
+				IgnoreMedia:             stored.IgnoreMedia,
+			},
+			set: make(map[string]bool),
+		}
+		fields := stored.Overridden
+		if fields == nil {
+			fields = allFields
+		}
+		for _, field := range fields {
+			chat.set[field] = true
+		}
+	}
+
+	m.publish(chatID, chat)
+	return chat, nil
+}
+
+// save persists a chat's overrides and updates the cache on success.
+// Caller must hold the chat's lock.
+func (m *Manager) save(ctx context.Context, chatID int64, chat *overrides) error {
+	now := time.Now()
+	stored := &storage.ChatSettings{
+		ChatID:                  chatID,
+		ResponseFrequency:       chat.values.ResponseFrequency,
+		AlwaysRespondToMentions: chat.values.AlwaysRespondToMentions,
+		RetentionMaxAge:         chat.values.RetentionMaxAge,
+		RetentionMaxMessages:    chat.values.RetentionMaxMessages,
+		IgnoreMedia:             chat.values.IgnoreMedia,
+		Overridden:              chat.fields(),
+		CreatedAt:               now,
+		UpdatedAt:               now,
+	}
+	if err := m.storage.SaveChatSettings(ctx, chatID, stored); err != nil {
+		return fmt.Errorf("failed to save settings: %w", err)
+	}
+
+	m.publish(chatID, chat)
+	return nil
+}
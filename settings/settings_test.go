@@ -1,6 +1,13 @@
 package settings
 
-import "testing"
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
 
 func TestNewDefaultSettings(t *testing.T) {
 	settings := NewDefaultSettings()
@@ -134,7 +141,7 @@ func TestShouldRespondToMention(t *testing.T) {
 
 func TestNewManager(t *testing.T) {
 	defaults := NewDefaultSettings()
-	manager := NewManager(defaults)
+	manager := NewManager(defaults, storage.NewMockStorage())
 
 	if manager == nil {
 		t.Fatal("NewManager returned nil")
@@ -146,11 +153,13 @@ func TestNewManager(t *testing.T) {
 }
 
 func TestManagerGetSettings_Default(t *testing.T) {
+	ctx := context.Background()
+
 	defaults := NewCustomSettings(15, false)
-	manager := NewManager(defaults)
+	manager := NewManager(defaults, storage.NewMockStorage())
 
 	// Get settings for a chat that hasn't been configured
-	settings := manager.GetSettings(12345)
+	settings := manager.GetSettings(ctx, 12345)
 
 	if settings.ResponseFrequency != 15 {
 		t.Errorf("Expected default frequency 15, got %d", settings.ResponseFrequency)
@@ -161,13 +170,79 @@ func TestManagerGetSettings_Default(t *testing.T) {
 	}
 }
 
+func TestManagerSetDefaults(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(NewCustomSettings(10, true), storage.NewMockStorage())
+
+	if err := manager.SetFrequency(ctx, 1, 3); err != nil {
+		t.Fatalf("Failed to set frequency: %v", err)
+	}
+	manager.GetSettings(ctx, 2)
+
+	manager.SetDefaults(NewCustomSettings(20, false))
+
+	// Chats keep the settings they changed and follow the new defaults for the rest
+	if settings := manager.GetSettings(ctx, 1); settings.ResponseFrequency != 3 || settings.AlwaysRespondToMentions {
+		t.Errorf("Expected chat 1 to keep its frequency and take the new mention default, got %+v", settings)
+	}
+	// Chats on defaults, cached or not, follow the new ones
+	for _, chatID := range []int64{2, 3} {
+		if settings := manager.GetSettings(ctx, chatID); settings.ResponseFrequency != 20 || settings.AlwaysRespondToMentions {
+			t.Errorf("Expected chat %d to use the new defaults, got %+v", chatID, settings)
+		}
+	}
+}
+
+func TestManagerSetDefaults_AfterRetention(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newStore := range newTestBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			manager := NewManager(NewCustomSettings(10, true), store)
+
+			// Changing retention does not pin the other settings
+			if err := manager.SetRetention(ctx, 100, 7*24*time.Hour, 0); err != nil {
+				t.Fatalf("SetRetention failed: %v", err)
+			}
+			manager.SetDefaults(NewCustomSettings(25, false))
+
+			settings := manager.GetSettings(ctx, 100)
+			if settings.ResponseFrequency != 25 || settings.AlwaysRespondToMentions {
+				t.Errorf("Expected the reloaded defaults, got %+v", settings)
+			}
+			if settings.RetentionMaxAge != 7*24*time.Hour {
+				t.Errorf("Expected retention 168h to be kept, got %v", settings.RetentionMaxAge)
+			}
+
+			// The same holds after a restart with yet other defaults
+			restarted := NewManager(NewCustomSettings(4, true), store)
+			settings = restarted.GetSettings(ctx, 100)
+			if settings.ResponseFrequency != 4 || !settings.AlwaysRespondToMentions || settings.RetentionMaxAge != 7*24*time.Hour {
+				t.Errorf("Expected defaults with the stored retention after restart, got %+v", settings)
+			}
+
+			// Toggling starts from the default and then keeps its own value
+			if enabled, err := restarted.ToggleMentionResponse(ctx, 100); err != nil || enabled {
+				t.Fatalf("Expected mentions toggled off, got %v (error %v)", enabled, err)
+			}
+			restarted.SetDefaults(NewCustomSettings(4, true))
+			if restarted.GetSettings(ctx, 100).AlwaysRespondToMentions {
+				t.Error("Expected the toggled mention setting to override the defaults")
+			}
+		})
+	}
+}
+
 func TestManagerSetSettings(t *testing.T) {
-	manager := NewManager(NewDefaultSettings())
+	ctx := context.Background()
+
+	manager := NewManager(NewDefaultSettings(), storage.NewMockStorage())
 
 	customSettings := NewCustomSettings(5, false)
-	manager.SetSettings(100, customSettings)
+	manager.SetSettings(ctx, 100, customSettings)
 
-	retrieved := manager.GetSettings(100)
+	retrieved := manager.GetSettings(ctx, 100)
 	if retrieved.ResponseFrequency != 5 {
 		t.Errorf("Expected frequency 5, got %d", retrieved.ResponseFrequency)
 	}
@@ -177,79 +252,162 @@ func TestManagerSetSettings(t *testing.T) {
 }
 
 func TestManagerSetFrequency(t *testing.T) {
-	manager := NewManager(NewDefaultSettings())
+	ctx := context.Background()
+
+	manager := NewManager(NewDefaultSettings(), storage.NewMockStorage())
 
 	// Set frequency for new chat
-	manager.SetFrequency(100, 20)
+	manager.SetFrequency(ctx, 100, 20)
 
-	settings := manager.GetSettings(100)
+	settings := manager.GetSettings(ctx, 100)
 	if settings.ResponseFrequency != 20 {
 		t.Errorf("Expected frequency 20, got %d", settings.ResponseFrequency)
 	}
 
 	// Update existing chat
-	manager.SetFrequency(100, 30)
-	settings = manager.GetSettings(100)
+	manager.SetFrequency(ctx, 100, 30)
+	settings = manager.GetSettings(ctx, 100)
 	if settings.ResponseFrequency != 30 {
 		t.Errorf("Expected frequency 30, got %d", settings.ResponseFrequency)
 	}
 }
 
+func TestManagerSetRetention(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newStore := range newTestBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			manager := NewManager(NewCustomSettings(5, false), store)
+
+			if err := manager.SetRetention(ctx, 100, 48*time.Hour, 1000); err != nil {
+				t.Fatalf("SetRetention failed: %v", err)
+			}
+
+			settings := manager.GetSettings(ctx, 100)
+			if settings.RetentionMaxAge != 48*time.Hour || settings.RetentionMaxMessages != 1000 {
+				t.Errorf("Expected retention 48h/1000, got %v/%d", settings.RetentionMaxAge, settings.RetentionMaxMessages)
+			}
+			if settings.ResponseFrequency != 5 {
+				t.Errorf("Expected other settings to keep defaults, got frequency %d", settings.ResponseFrequency)
+			}
+
+			restarted := NewManager(NewDefaultSettings(), store)
+			settings = restarted.GetSettings(ctx, 100)
+			if settings.RetentionMaxAge != 48*time.Hour || settings.RetentionMaxMessages != 1000 {
+				t.Errorf("Expected retention 48h/1000 after restart, got %v/%d", settings.RetentionMaxAge, settings.RetentionMaxMessages)
+			}
+
+			// Zero disables a limit
+			if err := restarted.SetRetention(ctx, 100, 0, 1000); err != nil {
+				t.Fatalf("SetRetention failed: %v", err)
+			}
+			if settings := restarted.GetSettings(ctx, 100); settings.RetentionMaxAge != 0 {
+				t.Errorf("Expected max age cleared, got %v", settings.RetentionMaxAge)
+			}
+		})
+	}
+}
+
 func TestManagerToggleMentionResponse(t *testing.T) {
-	manager := NewManager(NewDefaultSettings())
+	ctx := context.Background()
+
+	manager := NewManager(NewDefaultSettings(), storage.NewMockStorage())
 
 	// Toggle for new chat (default is true, so should become false)
-	result := manager.ToggleMentionResponse(100)
+	result, err := manager.ToggleMentionResponse(ctx, 100)
+	if err != nil {
+		t.Fatalf("ToggleMentionResponse failed: %v", err)
+	}
 	if result {
 		t.Error("Expected toggle to return false (toggled from default true)")
 	}
 
-	settings := manager.GetSettings(100)
+	settings := manager.GetSettings(ctx, 100)
 	if settings.AlwaysRespondToMentions {
 		t.Error("Expected AlwaysRespondToMentions to be false after toggle")
 	}
 
 	// Toggle again
-	result = manager.ToggleMentionResponse(100)
+	result, err = manager.ToggleMentionResponse(ctx, 100)
+	if err != nil {
+		t.Fatalf("ToggleMentionResponse failed: %v", err)
+	}
 	if !result {
 		t.Error("Expected toggle to return true")
 	}
 
-	settings = manager.GetSettings(100)
+	settings = manager.GetSettings(ctx, 100)
 	if !settings.AlwaysRespondToMentions {
 		t.Error("Expected AlwaysRespondToMentions to be true after second toggle")
 	}
 }
 
+func TestManagerToggleMediaCounting(t *testing.T) {
+	ctx := context.Background()
+
+	store := storage.NewMockStorage()
+	manager := NewManager(NewDefaultSettings(), store)
+
+	// Media counts by default, so the first toggle turns it off
+	counts, err := manager.ToggleMediaCounting(ctx, 100)
+	if err != nil {
+		t.Fatalf("ToggleMediaCounting failed: %v", err)
+	}
+	if counts {
+		t.Error("Expected toggle to return false (toggled from default true)")
+	}
+	if !manager.GetSettings(ctx, 100).IgnoreMedia {
+		t.Error("Expected IgnoreMedia to be true after toggle")
+	}
+
+	stored, _ := store.GetChatSettings(ctx, 100)
+	if stored == nil || !stored.IgnoreMedia {
+		t.Errorf("Expected IgnoreMedia to be persisted, got %+v", stored)
+	}
+
+	counts, err = manager.ToggleMediaCounting(ctx, 100)
+	if err != nil {
+		t.Fatalf("ToggleMediaCounting failed: %v", err)
+	}
+	if !counts || manager.GetSettings(ctx, 100).IgnoreMedia {
+		t.Error("Expected media to count again after second toggle")
+	}
+}
+
 func TestManagerResetSettings(t *testing.T) {
-	manager := NewManager(NewDefaultSettings())
+	ctx := context.Background()
+
+	manager := NewManager(NewDefaultSettings(), storage.NewMockStorage())
 
 	// Set custom settings
-	manager.SetFrequency(100, 5)
+	manager.SetFrequency(ctx, 100, 5)
 
 	// Verify custom settings
-	settings := manager.GetSettings(100)
+	settings := manager.GetSettings(ctx, 100)
 	if settings.ResponseFrequency != 5 {
 		t.Errorf("Expected frequency 5, got %d", settings.ResponseFrequency)
 	}
 
 	// Reset
-	manager.ResetSettings(100)
+	manager.ResetSettings(ctx, 100)
 
 	// Should now return defaults
-	settings = manager.GetSettings(100)
+	settings = manager.GetSettings(ctx, 100)
 	if settings.ResponseFrequency != 10 {
 		t.Errorf("Expected default frequency 10 after reset, got %d", settings.ResponseFrequency)
 	}
 }
 
 func TestManagerGetAllChatSettings(t *testing.T) {
-	manager := NewManager(NewDefaultSettings())
+	ctx := context.Background()
+
+	manager := NewManager(NewDefaultSettings(), storage.NewMockStorage())
 
 	// Set settings for multiple chats
-	manager.SetFrequency(100, 5)
-	manager.SetFrequency(200, 15)
-	manager.SetFrequency(300, 25)
+	manager.SetFrequency(ctx, 100, 5)
+	manager.SetFrequency(ctx, 200, 15)
+	manager.SetFrequency(ctx, 300, 25)
 
 	allSettings := manager.GetAllChatSettings()
 
@@ -270,15 +428,17 @@ func TestManagerGetAllChatSettings(t *testing.T) {
 }
 
 func TestManagerIndependentChats(t *testing.T) {
-	manager := NewManager(NewDefaultSettings())
+	ctx := context.Background()
+
+	manager := NewManager(NewDefaultSettings(), storage.NewMockStorage())
 
 	// Set different settings for different chats
-	manager.SetFrequency(100, 5)
-	manager.SetFrequency(200, 10)
+	manager.SetFrequency(ctx, 100, 5)
+	manager.SetFrequency(ctx, 200, 10)
 
 	// Verify independence
-	settings100 := manager.GetSettings(100)
-	settings200 := manager.GetSettings(200)
+	settings100 := manager.GetSettings(ctx, 100)
+	settings200 := manager.GetSettings(ctx, 200)
 
 	if settings100.ResponseFrequency != 5 {
 		t.Errorf("Chat 100: expected frequency 5, got %d", settings100.ResponseFrequency)
@@ -288,10 +448,10 @@ func TestManagerIndependentChats(t *testing.T) {
 	}
 
 	// Toggle mentions for one chat shouldn't affect the other
-	manager.ToggleMentionResponse(100)
+	manager.ToggleMentionResponse(ctx, 100)
 
-	settings100 = manager.GetSettings(100)
-	settings200 = manager.GetSettings(200)
+	settings100 = manager.GetSettings(ctx, 100)
+	settings200 = manager.GetSettings(ctx, 200)
 
 	if settings100.AlwaysRespondToMentions {
 		t.Error("Chat 100: expected AlwaysRespondToMentions to be false")
@@ -300,3 +460,215 @@ func TestManagerIndependentChats(t *testing.T) {
 		t.Error("Chat 200: expected AlwaysRespondToMentions to be true (unchanged)")
 	}
 }
+
+// stallingStore holds SaveChatSettings for one chat until released
+type stallingStore struct {
+	storage.Storage
+	chatID  int64
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *stallingStore) SaveChatSettings(ctx context.Context, chatID int64, settings *storage.ChatSettings) error {
+	if chatID == s.chatID {
+		close(s.entered)
+		<-s.release
+	}
+	return s.Storage.SaveChatSettings(ctx, chatID, settings)
+}
+
+func TestManagerSlowSaveDoesNotBlockOtherChats(t *testing.T) {
+	ctx := context.Background()
+	store := &stallingStore{
+		Storage: storage.NewMockStorage(),
+		chatID:  100,
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	manager := NewManager(NewDefaultSettings(), store)
+	manager.SetFrequency(ctx, 200, 15)
+
+	saved := make(chan error)
+	go func() { saved <- manager.SetFrequency(ctx, 100, 5) }()
+	<-store.entered
+
+	// Chat 200 is read and changed while chat 100's save is stuck
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		manager.GetSettings(ctx, 200)
+		manager.GetSettings(ctx, 300)
+		manager.SetFrequency(ctx, 200, 20)
+		manager.GetAllChatSettings()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		close(store.release)
+		t.Fatal("Expected other chats not to wait for chat 100's save")
+	}
+
+	close(store.release)
+	if err := <-saved; err != nil {
+		t.Fatalf("SetFrequency failed: %v", err)
+	}
+	if got := manager.GetSettings(ctx, 100).ResponseFrequency; got != 5 {
+		t.Errorf("Expected frequency 5 once the save finished, got %d", got)
+	}
+	if got := manager.GetSettings(ctx, 200).ResponseFrequency; got != 20 {
+		t.Errorf("Expected frequency 20 for chat 200, got %d", got)
+	}
+}
+
+// newTestBackends returns every storage backend the manager should work with
+func newTestBackends(t *testing.T) map[string]func() storage.Storage {
+	ctx := context.Background()
+
+	dbPath := filepath.Join(t.TempDir(), "settings_test.db")
+
+	return map[string]func() storage.Storage{
+		"SQLite": func() storage.Storage {
+			store, err := storage.NewSQLiteStorage(dbPath)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			if err := store.Initialize(ctx); err != nil {
+				t.Fatalf("Failed to initialize storage: %v", err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+		"Mock": func() storage.Storage {
+			return storage.NewMockStorage()
+		},
+	}
+}
+
+func TestManagerPersistence(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newStore := range newTestBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			manager := NewManager(NewDefaultSettings(), store)
+
+			if err := manager.SetFrequency(ctx, 100, 7); err != nil {
+				t.Fatalf("SetFrequency failed: %v", err)
+			}
+			if _, err := manager.ToggleMentionResponse(ctx, 100); err != nil {
+				t.Fatalf("ToggleMentionResponse failed: %v", err)
+			}
+			if err := manager.SetSettings(ctx, 200, NewCustomSettings(3, true)); err != nil {
+				t.Fatalf("SetSettings failed: %v", err)
+			}
+
+			stored, err := store.GetChatSettings(ctx, 100)
+			if err != nil {
+				t.Fatalf("GetChatSettings failed: %v", err)
+			}
+			if stored == nil {
+				t.Fatal("Expected settings for chat 100 to be persisted")
+			}
+			if stored.ResponseFrequency != 7 {
+				t.Errorf("Expected stored frequency 7, got %d", stored.ResponseFrequency)
+			}
+			if stored.AlwaysRespondToMentions {
+				t.Error("Expected stored AlwaysRespondToMentions to be false")
+			}
+
+			// A fresh manager simulates a restart and must load from storage
+			restarted := NewManager(NewDefaultSettings(), store)
+
+			settings := restarted.GetSettings(ctx, 100)
+			if settings.ResponseFrequency != 7 {
+				t.Errorf("Expected frequency 7 after restart, got %d", settings.ResponseFrequency)
+			}
+			if settings.AlwaysRespondToMentions {
+				t.Error("Expected AlwaysRespondToMentions to be false after restart")
+			}
+
+			settings = restarted.GetSettings(ctx, 200)
+			if settings.ResponseFrequency != 3 {
+				t.Errorf("Expected frequency 3 after restart, got %d", settings.ResponseFrequency)
+			}
+		})
+	}
+}
+
+func TestManagerPersistence_Reset(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newStore := range newTestBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			manager := NewManager(NewDefaultSettings(), store)
+
+			if err := manager.SetFrequency(ctx, 100, 5); err != nil {
+				t.Fatalf("SetFrequency failed: %v", err)
+			}
+			if err := manager.ResetSettings(ctx, 100); err != nil {
+				t.Fatalf("ResetSettings failed: %v", err)
+			}
+
+			stored, err := store.GetChatSettings(ctx, 100)
+			if err != nil {
+				t.Fatalf("GetChatSettings failed: %v", err)
+			}
+			if stored != nil {
+				t.Error("Expected settings to be deleted from storage")
+			}
+
+			restarted := NewManager(NewDefaultSettings(), store)
+			settings := restarted.GetSettings(ctx, 100)
+			if settings.ResponseFrequency != 10 {
+				t.Errorf("Expected default frequency 10 after reset, got %d", settings.ResponseFrequency)
+			}
+		})
+	}
+}
+
+func TestManagerPersistence_LazyLoad(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newStore := range newTestBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+
+			// Settings written directly to storage, e.g. by a previous run
+			err := store.SaveChatSettings(ctx, 100, &storage.ChatSettings{
+				ChatID:                  100,
+				ResponseFrequency:       42,
+				AlwaysRespondToMentions: true,
+			})
+			if err != nil {
+				t.Fatalf("SaveChatSettings failed: %v", err)
+			}
+
+			manager := NewManager(NewDefaultSettings(), store)
+			if len(manager.GetAllChatSettings()) != 0 {
+				t.Error("Expected no settings to be cached before first access")
+			}
+
+			settings := manager.GetSettings(ctx, 100)
+			if settings.ResponseFrequency != 42 {
+				t.Errorf("Expected frequency 42, got %d", settings.ResponseFrequency)
+			}
+
+			// Updating one field must preserve the stored value of the others
+			if err := manager.SetFrequency(ctx, 100, 43); err != nil {
+				t.Fatalf("SetFrequency failed: %v", err)
+			}
+			if !manager.GetSettings(ctx, 100).AlwaysRespondToMentions {
+				t.Error("Expected stored AlwaysRespondToMentions to be preserved")
+			}
+
+			// Chats without stored settings fall back to defaults
+			if manager.GetSettings(ctx, 999).ResponseFrequency != 10 {
+				t.Error("Expected defaults for chat without stored settings")
+			}
+			if len(manager.GetAllChatSettings()) != 1 {
+				t.Errorf("Expected 1 custom setting, got %d", len(manager.GetAllChatSettings()))
+			}
+		})
+	}
+}
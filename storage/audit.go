@@ -0,0 +1,22 @@
+package storage
+
+import "database/sql"
+
+// auditColumns is the column list every audit log query selects, in the
+// order scanAuditEntries reads them
+const auditColumns = `id, chat_id, actor_id, action, old_value, new_value, timestamp`
+
+// scanAuditEntries reads rows selected with auditColumns
+func scanAuditEntries(rows *sql.Rows) ([]*AuditEntry, error) {
+	var entries []*AuditEntry
+	for rows.Next() {
+		entry := &AuditEntry{}
+		err := rows.Scan(&entry.ID, &entry.ChatID, &entry.ActorID, &entry.Action,
+			&entry.OldValue, &entry.NewValue, &entry.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// CounterBuffer wraps a Storage and batches message counter writes.
+// UpdateChatMessageCount and UpdateUserMessageCount only record the latest
+// value in memory; Flush (called periodically by Run and on shutdown)
+// writes each changed counter once. All other calls go straight through.
+type CounterBuffer struct {
+	Storage
+
+	chats map[int64]int // Pending chat counts by chat ID
+	users map[int64]int // Pending user counts by user ID
+	mu    sync.Mutex
+
+	flushMu sync.Mutex // Serializes flushes so older values never overwrite newer ones
+
+	log *slog.Logger
+}
+
+// NewCounterBuffer creates a counter buffer in front of store
+func NewCounterBuffer(store Storage) *CounterBuffer {
+	return &CounterBuffer{
+		Storage: store,
+		chats:   make(map[int64]int),
+		users:   make(map[int64]int),
+		log:     slog.Default(),
+	}
+}
+
+// SetLogger sets where failed background flushes are logged
+func (b *CounterBuffer) SetLogger(logger *slog.Logger) {
+	b.log = logger
+}
+
+// UpdateChatMessageCount buffers a chat's message count until the next flush
+func (b *CounterBuffer) UpdateChatMessageCount(ctx context.Context, chatID int64, count int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.chats[chatID] = count
+	return nil
+}
+
+// UpdateUserMessageCount buffers a user's message count until the next flush
+func (b *CounterBuffer) UpdateUserMessageCount(ctx context.Context, userID int64, count int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.users[userID] = count
+	return nil
+}
+
+// GetChat returns the stored chat with any buffered count applied
+func (b *CounterBuffer) GetChat(ctx context.Context, chatID int64) (*Chat, error) {
+	chat, err := b.Storage.GetChat(ctx, chatID)
+	if err != nil || chat == nil {
+		return chat, err
+	}
+
+	b.mu.Lock()
+	if count, ok := b.chats[chatID]; ok {
+		chat.MessageCount = count
+	}
+	b.mu.Unlock()
+	return chat, nil
+}
+
+// GetUser returns the stored user with any buffered count applied
+func (b *CounterBuffer) GetUser(ctx context.Context, userID int64) (*User, error) {
+	user, err := b.Storage.GetUser(ctx, userID)
+	if err != nil || user == nil {
+		return user, err
+	}
+
+	b.mu.Lock()
+	if count, ok := b.users[userID]; ok {
+		user.MessageCount = count
+	}
+	b.mu.Unlock()
+	return user, nil
+}
+
+// Pending returns the number of counters waiting to be written
+func (b *CounterBuffer) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.chats) + len(b.users)
+}
+
+// Flush writes all buffered counters. Counters that fail to write stay
+// buffered for the next flush unless a newer value has arrived meanwhile.
+func (b *CounterBuffer) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	chats, users := b.chats, b.users
+	b.chats = make(map[int64]int)
+	b.users = make(map[int64]int)
+	b.mu.Unlock()
+
+	var firstErr error
+	failedChats := make(map[int64]int)
+	for chatID, count := range chats {
+		if err := b.Storage.UpdateChatMessageCount(ctx, chatID, count); err != nil {
+			failedChats[chatID] = count
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to write message count for chat %d: %w", chatID, err)
+			}
+		}
+	}
+
+	failedUsers := make(map[int64]int)
+	for userID, count := range users {
+		if err := b.Storage.UpdateUserMessageCount(ctx, userID, count); err != nil {
+			failedUsers[userID] = count
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to write message count for user %d: %w", userID, err)
+			}
+		}
+	}
+
+	if len(failedChats) > 0 || len(failedUsers) > 0 {
+		b.mu.Lock()
+		for chatID, count := range failedChats {
+			if _, newer := b.chats[chatID]; !newer {
+				b.chats[chatID] = count
+			}
+		}
+		for userID, count := range failedUsers {
+			if _, newer := b.users[userID]; !newer {
+				b.users[userID] = count
+			}
+		}
+		b.mu.Unlock()
+	}
+
+	return firstErr
+}
+
+// Run flushes buffered counters every interval until ctx is cancelled.
+// The final flush on shutdown is left to the caller.
+func (b *CounterBuffer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.Flush(ctx); err != nil {
+				b.log.Warn("Failed to flush message counters", "error", err)
+			}
+		}
+	}
+}
+
+// Close flushes buffered counters and closes the underlying storage
+func (b *CounterBuffer) Close() error {
+	if err := b.Flush(context.Background()); err != nil {
+		b.log.Warn("Failed to flush message counters on close", "error", err)
+	}
+	return b.Storage.Close()
+}
@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/metrics"
+)
+
+// Instrumented wraps a Storage and records the latency and errors of every
+// operation, labelled with the method name. Initialize and Close go straight
+// through.
+type Instrumented struct {
+	Storage
+
+	latency *metrics.Histogram
+	errors  *metrics.Counter
+}
+
+// NewInstrumented registers storage metrics with reg and returns store
+// wrapped to record them
+func NewInstrumented(store Storage, reg *metrics.Registry) *Instrumented {
+	return &Instrumented{
+		Storage: store,
+		latency: reg.Histogram("howard_storage_operation_duration_seconds",
+			"Time taken by storage operations.", nil, "operation"),
+		errors: reg.Counter("howard_storage_errors_total",
+			"Storage operations that returned an error.", "operation"),
+	}
+}
+
+// observe records one operation that started at start
+func (s *Instrumented) observe(operation string, start time.Time, err error) {
+	s.latency.ObserveSince(start, operation)
+	if err != nil {
+		s.errors.Inc(operation)
+	}
+}
+
+func (s *Instrumented) Ping(ctx context.Context) error {
+	began := time.Now()
+	err := s.Storage.Ping(ctx)
+	s.observe("Ping", began, err)
+	return err
+}
+
+func (s *Instrumented) SaveChat(ctx context.Context, chat *Chat) error {
+	began := time.Now()
+	err := s.Storage.SaveChat(ctx, chat)
+	s.observe("SaveChat", began, err)
+	return err
+}
+
+func (s *Instrumented) GetChat(ctx context.Context, chatID int64) (*Chat, error) {
+	began := time.Now()
+	result, err := s.Storage.GetChat(ctx, chatID)
+	s.observe("GetChat", began, err)
+	return result, err
+}
+
+func (s *Instrumented) GetAllChats(ctx context.Context) ([]*Chat, error) {
+	began := time.Now()
+	result, err := s.Storage.GetAllChats(ctx)
+	s.observe("GetAllChats", began, err)
+	return result, err
+}
+
+func (s *Instrumented) UpdateChatMessageCount(ctx context.Context, chatID int64, count int) error {
+	began := time.Now()
+	err := s.Storage.UpdateChatMessageCount(ctx, chatID, count)
+	s.observe("UpdateChatMessageCount", began, err)
+	return err
+}
+
+func (s *Instrumented) SaveUser(ctx context.Context, user *User) error {
+	began := time.Now()
+	err := s.Storage.SaveUser(ctx, user)
+	s.observe("SaveUser", began, err)
+	return err
+}
+
+func (s *Instrumented) GetUser(ctx context.Context, userID int64) (*User, error) {
+	began := time.Now()
+	result, err := s.Storage.GetUser(ctx, userID)
+	s.observe("GetUser", began, err)
+	return result, err
+}
+
+func (s *Instrumented) GetAllUsers(ctx context.Context) ([]*User, error) {
+	began := time.Now()
+	result, err := s.Storage.GetAllUsers(ctx)
+	s.observe("GetAllUsers", began, err)
+	return result, err
+}
+
+func (s *Instrumented) GetChatUsers(ctx context.Context, chatID int64) ([]*User, error) {
+	began := time.Now()
+	result, err := s.Storage.GetChatUsers(ctx, chatID)
+	s.observe("GetChatUsers", began, err)
+	return result, err
+}
+
+func (s *Instrumented) UpdateUserMessageCount(ctx context.Context, userID int64, count int) error {
+	began := time.Now()
+	err := s.Storage.UpdateUserMessageCount(ctx, userID, count)
+	s.observe("UpdateUserMessageCount", began, err)
+	return err
+}
+
+func (s *Instrumented) SaveChatSettings(ctx context.Context, chatID int64, settings *ChatSettings) error {
+	began := time.Now()
+	err := s.Storage.SaveChatSettings(ctx, chatID, settings)
+	s.observe("SaveChatSettings", began, err)
+	return err
+}
+
+func (s *Instrumented) GetChatSettings(ctx context.Context, chatID int64) (*ChatSettings, error) {
+	began := time.Now()
+	result, err := s.Storage.GetChatSettings(ctx, chatID)
+	s.observe("GetChatSettings", began, err)
+	return result, err
+}
+
+func (s *Instrumented) DeleteChatSettings(ctx context.Context, chatID int64) error {
+	began := time.Now()
+	err := s.Storage.DeleteChatSettings(ctx, chatID)
+	s.observe("DeleteChatSettings", began, err)
+	return err
+}
+
+func (s *Instrumented) SaveMessage(ctx context.Context, msg *Message) error {
+	began := time.Now()
+	err := s.Storage.SaveMessage(ctx, msg)
+	s.observe("SaveMessage", began, err)
+	return err
+}
+
+func (s *Instrumented) GetRecentMessages(ctx context.Context, chatID int64, limit int) ([]*Message, error) {
+	began := time.Now()
+	result, err := s.Storage.GetRecentMessages(ctx, chatID, limit)
+	s.observe("GetRecentMessages", began, err)
+	return result, err
+}
+
+func (s *Instrumented) GetUserMessagesInChat(ctx context.Context, chatID int64, userID int64, limit int) ([]*Message, error) {
+	began := time.Now()
+	result, err := s.Storage.GetUserMessagesInChat(ctx, chatID, userID, limit)
+	s.observe("GetUserMessagesInChat", began, err)
+	return result, err
+}
+
+func (s *Instrumented) GetMessagesByTimeRange(ctx context.Context, chatID int64, start, end time.Time) ([]*Message, error) {
+	began := time.Now()
+	result, err := s.Storage.GetMessagesByTimeRange(ctx, chatID, start, end)
+	s.observe("GetMessagesByTimeRange", began, err)
+	return result, err
+}
+
+func (s *Instrumented) GetMessage(ctx context.Context, chatID int64, messageID int) (*Message, error) {
+	began := time.Now()
+	result, err := s.Storage.GetMessage(ctx, chatID, messageID)
+	s.observe("GetMessage", began, err)
+	return result, err
+}
+
+func (s *Instrumented) EditMessage(ctx context.Context, chatID int64, messageID int, text, caption string, editedAt time.Time) error {
+	began := time.Now()
+	err := s.Storage.EditMessage(ctx, chatID, messageID, text, caption, editedAt)
+	s.observe("EditMessage", began, err)
+	return err
+}
+
+func (s *Instrumented) GetMessageEdits(ctx context.Context, chatID int64, messageID int) ([]*MessageEdit, error) {
+	began := time.Now()
+	result, err := s.Storage.GetMessageEdits(ctx, chatID, messageID)
+	s.observe("GetMessageEdits", began, err)
+	return result, err
+}
+
+func (s *Instrumented) PruneMessages(ctx context.Context, chatID int64, before time.Time, keepLatest int, limit int) (int64, error) {
+	began := time.Now()
+	result, err := s.Storage.PruneMessages(ctx, chatID, before, keepLatest, limit)
+	s.observe("PruneMessages", began, err)
+	return result, err
+}
+
+func (s *Instrumented) Vacuum(ctx context.Context) error {
+	began := time.Now()
+	err := s.Storage.Vacuum(ctx)
+	s.observe("Vacuum", began, err)
+	return err
+}
+
+func (s *Instrumented) SearchMessages(ctx context.Context, chatID int64, query string, limit int) ([]*Message, error) {
+	began := time.Now()
+	result, err := s.Storage.SearchMessages(ctx, chatID, query, limit)
+	s.observe("SearchMessages", began, err)
+	return result, err
+}
+
+func (s *Instrumented) SaveChatRole(ctx context.Context, role *ChatRole) error {
+	began := time.Now()
+	err := s.Storage.SaveChatRole(ctx, role)
+	s.observe("SaveChatRole", began, err)
+	return err
+}
+
+func (s *Instrumented) GetChatRole(ctx context.Context, chatID int64, userID int64) (*ChatRole, error) {
+	began := time.Now()
+	result, err := s.Storage.GetChatRole(ctx, chatID, userID)
+	s.observe("GetChatRole", began, err)
+	return result, err
+}
+
+func (s *Instrumented) GetChatRoles(ctx context.Context, chatID int64) ([]*ChatRole, error) {
+	began := time.Now()
+	result, err := s.Storage.GetChatRoles(ctx, chatID)
+	s.observe("GetChatRoles", began, err)
+	return result, err
+}
+
+func (s *Instrumented) DeleteChatRole(ctx context.Context, chatID int64, userID int64) error {
+	began := time.Now()
+	err := s.Storage.DeleteChatRole(ctx, chatID, userID)
+	s.observe("DeleteChatRole", began, err)
+	return err
+}
+
+func (s *Instrumented) SaveAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	began := time.Now()
+	err := s.Storage.SaveAuditEntry(ctx, entry)
+	s.observe("SaveAuditEntry", began, err)
+	return err
+}
+
+func (s *Instrumented) GetAuditLog(ctx context.Context, chatID int64, limit int, offset int) ([]*AuditEntry, error) {
+	began := time.Now()
+	result, err := s.Storage.GetAuditLog(ctx, chatID, limit, offset)
+	s.observe("GetAuditLog", began, err)
+	return result, err
+}
+
+func (s *Instrumented) GetAuditLogBefore(ctx context.Context, chatID int64, beforeID int64, limit int) ([]*AuditEntry, error) {
+	began := time.Now()
+	result, err := s.Storage.GetAuditLogBefore(ctx, chatID, beforeID, limit)
+	s.observe("GetAuditLogBefore", began, err)
+	return result, err
+}
+
+func (s *Instrumented) SaveUserProfile(ctx context.Context, profile *UserProfile) error {
+	began := time.Now()
+	err := s.Storage.SaveUserProfile(ctx, profile)
+	s.observe("SaveUserProfile", began, err)
+	return err
+}
+
+func (s *Instrumented) GetUserProfile(ctx context.Context, chatID int64, userID int64) (*UserProfile, error) {
+	began := time.Now()
+	result, err := s.Storage.GetUserProfile(ctx, chatID, userID)
+	s.observe("GetUserProfile", began, err)
+	return result, err
+}
+
+func (s *Instrumented) UpdateUserProfile(ctx context.Context, chatID int64, userID int64, updates map[string]interface{}) error {
+	began := time.Now()
+	err := s.Storage.UpdateUserProfile(ctx, chatID, userID, updates)
+	s.observe("UpdateUserProfile", began, err)
+	return err
+}
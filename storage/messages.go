@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Message content types
+const (
+	ContentText      = "text"
+	ContentPhoto     = "photo"
+	ContentSticker   = "sticker"
+	ContentVoice     = "voice"
+	ContentDocument  = "document"
+	ContentVideo     = "video"
+	ContentAudio     = "audio"
+	ContentAnimation = "animation"
+	ContentVideoNote = "video_note"
+)
+
+// contentLabels name media types in message descriptions
+var contentLabels = map[string]string{
+	ContentPhoto:     "photo",
+	ContentSticker:   "sticker",
+	ContentVoice:     "voice message",
+	ContentDocument:  "document",
+	ContentVideo:     "video",
+	ContentAudio:     "audio",
+	ContentAnimation: "GIF",
+	ContentVideoNote: "video message",
+}
+
+// IsMedia reports whether the message carries a photo, sticker, file or
+// other attachment rather than plain text
+func (m *Message) IsMedia() bool {
+	return m.ContentType != "" && m.ContentType != ContentText
+}
+
+// Describe returns the message as it should read in chat history: the text
+// itself, or a label for media followed by the sticker emoji or caption,
+// e.g. "[photo] Look at this" or "[document: report.pdf]"
+func (m *Message) Describe() string {
+	if !m.IsMedia() {
+		return m.Text
+	}
+
+	label, ok := contentLabels[m.ContentType]
+	if !ok {
+		label = m.ContentType
+	}
+	if m.FileName != "" {
+		label += ": " + m.FileName
+	}
+
+	description := fmt.Sprintf("[%s]", label)
+	for _, part := range []string{m.Text, m.Caption} {
+		if part != "" {
+			description += " " + part
+		}
+	}
+	return description
+}
+
+// messageColumns is the column list every message query selects, in the
+// order scanMessages reads them
+const messageColumns = `id, chat_id, user_id, message_id, reply_to_message_id, text, is_bot, timestamp, edited_at,
+	content_type, caption, file_id, file_unique_id, file_name, file_size`
+
+// scanMessages reads rows selected with messageColumns
+func scanMessages(rows *sql.Rows) ([]*Message, error) {
+	var messages []*Message
+	for rows.Next() {
+		msg := &Message{}
+		var editedAt sql.NullTime
+		err := rows.Scan(&msg.ID, &msg.ChatID, &msg.UserID, &msg.MessageID, &msg.ReplyToMessageID,
+			&msg.Text, &msg.IsBot, &msg.Timestamp, &editedAt,
+			&msg.ContentType, &msg.Caption, &msg.FileID, &msg.FileUniqueID, &msg.FileName, &msg.FileSize)
+		if err != nil {
+			return nil, err
+		}
+		msg.EditedAt = editedAt.Time
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// scanMessageEdits reads chat_id, message_id, text, edited_at rows
+func scanMessageEdits(rows *sql.Rows) ([]*MessageEdit, error) {
+	var edits []*MessageEdit
+	for rows.Next() {
+		edit := &MessageEdit{}
+		var text sql.NullString
+		if err := rows.Scan(&edit.ChatID, &edit.MessageID, &text, &edit.Caption, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edit.Text = text.String
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// deleteOrphanEdits removes a chat's edit history for messages that are no
+// longer stored, e.g. after pruning. Format with the chat ID placeholder.
+const deleteOrphanEdits = `
+	DELETE FROM message_edits
+	WHERE chat_id = %s AND NOT EXISTS (
+		SELECT 1 FROM messages m
+		WHERE m.chat_id = message_edits.chat_id AND m.message_id = message_edits.message_id
+	)`
@@ -0,0 +1,491 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// version of the bot than the one running
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// Migration is one versioned schema change, applied in a transaction.
+// Each backend has its own statements; versions are shared.
+type Migration struct {
+	Version     int
+	Description string
+	SQLite      string // Statements for SQLiteStorage
+	Postgres    string // Statements for PostgresStorage
+}
+
+// Migrator is implemented by storage backends with a versioned schema
+type Migrator interface {
+	// SchemaVersion returns the applied version, 0 for an empty database
+	SchemaVersion(ctx context.Context) (int, error)
+	// PendingMigrations lists what Migrate would apply (dry run)
+	PendingMigrations(ctx context.Context) ([]Migration, error)
+	// Migrate applies all pending migrations in order
+	Migrate(ctx context.Context) error
+}
+
+// migrations lists every schema change in order.
+// Never edit a released migration; append a new one instead.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		SQLite: `
+	CREATE TABLE IF NOT EXISTS chats (
+		id INTEGER PRIMARY KEY,
+		title TEXT,
+		type TEXT,
+		message_count INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY,
+		username TEXT,
+		first_name TEXT,
+		last_name TEXT,
+		message_count INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS chat_settings (
+		chat_id INTEGER PRIMARY KEY,
+		response_frequency INTEGER DEFAULT 10,
+		always_respond_to_mentions BOOLEAN DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		text TEXT,
+		is_bot BOOLEAN DEFAULT 0,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (chat_id) REFERENCES chats(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
+	CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+
+	CREATE TABLE IF NOT EXISTS user_profiles (
+		chat_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		interests TEXT,
+		topics TEXT,
+		personality TEXT,
+		last_interaction DATETIME,
+		interaction_count INTEGER DEFAULT 0,
+		notes TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (chat_id, user_id),
+		FOREIGN KEY (chat_id) REFERENCES chats(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	`,
+		Postgres: `
+	-- Foreign keys are left out to match SQLite, which does not enforce
+	-- them: bot replies are stored without a users row for the bot itself
+	CREATE TABLE IF NOT EXISTS chats (
+		id BIGINT PRIMARY KEY,
+		title TEXT,
+		type TEXT,
+		message_count INTEGER DEFAULT 0,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id BIGINT PRIMARY KEY,
+		username TEXT,
+		first_name TEXT,
+		last_name TEXT,
+		message_count INTEGER DEFAULT 0,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS chat_settings (
+		chat_id BIGINT PRIMARY KEY,
+		response_frequency INTEGER DEFAULT 10,
+		always_respond_to_mentions BOOLEAN DEFAULT TRUE,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS messages (
+		id BIGSERIAL PRIMARY KEY,
+		chat_id BIGINT NOT NULL,
+		user_id BIGINT NOT NULL,
+		text TEXT,
+		is_bot BOOLEAN DEFAULT FALSE,
+		timestamp TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
+	CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+
+	CREATE TABLE IF NOT EXISTS user_profiles (
+		chat_id BIGINT NOT NULL,
+		user_id BIGINT NOT NULL,
+		interests TEXT,
+		topics TEXT,
+		personality TEXT,
+		last_interaction TIMESTAMPTZ,
+		interaction_count INTEGER DEFAULT 0,
+		notes TEXT,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (chat_id, user_id)
+	);
+	`,
+	},
+	{
+		Version:     2,
+		Description: "index messages by chat and time, and by chat and user",
+		SQLite: `
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_timestamp ON messages(chat_id, timestamp);
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_user ON messages(chat_id, user_id);
+	`,
+		Postgres: `
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_timestamp ON messages(chat_id, timestamp);
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_user ON messages(chat_id, user_id);
+	`,
+	},
+	{
+		Version:     3,
+		Description: "per-chat message retention settings",
+		SQLite: `
+	ALTER TABLE chat_settings ADD COLUMN retention_max_age_seconds INTEGER DEFAULT 0;
+	ALTER TABLE chat_settings ADD COLUMN retention_max_messages INTEGER DEFAULT 0;
+	`,
+		Postgres: `
+	ALTER TABLE chat_settings ADD COLUMN IF NOT EXISTS retention_max_age_seconds BIGINT DEFAULT 0;
+	ALTER TABLE chat_settings ADD COLUMN IF NOT EXISTS retention_max_messages INTEGER DEFAULT 0;
+	`,
+	},
+	{
+		Version:     4,
+		Description: "full-text search over message text",
+		// SQLite's FTS5 index depends on how the driver was built, so
+		// SQLiteStorage.Initialize manages it outside the migrations
+		SQLite: `
+	-- messages_fts is created by initSearchIndex when FTS5 is available
+	`,
+		Postgres: `
+	CREATE INDEX IF NOT EXISTS idx_messages_text_search ON messages USING GIN (to_tsvector('simple', coalesce(text, '')));
+	`,
+	},
+	{
+		Version:     5,
+		Description: "Telegram message IDs, replies and edit history",
+		SQLite: `
+	ALTER TABLE messages ADD COLUMN message_id INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN reply_to_message_id INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN edited_at DATETIME;
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_message ON messages(chat_id, message_id);
+
+	CREATE TABLE IF NOT EXISTS message_edits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER NOT NULL,
+		message_id INTEGER NOT NULL,
+		text TEXT,
+		edited_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits(chat_id, message_id);
+	`,
+		Postgres: `
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS message_id INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_message_id INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+	CREATE INDEX IF NOT EXISTS idx_messages_chat_message ON messages(chat_id, message_id);
+
+	CREATE TABLE IF NOT EXISTS message_edits (
+		id BIGSERIAL PRIMARY KEY,
+		chat_id BIGINT NOT NULL,
+		message_id INTEGER NOT NULL,
+		text TEXT,
+		edited_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits(chat_id, message_id);
+	`,
+	},
+	{
+		Version:     6,
+		Description: "media message fields and per-chat media counting",
+		SQLite: `
+	ALTER TABLE messages ADD COLUMN content_type TEXT NOT NULL DEFAULT 'text';
+	ALTER TABLE messages ADD COLUMN caption TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN file_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN file_unique_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN file_name TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN file_size INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE chat_settings ADD COLUMN ignore_media BOOLEAN DEFAULT 0;
+	`,
+		Postgres: `
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT 'text';
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS caption TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS file_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS file_unique_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS file_name TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN IF NOT EXISTS file_size BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE chat_settings ADD COLUMN IF NOT EXISTS ignore_media BOOLEAN DEFAULT FALSE;
+	`,
+	},
+	{
+		Version:     7,
+		Description: "per-chat bot roles",
+		SQLite: `
+	CREATE TABLE IF NOT EXISTS chat_roles (
+		chat_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		role TEXT NOT NULL,
+		granted_by INTEGER NOT NULL DEFAULT 0,
+		granted_at DATETIME NOT NULL,
+		PRIMARY KEY (chat_id, user_id)
+	);
+	`,
+		Postgres: `
+	CREATE TABLE IF NOT EXISTS chat_roles (
+		chat_id BIGINT NOT NULL,
+		user_id BIGINT NOT NULL,
+		role TEXT NOT NULL,
+		granted_by BIGINT NOT NULL DEFAULT 0,
+		granted_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (chat_id, user_id)
+	);
+	`,
+	},
+	{
+		Version:     8,
+		Description: "audit log of settings changes and admin actions",
+		SQLite: `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER NOT NULL,
+		actor_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		old_value TEXT NOT NULL DEFAULT '',
+		new_value TEXT NOT NULL DEFAULT '',
+		timestamp DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_chat ON audit_log(chat_id, id);
+	`,
+		Postgres: `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		chat_id BIGINT NOT NULL,
+		actor_id BIGINT NOT NULL,
+		action TEXT NOT NULL,
+		old_value TEXT NOT NULL DEFAULT '',
+		new_value TEXT NOT NULL DEFAULT '',
+		timestamp TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_chat ON audit_log(chat_id, id);
+	`,
+	},
+	{
+		Version:     9,
+		Description: "captions in the edit history of media messages",
+		SQLite: `
+	ALTER TABLE message_edits ADD COLUMN caption TEXT NOT NULL DEFAULT '';
+	`,
+		Postgres: `
+	ALTER TABLE message_edits ADD COLUMN IF NOT EXISTS caption TEXT NOT NULL DEFAULT '';
+	`,
+	},
+	{
+		Version:     10,
+		Description: "full-text search over media captions",
+		// Without its triggers, initSearchIndex recreates messages_fts with
+		// the caption column and rebuilds it from the messages table
+		SQLite: `
+	DROP TRIGGER IF EXISTS messages_fts_insert;
+	DROP TRIGGER IF EXISTS messages_fts_delete;
+	DROP TRIGGER IF EXISTS messages_fts_update;
+	`,
+		Postgres: `
+	DROP INDEX IF EXISTS idx_messages_text_search;
+	CREATE INDEX idx_messages_text_search ON messages USING GIN (to_tsvector('simple', coalesce(text, '') || ' ' || coalesce(caption, '')));
+	`,
+	},
+	{
+		Version:     11,
+		Description: "track which chat settings override the defaults",
+		// NULL, as existing rows get, means every setting is overridden
+		SQLite: `
+	ALTER TABLE chat_settings ADD COLUMN overridden TEXT;
+	`,
+		Postgres: `
+	ALTER TABLE chat_settings ADD COLUMN IF NOT EXISTS overridden TEXT;
+	`,
+	},
+}
+
+// LatestSchemaVersion returns the newest schema version this binary knows
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// dialect holds the migration SQL that differs between backends
+type dialect struct {
+	versionTableExists string
+	createVersionTable string
+	recordVersion      string
+	statements         func(m Migration) string
+}
+
+var sqliteDialect = dialect{
+	versionTableExists: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`,
+	createVersionTable: `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	recordVersion: `INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)`,
+	statements:    func(m Migration) string { return m.SQLite },
+}
+
+var postgresDialect = dialect{
+	versionTableExists: `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_version'`,
+	createVersionTable: `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT,
+		applied_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+	recordVersion: `INSERT INTO schema_version (version, description, applied_at) VALUES ($1, $2, $3)`,
+	statements:    func(m Migration) string { return m.Postgres },
+}
+
+// migrator applies migrations to a database/sql backend
+type migrator struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// schemaVersion returns the applied version without modifying the database
+func (m migrator) schemaVersion(ctx context.Context) (int, error) {
+	var tables int
+	if err := m.db.QueryRowContext(ctx, m.dialect.versionTableExists).Scan(&tables); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+
+	var version int
+	err := m.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// pending lists unapplied migrations, or ErrSchemaTooNew if the database is ahead
+func (m migrator) pending(ctx context.Context) ([]Migration, error) {
+	current, err := m.schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if latest := LatestSchemaVersion(); current > latest {
+		return nil, fmt.Errorf("%w: database is at version %d, binary supports up to %d", ErrSchemaTooNew, current, latest)
+	}
+
+	var pending []Migration
+	for _, mig := range migrations {
+		if mig.Version > current {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// migrateTo applies pending migrations up to and including target
+func (m migrator) migrateTo(ctx context.Context, target int) error {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return err
+	}
+
+	pending, err := m.pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range pending {
+		if mig.Version > target {
+			break
+		}
+		if err := m.apply(ctx, mig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply runs one migration and records it in the same transaction
+func (m migrator) apply(ctx context.Context, mig Migration) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %w", mig.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.dialect.statements(mig)); err != nil {
+		return fmt.Errorf("failed to apply migration %d (%s): %w", mig.Version, mig.Description, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, m.dialect.recordVersion, mig.Version, mig.Description, time.Now()); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", mig.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", mig.Version, err)
+	}
+	return nil
+}
+
+// ensureVersionTable creates the schema_version table if needed.
+// Databases created before migrations existed have the version 1 tables but
+// no schema_version; migration 1 only uses IF NOT EXISTS, so it is a no-op there.
+func (m migrator) ensureVersionTable(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, m.dialect.createVersionTable); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+	return nil
+}
+
+// migrator returns the migration runner for this database
+func (s *SQLiteStorage) migrator() migrator {
+	return migrator{db: s.db, dialect: sqliteDialect}
+}
+
+// SchemaVersion returns the version the database is migrated to, 0 if none.
+// It does not modify the database.
+func (s *SQLiteStorage) SchemaVersion(ctx context.Context) (int, error) {
+	return s.migrator().schemaVersion(ctx)
+}
+
+// PendingMigrations lists the migrations Migrate would apply, without applying them.
+// It returns ErrSchemaTooNew if the database is ahead of this binary.
+func (s *SQLiteStorage) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	return s.migrator().pending(ctx)
+}
+
+// Migrate applies all pending migrations in order
+func (s *SQLiteStorage) Migrate(ctx context.Context) error {
+	return s.migrator().migrateTo(ctx, LatestSchemaVersion())
+}
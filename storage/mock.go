@@ -1,18 +1,28 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-// MockStorage is an in-memory implementation for testing
+// MockStorage is an in-memory implementation for testing.
+// It mirrors SQLiteStorage semantics: upserts keep CreatedAt, results are
+// copies, and message queries are ordered by timestamp then ID.
 type MockStorage struct {
 	chats    map[int64]*Chat
 	users    map[int64]*User
 	settings map[int64]*ChatSettings
 	messages []*Message
+	edits    []*MessageEdit
+	lastID   int64                   // IDs are never reused, even after pruning
 	profiles map[string]*UserProfile // key: "chatID:userID"
+	roles    map[string]*ChatRole    // key: "chatID:userID"
+	audit    []*AuditEntry           // Oldest first
 	mu       sync.RWMutex
 }
 
@@ -24,36 +34,49 @@ func NewMockStorage() *MockStorage {
 		settings: make(map[int64]*ChatSettings),
 		messages: []*Message{},
 		profiles: make(map[string]*UserProfile),
+		roles:    make(map[string]*ChatRole),
 	}
 }
 
-func (m *MockStorage) Initialize() error { return nil }
-func (m *MockStorage) Close() error      { return nil }
+func (m *MockStorage) Initialize(ctx context.Context) error { return nil }
+func (m *MockStorage) Close() error                         { return nil }
+func (m *MockStorage) Ping(ctx context.Context) error       { return nil }
 
-func (m *MockStorage) SaveChat(chat *Chat) error {
+func (m *MockStorage) SaveChat(ctx context.Context, chat *Chat) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.chats[chat.ID] = chat
+	saved := *chat
+	if existing, ok := m.chats[chat.ID]; ok {
+		saved.CreatedAt = existing.CreatedAt
+	}
+	saved.UpdatedAt = time.Now()
+	m.chats[chat.ID] = &saved
 	return nil
 }
 
-func (m *MockStorage) GetChat(chatID int64) (*Chat, error) {
+func (m *MockStorage) GetChat(ctx context.Context, chatID int64) (*Chat, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.chats[chatID], nil
+	chat, ok := m.chats[chatID]
+	if !ok {
+		return nil, nil
+	}
+	c := *chat
+	return &c, nil
 }
 
-func (m *MockStorage) GetAllChats() ([]*Chat, error) {
+func (m *MockStorage) GetAllChats(ctx context.Context) ([]*Chat, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	chats := make([]*Chat, 0, len(m.chats))
 	for _, chat := range m.chats {
-		chats = append(chats, chat)
+		c := *chat
+		chats = append(chats, &c)
 	}
 	return chats, nil
 }
 
-func (m *MockStorage) UpdateChatMessageCount(chatID int64, count int) error {
+func (m *MockStorage) UpdateChatMessageCount(ctx context.Context, chatID int64, count int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if chat, ok := m.chats[chatID]; ok {
@@ -63,44 +86,62 @@ func (m *MockStorage) UpdateChatMessageCount(chatID int64, count int) error {
 	return nil
 }
 
-func (m *MockStorage) SaveUser(user *User) error {
+func (m *MockStorage) SaveUser(ctx context.Context, user *User) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.users[user.ID] = user
+	saved := *user
+	if existing, ok := m.users[user.ID]; ok {
+		saved.CreatedAt = existing.CreatedAt
+		saved.MessageCount = existing.MessageCount
+	}
+	saved.UpdatedAt = time.Now()
+	m.users[user.ID] = &saved
 	return nil
 }
 
-func (m *MockStorage) GetUser(userID int64) (*User, error) {
+func (m *MockStorage) GetUser(ctx context.Context, userID int64) (*User, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.users[userID], nil
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	u := *user
+	return &u, nil
 }
 
-func (m *MockStorage) GetAllUsers() ([]*User, error) {
+func (m *MockStorage) GetAllUsers(ctx context.Context) ([]*User, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	users := make([]*User, 0, len(m.users))
 	for _, user := range m.users {
-		users = append(users, user)
+		u := *user
+		users = append(users, &u)
 	}
 	return users, nil
 }
 
-func (m *MockStorage) GetChatUsers(chatID int64) ([]*User, error) {
+// GetChatUsers returns each user with a stored row who posted in the chat, ordered by username
+func (m *MockStorage) GetChatUsers(ctx context.Context, chatID int64) ([]*User, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	seen := make(map[int64]bool)
 	users := []*User{}
 	for _, msg := range m.messages {
-		if msg.ChatID == chatID {
-			if user, ok := m.users[msg.UserID]; ok {
-				users = append(users, user)
-			}
+		if msg.ChatID != chatID || seen[msg.UserID] {
+			continue
+		}
+		if user, ok := m.users[msg.UserID]; ok {
+			seen[msg.UserID] = true
+			u := *user
+			users = append(users, &u)
 		}
 	}
+	sort.SliceStable(users, func(i, j int) bool { return users[i].UserName < users[j].UserName })
 	return users, nil
 }
 
-func (m *MockStorage) UpdateUserMessageCount(userID int64, count int) error {
+func (m *MockStorage) UpdateUserMessageCount(ctx context.Context, userID int64, count int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if user, ok := m.users[userID]; ok {
@@ -110,93 +151,369 @@ func (m *MockStorage) UpdateUserMessageCount(userID int64, count int) error {
 	return nil
 }
 
-func (m *MockStorage) SaveChatSettings(chatID int64, settings *ChatSettings) error {
+func (m *MockStorage) SaveChatSettings(ctx context.Context, chatID int64, settings *ChatSettings) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.settings[chatID] = settings
+	saved := *settings
+	saved.ChatID = chatID
+	saved.Overridden = slices.Clone(settings.Overridden)
+	if existing, ok := m.settings[chatID]; ok {
+		saved.CreatedAt = existing.CreatedAt
+	}
+	saved.UpdatedAt = time.Now()
+	m.settings[chatID] = &saved
 	return nil
 }
 
-func (m *MockStorage) GetChatSettings(chatID int64) (*ChatSettings, error) {
+func (m *MockStorage) GetChatSettings(ctx context.Context, chatID int64) (*ChatSettings, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.settings[chatID], nil
+	settings, ok := m.settings[chatID]
+	if !ok {
+		return nil, nil
+	}
+	s := *settings
+	s.Overridden = slices.Clone(settings.Overridden)
+	return &s, nil
 }
 
-func (m *MockStorage) DeleteChatSettings(chatID int64) error {
+func (m *MockStorage) DeleteChatSettings(ctx context.Context, chatID int64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	delete(m.settings, chatID)
 	return nil
 }
 
-func (m *MockStorage) SaveMessage(msg *Message) error {
+func (m *MockStorage) SaveChatRole(ctx context.Context, role *ChatRole) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	msg.ID = int64(len(m.messages) + 1)
-	m.messages = append(m.messages, msg)
+	saved := *role
+	m.roles[profileKey(role.ChatID, role.UserID)] = &saved
 	return nil
 }
 
-func (m *MockStorage) GetRecentMessages(chatID int64, limit int) ([]*Message, error) {
+func (m *MockStorage) GetChatRole(ctx context.Context, chatID int64, userID int64) (*ChatRole, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	role, ok := m.roles[profileKey(chatID, userID)]
+	if !ok {
+		return nil, nil
+	}
+	r := *role
+	return &r, nil
+}
 
-	var messages []*Message
-	for i := len(m.messages) - 1; i >= 0 && len(messages) < limit; i-- {
-		if m.messages[i].ChatID == chatID {
-			messages = append([]*Message{m.messages[i]}, messages...)
+func (m *MockStorage) GetChatRoles(ctx context.Context, chatID int64) ([]*ChatRole, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var roles []*ChatRole
+	for _, role := range m.roles {
+		if role.ChatID == chatID {
+			r := *role
+			roles = append(roles, &r)
 		}
 	}
-	return messages, nil
+	sort.Slice(roles, func(i, j int) bool {
+		if !roles[i].GrantedAt.Equal(roles[j].GrantedAt) {
+			return roles[i].GrantedAt.Before(roles[j].GrantedAt)
+		}
+		return roles[i].UserID < roles[j].UserID
+	})
+	return roles, nil
 }
 
-func (m *MockStorage) GetUserMessagesInChat(chatID int64, userID int64, limit int) ([]*Message, error) {
+func (m *MockStorage) DeleteChatRole(ctx context.Context, chatID int64, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.roles, profileKey(chatID, userID))
+	return nil
+}
+
+func (m *MockStorage) SaveAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry.ID = int64(len(m.audit) + 1)
+	saved := *entry
+	m.audit = append(m.audit, &saved)
+	return nil
+}
+
+func (m *MockStorage) GetAuditLog(ctx context.Context, chatID int64, limit int, offset int) ([]*AuditEntry, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	var entries []*AuditEntry
+	for i := len(m.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := m.audit[i]
+		if chatID != 0 && entry.ChatID != chatID {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		e := *entry
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
 
-	var messages []*Message
-	for i := len(m.messages) - 1; i >= 0 && len(messages) < limit; i-- {
-		if m.messages[i].ChatID == chatID && m.messages[i].UserID == userID {
-			messages = append(messages, m.messages[i])
+func (m *MockStorage) GetAuditLogBefore(ctx context.Context, chatID int64, beforeID int64, limit int) ([]*AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []*AuditEntry
+	for i := len(m.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := m.audit[i]
+		if chatID != 0 && entry.ChatID != chatID {
+			continue
+		}
+		if beforeID != 0 && entry.ID >= beforeID {
+			continue
 		}
+		e := *entry
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
+
+func (m *MockStorage) SaveMessage(ctx context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastID++
+	msg.ID = m.lastID
+	saved := *msg
+	m.messages = append(m.messages, &saved)
+	return nil
+}
+
+func (m *MockStorage) GetRecentMessages(ctx context.Context, chatID int64, limit int) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	messages := m.filterMessages(func(msg *Message) bool { return msg.ChatID == chatID })
+	if limit >= 0 && len(messages) > limit {
+		messages = messages[len(messages)-limit:]
 	}
 	return messages, nil
 }
 
-func (m *MockStorage) GetMessagesByTimeRange(chatID int64, start, end time.Time) ([]*Message, error) {
+func (m *MockStorage) GetUserMessagesInChat(ctx context.Context, chatID int64, userID int64, limit int) ([]*Message, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	messages := m.filterMessages(func(msg *Message) bool {
+		return msg.ChatID == chatID && msg.UserID == userID
+	})
+
+	// Newest first, like SQLiteStorage
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	if limit >= 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (m *MockStorage) GetMessagesByTimeRange(ctx context.Context, chatID int64, start, end time.Time) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.filterMessages(func(msg *Message) bool {
+		return msg.ChatID == chatID && !msg.Timestamp.Before(start) && !msg.Timestamp.After(end)
+	}), nil
+}
+
+// filterMessages returns copies of matching messages, oldest first.
+// Callers must hold m.mu.
+func (m *MockStorage) filterMessages(match func(msg *Message) bool) []*Message {
 	var messages []*Message
 	for _, msg := range m.messages {
-		if msg.ChatID == chatID && !msg.Timestamp.Before(start) && !msg.Timestamp.After(end) {
-			messages = append(messages, msg)
+		if match(msg) {
+			c := *msg
+			messages = append(messages, &c)
 		}
 	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		if !messages[i].Timestamp.Equal(messages[j].Timestamp) {
+			return messages[i].Timestamp.Before(messages[j].Timestamp)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+	return messages
+}
+
+func (m *MockStorage) GetMessage(ctx context.Context, chatID int64, messageID int) (*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if msg := m.findMessage(chatID, messageID); msg != nil {
+		c := *msg
+		return &c, nil
+	}
+	return nil, nil
+}
+
+func (m *MockStorage) EditMessage(ctx context.Context, chatID int64, messageID int, text, caption string, editedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := m.findMessage(chatID, messageID)
+	if msg == nil {
+		return nil
+	}
+	if msg.Text != text || msg.Caption != caption {
+		m.edits = append(m.edits, &MessageEdit{ChatID: chatID, MessageID: messageID, Text: msg.Text, Caption: msg.Caption, EditedAt: editedAt})
+	}
+	msg.Text = text
+	msg.Caption = caption
+	msg.EditedAt = editedAt
+	return nil
+}
+
+func (m *MockStorage) GetMessageEdits(ctx context.Context, chatID int64, messageID int) ([]*MessageEdit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var edits []*MessageEdit
+	for _, edit := range m.edits {
+		if edit.ChatID == chatID && edit.MessageID == messageID {
+			c := *edit
+			edits = append(edits, &c)
+		}
+	}
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].EditedAt.Before(edits[j].EditedAt) })
+	return edits, nil
+}
+
+// findMessage returns the latest stored message with a Telegram ID.
+// Callers must hold the lock.
+func (m *MockStorage) findMessage(chatID int64, messageID int) *Message {
+	if messageID == 0 {
+		return nil
+	}
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if msg := m.messages[i]; msg.ChatID == chatID && msg.MessageID == messageID {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (m *MockStorage) PruneMessages(ctx context.Context, chatID int64, before time.Time, keepLatest int, limit int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if before.IsZero() && keepLatest <= 0 {
+		return 0, nil
+	}
+
+	chat := m.filterMessages(func(msg *Message) bool { return msg.ChatID == chatID })
+	doomed := make(map[int64]bool)
+	for i, msg := range chat {
+		if len(doomed) >= limit {
+			break
+		}
+		expired := !before.IsZero() && msg.Timestamp.Before(before)
+		excess := keepLatest > 0 && i < len(chat)-keepLatest
+		if expired || excess {
+			doomed[msg.ID] = true
+		}
+	}
+
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if !doomed[msg.ID] {
+			kept = append(kept, msg)
+		}
+	}
+	m.messages = kept
+
+	// Drop edit history of messages that are gone, like the SQL backends
+	stored := make(map[int]bool)
+	for _, msg := range m.messages {
+		if msg.ChatID == chatID {
+			stored[msg.MessageID] = true
+		}
+	}
+	keptEdits := m.edits[:0]
+	for _, edit := range m.edits {
+		if edit.ChatID != chatID || stored[edit.MessageID] {
+			keptEdits = append(keptEdits, edit)
+		}
+	}
+	m.edits = keptEdits
+	return int64(len(doomed)), nil
+}
+
+func (m *MockStorage) Vacuum(ctx context.Context) error { return nil }
+
+func (m *MockStorage) SearchMessages(ctx context.Context, chatID int64, query string, limit int) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	terms := SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	messages := m.filterMessages(func(msg *Message) bool {
+		if msg.ChatID != chatID || strings.HasPrefix(msg.Text, "/") {
+			return false
+		}
+		text := strings.ToLower(msg.Text + " " + msg.Caption)
+		for _, term := range terms {
+			if !strings.Contains(text, term) {
+				return false
+			}
+		}
+		return true
+	})
+
+	// Newest first, like SQLiteStorage
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	if limit >= 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
 	return messages, nil
 }
 
-func (m *MockStorage) SaveUserProfile(profile *UserProfile) error {
+func (m *MockStorage) SaveUserProfile(ctx context.Context, profile *UserProfile) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	key := profileKey(profile.ChatID, profile.UserID)
-	m.profiles[key] = profile
+	saved := *profile
+	if existing, ok := m.profiles[key]; ok {
+		saved.CreatedAt = existing.CreatedAt
+	}
+	saved.UpdatedAt = time.Now()
+	m.profiles[key] = &saved
 	return nil
 }
 
-func (m *MockStorage) GetUserProfile(chatID int64, userID int64) (*UserProfile, error) {
+func (m *MockStorage) GetUserProfile(ctx context.Context, chatID int64, userID int64) (*UserProfile, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	key := profileKey(chatID, userID)
-	return m.profiles[key], nil
+	profile, ok := m.profiles[profileKey(chatID, userID)]
+	if !ok {
+		return nil, nil
+	}
+	p := *profile
+	return &p, nil
 }
 
-func (m *MockStorage) UpdateUserProfile(chatID int64, userID int64, updates map[string]interface{}) error {
+func (m *MockStorage) UpdateUserProfile(ctx context.Context, chatID int64, userID int64, updates map[string]interface{}) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	for k := range updates {
+		if !profileColumns[k] {
+			return fmt.Errorf("unknown profile field: %s", k)
+		}
+	}
+
 	key := profileKey(chatID, userID)
-	if profile, ok := m.profiles[key]; ok {
+	if profile, ok := m.profiles[key]; ok && len(updates) > 0 {
 		for k, v := range updates {
 			switch k {
 			case "interests":
@@ -209,6 +526,8 @@ func (m *MockStorage) UpdateUserProfile(chatID int64, userID int64, updates map[
 				profile.Notes = v.(string)
 			case "interaction_count":
 				profile.InteractionCount = v.(int)
+			case "last_interaction":
+				profile.LastInteraction = v.(time.Time)
 			}
 		}
 		profile.UpdatedAt = time.Now()
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pruneQuery builds the DELETE used by PruneMessages. placeholder returns the
+// backend's bind parameter for the nth argument. It returns an empty query
+// when neither limit applies.
+func pruneQuery(chatID int64, before time.Time, keepLatest int, limit int, placeholder func(n int) string) (string, []interface{}) {
+	args := []interface{}{chatID}
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		return placeholder(len(args))
+	}
+
+	var conditions []string
+	if !before.IsZero() {
+		conditions = append(conditions, "timestamp < "+bind(before))
+	}
+	if keepLatest > 0 {
+		conditions = append(conditions, fmt.Sprintf(
+			"id NOT IN (SELECT id FROM messages WHERE chat_id = %s ORDER BY timestamp DESC, id DESC LIMIT %s)",
+			bind(chatID), bind(keepLatest)))
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	// Delete through a bounded subquery so each batch is a short transaction
+	query := fmt.Sprintf(`
+	DELETE FROM messages WHERE id IN (
+		SELECT id FROM messages
+		WHERE chat_id = %s AND (%s)
+		ORDER BY timestamp ASC, id ASC
+		LIMIT %s
+	)`, placeholder(1), strings.Join(conditions, " OR "), bind(limit))
+
+	return query, args
+}
@@ -0,0 +1,27 @@
+package storage
+
+import "database/sql"
+
+// Chat roles
+const (
+	// RoleModerator may change a chat's settings without being a Telegram
+	// administrator
+	RoleModerator = "moderator"
+)
+
+// roleColumns is the column list every role query selects, in the order
+// scanChatRoles reads them
+const roleColumns = `chat_id, user_id, role, granted_by, granted_at`
+
+// scanChatRoles reads rows selected with roleColumns
+func scanChatRoles(rows *sql.Rows) ([]*ChatRole, error) {
+	var roles []*ChatRole
+	for rows.Next() {
+		role := &ChatRole{}
+		if err := rows.Scan(&role.ChatID, &role.UserID, &role.Role, &role.GrantedBy, &role.GrantedAt); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
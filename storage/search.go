@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SearchTerms splits a search query into lowercase words. Every backend
+// matches messages containing all terms, each as a word prefix or substring.
+func SearchTerms(query string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return fields
+}
+
+// SQLite full-text index over messages.text and messages.caption. It is an
+// external-content FTS5 table kept in sync by triggers, so message rows are
+// stored only once.
+const (
+	createSearchIndex = `
+	CREATE VIRTUAL TABLE messages_fts USING fts5(text, caption, content='messages', content_rowid='id');
+
+	CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts(rowid, text, caption) VALUES (new.id, new.text, new.caption);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, text, caption) VALUES ('delete', old.id, old.text, old.caption);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF text, caption ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, text, caption) VALUES ('delete', old.id, old.text, old.caption);
+		INSERT INTO messages_fts(rowid, text, caption) VALUES (new.id, new.text, new.caption);
+	END;
+	`
+
+	dropSearchTriggers = `
+	DROP TRIGGER IF EXISTS messages_fts_insert;
+	DROP TRIGGER IF EXISTS messages_fts_delete;
+	DROP TRIGGER IF EXISTS messages_fts_update;
+	`
+)
+
+// initSearchIndex creates and backfills the FTS5 index when SQLite was built
+// with it (go build -tags sqlite_fts5). Otherwise searches use LIKE.
+func (s *SQLiteStorage) initSearchIndex(ctx context.Context) error {
+	var available bool
+	if err := s.db.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&available); err != nil {
+		return fmt.Errorf("failed to detect FTS5: %w", err)
+	}
+
+	if !available {
+		// Triggers left by an FTS5 build would make every insert fail here;
+		// the stale index is rebuilt when an FTS5 build next starts
+		if _, err := s.db.ExecContext(ctx, dropSearchTriggers); err != nil {
+			return fmt.Errorf("failed to drop search triggers: %w", err)
+		}
+		s.log.Warn("SQLite built without FTS5 (use -tags sqlite_fts5); search falls back to substring matching")
+		return nil
+	}
+
+	var triggers int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'messages_fts_%'`).Scan(&triggers)
+	if err != nil {
+		return fmt.Errorf("failed to inspect search index: %w", err)
+	}
+
+	// Missing triggers mean the index is new, missed writes or has an older
+	// set of columns (migrations drop the triggers to force this): rebuild it
+	if triggers < 3 {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(ctx, `DROP TABLE IF EXISTS messages_fts`); err != nil {
+			return fmt.Errorf("failed to drop stale search index: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, createSearchIndex); err != nil {
+			return fmt.Errorf("failed to create search index: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("failed to backfill search index: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	s.fullText = true
+	return nil
+}
+
+// SearchMessages returns a chat's messages whose text or caption contains
+// every word of query, newest first, leaving out bot commands
+func (s *SQLiteStorage) SearchMessages(ctx context.Context, chatID int64, query string, limit int) ([]*Message, error) {
+	terms := SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var sqlQuery string
+	var args []interface{}
+	if s.fullText {
+		// Quote each term so user input is never parsed as FTS5 syntax; * matches
+		// prefixes. Each term may match either column.
+		quoted := make([]string, len(terms))
+		for i, term := range terms {
+			quoted[i] = `"` + term + `"*`
+		}
+		sqlQuery = `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?) AND chat_id = ? AND text NOT LIKE '/%'
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?`
+		args = []interface{}{strings.Join(quoted, " "), chatID, limit}
+	} else {
+		// Terms are letters and digits only, so they hold no LIKE wildcards
+		conditions := make([]string, len(terms))
+		args = []interface{}{chatID}
+		for i, term := range terms {
+			conditions[i] = `(text LIKE ? OR caption LIKE ?)`
+			args = append(args, "%"+term+"%", "%"+term+"%")
+		}
+		sqlQuery = fmt.Sprintf(`
+		SELECT %s
+		FROM messages
+		WHERE chat_id = ? AND text NOT LIKE '/%%' AND %s
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?`, messageColumns, strings.Join(conditions, " AND "))
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// overriddenColumn encodes ChatSettings.Overridden for the overridden column.
+// nil is stored as NULL, which every row saved before migration 11 has.
+func overriddenColumn(fields []string) sql.NullString {
+	if fields == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: strings.Join(fields, ","), Valid: true}
+}
+
+// parseOverridden decodes the overridden column
+func parseOverridden(column sql.NullString) []string {
+	if !column.Valid {
+		return nil
+	}
+	if column.String == "" {
+		return []string{}
+	}
+	return strings.Split(column.String, ",")
+}
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -10,7 +12,9 @@ import (
 
 // SQLiteStorage implements the Storage interface using SQLite
 type SQLiteStorage struct {
-	db *sql.DB
+	db       *sql.DB
+	fullText bool // FTS5 index available; set by Initialize
+	log      *slog.Logger
 }
 
 // NewSQLiteStorage creates a new SQLite storage instance
@@ -20,76 +24,36 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	storage := &SQLiteStorage{db: db}
+	// SQLite allows a single writer; serialising connections avoids
+	// "database is locked" errors when handlers run concurrently
+	db.SetMaxOpenConns(1)
+
+	storage := &SQLiteStorage{db: db, log: slog.Default()}
 	return storage, nil
 }
 
-// Initialize creates all necessary tables
-func (s *SQLiteStorage) Initialize() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS chats (
-		id INTEGER PRIMARY KEY,
-		title TEXT,
-		type TEXT,
-		message_count INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY,
-		username TEXT,
-		first_name TEXT,
-		last_name TEXT,
-		message_count INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS chat_settings (
-		chat_id INTEGER PRIMARY KEY,
-		response_frequency INTEGER DEFAULT 10,
-		always_respond_to_mentions BOOLEAN DEFAULT 1,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		chat_id INTEGER NOT NULL,
-		user_id INTEGER NOT NULL,
-		text TEXT,
-		is_bot BOOLEAN DEFAULT 0,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (chat_id) REFERENCES chats(id),
-		FOREIGN KEY (user_id) REFERENCES users(id)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
-	CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
-
-	CREATE TABLE IF NOT EXISTS user_profiles (
-		chat_id INTEGER NOT NULL,
-		user_id INTEGER NOT NULL,
-		interests TEXT,
-		topics TEXT,
-		personality TEXT,
-		last_interaction DATETIME,
-		interaction_count INTEGER DEFAULT 0,
-		notes TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		PRIMARY KEY (chat_id, user_id),
-		FOREIGN KEY (chat_id) REFERENCES chats(id),
-		FOREIGN KEY (user_id) REFERENCES users(id)
-	);
-	`
+// SetLogger sets where warnings about the database are logged
+func (s *SQLiteStorage) SetLogger(logger *slog.Logger) {
+	s.log = logger
+}
 
-	_, err := s.db.Exec(schema)
-	if err != nil {
+// Initialize brings the schema up to date by applying pending migrations.
+// It returns ErrSchemaTooNew if the database was migrated by a newer binary.
+func (s *SQLiteStorage) Initialize(ctx context.Context) error {
+	// Let Vacuum reclaim space in small steps. This only takes effect before
+	// the first table is created, or after a full VACUUM.
+	if _, err := s.db.ExecContext(ctx, `PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+		return fmt.Errorf("failed to set auto_vacuum: %w", err)
+	}
+
+	if err := s.Migrate(ctx); err != nil {
 		return fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
+	if err := s.initSearchIndex(ctx); err != nil {
+		return fmt.Errorf("failed to initialize search index: %w", err)
+	}
+
 	return nil
 }
 
@@ -98,8 +62,15 @@ func (s *SQLiteStorage) Close() error {
 	return s.db.Close()
 }
 
+// Ping runs a trivial query; opening a SQLite connection alone does not
+// touch the database file
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	var one int
+	return s.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one)
+}
+
 // SaveChat saves or updates a chat
-func (s *SQLiteStorage) SaveChat(chat *Chat) error {
+func (s *SQLiteStorage) SaveChat(ctx context.Context, chat *Chat) error {
 	query := `
 	INSERT INTO chats (id, title, type, message_count, created_at, updated_at)
 	VALUES (?, ?, ?, ?, ?, ?)
@@ -110,7 +81,7 @@ func (s *SQLiteStorage) SaveChat(chat *Chat) error {
 		updated_at = excluded.updated_at
 	`
 
-	_, err := s.db.Exec(query,
+	_, err := s.db.ExecContext(ctx, query,
 		chat.ID, chat.Title, chat.Type, chat.MessageCount,
 		chat.CreatedAt, time.Now())
 
@@ -118,12 +89,12 @@ func (s *SQLiteStorage) SaveChat(chat *Chat) error {
 }
 
 // GetChat retrieves a chat by ID
-func (s *SQLiteStorage) GetChat(chatID int64) (*Chat, error) {
+func (s *SQLiteStorage) GetChat(ctx context.Context, chatID int64) (*Chat, error) {
 	query := `SELECT id, title, type, message_count, created_at, updated_at 
 	          FROM chats WHERE id = ?`
 
 	chat := &Chat{}
-	err := s.db.QueryRow(query, chatID).Scan(
+	err := s.db.QueryRowContext(ctx, query, chatID).Scan(
 		&chat.ID, &chat.Title, &chat.Type, &chat.MessageCount,
 		&chat.CreatedAt, &chat.UpdatedAt)
 
@@ -138,10 +109,10 @@ func (s *SQLiteStorage) GetChat(chatID int64) (*Chat, error) {
 }
 
 // GetAllChats retrieves all chats
-func (s *SQLiteStorage) GetAllChats() ([]*Chat, error) {
+func (s *SQLiteStorage) GetAllChats(ctx context.Context) ([]*Chat, error) {
 	query := `SELECT id, title, type, message_count, created_at, updated_at FROM chats`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -162,14 +133,14 @@ func (s *SQLiteStorage) GetAllChats() ([]*Chat, error) {
 }
 
 // UpdateChatMessageCount updates the message count for a chat
-func (s *SQLiteStorage) UpdateChatMessageCount(chatID int64, count int) error {
+func (s *SQLiteStorage) UpdateChatMessageCount(ctx context.Context, chatID int64, count int) error {
 	query := `UPDATE chats SET message_count = ?, updated_at = ? WHERE id = ?`
-	_, err := s.db.Exec(query, count, time.Now(), chatID)
+	_, err := s.db.ExecContext(ctx, query, count, time.Now(), chatID)
 	return err
 }
 
-// SaveUser saves or updates a user
-func (s *SQLiteStorage) SaveUser(user *User) error {
+// SaveUser saves or updates a user, leaving an existing user's message count alone
+func (s *SQLiteStorage) SaveUser(ctx context.Context, user *User) error {
 	query := `
 	INSERT INTO users (id, username, first_name, last_name, message_count, created_at, updated_at)
 	VALUES (?, ?, ?, ?, ?, ?, ?)
@@ -177,11 +148,10 @@ func (s *SQLiteStorage) SaveUser(user *User) error {
 		username = excluded.username,
 		first_name = excluded.first_name,
 		last_name = excluded.last_name,
-		message_count = excluded.message_count,
 		updated_at = excluded.updated_at
 	`
 
-	_, err := s.db.Exec(query,
+	_, err := s.db.ExecContext(ctx, query,
 		user.ID, user.UserName, user.FirstName, user.LastName,
 		user.MessageCount, user.CreatedAt, time.Now())
 
@@ -189,12 +159,12 @@ func (s *SQLiteStorage) SaveUser(user *User) error {
 }
 
 // GetUser retrieves a user by ID
-func (s *SQLiteStorage) GetUser(userID int64) (*User, error) {
+func (s *SQLiteStorage) GetUser(ctx context.Context, userID int64) (*User, error) {
 	query := `SELECT id, username, first_name, last_name, message_count, created_at, updated_at 
 	          FROM users WHERE id = ?`
 
 	user := &User{}
-	err := s.db.QueryRow(query, userID).Scan(
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
 		&user.ID, &user.UserName, &user.FirstName, &user.LastName,
 		&user.MessageCount, &user.CreatedAt, &user.UpdatedAt)
 
@@ -209,10 +179,10 @@ func (s *SQLiteStorage) GetUser(userID int64) (*User, error) {
 }
 
 // GetAllUsers retrieves all users
-func (s *SQLiteStorage) GetAllUsers() ([]*User, error) {
+func (s *SQLiteStorage) GetAllUsers(ctx context.Context) ([]*User, error) {
 	query := `SELECT id, username, first_name, last_name, message_count, created_at, updated_at FROM users`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -233,7 +203,7 @@ func (s *SQLiteStorage) GetAllUsers() ([]*User, error) {
 }
 
 // GetChatUsers retrieves all users who have participated in a chat
-func (s *SQLiteStorage) GetChatUsers(chatID int64) ([]*User, error) {
+func (s *SQLiteStorage) GetChatUsers(ctx context.Context, chatID int64) ([]*User, error) {
 	query := `
 	SELECT DISTINCT u.id, u.username, u.first_name, u.last_name, 
 	       u.message_count, u.created_at, u.updated_at
@@ -243,7 +213,7 @@ func (s *SQLiteStorage) GetChatUsers(chatID int64) ([]*User, error) {
 	ORDER BY u.username
 	`
 
-	rows, err := s.db.Query(query, chatID)
+	rows, err := s.db.QueryContext(ctx, query, chatID)
 	if err != nil {
 		return nil, err
 	}
@@ -264,39 +234,49 @@ func (s *SQLiteStorage) GetChatUsers(chatID int64) ([]*User, error) {
 }
 
 // UpdateUserMessageCount updates the message count for a user
-func (s *SQLiteStorage) UpdateUserMessageCount(userID int64, count int) error {
+func (s *SQLiteStorage) UpdateUserMessageCount(ctx context.Context, userID int64, count int) error {
 	query := `UPDATE users SET message_count = ?, updated_at = ? WHERE id = ?`
-	_, err := s.db.Exec(query, count, time.Now(), userID)
+	_, err := s.db.ExecContext(ctx, query, count, time.Now(), userID)
 	return err
 }
 
 // SaveChatSettings saves or updates chat settings
-func (s *SQLiteStorage) SaveChatSettings(chatID int64, settings *ChatSettings) error {
+func (s *SQLiteStorage) SaveChatSettings(ctx context.Context, chatID int64, settings *ChatSettings) error {
 	query := `
-	INSERT INTO chat_settings (chat_id, response_frequency, always_respond_to_mentions, created_at, updated_at)
-	VALUES (?, ?, ?, ?, ?)
+	INSERT INTO chat_settings (chat_id, response_frequency, always_respond_to_mentions,
+	                           retention_max_age_seconds, retention_max_messages, ignore_media, overridden, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(chat_id) DO UPDATE SET
 		response_frequency = excluded.response_frequency,
 		always_respond_to_mentions = excluded.always_respond_to_mentions,
+		retention_max_age_seconds = excluded.retention_max_age_seconds,
+		retention_max_messages = excluded.retention_max_messages,
+		ignore_media = excluded.ignore_media,
+		overridden = excluded.overridden,
 		updated_at = excluded.updated_at
 	`
 
-	_, err := s.db.Exec(query,
+	_, err := s.db.ExecContext(ctx, query,
 		chatID, settings.ResponseFrequency, settings.AlwaysRespondToMentions,
-		settings.CreatedAt, time.Now())
+		int64(settings.RetentionMaxAge/time.Second), settings.RetentionMaxMessages, settings.IgnoreMedia,
+		overriddenColumn(settings.Overridden), settings.CreatedAt, time.Now())
 
 	return err
 }
 
 // GetChatSettings retrieves settings for a chat
-func (s *SQLiteStorage) GetChatSettings(chatID int64) (*ChatSettings, error) {
-	query := `SELECT chat_id, response_frequency, always_respond_to_mentions, created_at, updated_at 
+func (s *SQLiteStorage) GetChatSettings(ctx context.Context, chatID int64) (*ChatSettings, error) {
+	query := `SELECT chat_id, response_frequency, always_respond_to_mentions,
+	                 retention_max_age_seconds, retention_max_messages, ignore_media, overridden, created_at, updated_at
 	          FROM chat_settings WHERE chat_id = ?`
 
 	settings := &ChatSettings{}
-	err := s.db.QueryRow(query, chatID).Scan(
+	var maxAgeSeconds int64
+	var overridden sql.NullString
+	err := s.db.QueryRowContext(ctx, query, chatID).Scan(
 		&settings.ChatID, &settings.ResponseFrequency, &settings.AlwaysRespondToMentions,
-		&settings.CreatedAt, &settings.UpdatedAt)
+		&maxAgeSeconds, &settings.RetentionMaxMessages, &settings.IgnoreMedia,
+		&overridden, &settings.CreatedAt, &settings.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -304,23 +284,137 @@ func (s *SQLiteStorage) GetChatSettings(chatID int64) (*ChatSettings, error) {
 	if err != nil {
 		return nil, err
 	}
+	settings.RetentionMaxAge = time.Duration(maxAgeSeconds) * time.Second
+	settings.Overridden = parseOverridden(overridden)
 
 	return settings, nil
 }
 
 // DeleteChatSettings deletes settings for a chat
-func (s *SQLiteStorage) DeleteChatSettings(chatID int64) error {
+func (s *SQLiteStorage) DeleteChatSettings(ctx context.Context, chatID int64) error {
 	query := `DELETE FROM chat_settings WHERE chat_id = ?`
-	_, err := s.db.Exec(query, chatID)
+	_, err := s.db.ExecContext(ctx, query, chatID)
 	return err
 }
 
-// SaveMessage saves a message to the database
-func (s *SQLiteStorage) SaveMessage(msg *Message) error {
-	query := `INSERT INTO messages (chat_id, user_id, text, is_bot, timestamp) 
-	          VALUES (?, ?, ?, ?, ?)`
+// SaveChatRole grants a user a role in a chat, replacing any previous role
+func (s *SQLiteStorage) SaveChatRole(ctx context.Context, role *ChatRole) error {
+	query := `
+	INSERT INTO chat_roles (chat_id, user_id, role, granted_by, granted_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(chat_id, user_id) DO UPDATE SET
+		role = excluded.role,
+		granted_by = excluded.granted_by,
+		granted_at = excluded.granted_at
+	`
+
+	_, err := s.db.ExecContext(ctx, query, role.ChatID, role.UserID, role.Role, role.GrantedBy, role.GrantedAt)
+	return err
+}
+
+// GetChatRole retrieves a user's role in a chat
+func (s *SQLiteStorage) GetChatRole(ctx context.Context, chatID int64, userID int64) (*ChatRole, error) {
+	query := `SELECT ` + roleColumns + ` FROM chat_roles WHERE chat_id = ? AND user_id = ?`
+
+	role := &ChatRole{}
+	err := s.db.QueryRowContext(ctx, query, chatID, userID).Scan(
+		&role.ChatID, &role.UserID, &role.Role, &role.GrantedBy, &role.GrantedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// GetChatRoles retrieves every role granted in a chat, oldest grant first
+func (s *SQLiteStorage) GetChatRoles(ctx context.Context, chatID int64) ([]*ChatRole, error) {
+	query := `SELECT ` + roleColumns + ` FROM chat_roles WHERE chat_id = ? ORDER BY granted_at ASC, user_id ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanChatRoles(rows)
+}
+
+// DeleteChatRole removes a user's role in a chat
+func (s *SQLiteStorage) DeleteChatRole(ctx context.Context, chatID int64, userID int64) error {
+	query := `DELETE FROM chat_roles WHERE chat_id = ? AND user_id = ?`
+	_, err := s.db.ExecContext(ctx, query, chatID, userID)
+	return err
+}
+
+// SaveAuditEntry appends an entry to the audit log
+func (s *SQLiteStorage) SaveAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	query := `INSERT INTO audit_log (chat_id, actor_id, action, old_value, new_value, timestamp)
+	          VALUES (?, ?, ?, ?, ?, ?)`
+
+	result, err := s.db.ExecContext(ctx, query, entry.ChatID, entry.ActorID, entry.Action,
+		entry.OldValue, entry.NewValue, entry.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err == nil {
+		entry.ID = id
+	}
+	return err
+}
+
+// GetAuditLog retrieves a page of audit entries, newest first
+func (s *SQLiteStorage) GetAuditLog(ctx context.Context, chatID int64, limit int, offset int) ([]*AuditEntry, error) {
+	query := `
+	SELECT ` + auditColumns + `
+	FROM audit_log
+	WHERE ? = 0 OR chat_id = ?
+	ORDER BY id DESC
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, chatID, chatID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAuditEntries(rows)
+}
+
+// GetAuditLogBefore retrieves audit entries older than beforeID, newest first
+func (s *SQLiteStorage) GetAuditLogBefore(ctx context.Context, chatID int64, beforeID int64, limit int) ([]*AuditEntry, error) {
+	query := `
+	SELECT ` + auditColumns + `
+	FROM audit_log
+	WHERE (? = 0 OR chat_id = ?) AND (? = 0 OR id < ?)
+	ORDER BY id DESC
+	LIMIT ?
+	`
 
-	result, err := s.db.Exec(query, msg.ChatID, msg.UserID, msg.Text, msg.IsBot, msg.Timestamp)
+	rows, err := s.db.QueryContext(ctx, query, chatID, chatID, beforeID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAuditEntries(rows)
+}
+
+// SaveMessage saves a message to the database
+func (s *SQLiteStorage) SaveMessage(ctx context.Context, msg *Message) error {
+	query := `INSERT INTO messages (chat_id, user_id, message_id, reply_to_message_id, text, is_bot, timestamp, edited_at,
+	                                content_type, caption, file_id, file_unique_id, file_name, file_size) 
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := s.db.ExecContext(ctx, query, msg.ChatID, msg.UserID, msg.MessageID, msg.ReplyToMessageID,
+		msg.Text, msg.IsBot, msg.Timestamp, nullTime(msg.EditedAt),
+		msg.ContentType, msg.Caption, msg.FileID, msg.FileUniqueID, msg.FileName, msg.FileSize)
 	if err != nil {
 		return err
 	}
@@ -334,29 +428,24 @@ func (s *SQLiteStorage) SaveMessage(msg *Message) error {
 }
 
 // GetRecentMessages retrieves recent messages from a chat (for AI context)
-func (s *SQLiteStorage) GetRecentMessages(chatID int64, limit int) ([]*Message, error) {
+func (s *SQLiteStorage) GetRecentMessages(ctx context.Context, chatID int64, limit int) ([]*Message, error) {
 	query := `
-	SELECT id, chat_id, user_id, text, is_bot, timestamp 
+	SELECT ` + messageColumns + ` 
 	FROM messages 
 	WHERE chat_id = ? 
-	ORDER BY timestamp DESC 
+	ORDER BY timestamp DESC, id DESC
 	LIMIT ?
 	`
 
-	rows, err := s.db.Query(query, chatID, limit)
+	rows, err := s.db.QueryContext(ctx, query, chatID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []*Message
-	for rows.Next() {
-		msg := &Message{}
-		err := rows.Scan(&msg.ID, &msg.ChatID, &msg.UserID, &msg.Text, &msg.IsBot, &msg.Timestamp)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, msg)
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
 	}
 
 	// Reverse to get chronological order
@@ -369,64 +458,166 @@ func (s *SQLiteStorage) GetRecentMessages(chatID int64, limit int) ([]*Message,
 }
 
 // GetUserMessagesInChat retrieves recent messages from a specific user in a chat
-func (s *SQLiteStorage) GetUserMessagesInChat(chatID int64, userID int64, limit int) ([]*Message, error) {
+func (s *SQLiteStorage) GetUserMessagesInChat(ctx context.Context, chatID int64, userID int64, limit int) ([]*Message, error) {
 	query := `
-	SELECT id, chat_id, user_id, text, is_bot, timestamp 
+	SELECT ` + messageColumns + ` 
 	FROM messages 
 	WHERE chat_id = ? AND user_id = ?
-	ORDER BY timestamp DESC 
+	ORDER BY timestamp DESC, id DESC
 	LIMIT ?
 	`
 
-	rows, err := s.db.Query(query, chatID, userID, limit)
+	rows, err := s.db.QueryContext(ctx, query, chatID, userID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []*Message
-	for rows.Next() {
-		msg := &Message{}
-		err := rows.Scan(&msg.ID, &msg.ChatID, &msg.UserID, &msg.Text, &msg.IsBot, &msg.Timestamp)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, msg)
-	}
-
-	return messages, nil
+	return scanMessages(rows)
 }
 
 // GetMessagesByTimeRange retrieves messages within a time range
-func (s *SQLiteStorage) GetMessagesByTimeRange(chatID int64, start, end time.Time) ([]*Message, error) {
+func (s *SQLiteStorage) GetMessagesByTimeRange(ctx context.Context, chatID int64, start, end time.Time) ([]*Message, error) {
 	query := `
-	SELECT id, chat_id, user_id, text, is_bot, timestamp 
+	SELECT ` + messageColumns + ` 
 	FROM messages 
 	WHERE chat_id = ? AND timestamp BETWEEN ? AND ?
-	ORDER BY timestamp ASC
+	ORDER BY timestamp ASC, id ASC
 	`
 
-	rows, err := s.db.Query(query, chatID, start, end)
+	rows, err := s.db.QueryContext(ctx, query, chatID, start, end)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []*Message
-	for rows.Next() {
-		msg := &Message{}
-		err := rows.Scan(&msg.ID, &msg.ChatID, &msg.UserID, &msg.Text, &msg.IsBot, &msg.Timestamp)
+	return scanMessages(rows)
+}
+
+// GetMessage retrieves a message by its Telegram message ID
+func (s *SQLiteStorage) GetMessage(ctx context.Context, chatID int64, messageID int) (*Message, error) {
+	if messageID == 0 {
+		return nil, nil
+	}
+
+	// A redelivered update can store the same message twice; use the latest
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE chat_id = ? AND message_id = ?
+	ORDER BY id DESC
+	LIMIT 1
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+// EditMessage replaces a message's text and caption and records the previous version
+func (s *SQLiteStorage) EditMessage(ctx context.Context, chatID int64, messageID int, text, caption string, editedAt time.Time) error {
+	if messageID == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var previous sql.NullString
+	var previousCaption string
+	err = tx.QueryRowContext(ctx, `SELECT id, text, caption FROM messages WHERE chat_id = ? AND message_id = ? ORDER BY id DESC LIMIT 1`,
+		chatID, messageID).Scan(&id, &previous, &previousCaption)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if previous.String != text || previousCaption != caption {
+		_, err = tx.ExecContext(ctx, `INSERT INTO message_edits (chat_id, message_id, text, caption, edited_at) VALUES (?, ?, ?, ?, ?)`,
+			chatID, messageID, previous.String, previousCaption, editedAt)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("failed to record edit: %w", err)
 		}
-		messages = append(messages, msg)
 	}
 
-	return messages, nil
+	if _, err := tx.ExecContext(ctx, `UPDATE messages SET text = ?, caption = ?, edited_at = ? WHERE id = ?`, text, caption, editedAt, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetMessageEdits retrieves a message's previous versions, oldest first
+func (s *SQLiteStorage) GetMessageEdits(ctx context.Context, chatID int64, messageID int) ([]*MessageEdit, error) {
+	query := `
+	SELECT chat_id, message_id, text, caption, edited_at
+	FROM message_edits
+	WHERE chat_id = ? AND message_id = ?
+	ORDER BY edited_at ASC, id ASC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessageEdits(rows)
+}
+
+// PruneMessages deletes up to limit of a chat's oldest messages outside its retention limits
+func (s *SQLiteStorage) PruneMessages(ctx context.Context, chatID int64, before time.Time, keepLatest int, limit int) (int64, error) {
+	query, args := pruneQuery(chatID, before, keepLatest, limit, func(int) string { return "?" })
+	if query == "" {
+		return 0, nil
+	}
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil || removed == 0 {
+		return removed, err
+	}
+
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(deleteOrphanEdits, "?"), chatID); err != nil {
+		return removed, fmt.Errorf("failed to delete edit history: %w", err)
+	}
+	return removed, nil
+}
+
+// Vacuum returns free pages to the operating system. It only has an effect
+// on databases with auto_vacuum = INCREMENTAL, which Initialize sets for new
+// databases; older ones need a one-off VACUUM to switch modes.
+func (s *SQLiteStorage) Vacuum(ctx context.Context) error {
+	// The pragma frees one page per step, so read it to completion
+	rows, err := s.db.QueryContext(ctx, `PRAGMA incremental_vacuum`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
 }
 
 // SaveUserProfile saves or updates a user profile
-func (s *SQLiteStorage) SaveUserProfile(profile *UserProfile) error {
+func (s *SQLiteStorage) SaveUserProfile(ctx context.Context, profile *UserProfile) error {
 	query := `
 	INSERT INTO user_profiles (chat_id, user_id, interests, topics, personality, 
 	                           last_interaction, interaction_count, notes, created_at, updated_at)
@@ -441,7 +632,7 @@ func (s *SQLiteStorage) SaveUserProfile(profile *UserProfile) error {
 		updated_at = excluded.updated_at
 	`
 
-	_, err := s.db.Exec(query,
+	_, err := s.db.ExecContext(ctx, query,
 		profile.ChatID, profile.UserID, profile.Interests, profile.Topics,
 		profile.Personality, profile.LastInteraction, profile.InteractionCount,
 		profile.Notes, profile.CreatedAt, time.Now())
@@ -450,7 +641,7 @@ func (s *SQLiteStorage) SaveUserProfile(profile *UserProfile) error {
 }
 
 // GetUserProfile retrieves a user profile for a specific chat
-func (s *SQLiteStorage) GetUserProfile(chatID int64, userID int64) (*UserProfile, error) {
+func (s *SQLiteStorage) GetUserProfile(ctx context.Context, chatID int64, userID int64) (*UserProfile, error) {
 	query := `
 	SELECT chat_id, user_id, interests, topics, personality, last_interaction, 
 	       interaction_count, notes, created_at, updated_at
@@ -459,7 +650,7 @@ func (s *SQLiteStorage) GetUserProfile(chatID int64, userID int64) (*UserProfile
 	`
 
 	profile := &UserProfile{}
-	err := s.db.QueryRow(query, chatID, userID).Scan(
+	err := s.db.QueryRowContext(ctx, query, chatID, userID).Scan(
 		&profile.ChatID, &profile.UserID, &profile.Interests, &profile.Topics,
 		&profile.Personality, &profile.LastInteraction, &profile.InteractionCount,
 		&profile.Notes, &profile.CreatedAt, &profile.UpdatedAt)
@@ -475,7 +666,7 @@ func (s *SQLiteStorage) GetUserProfile(chatID int64, userID int64) (*UserProfile
 }
 
 // UpdateUserProfile updates specific fields of a user profile
-func (s *SQLiteStorage) UpdateUserProfile(chatID int64, userID int64, updates map[string]interface{}) error {
+func (s *SQLiteStorage) UpdateUserProfile(ctx context.Context, chatID int64, userID int64, updates map[string]interface{}) error {
 	if len(updates) == 0 {
 		return nil
 	}
@@ -486,6 +677,9 @@ func (s *SQLiteStorage) UpdateUserProfile(chatID int64, userID int64, updates ma
 	first := true
 
 	for key, value := range updates {
+		if !profileColumns[key] {
+			return fmt.Errorf("unknown profile field: %s", key)
+		}
 		if !first {
 			query += ", "
 		}
@@ -497,6 +691,6 @@ func (s *SQLiteStorage) UpdateUserProfile(chatID int64, userID int64, updates ma
 	query += ", updated_at = ? WHERE chat_id = ? AND user_id = ?"
 	args = append(args, time.Now(), chatID, userID)
 
-	_, err := s.db.Exec(query, args...)
+	_, err := s.db.ExecContext(ctx, query, args...)
 	return err
 }
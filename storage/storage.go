@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"time"
 )
 
@@ -8,39 +11,111 @@ import (
 // This allows switching between SQLite, PostgreSQL, or other backends
 type Storage interface {
 	// Initialize sets up the storage (creates tables, connections, etc.)
-	Initialize() error
+	Initialize(ctx context.Context) error
 
 	// Close closes the storage connection
 	Close() error
 
+	// Ping checks that the storage answers a trivial query
+	Ping(ctx context.Context) error
+
 	// Chat operations
-	SaveChat(chat *Chat) error
-	GetChat(chatID int64) (*Chat, error)
-	GetAllChats() ([]*Chat, error)
-	UpdateChatMessageCount(chatID int64, count int) error
+	SaveChat(ctx context.Context, chat *Chat) error
+	GetChat(ctx context.Context, chatID int64) (*Chat, error)
+	GetAllChats(ctx context.Context) ([]*Chat, error)
+	UpdateChatMessageCount(ctx context.Context, chatID int64, count int) error
 
 	// User operations
-	SaveUser(user *User) error
-	GetUser(userID int64) (*User, error)
-	GetAllUsers() ([]*User, error)
-	GetChatUsers(chatID int64) ([]*User, error)
-	UpdateUserMessageCount(userID int64, count int) error
+	// SaveUser inserts a user or updates their name. An existing user's
+	// message count is only ever written by UpdateUserMessageCount, so a
+	// save cannot overwrite a newer buffered count.
+	SaveUser(ctx context.Context, user *User) error
+	GetUser(ctx context.Context, userID int64) (*User, error)
+	GetAllUsers(ctx context.Context) ([]*User, error)
+	GetChatUsers(ctx context.Context, chatID int64) ([]*User, error)
+	UpdateUserMessageCount(ctx context.Context, userID int64, count int) error
 
 	// Settings operations
-	SaveChatSettings(chatID int64, settings *ChatSettings) error
-	GetChatSettings(chatID int64) (*ChatSettings, error)
-	DeleteChatSettings(chatID int64) error
+	SaveChatSettings(ctx context.Context, chatID int64, settings *ChatSettings) error
+	GetChatSettings(ctx context.Context, chatID int64) (*ChatSettings, error)
+	DeleteChatSettings(ctx context.Context, chatID int64) error
 
 	// Message history operations (for AI context)
-	SaveMessage(msg *Message) error
-	GetRecentMessages(chatID int64, limit int) ([]*Message, error)
-	GetUserMessagesInChat(chatID int64, userID int64, limit int) ([]*Message, error)
-	GetMessagesByTimeRange(chatID int64, start, end time.Time) ([]*Message, error)
+	SaveMessage(ctx context.Context, msg *Message) error
+	GetRecentMessages(ctx context.Context, chatID int64, limit int) ([]*Message, error)
+	GetUserMessagesInChat(ctx context.Context, chatID int64, userID int64, limit int) ([]*Message, error)
+	GetMessagesByTimeRange(ctx context.Context, chatID int64, start, end time.Time) ([]*Message, error)
+	// GetMessage looks up a message by its Telegram message ID
+	GetMessage(ctx context.Context, chatID int64, messageID int) (*Message, error)
+	// EditMessage replaces a message's text and caption, keeping the previous
+	// ones in its edit history. Messages that were never stored are ignored.
+	EditMessage(ctx context.Context, chatID int64, messageID int, text, caption string, editedAt time.Time) error
+	// GetMessageEdits returns a message's previous versions, oldest first
+	GetMessageEdits(ctx context.Context, chatID int64, messageID int) ([]*MessageEdit, error)
+	// PruneMessages deletes up to limit of a chat's oldest messages that are
+	// older than before (ignored if zero) or not among the newest keepLatest
+	// (ignored if 0), and returns how many were deleted
+	PruneMessages(ctx context.Context, chatID int64, before time.Time, keepLatest int, limit int) (int64, error)
+	// Vacuum returns space freed by deleted rows to the operating system
+	Vacuum(ctx context.Context) error
+	// SearchMessages returns up to limit of a chat's messages containing every
+	// word of query (see SearchTerms), newest first. Bot commands (text
+	// starting with "/") are left out.
+	SearchMessages(ctx context.Context, chatID int64, query string, limit int) ([]*Message, error)
+
+	// Role operations (bot permissions granted within a chat)
+	// SaveChatRole grants a user a role in a chat, replacing any role they had
+	SaveChatRole(ctx context.Context, role *ChatRole) error
+	// GetChatRole returns a user's role in a chat, or nil if they have none
+	GetChatRole(ctx context.Context, chatID int64, userID int64) (*ChatRole, error)
+	// GetChatRoles returns every role granted in a chat, oldest grant first
+	GetChatRoles(ctx context.Context, chatID int64) ([]*ChatRole, error)
+	DeleteChatRole(ctx context.Context, chatID int64, userID int64) error
+
+	// Audit log operations
+	// SaveAuditEntry appends an entry to the audit log and sets its ID
+	SaveAuditEntry(ctx context.Context, entry *AuditEntry) error
+	// GetAuditLog returns up to limit of a chat's audit entries, newest
+	// first, after skipping the newest offset. Chat ID 0 returns entries
+	// from every chat.
+	GetAuditLog(ctx context.Context, chatID int64, limit int, offset int) ([]*AuditEntry, error)
+	// GetAuditLogBefore returns up to limit of a chat's audit entries with
+	// an ID below beforeID, newest first. Before ID 0 starts from the newest
+	// entry. Unlike offset paging it neither skips nor repeats entries when
+	// new ones are written between calls.
+	GetAuditLogBefore(ctx context.Context, chatID int64, beforeID int64, limit int) ([]*AuditEntry, error)
 
 	// User profile operations (for AI personalization)
-	SaveUserProfile(profile *UserProfile) error
-	GetUserProfile(chatID int64, userID int64) (*UserProfile, error)
-	UpdateUserProfile(chatID int64, userID int64, updates map[string]interface{}) error
+	SaveUserProfile(ctx context.Context, profile *UserProfile) error
+	GetUserProfile(ctx context.Context, chatID int64, userID int64) (*UserProfile, error)
+	UpdateUserProfile(ctx context.Context, chatID int64, userID int64, updates map[string]interface{}) error
+}
+
+// Flusher is implemented by storage backends that buffer writes.
+// Flush must persist everything buffered so far before returning.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Open creates the storage backend named by backend ("sqlite" or "postgres").
+// dsn is the SQLite file path or the PostgreSQL connection string. Warnings
+// go to logger, or the default logger if it is nil. Call Initialize before use.
+func Open(backend, dsn string, logger *slog.Logger) (Storage, error) {
+	switch backend {
+	case "sqlite":
+		store, err := NewSQLiteStorage(dsn)
+		if err != nil {
+			return nil, err
+		}
+		if logger != nil {
+			store.SetLogger(logger)
+		}
+		return store, nil
+	case "postgres":
+		return NewPostgresStorage(dsn)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
 }
 
 // Chat represents a Telegram chat
@@ -69,20 +144,72 @@ type ChatSettings struct {
 	ChatID                  int64
 	ResponseFrequency       int
 	AlwaysRespondToMentions bool
+	RetentionMaxAge         time.Duration // Messages older than this are pruned; 0 keeps them
+	RetentionMaxMessages    int           // Only this many recent messages are kept; 0 means no limit
+	IgnoreMedia             bool          // Media messages do not count toward ResponseFrequency
+	Overridden              []string      // Settings the chat changed itself, the rest follow the defaults; nil means all
 	CreatedAt               time.Time
 	UpdatedAt               time.Time
 }
 
-// Message represents a chat message (for AI context)
-type Message struct {
-	ID        int64
+// ChatRole is a bot-level role a user holds in one chat
+type ChatRole struct {
 	ChatID    int64
 	UserID    int64
-	Text      string
-	IsBot     bool
+	Role      string // One of the Role* constants
+	GrantedBy int64  // User who granted the role
+	GrantedAt time.Time
+}
+
+// AuditEntry records who changed a setting or performed an admin action
+type AuditEntry struct {
+	ID        int64
+	ChatID    int64
+	ActorID   int64  // User who performed the action
+	Action    string // Command name such as "setfrequency", or "denied"
+	OldValue  string // Value before the change; empty if not applicable
+	NewValue  string // Value after the change; empty if not applicable
 	Timestamp time.Time
 }
 
+// Message represents a chat message (for AI context)
+type Message struct {
+	ID               int64
+	ChatID           int64
+	UserID           int64
+	MessageID        int // Telegram message ID, unique within the chat; 0 if unknown
+	ReplyToMessageID int // Telegram ID of the message this one replies to; 0 if none
+	Text             string
+	IsBot            bool
+	Timestamp        time.Time // When Telegram says the message was sent
+	EditedAt         time.Time // Time of the latest edit; zero if never edited
+	ContentType      string    // One of the Content* constants
+	Caption          string    // Caption of a media message
+	FileID           string    // Telegram file ID of the media, for downloading or resending
+	FileUniqueID     string    // Stable file ID, the same for every bot
+	FileName         string    // Original name of a document, audio, video or animation
+	FileSize         int       // Size of the media in bytes, if Telegram reported it
+}
+
+// MessageEdit is a previous version of an edited message
+type MessageEdit struct {
+	ChatID    int64
+	MessageID int
+	Text      string    // Text before the edit
+	Caption   string    // Caption before the edit, for media messages
+	EditedAt  time.Time // When it was replaced
+}
+
+// profileColumns are the user_profiles columns UpdateUserProfile accepts
+var profileColumns = map[string]bool{
+	"interests":         true,
+	"topics":            true,
+	"personality":       true,
+	"last_interaction":  true,
+	"interaction_count": true,
+	"notes":             true,
+}
+
 // UserProfile stores AI-relevant information about a user in a specific chat
 type UserProfile struct {
 	ChatID int64
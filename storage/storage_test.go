@@ -1,12 +1,22 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/metrics"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 func TestSQLiteStorage(t *testing.T) {
+	ctx := context.Background()
+
 	// Create temporary database file
 	dbPath := "test_bot.db"
 	defer os.Remove(dbPath)
@@ -18,7 +28,7 @@ func TestSQLiteStorage(t *testing.T) {
 	}
 	defer storage.Close()
 
-	if err := storage.Initialize(); err != nil {
+	if err := storage.Initialize(ctx); err != nil {
 		t.Fatalf("Failed to initialize storage: %v", err)
 	}
 
@@ -33,12 +43,12 @@ func TestSQLiteStorage(t *testing.T) {
 		}
 
 		// Save chat
-		if err := storage.SaveChat(chat); err != nil {
+		if err := storage.SaveChat(ctx, chat); err != nil {
 			t.Errorf("Failed to save chat: %v", err)
 		}
 
 		// Get chat
-		retrieved, err := storage.GetChat(123)
+		retrieved, err := storage.GetChat(ctx, 123)
 		if err != nil {
 			t.Errorf("Failed to get chat: %v", err)
 		}
@@ -54,17 +64,17 @@ func TestSQLiteStorage(t *testing.T) {
 		}
 
 		// Update message count
-		if err := storage.UpdateChatMessageCount(123, 10); err != nil {
+		if err := storage.UpdateChatMessageCount(ctx, 123, 10); err != nil {
 			t.Errorf("Failed to update message count: %v", err)
 		}
 
-		updated, _ := storage.GetChat(123)
+		updated, _ := storage.GetChat(ctx, 123)
 		if updated.MessageCount != 10 {
 			t.Errorf("Expected message count 10, got %d", updated.MessageCount)
 		}
 
 		// Get all chats
-		chats, err := storage.GetAllChats()
+		chats, err := storage.GetAllChats(ctx)
 		if err != nil {
 			t.Errorf("Failed to get all chats: %v", err)
 		}
@@ -85,12 +95,12 @@ func TestSQLiteStorage(t *testing.T) {
 		}
 
 		// Save user
-		if err := storage.SaveUser(user); err != nil {
+		if err := storage.SaveUser(ctx, user); err != nil {
 			t.Errorf("Failed to save user: %v", err)
 		}
 
 		// Get user
-		retrieved, err := storage.GetUser(456)
+		retrieved, err := storage.GetUser(ctx, 456)
 		if err != nil {
 			t.Errorf("Failed to get user: %v", err)
 		}
@@ -106,17 +116,17 @@ func TestSQLiteStorage(t *testing.T) {
 		}
 
 		// Update message count
-		if err := storage.UpdateUserMessageCount(456, 7); err != nil {
+		if err := storage.UpdateUserMessageCount(ctx, 456, 7); err != nil {
 			t.Errorf("Failed to update user message count: %v", err)
 		}
 
-		updated, _ := storage.GetUser(456)
+		updated, _ := storage.GetUser(ctx, 456)
 		if updated.MessageCount != 7 {
 			t.Errorf("Expected message count 7, got %d", updated.MessageCount)
 		}
 
 		// Get all users
-		users, err := storage.GetAllUsers()
+		users, err := storage.GetAllUsers(ctx)
 		if err != nil {
 			t.Errorf("Failed to get all users: %v", err)
 		}
@@ -135,12 +145,12 @@ func TestSQLiteStorage(t *testing.T) {
 		}
 
 		// Save settings
-		if err := storage.SaveChatSettings(123, settings); err != nil {
+		if err := storage.SaveChatSettings(ctx, 123, settings); err != nil {
 			t.Errorf("Failed to save chat settings: %v", err)
 		}
 
 		// Get settings
-		retrieved, err := storage.GetChatSettings(123)
+		retrieved, err := storage.GetChatSettings(ctx, 123)
 		if err != nil {
 			t.Errorf("Failed to get chat settings: %v", err)
 		}
@@ -157,21 +167,21 @@ func TestSQLiteStorage(t *testing.T) {
 
 		// Update settings
 		settings.ResponseFrequency = 20
-		if err := storage.SaveChatSettings(123, settings); err != nil {
+		if err := storage.SaveChatSettings(ctx, 123, settings); err != nil {
 			t.Errorf("Failed to update chat settings: %v", err)
 		}
 
-		updated, _ := storage.GetChatSettings(123)
+		updated, _ := storage.GetChatSettings(ctx, 123)
 		if updated.ResponseFrequency != 20 {
 			t.Errorf("Expected frequency 20, got %d", updated.ResponseFrequency)
 		}
 
 		// Delete settings
-		if err := storage.DeleteChatSettings(123); err != nil {
+		if err := storage.DeleteChatSettings(ctx, 123); err != nil {
 			t.Errorf("Failed to delete chat settings: %v", err)
 		}
 
-		deleted, _ := storage.GetChatSettings(123)
+		deleted, _ := storage.GetChatSettings(ctx, 123)
 		if deleted != nil {
 			t.Error("Settings should be deleted")
 		}
@@ -211,7 +221,7 @@ func TestSQLiteStorage(t *testing.T) {
 		}
 
 		for _, msg := range messages {
-			if err := storage.SaveMessage(msg); err != nil {
+			if err := storage.SaveMessage(ctx, msg); err != nil {
 				t.Errorf("Failed to save message: %v", err)
 			}
 			if msg.ID == 0 {
@@ -220,7 +230,7 @@ func TestSQLiteStorage(t *testing.T) {
 		}
 
 		// Get recent messages
-		recent, err := storage.GetRecentMessages(123, 3)
+		recent, err := storage.GetRecentMessages(ctx, 123, 3)
 		if err != nil {
 			t.Errorf("Failed to get recent messages: %v", err)
 		}
@@ -236,7 +246,7 @@ func TestSQLiteStorage(t *testing.T) {
 		}
 
 		// Get user messages in chat
-		userMsgs, err := storage.GetUserMessagesInChat(123, 456, 10)
+		userMsgs, err := storage.GetUserMessagesInChat(ctx, 123, 456, 10)
 		if err != nil {
 			t.Errorf("Failed to get user messages: %v", err)
 		}
@@ -247,7 +257,7 @@ func TestSQLiteStorage(t *testing.T) {
 		// Get messages by time range
 		start := time.Now().Add(-4 * time.Minute)
 		end := time.Now()
-		rangeMessages, err := storage.GetMessagesByTimeRange(123, start, end)
+		rangeMessages, err := storage.GetMessagesByTimeRange(ctx, 123, start, end)
 		if err != nil {
 			t.Errorf("Failed to get messages by time range: %v", err)
 		}
@@ -271,12 +281,12 @@ func TestSQLiteStorage(t *testing.T) {
 		}
 
 		// Save profile
-		if err := storage.SaveUserProfile(profile); err != nil {
+		if err := storage.SaveUserProfile(ctx, profile); err != nil {
 			t.Errorf("Failed to save user profile: %v", err)
 		}
 
 		// Get profile
-		retrieved, err := storage.GetUserProfile(123, 456)
+		retrieved, err := storage.GetUserProfile(ctx, 123, 456)
 		if err != nil {
 			t.Errorf("Failed to get user profile: %v", err)
 		}
@@ -296,11 +306,11 @@ func TestSQLiteStorage(t *testing.T) {
 			"interaction_count": 10,
 			"notes":             "Very active",
 		}
-		if err := storage.UpdateUserProfile(123, 456, updates); err != nil {
+		if err := storage.UpdateUserProfile(ctx, 123, 456, updates); err != nil {
 			t.Errorf("Failed to update user profile: %v", err)
 		}
 
-		updated, _ := storage.GetUserProfile(123, 456)
+		updated, _ := storage.GetUserProfile(ctx, 123, 456)
 		if updated.InteractionCount != 10 {
 			t.Errorf("Expected interaction count 10, got %d", updated.InteractionCount)
 		}
@@ -319,10 +329,10 @@ func TestSQLiteStorage(t *testing.T) {
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
-		storage.SaveUser(user2)
+		storage.SaveUser(ctx, user2)
 
 		// Get chat users (should return users who have messages in the chat)
-		users, err := storage.GetChatUsers(123)
+		users, err := storage.GetChatUsers(ctx, 123)
 		if err != nil {
 			t.Errorf("Failed to get chat users: %v", err)
 		}
@@ -333,6 +343,8 @@ func TestSQLiteStorage(t *testing.T) {
 }
 
 func TestSQLiteStorageNonExistent(t *testing.T) {
+	ctx := context.Background()
+
 	dbPath := "test_nonexistent.db"
 	defer os.Remove(dbPath)
 
@@ -342,13 +354,13 @@ func TestSQLiteStorageNonExistent(t *testing.T) {
 	}
 	defer storage.Close()
 
-	if err := storage.Initialize(); err != nil {
+	if err := storage.Initialize(ctx); err != nil {
 		t.Fatalf("Failed to initialize storage: %v", err)
 	}
 
 	// Test getting non-existent records
 	t.Run("Non-existent Chat", func(t *testing.T) {
-		chat, err := storage.GetChat(999)
+		chat, err := storage.GetChat(ctx, 999)
 		if err != nil {
 			t.Errorf("Should not error on non-existent chat: %v", err)
 		}
@@ -358,7 +370,7 @@ func TestSQLiteStorageNonExistent(t *testing.T) {
 	})
 
 	t.Run("Non-existent User", func(t *testing.T) {
-		user, err := storage.GetUser(999)
+		user, err := storage.GetUser(ctx, 999)
 		if err != nil {
 			t.Errorf("Should not error on non-existent user: %v", err)
 		}
@@ -368,7 +380,7 @@ func TestSQLiteStorageNonExistent(t *testing.T) {
 	})
 
 	t.Run("Non-existent Settings", func(t *testing.T) {
-		settings, err := storage.GetChatSettings(999)
+		settings, err := storage.GetChatSettings(ctx, 999)
 		if err != nil {
 			t.Errorf("Should not error on non-existent settings: %v", err)
 		}
@@ -378,7 +390,7 @@ func TestSQLiteStorageNonExistent(t *testing.T) {
 	})
 
 	t.Run("Non-existent Profile", func(t *testing.T) {
-		profile, err := storage.GetUserProfile(999, 999)
+		profile, err := storage.GetUserProfile(ctx, 999, 999)
 		if err != nil {
 			t.Errorf("Should not error on non-existent profile: %v", err)
 		}
@@ -387,3 +399,652 @@ func TestSQLiteStorageNonExistent(t *testing.T) {
 		}
 	})
 }
+
+func TestSQLiteStorageCancelledContext(t *testing.T) {
+	dbPath := "test_cancelled.db"
+	defer os.Remove(dbPath)
+
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	if err := storage.Initialize(context.Background()); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A cancelled context must abort the query instead of running it
+	if err := storage.SaveChat(ctx, &Chat{ID: 1, Title: "Test"}); err == nil {
+		t.Error("Expected error when saving with a cancelled context")
+	}
+	if _, err := storage.GetRecentMessages(ctx, 1, 10); err == nil {
+		t.Error("Expected error when querying with a cancelled context")
+	}
+}
+
+// countingStorage counts counter writes reaching the wrapped storage
+type countingStorage struct {
+	Storage
+	writes int
+	fail   bool
+}
+
+func (c *countingStorage) UpdateChatMessageCount(ctx context.Context, chatID int64, count int) error {
+	if c.fail {
+		return fmt.Errorf("storage unavailable")
+	}
+	c.writes++
+	return c.Storage.UpdateChatMessageCount(ctx, chatID, count)
+}
+
+func (c *countingStorage) UpdateUserMessageCount(ctx context.Context, userID int64, count int) error {
+	if c.fail {
+		return fmt.Errorf("storage unavailable")
+	}
+	c.writes++
+	return c.Storage.UpdateUserMessageCount(ctx, userID, count)
+}
+
+func TestCounterBuffer(t *testing.T) {
+	ctx := context.Background()
+
+	inner := &countingStorage{Storage: NewMockStorage()}
+	inner.SaveChat(ctx, &Chat{ID: 1, Title: "Group", MessageCount: 10})
+	inner.SaveUser(ctx, &User{ID: 7, UserName: "alice", MessageCount: 3})
+
+	buffer := NewCounterBuffer(inner)
+
+	// Many updates collapse into one write per counter
+	for i := 11; i <= 50; i++ {
+		buffer.UpdateChatMessageCount(ctx, 1, i)
+	}
+	buffer.UpdateUserMessageCount(ctx, 7, 4)
+
+	if inner.writes != 0 {
+		t.Errorf("Expected no writes before flush, got %d", inner.writes)
+	}
+	if buffer.Pending() != 2 {
+		t.Errorf("Expected 2 pending counters, got %d", buffer.Pending())
+	}
+
+	// Reads see buffered values
+	chat, _ := buffer.GetChat(ctx, 1)
+	if chat.MessageCount != 50 {
+		t.Errorf("Expected buffered chat count 50, got %d", chat.MessageCount)
+	}
+
+	if err := buffer.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if inner.writes != 2 {
+		t.Errorf("Expected 2 writes after flush, got %d", inner.writes)
+	}
+	if buffer.Pending() != 0 {
+		t.Errorf("Expected nothing pending after flush, got %d", buffer.Pending())
+	}
+
+	stored, _ := inner.GetChat(ctx, 1)
+	if stored.MessageCount != 50 {
+		t.Errorf("Expected stored chat count 50, got %d", stored.MessageCount)
+	}
+	user, _ := inner.GetUser(ctx, 7)
+	if user.MessageCount != 4 {
+		t.Errorf("Expected stored user count 4, got %d", user.MessageCount)
+	}
+}
+
+func TestCounterBuffer_FailedFlushRetries(t *testing.T) {
+	ctx := context.Background()
+
+	inner := &countingStorage{Storage: NewMockStorage()}
+	inner.SaveChat(ctx, &Chat{ID: 1, Title: "Group"})
+	buffer := NewCounterBuffer(inner)
+
+	buffer.UpdateChatMessageCount(ctx, 1, 5)
+	inner.fail = true
+	if err := buffer.Flush(ctx); err == nil {
+		t.Fatal("Expected flush to fail")
+	}
+	if buffer.Pending() != 1 {
+		t.Errorf("Expected failed counter to stay pending, got %d", buffer.Pending())
+	}
+
+	inner.fail = false
+	if err := buffer.Flush(ctx); err != nil {
+		t.Fatalf("Retry flush failed: %v", err)
+	}
+	stored, _ := inner.GetChat(ctx, 1)
+	if stored.MessageCount != 5 {
+		t.Errorf("Expected stored count 5 after retry, got %d", stored.MessageCount)
+	}
+}
+
+func TestInstrumented(t *testing.T) {
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+	inner := &countingStorage{Storage: NewMockStorage()}
+	store := NewInstrumented(inner, reg)
+
+	store.SaveChat(ctx, &Chat{ID: 1, Title: "Group"})
+	if chat, err := store.GetChat(ctx, 1); err != nil || chat == nil {
+		t.Fatalf("Expected the chat through the wrapper, got %v (error %v)", chat, err)
+	}
+	inner.fail = true
+	if err := store.UpdateChatMessageCount(ctx, 1, 5); err == nil {
+		t.Fatal("Expected the inner error to be returned")
+	}
+
+	for _, op := range []string{"SaveChat", "GetChat", "UpdateChatMessageCount"} {
+		if n := store.latency.Count(op); n != 1 {
+			t.Errorf("Expected one %s timing, got %d", op, n)
+		}
+	}
+	if store.errors.Value("GetChat") != 0 || store.errors.Value("UpdateChatMessageCount") != 1 {
+		t.Errorf("Expected only the failed update to count as an error")
+	}
+
+	var out strings.Builder
+	reg.WriteTo(&out)
+	if !strings.Contains(out.String(), `howard_storage_errors_total{operation="UpdateChatMessageCount"} 1`) {
+		t.Errorf("Expected the error in the exposition, got:\n%s", out.String())
+	}
+}
+
+func TestCounterBuffer_SQLite(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "counters.db")
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+	store.SaveChat(ctx, &Chat{ID: -100, Title: "Group", Type: "supergroup"})
+
+	// Close flushes whatever is still buffered
+	buffer := NewCounterBuffer(store)
+	buffer.UpdateChatMessageCount(ctx, -100, 42)
+	if err := buffer.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	reopened, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer reopened.Close()
+
+	chat, err := reopened.GetChat(ctx, -100)
+	if err != nil || chat == nil {
+		t.Fatalf("Failed to get chat: %v", err)
+	}
+	if chat.MessageCount != 42 {
+		t.Errorf("Expected persisted count 42, got %d", chat.MessageCount)
+	}
+}
+
+func TestCounterBuffer_SaveUserKeepsCount(t *testing.T) {
+	ctx := context.Background()
+
+	sqlite, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer sqlite.Close()
+	if err := sqlite.Initialize(ctx); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	backends := map[string]Storage{"Mock": NewMockStorage(), "SQLite": sqlite}
+	for name, store := range backends {
+		t.Run(name, func(t *testing.T) {
+			buffer := NewCounterBuffer(store)
+			if err := buffer.SaveUser(ctx, &User{ID: 1, UserName: "alice", MessageCount: 1}); err != nil {
+				t.Fatalf("Failed to save user: %v", err)
+			}
+
+			// A rename saved while a newer count sits in the buffer must
+			// not reset the stored count, before or after the flush
+			buffer.UpdateUserMessageCount(ctx, 1, 10)
+			buffer.SaveUser(ctx, &User{ID: 1, UserName: "alice2", MessageCount: 3})
+			if err := buffer.Flush(ctx); err != nil {
+				t.Fatalf("Failed to flush: %v", err)
+			}
+			buffer.SaveUser(ctx, &User{ID: 1, UserName: "alice3", MessageCount: 3})
+
+			user, err := store.GetUser(ctx, 1)
+			if err != nil || user == nil {
+				t.Fatalf("Failed to get user: %v", err)
+			}
+			if user.MessageCount != 10 || user.UserName != "alice3" {
+				t.Errorf("Expected alice3 with the flushed count 10, got %s with %d", user.UserName, user.MessageCount)
+			}
+		})
+	}
+}
+
+func TestMigrations_Ordered(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Migration at index %d has version %d, expected %d", i, m.Version, i+1)
+		}
+		if m.SQLite == "" || m.Postgres == "" || m.Description == "" {
+			t.Errorf("Migration %d is missing SQL for a backend or a description", m.Version)
+		}
+	}
+}
+
+// openFixture creates a database from a SQL script in testdata
+func openFixture(t *testing.T, name string) (*SQLiteStorage, string) {
+	t.Helper()
+
+	script, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "fixture.db")
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if _, err := store.db.Exec(string(script)); err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	return store, dbPath
+}
+
+func TestMigrate_UpgradesV1Fixture(t *testing.T) {
+	ctx := context.Background()
+	store, _ := openFixture(t, "v1.sql")
+
+	// Dry run lists every migration without touching the database
+	pending, err := store.PendingMigrations(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list pending migrations: %v", err)
+	}
+	if len(pending) != len(migrations) {
+		t.Errorf("Expected %d pending migrations, got %d", len(migrations), len(pending))
+	}
+	if version, _ := store.SchemaVersion(ctx); version != 0 {
+		t.Errorf("Expected dry run to leave version 0, got %d", version)
+	}
+
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("Failed to migrate fixture: %v", err)
+	}
+
+	version, err := store.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read version: %v", err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("Expected version %d, got %d", LatestSchemaVersion(), version)
+	}
+
+	// Existing data survives the upgrade
+	chat, err := store.GetChat(ctx, -100123)
+	if err != nil || chat == nil {
+		t.Fatalf("Failed to get fixture chat: %v", err)
+	}
+	if chat.MessageCount != 57 {
+		t.Errorf("Expected message count 57, got %d", chat.MessageCount)
+	}
+	settings, _ := store.GetChatSettings(ctx, -100123)
+	if settings == nil || settings.ResponseFrequency != 5 || settings.AlwaysRespondToMentions {
+		t.Errorf("Fixture settings not preserved: %+v", settings)
+	}
+	messages, _ := store.GetRecentMessages(ctx, -100123, 10)
+	if len(messages) != 2 || messages[0].Text != "hello from the old schema" {
+		t.Errorf("Fixture messages not preserved: %+v", messages)
+	}
+	profile, err := store.GetUserProfile(ctx, -100123, 7)
+	if err != nil || profile == nil || profile.Interests != "chess" {
+		t.Errorf("Fixture profile not preserved: %+v", profile)
+	}
+
+	// Migration 2's indexes exist
+	var indexes int
+	store.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name IN ('idx_messages_chat_timestamp', 'idx_messages_chat_user')`).Scan(&indexes)
+	if indexes != 2 {
+		t.Errorf("Expected 2 new indexes, found %d", indexes)
+	}
+
+	// Running again is a no-op
+	if err := store.Initialize(ctx); err != nil {
+		t.Errorf("Second migration run failed: %v", err)
+	}
+	pending, _ = store.PendingMigrations(ctx)
+	if len(pending) != 0 {
+		t.Errorf("Expected no pending migrations, got %d", len(pending))
+	}
+}
+
+func TestMigrate_StepByStep(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "steps.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.migrator().migrateTo(ctx, 1); err != nil {
+		t.Fatalf("Failed to migrate to version 1: %v", err)
+	}
+	if version, _ := store.SchemaVersion(ctx); version != 1 {
+		t.Fatalf("Expected version 1, got %d", version)
+	}
+
+	pending, _ := store.PendingMigrations(ctx)
+	if len(pending) != LatestSchemaVersion()-1 {
+		t.Errorf("Expected %d pending migrations, got %d", LatestSchemaVersion()-1, len(pending))
+	}
+
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if version, _ := store.SchemaVersion(ctx); version != LatestSchemaVersion() {
+		t.Errorf("Expected version %d, got %d", LatestSchemaVersion(), version)
+	}
+}
+
+func TestMigrate_RefusesNewerSchema(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "newer.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("Failed to initialize: %v", err)
+	}
+
+	// Pretend a newer binary has migrated this database
+	future := LatestSchemaVersion() + 1
+	if _, err := store.db.Exec(`INSERT INTO schema_version (version, description) VALUES (?, 'from the future')`, future); err != nil {
+		t.Fatalf("Failed to record future version: %v", err)
+	}
+
+	err = store.Initialize(ctx)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew, got %v", err)
+	}
+	if _, err := store.PendingMigrations(ctx); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Expected dry run to report ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestMigrate_FailedMigrationRollsBack(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "broken.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.migrator().ensureVersionTable(ctx); err != nil {
+		t.Fatalf("Failed to create version table: %v", err)
+	}
+
+	broken := Migration{
+		Version:     1,
+		Description: "half applied",
+		SQLite:      `CREATE TABLE first (id INTEGER); CREATE TABLE broken (;`,
+	}
+	if err := store.migrator().apply(ctx, broken); err == nil {
+		t.Fatal("Expected broken migration to fail")
+	}
+
+	if version, _ := store.SchemaVersion(ctx); version != 0 {
+		t.Errorf("Expected version to stay 0, got %d", version)
+	}
+	var tables int
+	store.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'first'`).Scan(&tables)
+	if tables != 0 {
+		t.Error("Expected partial migration to be rolled back")
+	}
+}
+
+func TestSQLiteVacuum_ReclaimsPrunedPages(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	var mode int
+	store.db.QueryRow(`PRAGMA auto_vacuum`).Scan(&mode)
+	if mode != 2 {
+		t.Fatalf("Expected auto_vacuum INCREMENTAL (2) on a new database, got %d", mode)
+	}
+
+	text := strings.Repeat("x", 2000)
+	for i := 0; i < 200; i++ {
+		store.SaveMessage(ctx, &Message{ChatID: 1, UserID: 1, Text: text, Timestamp: time.Now()})
+	}
+	if _, err := store.PruneMessages(ctx, 1, time.Time{}, 1, 1000); err != nil {
+		t.Fatalf("Failed to prune messages: %v", err)
+	}
+
+	var free int
+	store.db.QueryRow(`PRAGMA freelist_count`).Scan(&free)
+	if free == 0 {
+		t.Fatal("Expected free pages after pruning")
+	}
+
+	if err := store.Vacuum(ctx); err != nil {
+		t.Fatalf("Failed to vacuum: %v", err)
+	}
+	store.db.QueryRow(`PRAGMA freelist_count`).Scan(&free)
+	if free != 0 {
+		t.Errorf("Expected incremental vacuum to release all free pages, %d left", free)
+	}
+}
+
+func TestSQLiteSearch_BackfillsExistingMessages(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "bot.db")
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// Simulate a database written without the index: no triggers, so these
+	// rows are not indexed yet
+	if _, err := store.db.Exec(dropSearchTriggers); err != nil {
+		t.Fatalf("Failed to drop triggers: %v", err)
+	}
+	store.SaveMessage(ctx, &Message{ChatID: 1, UserID: 1, Text: "the release is tomorrow", Timestamp: time.Now()})
+	store.SaveMessage(ctx, &Message{ChatID: 1, UserID: 1, Text: "lunch?", Timestamp: time.Now()})
+	store.Close()
+
+	store, err = NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	found, err := store.SearchMessages(ctx, 1, "release", 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(found) != 1 || found[0].Text != "the release is tomorrow" {
+		t.Errorf("Expected backfilled message to be found, got %v", found)
+	}
+
+	// New messages are indexed too
+	store.SaveMessage(ctx, &Message{ChatID: 1, UserID: 2, Text: "release notes are up", Timestamp: time.Now().Add(time.Second)})
+	found, _ = store.SearchMessages(ctx, 1, "release", 10)
+	if len(found) != 2 {
+		t.Errorf("Expected 2 matches after a new message, got %d", len(found))
+	}
+}
+
+func TestSQLiteSearch_RebuildsIndexForCaptions(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "bot.db")
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.migrator().migrateTo(ctx, 9); err != nil {
+		t.Fatalf("Failed to migrate to version 9: %v", err)
+	}
+
+	// Before migration 10 the index only covered the text column
+	var fts5 bool
+	store.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5)
+	if fts5 {
+		_, err := store.db.Exec(`
+		CREATE VIRTUAL TABLE messages_fts USING fts5(text, content='messages', content_rowid='id');
+		CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts(rowid, text) VALUES (new.id, new.text);
+		END;
+		CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+		END;
+		CREATE TRIGGER messages_fts_update AFTER UPDATE OF text ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+			INSERT INTO messages_fts(rowid, text) VALUES (new.id, new.text);
+		END;`)
+		if err != nil {
+			t.Fatalf("Failed to create the old search index: %v", err)
+		}
+	}
+	store.SaveMessage(ctx, &Message{ChatID: 1, UserID: 1, ContentType: ContentPhoto, Caption: "whiteboard from the retro", Timestamp: time.Now()})
+	store.Close()
+
+	store, err = NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	found, err := store.SearchMessages(ctx, 1, "whiteboard", 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(found) != 1 || found[0].Caption != "whiteboard from the retro" {
+		t.Errorf("Expected the existing caption to be found after the upgrade, got %v", found)
+	}
+}
+
+func TestMessageFromTelegram(t *testing.T) {
+	sentAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	base := tgbotapi.Message{
+		MessageID: 7,
+		Date:      int(sentAt.Unix()),
+		Chat:      &tgbotapi.Chat{ID: -100},
+		From:      &tgbotapi.User{ID: 1},
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(m *tgbotapi.Message)
+		expected Message
+	}{
+		{
+			name:     "Text",
+			setup:    func(m *tgbotapi.Message) { m.Text = "hello" },
+			expected: Message{ContentType: ContentText, Text: "hello"},
+		},
+		{
+			name: "Photo keeps the largest size",
+			setup: func(m *tgbotapi.Message) {
+				m.Photo = []tgbotapi.PhotoSize{{FileID: "small", FileSize: 100}, {FileID: "large", FileUniqueID: "u", FileSize: 9000}}
+				m.Caption = "sunset"
+			},
+			expected: Message{ContentType: ContentPhoto, Caption: "sunset", FileID: "large", FileUniqueID: "u", FileSize: 9000},
+		},
+		{
+			name:     "Sticker emoji becomes text",
+			setup:    func(m *tgbotapi.Message) { m.Sticker = &tgbotapi.Sticker{FileID: "st", Emoji: "😂"} },
+			expected: Message{ContentType: ContentSticker, Text: "😂", FileID: "st"},
+		},
+		{
+			name:     "Voice",
+			setup:    func(m *tgbotapi.Message) { m.Voice = &tgbotapi.Voice{FileID: "v", FileSize: 512} },
+			expected: Message{ContentType: ContentVoice, FileID: "v", FileSize: 512},
+		},
+		{
+			name: "Document",
+			setup: func(m *tgbotapi.Message) {
+				m.Document = &tgbotapi.Document{FileID: "d", FileName: "report.pdf", FileSize: 4096}
+			},
+			expected: Message{ContentType: ContentDocument, FileID: "d", FileName: "report.pdf", FileSize: 4096},
+		},
+		{
+			name: "Animation wins over its document",
+			setup: func(m *tgbotapi.Message) {
+				m.Animation = &tgbotapi.Animation{FileID: "a", FileName: "cat.mp4"}
+				m.Document = &tgbotapi.Document{FileID: "a", FileName: "cat.mp4"}
+			},
+			expected: Message{ContentType: ContentAnimation, FileID: "a", FileName: "cat.mp4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := base
+			tt.setup(&message)
+
+			got := MessageFromTelegram(&message)
+			if got.ChatID != -100 || got.UserID != 1 || got.MessageID != 7 || !got.Timestamp.Equal(sentAt) {
+				t.Errorf("Expected message 7 from user 1 in chat -100 at %v, got %+v", sentAt, got)
+			}
+
+			want := tt.expected
+			if got.ContentType != want.ContentType || got.Text != want.Text || got.Caption != want.Caption ||
+				got.FileID != want.FileID || got.FileUniqueID != want.FileUniqueID ||
+				got.FileName != want.FileName || got.FileSize != want.FileSize {
+				t.Errorf("Expected %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestMessageDescribe(t *testing.T) {
+	tests := []struct {
+		msg      Message
+		expected string
+	}{
+		{msg: Message{Text: "legacy row"}, expected: "legacy row"},
+		{msg: Message{ContentType: ContentText, Text: "hello"}, expected: "hello"},
+		{msg: Message{ContentType: ContentPhoto}, expected: "[photo]"},
+		{msg: Message{ContentType: ContentPhoto, Caption: "sunset"}, expected: "[photo] sunset"},
+		{msg: Message{ContentType: ContentSticker, Text: "😂"}, expected: "[sticker] 😂"},
+		{msg: Message{ContentType: ContentVoice}, expected: "[voice message]"},
+		{msg: Message{ContentType: ContentDocument, FileName: "report.pdf", Caption: "Q3"}, expected: "[document: report.pdf] Q3"},
+		{msg: Message{ContentType: "poll"}, expected: "[poll]"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.msg.Describe(); got != tt.expected {
+			t.Errorf("Expected '%s', got '%s'", tt.expected, got)
+		}
+	}
+}
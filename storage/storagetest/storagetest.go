@@ -0,0 +1,887 @@
+// Package storagetest provides a conformance suite for storage.Storage
+// implementations. Every backend must pass it, so behavior cannot drift
+// between SQLite, PostgreSQL and the in-memory mock.
+//
+// Usage from a backend's tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Storage {
+//			store := newMyStorage(t)
+//			t.Cleanup(func() { store.Close() })
+//			if err := store.Initialize(context.Background()); err != nil {
+//				t.Fatal(err)
+//			}
+//			return store
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+// Factory returns a fresh, initialized and empty Storage for one subtest.
+// It should register cleanup with t.Cleanup.
+type Factory func(t *testing.T) storage.Storage
+
+// Run checks every Storage method against the shared semantics
+func Run(t *testing.T, newStorage Factory) {
+	t.Run("Ping", func(t *testing.T) { testPing(t, newStorage(t)) })
+	t.Run("Chats", func(t *testing.T) { testChats(t, newStorage(t)) })
+	t.Run("Users", func(t *testing.T) { testUsers(t, newStorage(t)) })
+	t.Run("ChatUsers", func(t *testing.T) { testChatUsers(t, newStorage(t)) })
+	t.Run("Settings", func(t *testing.T) { testSettings(t, newStorage(t)) })
+	t.Run("Roles", func(t *testing.T) { testRoles(t, newStorage(t)) })
+	t.Run("AuditLog", func(t *testing.T) { testAuditLog(t, newStorage(t)) })
+	t.Run("SaveMessage", func(t *testing.T) { testSaveMessage(t, newStorage(t)) })
+	t.Run("RecentMessages", func(t *testing.T) { testRecentMessages(t, newStorage(t)) })
+	t.Run("UserMessages", func(t *testing.T) { testUserMessages(t, newStorage(t)) })
+	t.Run("TimeRange", func(t *testing.T) { testTimeRange(t, newStorage(t)) })
+	t.Run("PruneMessages", func(t *testing.T) { testPruneMessages(t, newStorage(t)) })
+	t.Run("SearchMessages", func(t *testing.T) { testSearchMessages(t, newStorage(t)) })
+	t.Run("GetMessage", func(t *testing.T) { testGetMessage(t, newStorage(t)) })
+	t.Run("EditMessage", func(t *testing.T) { testEditMessage(t, newStorage(t)) })
+	t.Run("Profiles", func(t *testing.T) { testProfiles(t, newStorage(t)) })
+	t.Run("ConcurrentWriters", func(t *testing.T) { testConcurrentWriters(t, newStorage(t)) })
+}
+
+// base is a fixed point in the past; whole seconds survive every backend's
+// timestamp precision
+var base = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testPing(t *testing.T, store storage.Storage) {
+	mustDo(t, "ping", store.Ping(context.Background()))
+}
+
+func testChats(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	if chat, err := store.GetChat(ctx, -100); chat != nil || err != nil {
+		t.Errorf("Expected nil, nil for a missing chat, got %v, %v", chat, err)
+	}
+	if chats, err := store.GetAllChats(ctx); len(chats) != 0 || err != nil {
+		t.Errorf("Expected no chats in an empty store, got %d (error %v)", len(chats), err)
+	}
+
+	mustDo(t, "save chat", store.SaveChat(ctx, &storage.Chat{ID: -100, Title: "Old", Type: "group", MessageCount: 1, CreatedAt: base}))
+	mustDo(t, "save chat", store.SaveChat(ctx, &storage.Chat{ID: -200, Title: "Other", Type: "group", CreatedAt: base}))
+
+	// Upsert replaces fields but keeps the original creation time
+	later := base.Add(time.Hour)
+	mustDo(t, "update chat", store.SaveChat(ctx, &storage.Chat{ID: -100, Title: "New", Type: "supergroup", MessageCount: 2, CreatedAt: later}))
+
+	got, err := store.GetChat(ctx, -100)
+	if err != nil || got == nil {
+		t.Fatalf("Expected chat, got %v (error %v)", got, err)
+	}
+	if got.ID != -100 || got.Title != "New" || got.Type != "supergroup" || got.MessageCount != 2 {
+		t.Errorf("Expected upserted chat, got %+v", got)
+	}
+	if !got.CreatedAt.Equal(base) {
+		t.Errorf("Expected CreatedAt %v to survive the upsert, got %v", base, got.CreatedAt)
+	}
+	if got.UpdatedAt.IsZero() {
+		t.Error("Expected UpdatedAt to be set")
+	}
+
+	// Returned values are copies
+	got.Title = "Mutated"
+	if again, _ := store.GetChat(ctx, -100); again.Title != "New" {
+		t.Errorf("Expected stored chat to be unaffected by caller changes, got title '%s'", again.Title)
+	}
+
+	mustDo(t, "update chat message count", store.UpdateChatMessageCount(ctx, -100, 42))
+	if got, _ := store.GetChat(ctx, -100); got.MessageCount != 42 {
+		t.Errorf("Expected message count 42, got %d", got.MessageCount)
+	}
+
+	// Updating a missing chat is a no-op, not an error
+	mustDo(t, "update missing chat", store.UpdateChatMessageCount(ctx, -300, 5))
+	if got, _ := store.GetChat(ctx, -300); got != nil {
+		t.Errorf("Expected UpdateChatMessageCount not to create a chat, got %+v", got)
+	}
+
+	chats, err := store.GetAllChats(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get all chats: %v", err)
+	}
+	ids := map[int64]bool{}
+	for _, chat := range chats {
+		ids[chat.ID] = true
+	}
+	if len(chats) != 2 || !ids[-100] || !ids[-200] {
+		t.Errorf("Expected chats -100 and -200, got %d chats %v", len(chats), ids)
+	}
+}
+
+func testUsers(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	if user, err := store.GetUser(ctx, 1); user != nil || err != nil {
+		t.Errorf("Expected nil, nil for a missing user, got %v, %v", user, err)
+	}
+	if users, err := store.GetAllUsers(ctx); len(users) != 0 || err != nil {
+		t.Errorf("Expected no users in an empty store, got %d (error %v)", len(users), err)
+	}
+
+	mustDo(t, "save user", store.SaveUser(ctx, &storage.User{ID: 1, UserName: "old", FirstName: "A", MessageCount: 1, CreatedAt: base}))
+	mustDo(t, "update user", store.SaveUser(ctx, &storage.User{ID: 1, UserName: "new", FirstName: "B", LastName: "C", MessageCount: 5, CreatedAt: base.Add(time.Hour)}))
+	mustDo(t, "save user", store.SaveUser(ctx, &storage.User{ID: 2, UserName: "second", CreatedAt: base}))
+
+	got, err := store.GetUser(ctx, 1)
+	if err != nil || got == nil {
+		t.Fatalf("Expected user, got %v (error %v)", got, err)
+	}
+	if got.UserName != "new" || got.FirstName != "B" || got.LastName != "C" {
+		t.Errorf("Expected upserted user, got %+v", got)
+	}
+	if got.MessageCount != 1 {
+		t.Errorf("Expected the upsert to keep message count 1, got %d", got.MessageCount)
+	}
+	if !got.CreatedAt.Equal(base) {
+		t.Errorf("Expected CreatedAt %v to survive the upsert, got %v", base, got.CreatedAt)
+	}
+
+	mustDo(t, "update user message count", store.UpdateUserMessageCount(ctx, 1, 6))
+	if got, _ := store.GetUser(ctx, 1); got.MessageCount != 6 {
+		t.Errorf("Expected message count 6, got %d", got.MessageCount)
+	}
+
+	mustDo(t, "update missing user", store.UpdateUserMessageCount(ctx, 3, 5))
+	if got, _ := store.GetUser(ctx, 3); got != nil {
+		t.Errorf("Expected UpdateUserMessageCount not to create a user, got %+v", got)
+	}
+
+	users, err := store.GetAllUsers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get all users: %v", err)
+	}
+	if len(users) != 2 {
+		t.Errorf("Expected 2 users, got %d", len(users))
+	}
+}
+
+func testChatUsers(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	if users, err := store.GetChatUsers(ctx, -100); len(users) != 0 || err != nil {
+		t.Errorf("Expected no users for an empty chat, got %d (error %v)", len(users), err)
+	}
+
+	mustDo(t, "save user", store.SaveUser(ctx, &storage.User{ID: 1, UserName: "zed", CreatedAt: base}))
+	mustDo(t, "save user", store.SaveUser(ctx, &storage.User{ID: 2, UserName: "amy", CreatedAt: base}))
+	mustDo(t, "save user", store.SaveUser(ctx, &storage.User{ID: 3, UserName: "elsewhere", CreatedAt: base}))
+
+	// User 1 posts twice; 999 is the bot, which has no users row
+	for _, msg := range []*storage.Message{
+		{ChatID: -100, UserID: 1, Text: "hi", Timestamp: base},
+		{ChatID: -100, UserID: 2, Text: "hey", Timestamp: base.Add(time.Second)},
+		{ChatID: -100, UserID: 1, Text: "again", Timestamp: base.Add(2 * time.Second)},
+		{ChatID: -100, UserID: 999, Text: "bot reply", IsBot: true, Timestamp: base.Add(3 * time.Second)},
+		{ChatID: -200, UserID: 3, Text: "other chat", Timestamp: base},
+	} {
+		mustDo(t, "save message", store.SaveMessage(ctx, msg))
+	}
+
+	users, err := store.GetChatUsers(ctx, -100)
+	if err != nil {
+		t.Fatalf("Failed to get chat users: %v", err)
+	}
+	var names []string
+	for _, user := range users {
+		names = append(names, user.UserName)
+	}
+	if got := strings.Join(names, ","); got != "amy,zed" {
+		t.Errorf("Expected distinct posters ordered by username 'amy,zed', got '%s'", got)
+	}
+}
+
+func testSettings(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	if settings, err := store.GetChatSettings(ctx, -100); settings != nil || err != nil {
+		t.Errorf("Expected nil, nil for missing settings, got %v, %v", settings, err)
+	}
+
+	mustDo(t, "save settings", store.SaveChatSettings(ctx, -100, &storage.ChatSettings{ChatID: -100, ResponseFrequency: 10, AlwaysRespondToMentions: true, CreatedAt: base}))
+	// Without a list of overridden settings, every setting is overridden
+	if got, _ := store.GetChatSettings(ctx, -100); got == nil || got.Overridden != nil {
+		t.Errorf("Expected nil Overridden to round-trip, got %+v", got)
+	}
+	mustDo(t, "update settings", store.SaveChatSettings(ctx, -100, &storage.ChatSettings{
+		ChatID: -100, ResponseFrequency: 3, AlwaysRespondToMentions: false,
+		RetentionMaxAge: 30 * 24 * time.Hour, RetentionMaxMessages: 5000, IgnoreMedia: true,
+		Overridden: []string{"response_frequency", "retention"}, CreatedAt: base.Add(time.Hour),
+	}))
+
+	got, err := store.GetChatSettings(ctx, -100)
+	if err != nil || got == nil {
+		t.Fatalf("Expected settings, got %v (error %v)", got, err)
+	}
+	if got.ChatID != -100 || got.ResponseFrequency != 3 || got.AlwaysRespondToMentions {
+		t.Errorf("Expected upserted settings, got %+v", got)
+	}
+	if got.RetentionMaxAge != 30*24*time.Hour || got.RetentionMaxMessages != 5000 {
+		t.Errorf("Expected retention 720h/5000, got %v/%d", got.RetentionMaxAge, got.RetentionMaxMessages)
+	}
+	if !got.IgnoreMedia {
+		t.Error("Expected IgnoreMedia to round-trip")
+	}
+	if strings.Join(got.Overridden, ",") != "response_frequency,retention" {
+		t.Errorf("Expected overridden settings to round-trip, got %v", got.Overridden)
+	}
+	if !got.CreatedAt.Equal(base) {
+		t.Errorf("Expected CreatedAt %v to survive the upsert, got %v", base, got.CreatedAt)
+	}
+
+	mustDo(t, "delete settings", store.DeleteChatSettings(ctx, -100))
+	if got, err := store.GetChatSettings(ctx, -100); got != nil || err != nil {
+		t.Errorf("Expected nil, nil after delete, got %v, %v", got, err)
+	}
+
+	// Deleting again is not an error
+	mustDo(t, "delete missing settings", store.DeleteChatSettings(ctx, -100))
+}
+
+func testRoles(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	if role, err := store.GetChatRole(ctx, -100, 1); role != nil || err != nil {
+		t.Errorf("Expected nil, nil for a missing role, got %v, %v", role, err)
+	}
+	if roles, err := store.GetChatRoles(ctx, -100); len(roles) != 0 || err != nil {
+		t.Errorf("Expected no roles in an empty store, got %d (error %v)", len(roles), err)
+	}
+
+	mustDo(t, "grant role", store.SaveChatRole(ctx, &storage.ChatRole{ChatID: -100, UserID: 2, Role: storage.RoleModerator, GrantedBy: 9, GrantedAt: at(20)}))
+	mustDo(t, "grant role", store.SaveChatRole(ctx, &storage.ChatRole{ChatID: -100, UserID: 1, Role: "old", GrantedBy: 8, GrantedAt: at(5)}))
+	mustDo(t, "grant role", store.SaveChatRole(ctx, &storage.ChatRole{ChatID: -200, UserID: 1, Role: storage.RoleModerator, GrantedBy: 9, GrantedAt: at(0)}))
+
+	// Granting again replaces the role
+	mustDo(t, "replace role", store.SaveChatRole(ctx, &storage.ChatRole{ChatID: -100, UserID: 1, Role: storage.RoleModerator, GrantedBy: 9, GrantedAt: at(10)}))
+
+	got, err := store.GetChatRole(ctx, -100, 1)
+	if err != nil || got == nil {
+		t.Fatalf("Expected role, got %v (error %v)", got, err)
+	}
+	if got.ChatID != -100 || got.UserID != 1 || got.Role != storage.RoleModerator || got.GrantedBy != 9 || !got.GrantedAt.Equal(at(10)) {
+		t.Errorf("Expected the replaced role, got %+v", got)
+	}
+
+	// Listed oldest grant first, for one chat only
+	roles, err := store.GetChatRoles(ctx, -100)
+	if err != nil || len(roles) != 2 || roles[0].UserID != 1 || roles[1].UserID != 2 {
+		t.Fatalf("Expected users 1 and 2 in grant order, got %+v (error %v)", roles, err)
+	}
+
+	mustDo(t, "revoke role", store.DeleteChatRole(ctx, -100, 1))
+	if got, err := store.GetChatRole(ctx, -100, 1); got != nil || err != nil {
+		t.Errorf("Expected nil, nil after revoke, got %v, %v", got, err)
+	}
+	if got, _ := store.GetChatRole(ctx, -200, 1); got == nil {
+		t.Error("Expected the role in another chat to remain")
+	}
+
+	// Revoking again is not an error
+	mustDo(t, "revoke missing role", store.DeleteChatRole(ctx, -100, 1))
+}
+
+func testAuditLog(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	if entries, err := store.GetAuditLog(ctx, -100, 10, 0); len(entries) != 0 || err != nil {
+		t.Errorf("Expected an empty audit log, got %d entries (error %v)", len(entries), err)
+	}
+
+	for i, chatID := range []int64{-100, -200, -100, -100} {
+		entry := &storage.AuditEntry{ChatID: chatID, ActorID: int64(i + 1), Action: "setfrequency",
+			OldValue: fmt.Sprint(i), NewValue: fmt.Sprint(i + 1), Timestamp: at(i)}
+		mustDo(t, "save audit entry", store.SaveAuditEntry(ctx, entry))
+		if entry.ID == 0 {
+			t.Errorf("Expected SaveAuditEntry to set the ID of entry %d", i)
+		}
+	}
+
+	got, err := store.GetAuditLog(ctx, -100, 10, 0)
+	if err != nil || len(got) != 3 {
+		t.Fatalf("Expected 3 entries for the chat, got %d (error %v)", len(got), err)
+	}
+	first := got[0]
+	if first.ChatID != -100 || first.ActorID != 4 || first.Action != "setfrequency" ||
+		first.OldValue != "3" || first.NewValue != "4" || !first.Timestamp.Equal(at(3)) {
+		t.Errorf("Expected the newest entry first, got %+v", first)
+	}
+
+	// Paging skips the newest entries
+	if page, _ := store.GetAuditLog(ctx, -100, 2, 1); len(page) != 2 || page[0].ActorID != 3 || page[1].ActorID != 1 {
+		t.Errorf("Expected actors 3 and 1 on the second page, got %+v", page)
+	}
+	if page, _ := store.GetAuditLog(ctx, -100, 2, 3); len(page) != 0 {
+		t.Errorf("Expected nothing past the end, got %+v", page)
+	}
+
+	// Chat 0 means every chat
+	if all, _ := store.GetAuditLog(ctx, 0, 10, 0); len(all) != 4 || all[2].ChatID != -200 {
+		t.Errorf("Expected all 4 entries across chats, got %+v", all)
+	}
+
+	// Keyset paging continues below the last ID seen, even after a newer
+	// entry shifts the offsets
+	page, err := store.GetAuditLogBefore(ctx, -100, 0, 2)
+	if err != nil || len(page) != 2 || page[0].ActorID != 4 || page[1].ActorID != 3 {
+		t.Fatalf("Expected actors 4 and 3 on the first keyset page, got %+v (error %v)", page, err)
+	}
+	mustDo(t, "save audit entry", store.SaveAuditEntry(ctx, &storage.AuditEntry{ChatID: -100, ActorID: 6,
+		Action: "setfrequency", Timestamp: at(5)}))
+	if next, _ := store.GetAuditLogBefore(ctx, -100, page[1].ID, 2); len(next) != 1 || next[0].ActorID != 1 {
+		t.Errorf("Expected only actor 1 below the last ID seen, got %+v", next)
+	}
+	if all, _ := store.GetAuditLogBefore(ctx, 0, got[0].ID, 10); len(all) != 3 || all[0].ChatID != -100 || all[1].ChatID != -200 {
+		t.Errorf("Expected the 3 entries below the newest original one across chats, got %+v", all)
+	}
+	// Supergroup IDs do not fit in 32 bits
+	const supergroup int64 = -1001234567890
+	mustDo(t, "save audit entry", store.SaveAuditEntry(ctx, &storage.AuditEntry{ChatID: supergroup, ActorID: 5,
+		Action: "togglementions", OldValue: "on", NewValue: "off", Timestamp: at(4)}))
+	if got, err := store.GetAuditLog(ctx, supergroup, 10, 0); err != nil || len(got) != 1 || got[0].ChatID != supergroup {
+		t.Errorf("Expected the supergroup's entry, got %+v (error %v)", got, err)
+	}
+}
+
+func testSaveMessage(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	first := &storage.Message{ChatID: -100, UserID: 1, Text: "hello", Timestamp: base}
+	second := &storage.Message{ChatID: -100, UserID: 999, MessageID: 11, ReplyToMessageID: 10,
+		Text: "hi there", IsBot: true, Timestamp: base.Add(time.Second)}
+	mustDo(t, "save message", store.SaveMessage(ctx, first))
+	mustDo(t, "save message", store.SaveMessage(ctx, second))
+
+	if first.ID == 0 || second.ID <= first.ID {
+		t.Errorf("Expected increasing IDs to be set, got %d and %d", first.ID, second.ID)
+	}
+
+	messages, err := store.GetRecentMessages(ctx, -100, 10)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d (error %v)", len(messages), err)
+	}
+	got := messages[1]
+	if got.ID != second.ID || got.ChatID != -100 || got.UserID != 999 || got.Text != "hi there" || !got.IsBot {
+		t.Errorf("Expected saved fields to round-trip, got %+v", got)
+	}
+	if got.MessageID != 11 || got.ReplyToMessageID != 10 {
+		t.Errorf("Expected Telegram IDs 11 replying to 10, got %d replying to %d", got.MessageID, got.ReplyToMessageID)
+	}
+	if !got.Timestamp.Equal(second.Timestamp) {
+		t.Errorf("Expected timestamp %v, got %v", second.Timestamp, got.Timestamp)
+	}
+	if !got.EditedAt.IsZero() || !messages[0].EditedAt.IsZero() {
+		t.Errorf("Expected unedited messages to have no edit time, got %v", got.EditedAt)
+	}
+
+	// Media fields round-trip too
+	document := &storage.Message{ChatID: -100, UserID: 1, MessageID: 12, ContentType: storage.ContentDocument,
+		Caption: "minutes", FileID: "file-1", FileUniqueID: "unique-1", FileName: "notes.pdf", FileSize: 2048,
+		Timestamp: base.Add(2 * time.Second)}
+	mustDo(t, "save media message", store.SaveMessage(ctx, document))
+
+	media, err := store.GetMessage(ctx, -100, 12)
+	if err != nil || media == nil {
+		t.Fatalf("Expected media message, got %v (error %v)", media, err)
+	}
+	if media.ContentType != storage.ContentDocument || media.Caption != "minutes" || media.FileID != "file-1" ||
+		media.FileUniqueID != "unique-1" || media.FileName != "notes.pdf" || media.FileSize != 2048 || media.Text != "" {
+		t.Errorf("Expected media fields to round-trip, got %+v", media)
+	}
+}
+
+func testRecentMessages(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	if messages, err := store.GetRecentMessages(ctx, -100, 10); len(messages) != 0 || err != nil {
+		t.Errorf("Expected no messages for an empty chat, got %d (error %v)", len(messages), err)
+	}
+
+	// Saved out of order; m3 and m4 share a timestamp and keep insertion order
+	saveMessages(t, store, -100,
+		msgAt("m2", 1, 2), msgAt("m0", 1, 0), msgAt("m1", 2, 1), msgAt("m3", 2, 3), msgAt("m4", 1, 3))
+	saveMessages(t, store, -200, msgAt("other", 1, 9))
+
+	tests := []struct {
+		limit int
+		want  string
+	}{
+		{limit: 3, want: "m2,m3,m4"},
+		{limit: 1, want: "m4"},
+		{limit: 10, want: "m0,m1,m2,m3,m4"},
+		{limit: 0, want: ""},
+	}
+	for _, tt := range tests {
+		messages, err := store.GetRecentMessages(ctx, -100, tt.limit)
+		if err != nil {
+			t.Fatalf("Failed to get recent messages: %v", err)
+		}
+		if got := texts(messages); got != tt.want {
+			t.Errorf("Limit %d: expected newest messages in chronological order '%s', got '%s'", tt.limit, tt.want, got)
+		}
+	}
+}
+
+func testUserMessages(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	saveMessages(t, store, -100,
+		msgAt("a0", 1, 0), msgAt("b0", 2, 1), msgAt("a1", 1, 2), msgAt("a2", 1, 3), msgAt("b1", 2, 4))
+	saveMessages(t, store, -200, msgAt("a-other", 1, 5))
+
+	tests := []struct {
+		userID int64
+		limit  int
+		want   string
+	}{
+		{userID: 1, limit: 10, want: "a2,a1,a0"},
+		{userID: 1, limit: 2, want: "a2,a1"},
+		{userID: 2, limit: 10, want: "b1,b0"},
+		{userID: 3, limit: 10, want: ""},
+	}
+	for _, tt := range tests {
+		messages, err := store.GetUserMessagesInChat(ctx, -100, tt.userID, tt.limit)
+		if err != nil {
+			t.Fatalf("Failed to get user messages: %v", err)
+		}
+		if got := texts(messages); got != tt.want {
+			t.Errorf("User %d, limit %d: expected newest first '%s', got '%s'", tt.userID, tt.limit, tt.want, got)
+		}
+	}
+}
+
+func testTimeRange(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	saveMessages(t, store, -100,
+		msgAt("m3", 1, 3), msgAt("m0", 1, 0), msgAt("m1", 1, 1), msgAt("m2", 1, 2), msgAt("m4", 1, 4))
+	saveMessages(t, store, -200, msgAt("other", 1, 2))
+
+	tests := []struct {
+		name       string
+		start, end int // Seconds after base
+		want       string
+	}{
+		{name: "Inclusive bounds", start: 1, end: 3, want: "m1,m2,m3"},
+		{name: "Single instant", start: 2, end: 2, want: "m2"},
+		{name: "Everything", start: -10, end: 10, want: "m0,m1,m2,m3,m4"},
+		{name: "Before all", start: -10, end: -1, want: ""},
+		{name: "Inverted", start: 3, end: 1, want: ""},
+	}
+	for _, tt := range tests {
+		messages, err := store.GetMessagesByTimeRange(ctx, -100, at(tt.start), at(tt.end))
+		if err != nil {
+			t.Fatalf("%s: failed to get messages by time range: %v", tt.name, err)
+		}
+		if got := texts(messages); got != tt.want {
+			t.Errorf("%s: expected '%s', got '%s'", tt.name, tt.want, got)
+		}
+	}
+}
+
+func testPruneMessages(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	seed := func(chatID int64) {
+		for i := 0; i < 10; i++ {
+			saveMessages(t, store, chatID, msgAt(fmt.Sprintf("m%d", i), 1, i))
+		}
+	}
+	prune := func(chatID int64, before time.Time, keepLatest, limit int) int64 {
+		t.Helper()
+		n, err := store.PruneMessages(ctx, chatID, before, keepLatest, limit)
+		mustDo(t, "prune messages", err)
+		return n
+	}
+	remaining := func(chatID int64) string {
+		messages, err := store.GetRecentMessages(ctx, chatID, 100)
+		mustDo(t, "get recent messages", err)
+		return texts(messages)
+	}
+
+	seed(-100)
+	seed(-200)
+
+	if n := prune(-100, time.Time{}, 0, 100); n != 0 {
+		t.Errorf("Expected no limits to delete nothing, deleted %d", n)
+	}
+
+	// By age, oldest first and in batches; the cutoff itself is kept
+	if n := prune(-100, at(4), 0, 3); n != 3 {
+		t.Errorf("Expected first batch to delete 3, deleted %d", n)
+	}
+	if got := remaining(-100); got != "m3,m4,m5,m6,m7,m8,m9" {
+		t.Errorf("Expected oldest messages pruned first, got '%s'", got)
+	}
+	if n := prune(-100, at(4), 0, 3); n != 1 {
+		t.Errorf("Expected second batch to delete 1, deleted %d", n)
+	}
+
+	// By count
+	if n := prune(-100, time.Time{}, 4, 100); n != 2 {
+		t.Errorf("Expected 2 messages beyond the newest 4, deleted %d", n)
+	}
+	if got := remaining(-100); got != "m6,m7,m8,m9" {
+		t.Errorf("Expected newest 4 kept, got '%s'", got)
+	}
+
+	// Either limit is enough to prune a message
+	if n := prune(-100, at(7), 3, 100); n != 1 {
+		t.Errorf("Expected 1 message pruned by combined limits, deleted %d", n)
+	}
+	if got := remaining(-100); got != "m7,m8,m9" {
+		t.Errorf("Expected 'm7,m8,m9', got '%s'", got)
+	}
+
+	// Other chats are untouched
+	if got := remaining(-200); got != "m0,m1,m2,m3,m4,m5,m6,m7,m8,m9" {
+		t.Errorf("Expected other chat untouched, got '%s'", got)
+	}
+
+	// New messages never reuse pruned IDs
+	latest, _ := store.GetRecentMessages(ctx, -200, 1)
+	fresh := msgAt("fresh", 1, 20)
+	saveMessages(t, store, -100, fresh)
+	if fresh.ID <= latest[0].ID {
+		t.Errorf("Expected new ID above %d, got %d", latest[0].ID, fresh.ID)
+	}
+
+	mustDo(t, "vacuum", store.Vacuum(ctx))
+}
+
+func testSearchMessages(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	saveMessages(t, store, -100,
+		msgAt("Deployment finished on staging", 1, 0),
+		msgAt("who broke the build?", 2, 1),
+		msgAt("deploying to production now", 1, 2),
+		msgAt("production build is green", 2, 3),
+		// Commands never match, so they cannot crowd out results
+		msgAt("/search deploy", 1, 5),
+		msgAt("/search production build", 2, 6))
+	saveMessages(t, store, -200, msgAt("deploying elsewhere", 1, 4))
+
+	tests := []struct {
+		query string
+		limit int
+		want  string
+	}{
+		{query: "deploy", limit: 10, want: "deploying to production now,Deployment finished on staging"},
+		{query: "DEPLOY", limit: 1, want: "deploying to production now"},
+		{query: "production build", limit: 10, want: "production build is green"},
+		{query: "build?!", limit: 10, want: "production build is green,who broke the build?"},
+		{query: "kubernetes", limit: 10, want: ""},
+		{query: "  ", limit: 10, want: ""},
+	}
+	for _, tt := range tests {
+		messages, err := store.SearchMessages(ctx, -100, tt.query, tt.limit)
+		if err != nil {
+			t.Fatalf("Search %q failed: %v", tt.query, err)
+		}
+		if got := texts(messages); got != tt.want {
+			t.Errorf("Search %q: expected '%s', got '%s'", tt.query, tt.want, got)
+		}
+	}
+
+	// Pruned messages no longer match
+	if _, err := store.PruneMessages(ctx, -100, at(1), 0, 10); err != nil {
+		t.Fatalf("Failed to prune messages: %v", err)
+	}
+	messages, err := store.SearchMessages(ctx, -100, "deploy", 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if got := texts(messages); got != "deploying to production now" {
+		t.Errorf("Expected pruned message to drop out of search, got '%s'", got)
+	}
+
+	// Media is found by its caption, alone or together with text words
+	saveMessages(t, store, -100, &storage.Message{UserID: 2, ContentType: storage.ContentPhoto,
+		Caption: "Whiteboard sketch of the rollout", FileID: "photo-1", Timestamp: at(7)})
+	for _, query := range []string{"whiteboard", "ROLLOUT sketch"} {
+		messages, err := store.SearchMessages(ctx, -100, query, 10)
+		if err != nil {
+			t.Fatalf("Search %q failed: %v", query, err)
+		}
+		if len(messages) != 1 || messages[0].Caption != "Whiteboard sketch of the rollout" {
+			t.Errorf("Search %q: expected the captioned photo, got %+v", query, messages)
+		}
+	}
+}
+
+func testGetMessage(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	saveMessages(t, store, -100,
+		&storage.Message{UserID: 1, MessageID: 10, Text: "question", Timestamp: at(0)},
+		&storage.Message{UserID: 2, MessageID: 11, ReplyToMessageID: 10, Text: "answer", Timestamp: at(1)},
+		&storage.Message{UserID: 3, Text: "legacy", Timestamp: at(2)})
+	saveMessages(t, store, -200, &storage.Message{UserID: 1, MessageID: 10, Text: "other chat", Timestamp: at(3)})
+
+	got, err := store.GetMessage(ctx, -100, 11)
+	if err != nil || got == nil {
+		t.Fatalf("Expected message 11, got %v (error %v)", got, err)
+	}
+	if got.Text != "answer" || got.UserID != 2 || got.ReplyToMessageID != 10 || !got.Timestamp.Equal(at(1)) {
+		t.Errorf("Expected the reply to round-trip, got %+v", got)
+	}
+
+	// Telegram IDs are only unique within a chat
+	if got, _ := store.GetMessage(ctx, -200, 10); got == nil || got.Text != "other chat" {
+		t.Errorf("Expected lookup scoped to the chat, got %+v", got)
+	}
+
+	// Missing and unknown (0) IDs are not found
+	for _, id := range []int{12, 0} {
+		if got, err := store.GetMessage(ctx, -100, id); got != nil || err != nil {
+			t.Errorf("Expected nil, nil for message %d, got %v, %v", id, got, err)
+		}
+	}
+}
+
+func testEditMessage(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	saveMessages(t, store, -100,
+		&storage.Message{UserID: 1, MessageID: 10, Text: "the meeting is at 5", Timestamp: at(0)},
+		&storage.Message{UserID: 2, MessageID: 11, Text: "ok", Timestamp: at(1)})
+
+	mustDo(t, "edit message", store.EditMessage(ctx, -100, 10, "the meeting is at 6", "", at(60)))
+	mustDo(t, "edit message", store.EditMessage(ctx, -100, 10, "the meeting is at 7", "", at(120)))
+	// Editing something other than the text keeps the history unchanged
+	mustDo(t, "edit message", store.EditMessage(ctx, -100, 10, "the meeting is at 7", "", at(180)))
+	// Messages the bot never stored are ignored
+	mustDo(t, "edit unknown message", store.EditMessage(ctx, -100, 99, "edited", "", at(60)))
+
+	got, err := store.GetMessage(ctx, -100, 10)
+	if err != nil || got == nil {
+		t.Fatalf("Expected edited message, got %v (error %v)", got, err)
+	}
+	if got.Text != "the meeting is at 7" || !got.EditedAt.Equal(at(180)) {
+		t.Errorf("Expected latest text edited at %v, got '%s' at %v", at(180), got.Text, got.EditedAt)
+	}
+	if !got.Timestamp.Equal(at(0)) {
+		t.Errorf("Expected edits to keep the original send time, got %v", got.Timestamp)
+	}
+
+	// History queries see the current text
+	recent, _ := store.GetRecentMessages(ctx, -100, 10)
+	if got := texts(recent); got != "the meeting is at 7,ok" {
+		t.Errorf("Expected edited text in history, got '%s'", got)
+	}
+	if found, _ := store.SearchMessages(ctx, -100, "meeting", 10); len(found) != 1 || found[0].Text != "the meeting is at 7" {
+		t.Errorf("Expected search to find the edited text, got %d results", len(found))
+	}
+
+	edits, err := store.GetMessageEdits(ctx, -100, 10)
+	if err != nil {
+		t.Fatalf("Failed to get edits: %v", err)
+	}
+	if len(edits) != 2 {
+		t.Fatalf("Expected 2 previous versions, got %d", len(edits))
+	}
+	if edits[0].Text != "the meeting is at 5" || !edits[0].EditedAt.Equal(at(60)) ||
+		edits[1].Text != "the meeting is at 6" || !edits[1].EditedAt.Equal(at(120)) {
+		t.Errorf("Expected previous versions oldest first, got %+v and %+v", edits[0], edits[1])
+	}
+	if edits, _ := store.GetMessageEdits(ctx, -100, 11); len(edits) != 0 {
+		t.Errorf("Expected no history for an unedited message, got %d", len(edits))
+	}
+
+	// Media messages have their caption edited, keeping the old one
+	saveMessages(t, store, -100,
+		&storage.Message{UserID: 2, MessageID: 12, ContentType: storage.ContentPhoto, Caption: "sunset", FileID: "photo-1", Timestamp: at(2)})
+	mustDo(t, "edit caption", store.EditMessage(ctx, -100, 12, "", "sunset over the bay", at(240)))
+	photo, _ := store.GetMessage(ctx, -100, 12)
+	if photo == nil || photo.Caption != "sunset over the bay" || photo.FileID != "photo-1" || !photo.EditedAt.Equal(at(240)) {
+		t.Errorf("Expected the edited caption stored, got %+v", photo)
+	}
+	if edits, _ := store.GetMessageEdits(ctx, -100, 12); len(edits) != 1 || edits[0].Caption != "sunset" {
+		t.Errorf("Expected the original caption in edit history, got %+v", edits)
+	}
+	if found, _ := store.SearchMessages(ctx, -100, "bay", 10); len(found) != 1 || found[0].MessageID != 12 {
+		t.Errorf("Expected search to find the edited caption, got %d results", len(found))
+	}
+
+	// Pruning a message drops its history
+	if _, err := store.PruneMessages(ctx, -100, at(1), 0, 10); err != nil {
+		t.Fatalf("Failed to prune messages: %v", err)
+	}
+	if edits, _ := store.GetMessageEdits(ctx, -100, 10); len(edits) != 0 {
+		t.Errorf("Expected pruned message's history removed, got %d", len(edits))
+	}
+}
+
+func testProfiles(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	if profile, err := store.GetUserProfile(ctx, -100, 1); profile != nil || err != nil {
+		t.Errorf("Expected nil, nil for a missing profile, got %v, %v", profile, err)
+	}
+
+	profile := &storage.UserProfile{
+		ChatID: -100, UserID: 1, Interests: "go", Topics: "tests", Personality: "curious",
+		LastInteraction: base, InteractionCount: 1, Notes: "first", CreatedAt: base,
+	}
+	mustDo(t, "save profile", store.SaveUserProfile(ctx, profile))
+
+	// Profiles are per chat
+	other := *profile
+	other.ChatID = -200
+	other.Interests = "rust"
+	mustDo(t, "save profile", store.SaveUserProfile(ctx, &other))
+
+	// Upsert replaces fields but keeps the original creation time
+	replaced := *profile
+	replaced.Notes = "second"
+	replaced.CreatedAt = base.Add(time.Hour)
+	mustDo(t, "update profile", store.SaveUserProfile(ctx, &replaced))
+
+	got, err := store.GetUserProfile(ctx, -100, 1)
+	if err != nil || got == nil {
+		t.Fatalf("Expected profile, got %v (error %v)", got, err)
+	}
+	if got.Interests != "go" || got.Notes != "second" || !got.CreatedAt.Equal(base) {
+		t.Errorf("Expected upserted profile with original CreatedAt, got %+v", got)
+	}
+
+	// Partial update leaves other fields alone
+	err = store.UpdateUserProfile(ctx, -100, 1, map[string]interface{}{
+		"topics":            "databases",
+		"interaction_count": 4,
+		"last_interaction":  base.Add(time.Minute),
+	})
+	mustDo(t, "update profile fields", err)
+
+	got, _ = store.GetUserProfile(ctx, -100, 1)
+	if got.Interests != "go" || got.Personality != "curious" || got.Topics != "databases" || got.InteractionCount != 4 {
+		t.Errorf("Expected partially updated profile, got %+v", got)
+	}
+	if !got.LastInteraction.Equal(base.Add(time.Minute)) {
+		t.Errorf("Expected LastInteraction %v, got %v", base.Add(time.Minute), got.LastInteraction)
+	}
+	if got, _ := store.GetUserProfile(ctx, -200, 1); got.Interests != "rust" || got.Topics != "tests" {
+		t.Errorf("Expected profile in another chat to be untouched, got %+v", got)
+	}
+
+	// Empty updates are a no-op; updating a missing profile does not create it
+	mustDo(t, "apply empty update", store.UpdateUserProfile(ctx, -100, 1, map[string]interface{}{}))
+	mustDo(t, "update missing profile", store.UpdateUserProfile(ctx, -100, 2, map[string]interface{}{"notes": "x"}))
+	if got, _ := store.GetUserProfile(ctx, -100, 2); got != nil {
+		t.Errorf("Expected UpdateUserProfile not to create a profile, got %+v", got)
+	}
+
+	// Unknown fields are rejected rather than ignored
+	if err := store.UpdateUserProfile(ctx, -100, 1, map[string]interface{}{"no_such_field": "x"}); err == nil {
+		t.Error("Expected error for an unknown profile field, got nil")
+	}
+}
+
+func testConcurrentWriters(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	const writers, perWriter = 8, 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*(perWriter+2))
+	ids := make(chan int64, writers*perWriter)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID := int64(w + 1)
+			errs <- store.SaveUser(ctx, &storage.User{ID: userID, UserName: fmt.Sprintf("user%d", w), CreatedAt: base})
+			for i := 0; i < perWriter; i++ {
+				msg := &storage.Message{ChatID: -100, UserID: userID, Text: fmt.Sprintf("%d-%d", w, i), Timestamp: at(i)}
+				errs <- store.SaveMessage(ctx, msg)
+				ids <- msg.ID
+			}
+			errs <- store.UpdateUserMessageCount(ctx, userID, perWriter)
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	close(ids)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Concurrent write failed: %v", err)
+		}
+	}
+
+	seen := map[int64]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("Message ID %d assigned twice", id)
+		}
+		seen[id] = true
+	}
+
+	messages, err := store.GetRecentMessages(ctx, -100, writers*perWriter*2)
+	if err != nil {
+		t.Fatalf("Failed to get recent messages: %v", err)
+	}
+	if len(messages) != writers*perWriter {
+		t.Errorf("Expected %d messages, got %d", writers*perWriter, len(messages))
+	}
+
+	users, err := store.GetAllUsers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get all users: %v", err)
+	}
+	if len(users) != writers {
+		t.Errorf("Expected %d users, got %d", writers, len(users))
+	}
+	for _, user := range users {
+		if user.MessageCount != perWriter {
+			t.Errorf("Expected user %d message count %d, got %d", user.ID, perWriter, user.MessageCount)
+		}
+	}
+}
+
+// at returns base plus the given number of seconds
+func at(seconds int) time.Time {
+	return base.Add(time.Duration(seconds) * time.Second)
+}
+
+// msgAt builds a message from a user at base plus the given seconds
+func msgAt(text string, userID int64, seconds int) *storage.Message {
+	return &storage.Message{UserID: userID, Text: text, Timestamp: at(seconds)}
+}
+
+// saveMessages stores messages in the given chat, in argument order
+func saveMessages(t *testing.T, store storage.Storage, chatID int64, messages ...*storage.Message) {
+	t.Helper()
+	for _, msg := range messages {
+		msg.ChatID = chatID
+		mustDo(t, "save message", store.SaveMessage(context.Background(), msg))
+	}
+}
+
+// texts joins message texts for compact comparisons
+func texts(messages []*storage.Message) string {
+	out := make([]string, len(messages))
+	for i, msg := range messages {
+		out[i] = msg.Text
+	}
+	return strings.Join(out, ",")
+}
+
+func mustDo(t *testing.T, action string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Failed to %s: %v", action, err)
+	}
+}
@@ -0,0 +1,124 @@
+package storagetest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Zind-dev/HowardTheChad_bot/metrics"
+	"github.com/Zind-dev/HowardTheChad_bot/storage"
+)
+
+func TestMockStorage(t *testing.T) {
+	Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewMockStorage()
+	})
+}
+
+func TestInstrumentedStorage(t *testing.T) {
+	Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewInstrumented(storage.NewMockStorage(), metrics.NewRegistry())
+	})
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	Run(t, func(t *testing.T) storage.Storage {
+		store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "bot.db"))
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		return initialized(t, store)
+	})
+}
+
+// TestPostgresStorage connects to TEST_POSTGRES_DSN, or starts a throwaway
+// cluster if initdb and pg_ctl are on PATH. Each subtest gets its own schema.
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		dsn = startLocalPostgres(t)
+	}
+	if dsn == "" {
+		t.Skip("PostgreSQL not available: set TEST_POSTGRES_DSN or install initdb and pg_ctl")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer admin.Close()
+
+	var next int
+	Run(t, func(t *testing.T) storage.Storage {
+		next++
+		schema := fmt.Sprintf("storagetest_%d_%d", os.Getpid(), next)
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatalf("Failed to create schema: %v", err)
+		}
+		t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+		store, err := storage.NewPostgresStorage(withSearchPath(dsn, schema))
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		return initialized(t, store)
+	})
+}
+
+// initialized runs Initialize and closes the store when the test ends
+func initialized(t *testing.T, store storage.Storage) storage.Storage {
+	t.Cleanup(func() { store.Close() })
+	if err := store.Initialize(context.Background()); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+	return store
+}
+
+// withSearchPath adds a search_path run-time parameter to a lib/pq DSN
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if strings.Contains(dsn, "?") {
+			return dsn + "&search_path=" + schema
+		}
+		return dsn + "?search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// startLocalPostgres runs a temporary cluster on a Unix socket and returns
+// its DSN, or "" if the server binaries are not installed
+func startLocalPostgres(t *testing.T) string {
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		return ""
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		return ""
+	}
+
+	// Socket paths are limited to ~100 bytes, so avoid the long t.TempDir()
+	dir, err := os.MkdirTemp("", "pg")
+	if err != nil {
+		t.Fatalf("Failed to create cluster directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "--auth=trust").CombinedOutput(); err != nil {
+		t.Fatalf("initdb failed: %v\n%s", err, out)
+	}
+
+	opts := fmt.Sprintf("-k %s -c listen_addresses=''", dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-o", opts, "-w", "start").CombinedOutput(); err != nil {
+		t.Fatalf("pg_ctl start failed: %v\n%s", err, out)
+	}
+	t.Cleanup(func() { exec.Command(pgCtl, "-D", data, "-m", "immediate", "stop").Run() })
+
+	return fmt.Sprintf("host=%s user=postgres dbname=postgres sslmode=disable", dir)
+}
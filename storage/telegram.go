@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MessageFromTelegram converts a Telegram message for storage. Stickers are
+// stored with their emoji as text; other media keep their caption separately.
+func MessageFromTelegram(message *tgbotapi.Message) *Message {
+	msg := &Message{
+		MessageID:   message.MessageID,
+		Text:        message.Text,
+		Caption:     message.Caption,
+		ContentType: ContentText,
+		Timestamp:   message.Time(),
+	}
+	if message.Chat != nil {
+		msg.ChatID = message.Chat.ID
+	}
+	if message.From != nil {
+		msg.UserID = message.From.ID
+		msg.IsBot = message.From.IsBot
+	}
+	if message.ReplyToMessage != nil {
+		msg.ReplyToMessageID = message.ReplyToMessage.MessageID
+	}
+	if message.EditDate != 0 {
+		msg.EditedAt = time.Unix(int64(message.EditDate), 0)
+	}
+	// Date is missing only from hand-built messages, e.g. in tests
+	if message.Date == 0 {
+		msg.Timestamp = time.Now()
+	}
+
+	// Animations also carry a Document, so check them first
+	switch {
+	case message.Animation != nil:
+		a := message.Animation
+		msg.setFile(ContentAnimation, a.FileID, a.FileUniqueID, a.FileName, a.FileSize)
+	case len(message.Photo) > 0:
+		// Sizes are listed smallest first; keep the original
+		p := message.Photo[len(message.Photo)-1]
+		msg.setFile(ContentPhoto, p.FileID, p.FileUniqueID, "", p.FileSize)
+	case message.Sticker != nil:
+		st := message.Sticker
+		msg.setFile(ContentSticker, st.FileID, st.FileUniqueID, "", st.FileSize)
+		msg.Text = st.Emoji
+	case message.Voice != nil:
+		v := message.Voice
+		msg.setFile(ContentVoice, v.FileID, v.FileUniqueID, "", v.FileSize)
+	case message.VideoNote != nil:
+		v := message.VideoNote
+		msg.setFile(ContentVideoNote, v.FileID, v.FileUniqueID, "", v.FileSize)
+	case message.Video != nil:
+		v := message.Video
+		msg.setFile(ContentVideo, v.FileID, v.FileUniqueID, v.FileName, v.FileSize)
+	case message.Audio != nil:
+		a := message.Audio
+		msg.setFile(ContentAudio, a.FileID, a.FileUniqueID, a.FileName, a.FileSize)
+	case message.Document != nil:
+		d := message.Document
+		msg.setFile(ContentDocument, d.FileID, d.FileUniqueID, d.FileName, d.FileSize)
+	}
+
+	return msg
+}
+
+// setFile records the attachment of a media message
+func (m *Message) setFile(contentType, fileID, fileUniqueID, fileName string, fileSize int) {
+	m.ContentType = contentType
+	m.FileID = fileID
+	m.FileUniqueID = fileUniqueID
+	m.FileName = fileName
+	m.FileSize = fileSize
+}
@@ -0,0 +1,68 @@
+-- A database created by the bot before schema migrations existed:
+-- the version 1 tables and some data, but no schema_version table.
+
+CREATE TABLE chats (
+	id INTEGER PRIMARY KEY,
+	title TEXT,
+	type TEXT,
+	message_count INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY,
+	username TEXT,
+	first_name TEXT,
+	last_name TEXT,
+	message_count INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE chat_settings (
+	chat_id INTEGER PRIMARY KEY,
+	response_frequency INTEGER DEFAULT 10,
+	always_respond_to_mentions BOOLEAN DEFAULT 1,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	text TEXT,
+	is_bot BOOLEAN DEFAULT 0,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (chat_id) REFERENCES chats(id),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_messages_chat_id ON messages(chat_id);
+CREATE INDEX idx_messages_timestamp ON messages(timestamp);
+
+CREATE TABLE user_profiles (
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	interests TEXT,
+	topics TEXT,
+	personality TEXT,
+	last_interaction DATETIME,
+	interaction_count INTEGER DEFAULT 0,
+	notes TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (chat_id, user_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+INSERT INTO chats (id, title, type, message_count) VALUES (-100123, 'Fixture Group', 'supergroup', 57);
+INSERT INTO users (id, username, first_name, message_count) VALUES (7, 'alice', 'Alice', 31);
+INSERT INTO chat_settings (chat_id, response_frequency, always_respond_to_mentions) VALUES (-100123, 5, 0);
+INSERT INTO messages (chat_id, user_id, text, is_bot, timestamp) VALUES
+	(-100123, 7, 'hello from the old schema', 0, '2024-01-15 12:00:00'),
+	(-100123, 7, 'still here', 0, '2024-01-15 12:01:00');
+INSERT INTO user_profiles (chat_id, user_id, interests, topics, personality, last_interaction, interaction_count, notes)
+	VALUES (-100123, 7, 'chess', 'openings', '', '2024-01-15 12:01:00', 3, '');
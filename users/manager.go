@@ -28,26 +28,43 @@ func NewManager() *Manager {
 	}
 }
 
-// UpdateUser updates or creates user information
-func (m *Manager) UpdateUser(from *tgbotapi.User) {
+// UpdateUser updates or creates user information and counts one message.
+// It reports whether the user is new or their names changed.
+func (m *Manager) UpdateUser(from *tgbotapi.User) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if user, exists := m.users[from.ID]; exists {
 		// Update existing user
+		changed := user.UserName != from.UserName ||
+			user.FirstName != from.FirstName ||
+			user.LastName != from.LastName
 		user.UserName = from.UserName
 		user.FirstName = from.FirstName
 		user.LastName = from.LastName
 		user.MessageCount++
-	} else {
-		// Create new user
-		m.users[from.ID] = &User{
-			ID:           from.ID,
-			UserName:     from.UserName,
-			FirstName:    from.FirstName,
-			LastName:     from.LastName,
-			MessageCount: 1,
-		}
+		return changed
+	}
+
+	// Create new user
+	m.users[from.ID] = &User{
+		ID:           from.ID,
+		UserName:     from.UserName,
+		FirstName:    from.FirstName,
+		LastName:     from.LastName,
+		MessageCount: 1,
+	}
+	return true
+}
+
+// Restore seeds a previously stored user, e.g. after a restart.
+// Users the manager already knows are left untouched.
+func (m *Manager) Restore(user User) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[user.ID]; !exists {
+		m.users[user.ID] = &user
 	}
 }
 
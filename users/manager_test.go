@@ -18,7 +18,7 @@ func TestNewManager(t *testing.T) {
 
 func TestUpdateUser(t *testing.T) {
 	manager := NewManager()
-	
+
 	// Create a mock user
 	tgUser := &tgbotapi.User{
 		ID:        12345,
@@ -26,16 +26,16 @@ func TestUpdateUser(t *testing.T) {
 		FirstName: "Test",
 		LastName:  "User",
 	}
-	
+
 	// Update user for the first time
 	manager.UpdateUser(tgUser)
-	
+
 	// Retrieve the user
 	user := manager.GetUser(12345)
 	if user == nil {
 		t.Fatal("GetUser returned nil")
 	}
-	
+
 	if user.ID != 12345 {
 		t.Errorf("Expected ID 12345, got %d", user.ID)
 	}
@@ -48,7 +48,7 @@ func TestUpdateUser(t *testing.T) {
 	if user.MessageCount != 1 {
 		t.Errorf("Expected message count 1, got %d", user.MessageCount)
 	}
-	
+
 	// Update user again
 	manager.UpdateUser(tgUser)
 	user = manager.GetUser(12345)
@@ -59,7 +59,7 @@ func TestUpdateUser(t *testing.T) {
 
 func TestGetUser_NotFound(t *testing.T) {
 	manager := NewManager()
-	
+
 	user := manager.GetUser(99999)
 	if user != nil {
 		t.Errorf("Expected nil for non-existent user, got %+v", user)
@@ -68,23 +68,23 @@ func TestGetUser_NotFound(t *testing.T) {
 
 func TestGetAllUsers(t *testing.T) {
 	manager := NewManager()
-	
+
 	// Add multiple users
 	users := []*tgbotapi.User{
 		{ID: 1, UserName: "user1", FirstName: "First1", LastName: "Last1"},
 		{ID: 2, UserName: "user2", FirstName: "First2", LastName: "Last2"},
 		{ID: 3, UserName: "user3", FirstName: "First3", LastName: "Last3"},
 	}
-	
+
 	for _, u := range users {
 		manager.UpdateUser(u)
 	}
-	
+
 	allUsers := manager.GetAllUsers()
 	if len(allUsers) != 3 {
 		t.Errorf("Expected 3 users, got %d", len(allUsers))
 	}
-	
+
 	// Verify each user
 	for _, u := range users {
 		user, exists := allUsers[u.ID]
@@ -96,3 +96,41 @@ func TestGetAllUsers(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateUser_ReportsChanges(t *testing.T) {
+	manager := NewManager()
+	from := &tgbotapi.User{ID: 1, UserName: "alice", FirstName: "Alice"}
+
+	if !manager.UpdateUser(from) {
+		t.Error("Expected a new user to be reported as changed")
+	}
+	if manager.UpdateUser(from) {
+		t.Error("Expected an unchanged user not to be reported")
+	}
+
+	renamed := &tgbotapi.User{ID: 1, UserName: "alice2", FirstName: "Alice"}
+	if !manager.UpdateUser(renamed) {
+		t.Error("Expected a username change to be reported")
+	}
+	if count := manager.GetUser(1).MessageCount; count != 3 {
+		t.Errorf("Expected message count 3, got %d", count)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	manager := NewManager()
+
+	manager.Restore(User{ID: 1, UserName: "alice", MessageCount: 41})
+	if manager.UpdateUser(&tgbotapi.User{ID: 1, UserName: "alice"}) {
+		t.Error("Expected restored user with the same details not to be reported as changed")
+	}
+	if count := manager.GetUser(1).MessageCount; count != 42 {
+		t.Errorf("Expected count to continue from 41 to 42, got %d", count)
+	}
+
+	// Restoring a known user does not overwrite the live count
+	manager.Restore(User{ID: 1, UserName: "alice", MessageCount: 5})
+	if count := manager.GetUser(1).MessageCount; count != 42 {
+		t.Errorf("Expected live count 42 to be kept, got %d", count)
+	}
+}
@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SecretHeader carries the secret token registered with setWebhook
+const SecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// maxBodySize bounds request bodies; real updates are a few kilobytes
+const maxBodySize = 1 << 20
+
+// DeliverFunc hands a decoded update to the processing pipeline.
+// Returning an error makes Telegram retry the update later.
+type DeliverFunc func(ctx context.Context, update tgbotapi.Update) error
+
+// Handler receives updates pushed by Telegram
+type Handler struct {
+	secret  string
+	deliver DeliverFunc
+	log     *slog.Logger
+}
+
+// NewHandler creates a webhook handler.
+// Requests must carry the secret in SecretHeader unless secret is empty.
+func NewHandler(secret string, deliver DeliverFunc) *Handler {
+	return &Handler{
+		secret:  secret,
+		deliver: deliver,
+		log:     slog.Default(),
+	}
+}
+
+// SetLogger sets where rejected and undeliverable requests are logged
+func (h *Handler) SetLogger(logger *slog.Logger) {
+	h.log = logger
+}
+
+// ServeHTTP validates and decodes one update
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.secret != "" {
+		token := r.Header.Get(SecretHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+			h.log.Warn("Rejected webhook request: invalid secret token", "remote_addr", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&update); err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	// The request context ends if Telegram gives up, so a saturated pipeline
+	// turns into a retry instead of a lost update
+	if err := h.deliver(r.Context(), update); err != nil {
+		h.log.Warn("Failed to queue webhook update", "update_id", update.UpdateID, "error", err)
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Server serves a Handler on the path of the public webhook URL
+type Server struct {
+	httpServer *http.Server
+}
+
+// NewServer creates a webhook server listening on addr.
+// Only requests to the path of publicURL reach the handler.
+func NewServer(addr, publicURL string, handler http.Handler) (*Server, error) {
+	path, err := Path(publicURL)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+
+	return &Server{
+		httpServer: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// Run serves until ctx is cancelled, then waits up to shutdownTimeout for
+// in-flight requests to finish
+func (s *Server) Run(ctx context.Context, shutdownTimeout time.Duration) error {
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.httpServer.Addr, err)
+	}
+	return s.Serve(ctx, listener, shutdownTimeout)
+}
+
+// Serve is like Run but accepts connections on an existing listener
+func (s *Server) Serve(ctx context.Context, listener net.Listener, shutdownTimeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.httpServer.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("webhook server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to stop webhook server: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("webhook server stopped: %w", err)
+	}
+	return nil
+}
+
+// Requester makes raw Bot API calls; *tgbotapi.BotAPI implements it
+type Requester interface {
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+}
+
+// Register points Telegram at publicURL with the given secret token.
+// allowedUpdates lists the update types to deliver; nil keeps Telegram's
+// default, which leaves out chat_member updates.
+func Register(api Requester, publicURL, secret string, allowedUpdates []string) error {
+	params := tgbotapi.Params{"url": publicURL}
+	params.AddNonEmpty("secret_token", secret)
+	if len(allowedUpdates) > 0 {
+		if err := params.AddInterface("allowed_updates", allowedUpdates); err != nil {
+			return fmt.Errorf("failed to encode allowed updates: %w", err)
+		}
+	}
+
+	if _, err := api.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+	return nil
+}
+
+// Path returns the request path Telegram will POST to for publicURL
+func Path(publicURL string) (string, error) {
+	u, err := url.Parse(publicURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if u.Path == "" {
+		return "/", nil
+	}
+	return u.Path, nil
+}
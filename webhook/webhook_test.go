@@ -0,0 +1,281 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/Zind-dev/HowardTheChad_bot/dispatcher"
+)
+
+const testSecret = "s3cret_token-1"
+
+// recorder collects delivered updates
+type recorder struct {
+	mu      sync.Mutex
+	updates []tgbotapi.Update
+	err     error
+}
+
+func (r *recorder) deliver(ctx context.Context, update tgbotapi.Update) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.updates = append(r.updates, update)
+	return nil
+}
+
+func loadFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	return data
+}
+
+func post(handler http.Handler, body []byte, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/telegram", bytes.NewReader(body))
+	if secret != "" {
+		req.Header.Set(SecretHeader, secret)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler_Fixtures(t *testing.T) {
+	rec := &recorder{}
+	handler := NewHandler(testSecret, rec.deliver)
+
+	resp := post(handler, loadFixture(t, "message.json"), testSecret)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.Code)
+	}
+	resp = post(handler, loadFixture(t, "callback_query.json"), testSecret)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.Code)
+	}
+
+	if len(rec.updates) != 2 {
+		t.Fatalf("Expected 2 delivered updates, got %d", len(rec.updates))
+	}
+
+	msg := rec.updates[0].Message
+	if msg == nil || msg.Chat.ID != -100123 || msg.Text != "@howard_bot hello" {
+		t.Errorf("Message update decoded incorrectly: %+v", msg)
+	}
+	if msg != nil && msg.From.UserName != "alice" {
+		t.Errorf("Expected sender 'alice', got '%s'", msg.From.UserName)
+	}
+
+	cb := rec.updates[1].CallbackQuery
+	if cb == nil || cb.Data != "frequency:5" {
+		t.Errorf("Callback query decoded incorrectly: %+v", cb)
+	}
+}
+
+func TestHandler_Rejects(t *testing.T) {
+	body := []byte(`{"update_id": 1}`)
+	tests := []struct {
+		name     string
+		method   string
+		body     []byte
+		secret   string
+		deliver  error
+		wantCode int
+	}{
+		{name: "Missing secret", method: http.MethodPost, body: body, wantCode: http.StatusUnauthorized},
+		{name: "Wrong secret", method: http.MethodPost, body: body, secret: "wrong", wantCode: http.StatusUnauthorized},
+		{name: "Wrong method", method: http.MethodGet, secret: testSecret, wantCode: http.StatusMethodNotAllowed},
+		{name: "Malformed body", method: http.MethodPost, body: []byte("{not json"), secret: testSecret, wantCode: http.StatusBadRequest},
+		{name: "Pipeline busy", method: http.MethodPost, body: body, secret: testSecret, deliver: errors.New("queue full"), wantCode: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{err: tt.deliver}
+			handler := NewHandler(testSecret, rec.deliver)
+
+			req := httptest.NewRequest(tt.method, "/telegram", bytes.NewReader(tt.body))
+			if tt.secret != "" {
+				req.Header.Set(SecretHeader, tt.secret)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, resp.Code)
+			}
+			if len(rec.updates) != 0 {
+				t.Errorf("Expected no delivered updates, got %d", len(rec.updates))
+			}
+		})
+	}
+}
+
+func TestHandler_NoSecret(t *testing.T) {
+	rec := &recorder{}
+	handler := NewHandler("", rec.deliver)
+
+	resp := post(handler, loadFixture(t, "message.json"), "")
+	if resp.Code != http.StatusOK {
+		t.Errorf("Expected status 200 without a configured secret, got %d", resp.Code)
+	}
+}
+
+func TestServer_RoutesAndShutsDown(t *testing.T) {
+	rec := &recorder{}
+	server, err := NewServer("", "https://example.com/hook/abc", NewHandler(testSecret, rec.deliver))
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, listener, time.Second)
+	}()
+
+	base := "http://" + listener.Addr().String()
+	send := func(path string) int {
+		req, _ := http.NewRequest(http.MethodPost, base+path, bytes.NewReader(loadFixture(t, "message.json")))
+		req.Header.Set(SecretHeader, testSecret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := send("/hook/abc"); code != http.StatusOK {
+		t.Errorf("Expected status 200 on webhook path, got %d", code)
+	}
+	if code := send("/other"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 on other paths, got %d", code)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Server did not shut down")
+	}
+
+	if len(rec.updates) != 1 {
+		t.Errorf("Expected 1 delivered update, got %d", len(rec.updates))
+	}
+}
+
+func TestServer_ShutdownTimeoutWithFullQueue(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	pool := dispatcher.New(1, 1, func(ctx context.Context, update tgbotapi.Update) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	pool.Start(context.Background())
+
+	server, err := NewServer("", "https://example.com/hook", NewHandler("", pool.Dispatch))
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, listener, 10*time.Millisecond)
+	}()
+
+	codes := make(chan int, 3)
+	send := func() {
+		resp, err := http.Post("http://"+listener.Addr().String()+"/hook", "application/json", bytes.NewReader(loadFixture(t, "message.json")))
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}
+
+	// One update busy in the worker, one queued, one waiting for space
+	send()
+	<-started
+	send()
+	go send()
+	for pool.Stats().Blocked == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The waiting request outlives the shutdown timeout, then the pool
+	// stops underneath it, as the bot does
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("Expected the shutdown to time out")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		pool.Stop()
+		close(stopped)
+	}()
+
+	for _, want := range []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable} {
+		select {
+		case code := <-codes:
+			if code != want {
+				t.Errorf("Expected status %d, got %d", want, code)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Request did not finish")
+		}
+	}
+	close(release)
+	<-stopped
+}
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://example.com/telegram/hook", want: "/telegram/hook"},
+		{url: "https://example.com", want: "/"},
+	}
+
+	for _, tt := range tests {
+		got, err := Path(tt.url)
+		if err != nil {
+			t.Fatalf("Path(%q) failed: %v", tt.url, err)
+		}
+		if got != tt.want {
+			t.Errorf("Path(%q) = %q, expected %q", tt.url, got, tt.want)
+		}
+	}
+}